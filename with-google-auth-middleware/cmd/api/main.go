@@ -1,17 +1,36 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/tyobaskara/jeki-backend/internal/config"
 	v1 "github.com/tyobaskara/jeki-backend/internal/handler/v1"
 	authconfig "github.com/tyobaskara/jeki-backend/internal/modules/auth/config"
+	authdomain "github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
 	"github.com/tyobaskara/jeki-backend/internal/modules/auth/handler"
 	"github.com/tyobaskara/jeki-backend/internal/modules/auth/middleware"
 	authrepo "github.com/tyobaskara/jeki-backend/internal/modules/auth/repository"
 	"github.com/tyobaskara/jeki-backend/internal/modules/auth/usecase"
+	consentdomain "github.com/tyobaskara/jeki-backend/internal/modules/consent/domain"
+	consenthandler "github.com/tyobaskara/jeki-backend/internal/modules/consent/handler"
+	consentmiddleware "github.com/tyobaskara/jeki-backend/internal/modules/consent/middleware"
+	consentrepo "github.com/tyobaskara/jeki-backend/internal/modules/consent/repository"
+	consentusecase "github.com/tyobaskara/jeki-backend/internal/modules/consent/usecase"
+	orghandler "github.com/tyobaskara/jeki-backend/internal/modules/organization/handler"
+	orgrepo "github.com/tyobaskara/jeki-backend/internal/modules/organization/repository"
+	orgusecase "github.com/tyobaskara/jeki-backend/internal/modules/organization/usecase"
+	privacyhandler "github.com/tyobaskara/jeki-backend/internal/modules/privacy/handler"
+	privacyrepo "github.com/tyobaskara/jeki-backend/internal/modules/privacy/repository"
+	privacyusecase "github.com/tyobaskara/jeki-backend/internal/modules/privacy/usecase"
+	scimhandler "github.com/tyobaskara/jeki-backend/internal/modules/scim/handler"
+	scimmiddleware "github.com/tyobaskara/jeki-backend/internal/modules/scim/middleware"
+	scimrepo "github.com/tyobaskara/jeki-backend/internal/modules/scim/repository"
+	scimusecase "github.com/tyobaskara/jeki-backend/internal/modules/scim/usecase"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
 	userhandler "github.com/tyobaskara/jeki-backend/internal/modules/user/handler"
 	userrepo "github.com/tyobaskara/jeki-backend/internal/modules/user/repository"
 	userusecase "github.com/tyobaskara/jeki-backend/internal/modules/user/usecase"
@@ -37,6 +56,9 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
+	if err := checkDBRole(db); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
 
 	// Convert config to auth config
 	authCfg := authconfig.NewConfig(
@@ -46,13 +68,89 @@ func main() {
 		cfg.AccessTokenTTL,
 		cfg.RefreshTokenTTL,
 	)
+	authCfg.AllowedHostedDomains = cfg.GoogleAllowedHostedDomains
+	authCfg.AllowedEmailPatterns = cfg.SignInAllowedEmails
+	authCfg.DeniedEmailPatterns = cfg.SignInDeniedEmails
+	authCfg.RequireVerifiedEmail = cfg.SignInRequireVerifiedEmail
+	authCfg.DPoPMode = cfg.DPoPMode
+	authCfg.StepUpMaxAge = cfg.StepUpMaxAge
+	authCfg.StepUpACR = cfg.StepUpACR
+	authCfg.ProvisioningMode = cfg.ProvisioningMode
+	authCfg.InvitationTTL = cfg.InvitationTTL
+	authCfg.GuestTTL = cfg.GuestTTL
+	authCfg.AccountStatusCacheTTL = cfg.AccountStatusCacheTTL
+	authCfg.SessionCacheTTL = cfg.SessionCacheTTL
+	authCfg.ProfileSyncProviderWins = cfg.ProfileSyncProviderWins
+	authCfg.AppleTeamID = cfg.AppleTeamID
+	authCfg.AppleKeyID = cfg.AppleKeyID
+	authCfg.ApplePrivateKey = cfg.ApplePrivateKey
+	authCfg.AppleBaseURL = cfg.AppleBaseURL
+	for _, provider := range cfg.OIDCProviders {
+		authCfg.OIDCProviders = append(authCfg.OIDCProviders, authconfig.OIDCProviderConfig(provider))
+	}
+	authCfg.SAMLEntityID = cfg.SAMLEntityID
+	authCfg.SAMLBaseURL = cfg.SAMLBaseURL
+	authCfg.SAMLCertificate = cfg.SAMLCertificate
+	authCfg.SAMLPrivateKey = cfg.SAMLPrivateKey
+	for _, idp := range cfg.SAMLIdPs {
+		authCfg.SAMLIdPs = append(authCfg.SAMLIdPs, authconfig.SAMLIdPConfig(idp))
+	}
+	for _, client := range cfg.AuthClients {
+		authCfg.Clients = append(authCfg.Clients, authconfig.ClientConfig(client))
+	}
+	authCfg.SCIMBaseURL = cfg.SCIMBaseURL
+	authCfg.OAuthIssuer = cfg.OAuthIssuer
+	authCfg.OAuthConsentURL = cfg.OAuthConsentURL
+	authCfg.OAuthSigningKey = cfg.OAuthSigningKey
+	if cfg.AuthorizationServerEnabled() && cfg.OAuthSigningKey == "" {
+		log.Printf("Warning: OAUTH_SIGNING_KEY is not set, ID tokens are signed with a key generated on startup")
+	}
+	authCfg.OAuthDeviceVerificationURL = cfg.OAuthDeviceVerificationURL
+	for _, policy := range cfg.TokenExchangePolicies {
+		authCfg.TokenExchangePolicies = append(authCfg.TokenExchangePolicies, authconfig.TokenExchangePolicyConfig(policy))
+	}
+	for _, document := range cfg.ConsentDocuments {
+		authCfg.ConsentDocuments = append(authCfg.ConsentDocuments, authconfig.ConsentDocumentConfig(document))
+	}
+	authCfg.DataExportTTL = cfg.DataExportTTL
+	authCfg.DataExportLinkTTL = cfg.DataExportLinkTTL
+	authCfg.ErasureGracePeriod = cfg.ErasureGracePeriod
 
 	// Auth module manual wiring
+	// API scopes declared by the modules
+	scopes := authdomain.NewScopeCatalog(userdomain.Scopes)
 	authRepo := authrepo.NewAuthRepository(db)
-	userRepo := userrepo.NewUserRepository(db)
+	// Modules move their rows when a user is merged into another user
+	userRepo := userrepo.NewUserRepository(db, authrepo.MergeHooks, orgrepo.MergeHooks, scimrepo.MergeHooks, consentrepo.MergeHooks)
+	invitationRepo := authrepo.NewInvitationRepository(db)
+	identityRepo := authrepo.NewIdentityRepository(db)
+	// Looks up the org roles of sessions switched to an organization
+	orgUsecase := orgusecase.NewOrganizationUsecase(
+		orgrepo.NewOrganizationRepository(db),
+		orgrepo.NewInvitationRepository(db),
+		userRepo,
+		authCfg.InvitationTTL,
+	)
+	// Shared by sign-in and the middleware, so that a DPoP proof is only accepted once
+	dpopVerifier := usecase.NewDPoPVerifier(authCfg.DPoPMode, authCfg.OAuthIssuer)
+	// Records the login history, modules subscribe to it e.g. to alert users of new devices
+	securityEventRepo := authrepo.NewSecurityEventRepository(db)
+	securityEvents := usecase.NewSecurityEventRecorder(securityEventRepo)
+	// Claims the modules add to access tokens, under their namespace
+	claimsRegistry, err := usecase.NewClaimsRegistry()
+	if err != nil {
+		log.Fatalf("Failed to register claim providers: %v", err)
+	}
+	// Flags the documents users must accept in login responses
+	consentUsecase, err := consentusecase.NewConsentUsecase(consentrepo.NewAcceptanceRepository(db), consentDocuments(authCfg.ConsentDocuments))
+	if err != nil {
+		log.Fatalf("Failed to configure consent documents: %v", err)
+	}
 	authUsecase := usecase.NewAuthUsecase(
 		authRepo,
 		userRepo,
+		invitationRepo,
+		identityRepo,
 		usecase.AuthUsecaseConfig{
 			ClientID:     authCfg.GoogleClientID,
 			ClientSecret: authCfg.GoogleClientSecret,
@@ -61,17 +159,111 @@ func main() {
 				AccessTTL:  authCfg.AccessTokenTTL,
 				RefreshTTL: authCfg.RefreshTokenTTL,
 			},
+			SignInPolicy: usecase.SignInPolicy{
+				AllowedHostedDomains: authCfg.AllowedHostedDomains,
+				AllowedEmailPatterns: authCfg.AllowedEmailPatterns,
+				DeniedEmailPatterns:  authCfg.DeniedEmailPatterns,
+				RequireVerifiedEmail: authCfg.RequireVerifiedEmail,
+			},
+			ProvisioningMode: authCfg.ProvisioningMode,
+			ProfileSync: usecase.ProfileSyncPolicy{
+				ProviderWins: authCfg.ProfileSyncProviderWins,
+			},
+			Clients: clientConfigs(authCfg.Clients),
+			Apple: usecase.AppleConfig{
+				TeamID:     authCfg.AppleTeamID,
+				KeyID:      authCfg.AppleKeyID,
+				PrivateKey: authCfg.ApplePrivateKey,
+				BaseURL:    authCfg.AppleBaseURL,
+			},
+			OIDCProviders:  oidcProviderConfigs(authCfg.OIDCProviders),
+			OIDCLogins:     authrepo.NewOIDCLoginRepository(db),
+			SAML:           samlConfig(authCfg, authrepo.NewSAMLMessageRepository(db)),
+			Scopes:         scopes,
+			DPoP:           dpopVerifier,
+			Organizations:  orgUsecase,
+			GuestTTL:       authCfg.GuestTTL,
+			Claims:         claimsRegistry,
+			SecurityEvents: securityEvents,
+			Consents:       consentUsecase,
 		},
 	)
 	authHandler := handler.NewAuthHandler(authUsecase)
-	authMiddleware := middleware.NewAuthMiddleware(authCfg.JWTSecret)
+	accountStatuses := usecase.NewAccountStatusCache(userRepo, authCfg.AccountStatusCacheTTL)
+	oauthUsecase := usecase.NewOAuthUsecase(authRepo, authCfg.JWTSecret, securityEvents, accountStatuses)
+	oauthHandler := handler.NewOAuthHandler(oauthUsecase)
+	authorizationServer := usecase.NewAuthorizationServerUsecase(
+		authRepo,
+		authrepo.NewOAuthRepository(db),
+		userRepo,
+		usecase.AuthorizationServerConfig{
+			Issuer:     authCfg.OAuthIssuer,
+			ConsentURL: authCfg.OAuthConsentURL,
+			SigningKey: authCfg.OAuthSigningKey,
+			JWTSecret:  authCfg.JWTSecret,
+			TokenConfig: usecase.TokenConfig{
+				AccessTTL:  authCfg.AccessTokenTTL,
+				RefreshTTL: authCfg.RefreshTokenTTL,
+			},
+			Scopes:                scopes,
+			TokenExchange:         tokenExchangePolicies(authCfg.TokenExchangePolicies),
+			DeviceVerificationURL: authCfg.OAuthDeviceVerificationURL,
+			SecurityEvents:        securityEvents,
+		},
+	)
+	authorizationHandler := handler.NewAuthorizationHandler(authorizationServer)
+	invitationUsecase := usecase.NewInvitationUsecase(invitationRepo, authCfg.InvitationTTL)
+	invitationHandler := handler.NewInvitationHandler(invitationUsecase)
+	securityEventHandler := handler.NewSecurityEventHandler(usecase.NewSecurityEventUsecase(securityEventRepo))
+	authMiddleware := middleware.NewAuthMiddleware(authCfg.JWTSecret, dpopVerifier, authdomain.StepUpPolicy{
+		MaxAge: authCfg.StepUpMaxAge,
+		ACR:    authCfg.StepUpACR,
+	}, accountStatuses, usecase.NewSessionCache(authRepo, authCfg.SessionCacheTTL))
 
 	// User module manual wiring
 	userUsecase := userusecase.NewUserUsecase(userRepo)
 	userHandler := userhandler.NewUserHandler(userUsecase)
 
+	// Organization module manual wiring
+	orgHandler := orghandler.NewOrganizationHandler(orgUsecase)
+
+	// SCIM module manual wiring
+	scimUsecase := scimusecase.NewSCIMUsecase(
+		scimrepo.NewClientRepository(db),
+		scimrepo.NewGroupRepository(db),
+		userUsecase,
+		authRepo,
+		authCfg.SCIMBaseURL,
+	)
+	scimHandler := scimhandler.NewSCIMHandler(scimUsecase)
+	scimMiddleware := scimmiddleware.NewSCIMMiddleware(scimUsecase)
+
+	// Consent module manual wiring
+	consentHandler := consenthandler.NewConsentHandler(consentUsecase)
+	consentMiddleware := consentmiddleware.NewConsentMiddleware(consentUsecase)
+
+	// Privacy module manual wiring
+	privacyUsecase := privacyusecase.NewPrivacyUsecase(
+		// Modules export and erase the rows they keep for users
+		privacyrepo.NewExportRepository(db, userrepo.ExportHooks, authrepo.ExportHooks, orgrepo.ExportHooks, scimrepo.ExportHooks, consentrepo.ExportHooks),
+		privacyrepo.NewErasureRepository(db, userrepo.ErasureHooks, authrepo.ErasureHooks, orgrepo.ErasureHooks, scimrepo.ErasureHooks, consentrepo.ErasureHooks, privacyrepo.ErasureHooks),
+		authRepo,
+		privacyusecase.PrivacyConfig{
+			SigningKey:  usecase.DeriveKey([]byte(authCfg.JWTSecret), "data-export"),
+			ExportTTL:   authCfg.DataExportTTL,
+			LinkTTL:     authCfg.DataExportLinkTTL,
+			GracePeriod: authCfg.ErasureGracePeriod,
+		},
+	)
+	// Builds requested exports and erases accounts at the end of their grace period
+	go privacyUsecase.Run(context.Background(), time.Minute)
+	privacyHandler := privacyhandler.NewPrivacyHandler(privacyUsecase)
+
 	// Initialize router
-	router := v1.SetupRouter(userHandler, authHandler, authMiddleware)
+	router, err := v1.SetupRouter(cfg.TrustedProxies, userHandler, authHandler, oauthHandler, authorizationHandler, invitationHandler, securityEventHandler, orgHandler, authMiddleware, scimHandler, scimMiddleware, consentHandler, consentMiddleware, privacyHandler)
+	if err != nil {
+		log.Fatalf("Failed to set up router: %v", err)
+	}
 
 	// Start server
 	serverAddr := fmt.Sprintf(":%s", cfg.ServerPort)
@@ -81,6 +273,65 @@ func main() {
 	}
 }
 
+// clientConfigs converts the configured apps into auth usecase clients
+func clientConfigs(clients []authconfig.ClientConfig) []usecase.ClientConfig {
+	result := make([]usecase.ClientConfig, 0, len(clients))
+	for _, client := range clients {
+		result = append(result, usecase.ClientConfig{
+			Name:           client.Name,
+			GoogleClientID: client.GoogleClientID,
+			AppleClientID:  client.AppleClientID,
+			TokenConfig: usecase.TokenConfig{
+				AccessTTL:  client.AccessTokenTTL,
+				RefreshTTL: client.RefreshTokenTTL,
+			},
+		})
+	}
+	return result
+}
+
+// oidcProviderConfigs converts the configured OpenID Connect providers into auth usecase providers
+func oidcProviderConfigs(providers []authconfig.OIDCProviderConfig) []usecase.OIDCProviderConfig {
+	result := make([]usecase.OIDCProviderConfig, 0, len(providers))
+	for _, provider := range providers {
+		result = append(result, usecase.OIDCProviderConfig(provider))
+	}
+	return result
+}
+
+// samlConfig converts the SAML settings into the auth usecase SAML config
+func samlConfig(authCfg *authconfig.Config, messages authdomain.SAMLMessageRepository) usecase.SAMLConfig {
+	cfg := usecase.SAMLConfig{
+		EntityID:    authCfg.SAMLEntityID,
+		BaseURL:     authCfg.SAMLBaseURL,
+		Certificate: authCfg.SAMLCertificate,
+		PrivateKey:  authCfg.SAMLPrivateKey,
+		Messages:    messages,
+	}
+	for _, idp := range authCfg.SAMLIdPs {
+		cfg.IdentityProviders = append(cfg.IdentityProviders, usecase.SAMLIdentityProviderConfig(idp))
+	}
+	return cfg
+}
+
+// tokenExchangePolicies converts the token exchange settings into auth usecase policies
+func tokenExchangePolicies(policies []authconfig.TokenExchangePolicyConfig) []usecase.TokenExchangePolicy {
+	result := make([]usecase.TokenExchangePolicy, 0, len(policies))
+	for _, policy := range policies {
+		result = append(result, usecase.TokenExchangePolicy(policy))
+	}
+	return result
+}
+
+// consentDocuments converts the configured documents into consent documents
+func consentDocuments(documents []authconfig.ConsentDocumentConfig) []consentdomain.Document {
+	result := make([]consentdomain.Document, 0, len(documents))
+	for _, document := range documents {
+		result = append(result, consentdomain.Document(document))
+	}
+	return result
+}
+
 func initDB(cfg *config.Config) (*gorm.DB, error) {
 	dsn := fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
@@ -97,4 +348,21 @@ func initDB(cfg *config.Config) (*gorm.DB, error) {
 	}
 
 	return db, nil
-} 
\ No newline at end of file
+}
+
+// checkDBRole rejects database roles that skip row-level security, which
+// isolates the tenants of the organization tables
+func checkDBRole(db *gorm.DB) error {
+	var role struct {
+		Name   string
+		Bypass bool
+	}
+	err := db.Raw("SELECT current_user AS name, rolsuper OR rolbypassrls AS bypass FROM pg_roles WHERE rolname = current_user").Scan(&role).Error
+	if err != nil {
+		return fmt.Errorf("failed to check database role: %w", err)
+	}
+	if role.Bypass {
+		return fmt.Errorf("database user %q is a superuser or has BYPASSRLS, which skips row-level security", role.Name)
+	}
+	return nil
+}
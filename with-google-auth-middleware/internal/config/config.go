@@ -2,10 +2,12 @@
 package config
 
 import (
-	"fmt"  // Package fmt implements formatted I/O with functions similar to C's printf and scanf
-	"os"   // Package os provides a platform-independent interface to operating system functionality
-	"sync" // Package sync provides basic synchronization primitives such as mutual exclusion locks
-	"time" // Package time provides functionality for measuring and displaying time
+	"fmt"     // Package fmt implements formatted I/O with functions similar to C's printf and scanf
+	"os"      // Package os provides a platform-independent interface to operating system functionality
+	"strconv" // Package strconv implements conversions to and from string representations of basic data types
+	"strings" // Package strings implements simple functions to manipulate UTF-8 encoded strings
+	"sync"    // Package sync provides basic synchronization primitives such as mutual exclusion locks
+	"time"    // Package time provides functionality for measuring and displaying time
 
 	"github.com/joho/godotenv" // Package godotenv loads environment variables from .env files
 )
@@ -15,6 +17,7 @@ import (
 type Config struct {
 	Environment        string        // The current environment (e.g., "development", "production")
 	ServerPort         string        // The port number where the server will listen
+	TrustedProxies     []string      // IPs or CIDRs of proxies whose X-Forwarded-For is trusted, none when empty
 	DBHost             string        // Database host address
 	DBPort             string        // Database port number
 	DBUser             string        // Database username
@@ -25,20 +28,115 @@ type Config struct {
 	JWTSecret          string        // JWT secret key
 	AccessTokenTTL     time.Duration // Access token time to live
 	RefreshTokenTTL    time.Duration // Refresh token time to live
+	DPoPMode           string        // disabled, optional or required
+	// Step-up authentication of sensitive routes
+	StepUpMaxAge time.Duration // How long ago the user may have signed in, no limit when zero
+	StepUpACR    string        // Minimum acr: single_factor, multi_factor or empty for any
+	// Sign-in policy
+	GoogleAllowedHostedDomains []string // Google Workspace domains (hd claim) allowed to sign in
+	SignInAllowedEmails        []string // Glob patterns of emails allowed to sign in
+	SignInDeniedEmails         []string // Glob patterns of emails that may never sign in
+	SignInRequireVerifiedEmail bool     // Reject Google accounts without a verified email
+	// User provisioning
+	ProvisioningMode string        // open, invite_only or closed
+	InvitationTTL    time.Duration // How long an invitation stays valid
+	GuestTTL         time.Duration // How long a guest that doesn't sign in is kept, 0 disables guests
+	// Account statuses
+	AccountStatusCacheTTL time.Duration // How long the auth middleware caches a user's account status
+	SessionCacheTTL       time.Duration // How long the auth middleware caches whether a token's session still exists
+	// Profile sync
+	ProfileSyncProviderWins []string // Profile fields where the provider value overwrites user edits
+	// Apps (platforms) with their own Google OAuth client ID
+	AuthClients []AuthClient
+	// Sign in with Apple
+	AppleTeamID     string // Apple Developer team ID
+	AppleKeyID      string // ID of the Sign in with Apple private key
+	ApplePrivateKey string // PEM encoded .p8 private key, "\n" escapes are allowed
+	AppleBaseURL    string // Apple's issuer and endpoint base URL
+	// OpenID Connect providers (Keycloak, Okta, Azure AD, ...)
+	OIDCProviders []OIDCProvider
+	// SAML service provider and identity providers
+	SAMLEntityID    string // Entity ID of our service provider
+	SAMLBaseURL     string // Public URL of the SAML routes, e.g. https://api.example.com/v1/auth/saml
+	SAMLCertificate string // PEM certificate published in our metadata, "\n" escapes are allowed
+	SAMLPrivateKey  string // PEM RSA key signing AuthnRequests, "\n" escapes are allowed
+	SAMLIdPs        []SAMLIdP
+	// SCIM provisioning API
+	SCIMBaseURL string // Public URL of the SCIM routes, e.g. https://api.example.com/scim/v2
+	// OAuth 2.0 / OpenID Connect authorization server for third-party apps
+	OAuthIssuer     string // Public base URL of the API, e.g. https://api.example.com
+	OAuthConsentURL string // Page of the web app that asks users to approve authorization requests
+	OAuthSigningKey string // PEM RSA key signing ID tokens, "\n" escapes are allowed
+	// Device authorization grant (RFC 8628) for CLIs and TV apps
+	OAuthDeviceVerificationURL string // Page of the web app where users enter the user code of a device
+	// Token exchange (RFC 8693) for calls to downstream services
+	TokenExchangePolicies []TokenExchangePolicy
+	// Terms of service, privacy policy and other documents users consent to
+	ConsentDocuments []ConsentDocument
+	// Data exports and account erasure
+	DataExportTTL      time.Duration // How long a built data export can be downloaded
+	DataExportLinkTTL  time.Duration // How long a signed download link stays valid
+	ErasureGracePeriod time.Duration // How long after DELETE /v1/me the account is erased
 	// Add other configuration fields as needed
 }
 
+// AuthClient holds the configuration of one app (web, iOS, Android, ...)
+// that signs in with its own Google OAuth client ID or Apple client ID
+type AuthClient struct {
+	Name            string        // Platform name recorded on sessions, e.g. "ios"
+	GoogleClientID  string        // Google OAuth client ID of the app
+	AppleClientID   string        // Apple bundle ID or services ID of the app
+	AccessTokenTTL  time.Duration // Overrides AccessTokenTTL when set
+	RefreshTokenTTL time.Duration // Overrides RefreshTokenTTL when set
+}
+
+// OIDCProvider holds the configuration of one OpenID Connect identity provider
+type OIDCProvider struct {
+	Name          string            // Provider name used in URLs, e.g. "okta"
+	IssuerURL     string            // Issuer, metadata is discovered below /.well-known/openid-configuration
+	ClientID      string            // OAuth client ID registered at the provider
+	ClientSecret  string            // OAuth client secret registered at the provider
+	Scopes        []string          // Scopes to request, defaults to openid, email and profile
+	ClaimMappings map[string]string // User field to claim, e.g. email=upn
+}
+
+// SAMLIdP holds the configuration of one SAML identity provider
+type SAMLIdP struct {
+	Name              string            // Provider name used in URLs, e.g. "acme"
+	MetadataXML       string            // Contents of the identity provider's metadata file
+	AttributeMappings map[string]string // User field to attribute name, e.g. email=mail
+	TrustEmail        bool              // Treat emails asserted by the provider as verified
+}
+
+// TokenExchangePolicy holds the audiences and scopes an OAuth client may
+// exchange user tokens for
+type TokenExchangePolicy struct {
+	ClientID  string        // OAuth client ID of the exchanging service, e.g. "api-gateway"
+	Audiences []string      // Downstream services the client may request tokens for
+	Scopes    []string      // Scopes exchanged tokens may keep, all of the subject token's when empty
+	TokenTTL  time.Duration // Lifetime of exchanged tokens, 5 minutes when zero
+}
+
+// ConsentDocument holds the current version of one document users consent to
+type ConsentDocument struct {
+	Name     string // Document name, e.g. "terms"
+	Version  string // Current version, e.g. "2024-06-01"
+	URL      string // Page where the document is published
+	Required bool   // Users must accept the version before using the API
+}
+
 // Global variables for singleton pattern implementation
 var (
-	cfg  *Config        // The single instance of Config that will be used throughout the application
-	once sync.Once      // sync.Once ensures that the initialization code runs only once
-	mu   sync.RWMutex   // RWMutex provides mutual exclusion lock with reader/writer semantics
+	cfg  *Config      // The single instance of Config that will be used throughout the application
+	once sync.Once    // sync.Once ensures that the initialization code runs only once
+	mu   sync.RWMutex // RWMutex provides mutual exclusion lock with reader/writer semantics
 )
 
 // LoadConfig loads the configuration based on the environment.
 // It uses singleton pattern with thread safety.
 // Parameters:
 //   - env: string representing the environment (e.g., "development", "production")
+//
 // Returns:
 //   - *Config: pointer to the configuration struct
 //   - error: any error that occurred during loading
@@ -62,9 +160,10 @@ func LoadConfig(env string) (*Config, error) {
 		cfg = &Config{
 			Environment:        env,
 			ServerPort:         getEnv("SERVER_PORT", "8080"),
+			TrustedProxies:     getEnvAsSlice("TRUSTED_PROXIES"),
 			DBHost:             getEnv("DB_HOST", "localhost"),
 			DBPort:             getEnv("DB_PORT", "5432"),
-			DBUser:             getEnv("DB_USER", "postgres"),
+			DBUser:             getEnv("DB_USER", "jeki_app"),
 			DBPassword:         getEnv("DB_PASSWORD", ""),
 			DBName:             getEnv("DB_NAME", "jeki"),
 			GoogleClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
@@ -72,6 +171,56 @@ func LoadConfig(env string) (*Config, error) {
 			JWTSecret:          getEnv("JWT_SECRET", ""),
 			AccessTokenTTL:     time.Duration(getEnvAsInt("ACCESS_TOKEN_TTL", 15)) * time.Minute,
 			RefreshTokenTTL:    time.Duration(getEnvAsInt("REFRESH_TOKEN_TTL", 7*24)) * time.Hour,
+
+			GoogleAllowedHostedDomains: getEnvAsSlice("GOOGLE_ALLOWED_HOSTED_DOMAINS"),
+			SignInAllowedEmails:        getEnvAsSlice("SIGNIN_ALLOWED_EMAILS"),
+			SignInDeniedEmails:         getEnvAsSlice("SIGNIN_DENIED_EMAILS"),
+			SignInRequireVerifiedEmail: getEnvAsBool("SIGNIN_REQUIRE_VERIFIED_EMAIL", true),
+
+			DPoPMode: getEnv("DPOP_MODE", "optional"),
+
+			StepUpMaxAge: time.Duration(getEnvAsInt("STEP_UP_MAX_AGE", 15)) * time.Minute,
+			StepUpACR:    getEnv("STEP_UP_ACR", ""),
+
+			ProvisioningMode: getEnv("PROVISIONING_MODE", "open"),
+			InvitationTTL:    time.Duration(getEnvAsInt("INVITATION_TTL", 7*24)) * time.Hour,
+			GuestTTL:         time.Duration(getEnvAsInt("GUEST_TTL", 0)) * time.Hour,
+
+			AccountStatusCacheTTL: time.Duration(getEnvAsInt("ACCOUNT_STATUS_CACHE_TTL", 30)) * time.Second,
+			SessionCacheTTL:       time.Duration(getEnvAsInt("SESSION_CACHE_TTL", 30)) * time.Second,
+
+			ProfileSyncProviderWins: getEnvAsSlice("PROFILE_SYNC_PROVIDER_WINS"),
+
+			AuthClients: loadAuthClients(),
+
+			AppleTeamID:     getEnv("APPLE_TEAM_ID", ""),
+			AppleKeyID:      getEnv("APPLE_KEY_ID", ""),
+			ApplePrivateKey: strings.ReplaceAll(getEnv("APPLE_PRIVATE_KEY", ""), `\n`, "\n"),
+			AppleBaseURL:    getEnv("APPLE_BASE_URL", "https://appleid.apple.com"),
+
+			OIDCProviders: loadOIDCProviders(),
+
+			SAMLEntityID:    getEnv("SAML_SP_ENTITY_ID", ""),
+			SAMLBaseURL:     getEnv("SAML_SP_BASE_URL", ""),
+			SAMLCertificate: strings.ReplaceAll(getEnv("SAML_SP_CERTIFICATE", ""), `\n`, "\n"),
+			SAMLPrivateKey:  strings.ReplaceAll(getEnv("SAML_SP_PRIVATE_KEY", ""), `\n`, "\n"),
+			SAMLIdPs:        loadSAMLIdPs(),
+
+			SCIMBaseURL: getEnv("SCIM_BASE_URL", ""),
+
+			OAuthIssuer:     getEnv("OAUTH_ISSUER", ""),
+			OAuthConsentURL: getEnv("OAUTH_CONSENT_URL", ""),
+			OAuthSigningKey: strings.ReplaceAll(getEnv("OAUTH_SIGNING_KEY", ""), `\n`, "\n"),
+
+			OAuthDeviceVerificationURL: getEnv("OAUTH_DEVICE_VERIFICATION_URL", ""),
+
+			TokenExchangePolicies: loadTokenExchangePolicies(),
+
+			ConsentDocuments: loadConsentDocuments(),
+
+			DataExportTTL:      time.Duration(getEnvAsInt("DATA_EXPORT_TTL", 48)) * time.Hour,
+			DataExportLinkTTL:  time.Duration(getEnvAsInt("DATA_EXPORT_LINK_TTL", 15)) * time.Minute,
+			ErasureGracePeriod: time.Duration(getEnvAsInt("ERASURE_GRACE_PERIOD", 30*24)) * time.Hour,
 		}
 
 		// Validate the configuration
@@ -100,6 +249,7 @@ func GetConfig() *Config {
 // Parameters:
 //   - key: string representing the environment variable name
 //   - defaultValue: string to return if the environment variable is not set
+//
 // Returns:
 //   - string: the value of the environment variable or the default value
 func getEnv(key, defaultValue string) string {
@@ -124,6 +274,140 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return result
 }
 
+// loadAuthClients reads the apps listed in AUTH_CLIENTS. Each app is configured
+// with AUTH_CLIENT_<NAME>_GOOGLE_CLIENT_ID and/or AUTH_CLIENT_<NAME>_APPLE_CLIENT_ID
+// and optionally overrides the token
+// TTLs with AUTH_CLIENT_<NAME>_ACCESS_TOKEN_TTL (minutes) and
+// AUTH_CLIENT_<NAME>_REFRESH_TOKEN_TTL (hours).
+func loadAuthClients() []AuthClient {
+	var clients []AuthClient
+	for _, name := range getEnvAsSlice("AUTH_CLIENTS") {
+		prefix := "AUTH_CLIENT_" + strings.ToUpper(name) + "_"
+		clients = append(clients, AuthClient{
+			Name:            name,
+			GoogleClientID:  getEnv(prefix+"GOOGLE_CLIENT_ID", ""),
+			AppleClientID:   getEnv(prefix+"APPLE_CLIENT_ID", ""),
+			AccessTokenTTL:  time.Duration(getEnvAsInt(prefix+"ACCESS_TOKEN_TTL", 0)) * time.Minute,
+			RefreshTokenTTL: time.Duration(getEnvAsInt(prefix+"REFRESH_TOKEN_TTL", 0)) * time.Hour,
+		})
+	}
+	return clients
+}
+
+// loadOIDCProviders reads the providers listed in OIDC_PROVIDERS. Each provider
+// is configured with OIDC_<NAME>_ISSUER_URL, OIDC_<NAME>_CLIENT_ID,
+// OIDC_<NAME>_CLIENT_SECRET, and optionally OIDC_<NAME>_SCOPES and
+// OIDC_<NAME>_CLAIM_MAPPINGS (comma separated field=claim pairs).
+func loadOIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range getEnvAsSlice("OIDC_PROVIDERS") {
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, OIDCProvider{
+			Name:          name,
+			IssuerURL:     getEnv(prefix+"ISSUER_URL", ""),
+			ClientID:      getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret:  getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:        getEnvAsSlice(prefix + "SCOPES"),
+			ClaimMappings: getEnvAsMap(prefix + "CLAIM_MAPPINGS"),
+		})
+	}
+	return providers
+}
+
+// loadConsentDocuments reads the documents listed in CONSENT_DOCUMENTS. Each
+// document is configured with CONSENT_<NAME>_VERSION, and optionally
+// CONSENT_<NAME>_URL and CONSENT_<NAME>_REQUIRED (default true).
+func loadConsentDocuments() []ConsentDocument {
+	var documents []ConsentDocument
+	for _, name := range getEnvAsSlice("CONSENT_DOCUMENTS") {
+		prefix := "CONSENT_" + strings.ToUpper(name) + "_"
+		documents = append(documents, ConsentDocument{
+			Name:     name,
+			Version:  getEnv(prefix+"VERSION", ""),
+			URL:      getEnv(prefix+"URL", ""),
+			Required: getEnvAsBool(prefix+"REQUIRED", true),
+		})
+	}
+	return documents
+}
+
+// loadSAMLIdPs reads the identity providers listed in SAML_IDPS. Each provider
+// is configured with SAML_IDP_<NAME>_METADATA_FILE, and optionally
+// SAML_IDP_<NAME>_ATTRIBUTE_MAPPINGS (comma separated field=attribute pairs) and
+// SAML_IDP_<NAME>_TRUST_EMAIL (default true).
+func loadSAMLIdPs() []SAMLIdP {
+	var idps []SAMLIdP
+	for _, name := range getEnvAsSlice("SAML_IDPS") {
+		prefix := "SAML_IDP_" + strings.ToUpper(name) + "_"
+		// A missing file leaves the metadata empty, which validate reports
+		metadata, _ := os.ReadFile(getEnv(prefix+"METADATA_FILE", ""))
+		idps = append(idps, SAMLIdP{
+			Name:              name,
+			MetadataXML:       string(metadata),
+			AttributeMappings: getEnvAsMap(prefix + "ATTRIBUTE_MAPPINGS"),
+			TrustEmail:        getEnvAsBool(prefix+"TRUST_EMAIL", true),
+		})
+	}
+	return idps
+}
+
+// loadTokenExchangePolicies reads the clients listed in TOKEN_EXCHANGE_CLIENTS.
+// Each client is configured with TOKEN_EXCHANGE_<CLIENT>_AUDIENCES, and optionally
+// TOKEN_EXCHANGE_<CLIENT>_SCOPES and TOKEN_EXCHANGE_<CLIENT>_TOKEN_TTL (minutes).
+// Dashes and dots of the client ID become underscores in the variable names.
+func loadTokenExchangePolicies() []TokenExchangePolicy {
+	var policies []TokenExchangePolicy
+	for _, clientID := range getEnvAsSlice("TOKEN_EXCHANGE_CLIENTS") {
+		name := strings.NewReplacer("-", "_", ".", "_").Replace(strings.ToUpper(clientID))
+		prefix := "TOKEN_EXCHANGE_" + name + "_"
+		policies = append(policies, TokenExchangePolicy{
+			ClientID:  clientID,
+			Audiences: getEnvAsSlice(prefix + "AUDIENCES"),
+			Scopes:    getEnvAsSlice(prefix + "SCOPES"),
+			TokenTTL:  time.Duration(getEnvAsInt(prefix+"TOKEN_TTL", 0)) * time.Minute,
+		})
+	}
+	return policies
+}
+
+// getEnvAsMap gets a comma separated list of key=value pairs as a map
+func getEnvAsMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range getEnvAsSlice(key) {
+		if k, v, ok := strings.Cut(pair, "="); ok {
+			result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return result
+}
+
+// getEnvAsSlice gets a comma separated environment variable as a slice of trimmed,
+// non-empty values. It returns nil if the variable is not set.
+func getEnvAsSlice(key string) []string {
+	var result []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// getEnvAsBool gets an environment variable as boolean or returns a default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// AuthorizationServerEnabled reports whether third-party apps can obtain
+// tokens, through the consent page or the device flow
+func (c *Config) AuthorizationServerEnabled() bool {
+	return c.OAuthConsentURL != "" || c.OAuthDeviceVerificationURL != ""
+}
+
 // validate performs validation on the configuration
 // This method checks if required configuration values are set
 // Returns:
@@ -137,5 +421,77 @@ func (c *Config) validate() error {
 	if c.DBName == "" {
 		return fmt.Errorf("database name is required")
 	}
+	// Check if every app has a Google or Apple client ID
+	for _, client := range c.AuthClients {
+		if client.GoogleClientID == "" && client.AppleClientID == "" {
+			return fmt.Errorf("google or apple client ID is required for auth client %q", client.Name)
+		}
+	}
+	// Check if every OIDC provider can be discovered and provider names are unique
+	providerNames := map[string]bool{"google": true, "apple": true}
+	for _, provider := range c.OIDCProviders {
+		if providerNames[provider.Name] {
+			return fmt.Errorf("duplicate or reserved OIDC provider name %q", provider.Name)
+		}
+		providerNames[provider.Name] = true
+		if provider.IssuerURL == "" || provider.ClientID == "" {
+			return fmt.Errorf("issuer URL and client ID are required for OIDC provider %q", provider.Name)
+		}
+	}
+	// Check if every SAML identity provider has metadata and a unique name
+	for _, idp := range c.SAMLIdPs {
+		if providerNames[idp.Name] {
+			return fmt.Errorf("duplicate or reserved SAML identity provider name %q", idp.Name)
+		}
+		providerNames[idp.Name] = true
+		if idp.MetadataXML == "" {
+			return fmt.Errorf("metadata file is required for SAML identity provider %q", idp.Name)
+		}
+	}
+	if len(c.SAMLIdPs) > 0 && (c.SAMLEntityID == "" || c.SAMLBaseURL == "") {
+		return fmt.Errorf("SAML service provider entity ID and base URL are required")
+	}
+	// Check if the authorization server can build its endpoint URLs
+	if c.OAuthConsentURL != "" && c.OAuthIssuer == "" {
+		return fmt.Errorf("OAuth issuer is required when a consent URL is configured")
+	}
+	if c.OAuthDeviceVerificationURL != "" && c.OAuthIssuer == "" {
+		return fmt.Errorf("OAuth issuer is required when a device verification URL is configured")
+	}
+	// Check if the authorization server has a key that survives restarts, only
+	// development may sign ID tokens with a key generated on startup
+	if c.AuthorizationServerEnabled() && c.OAuthSigningKey == "" && c.Environment != "dev" {
+		return fmt.Errorf("OAuth signing key is required when the authorization server is enabled")
+	}
+	// Check if every token exchange client may exchange for at least one audience
+	for _, policy := range c.TokenExchangePolicies {
+		if len(policy.Audiences) == 0 {
+			return fmt.Errorf("audiences are required for token exchange client %q", policy.ClientID)
+		}
+	}
+	// Check if every consent document has a version
+	for _, document := range c.ConsentDocuments {
+		if document.Version == "" {
+			return fmt.Errorf("version is required for consent document %q", document.Name)
+		}
+	}
+	// Check if provisioning mode is supported
+	switch c.ProvisioningMode {
+	case "open", "invite_only", "closed":
+	default:
+		return fmt.Errorf("unsupported provisioning mode %q", c.ProvisioningMode)
+	}
+	// Check if DPoP mode is supported
+	switch c.DPoPMode {
+	case "disabled", "optional", "required":
+	default:
+		return fmt.Errorf("unsupported DPoP mode %q", c.DPoPMode)
+	}
+	// Check if the step-up ACR is supported
+	switch c.StepUpACR {
+	case "", "single_factor", "multi_factor":
+	default:
+		return fmt.Errorf("unsupported step-up ACR %q", c.StepUpACR)
+	}
 	return nil
-} 
\ No newline at end of file
+}
@@ -2,13 +2,33 @@
 package handler
 
 import (
+	"context"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	v1 "github.com/tyobaskara/jeki-backend/internal/handler/v1"
 	"github.com/tyobaskara/jeki-backend/internal/modules/auth/config"
+	authdomain "github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
 	authhandler "github.com/tyobaskara/jeki-backend/internal/modules/auth/handler"
 	"github.com/tyobaskara/jeki-backend/internal/modules/auth/middleware"
 	authrepo "github.com/tyobaskara/jeki-backend/internal/modules/auth/repository"
 	"github.com/tyobaskara/jeki-backend/internal/modules/auth/usecase"
+	consentdomain "github.com/tyobaskara/jeki-backend/internal/modules/consent/domain"
+	consenthandler "github.com/tyobaskara/jeki-backend/internal/modules/consent/handler"
+	consentmiddleware "github.com/tyobaskara/jeki-backend/internal/modules/consent/middleware"
+	consentrepo "github.com/tyobaskara/jeki-backend/internal/modules/consent/repository"
+	consentusecase "github.com/tyobaskara/jeki-backend/internal/modules/consent/usecase"
+	orghandler "github.com/tyobaskara/jeki-backend/internal/modules/organization/handler"
+	orgrepo "github.com/tyobaskara/jeki-backend/internal/modules/organization/repository"
+	orgusecase "github.com/tyobaskara/jeki-backend/internal/modules/organization/usecase"
+	privacyhandler "github.com/tyobaskara/jeki-backend/internal/modules/privacy/handler"
+	privacyrepo "github.com/tyobaskara/jeki-backend/internal/modules/privacy/repository"
+	privacyusecase "github.com/tyobaskara/jeki-backend/internal/modules/privacy/usecase"
+	scimhandler "github.com/tyobaskara/jeki-backend/internal/modules/scim/handler"
+	scimmiddleware "github.com/tyobaskara/jeki-backend/internal/modules/scim/middleware"
+	scimrepo "github.com/tyobaskara/jeki-backend/internal/modules/scim/repository"
+	scimusecase "github.com/tyobaskara/jeki-backend/internal/modules/scim/usecase"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
 	userhandler "github.com/tyobaskara/jeki-backend/internal/modules/user/handler"
 	userrepo "github.com/tyobaskara/jeki-backend/internal/modules/user/repository"
 	userusecase "github.com/tyobaskara/jeki-backend/internal/modules/user/usecase"
@@ -19,13 +39,72 @@ import (
 // It initializes the Gin router and registers all route handlers
 // Returns:
 //   - *gin.Engine: configured Gin router instance
-func SetupRouter(db *gorm.DB, cfg *config.Config) *gin.Engine {
+//   - error: if the trusted proxies are invalid
+func SetupRouter(db *gorm.DB, cfg *config.Config, trustedProxies []string) (*gin.Engine, error) {
 	// Auth module manual wiring
+	clients := make([]usecase.ClientConfig, 0, len(cfg.Clients))
+	for _, client := range cfg.Clients {
+		clients = append(clients, usecase.ClientConfig{
+			Name:           client.Name,
+			GoogleClientID: client.GoogleClientID,
+			AppleClientID:  client.AppleClientID,
+			TokenConfig: usecase.TokenConfig{
+				AccessTTL:  client.AccessTokenTTL,
+				RefreshTTL: client.RefreshTokenTTL,
+			},
+		})
+	}
+	var oidcProviders []usecase.OIDCProviderConfig
+	for _, provider := range cfg.OIDCProviders {
+		oidcProviders = append(oidcProviders, usecase.OIDCProviderConfig(provider))
+	}
+	var tokenExchangePolicies []usecase.TokenExchangePolicy
+	for _, policy := range cfg.TokenExchangePolicies {
+		tokenExchangePolicies = append(tokenExchangePolicies, usecase.TokenExchangePolicy(policy))
+	}
+	var consentDocuments []consentdomain.Document
+	for _, document := range cfg.ConsentDocuments {
+		consentDocuments = append(consentDocuments, consentdomain.Document(document))
+	}
+	var samlIdPs []usecase.SAMLIdentityProviderConfig
+	for _, idp := range cfg.SAMLIdPs {
+		samlIdPs = append(samlIdPs, usecase.SAMLIdentityProviderConfig(idp))
+	}
+	// API scopes declared by the modules
+	scopes := authdomain.NewScopeCatalog(userdomain.Scopes)
 	authRepo := authrepo.NewAuthRepository(db)
-	userRepo := userrepo.NewUserRepository(db)
+	// Modules move their rows when a user is merged into another user
+	userRepo := userrepo.NewUserRepository(db, authrepo.MergeHooks, orgrepo.MergeHooks, scimrepo.MergeHooks, consentrepo.MergeHooks)
+	invitationRepo := authrepo.NewInvitationRepository(db)
+	identityRepo := authrepo.NewIdentityRepository(db)
+	// Looks up the org roles of sessions switched to an organization
+	orgUsecase := orgusecase.NewOrganizationUsecase(
+		orgrepo.NewOrganizationRepository(db),
+		orgrepo.NewInvitationRepository(db),
+		userRepo,
+		cfg.InvitationTTL,
+	)
+	// Shared by sign-in and the middleware, so that a DPoP proof is only accepted once
+	dpopVerifier := usecase.NewDPoPVerifier(cfg.DPoPMode, cfg.OAuthIssuer)
+	// Records the login history, modules subscribe to it e.g. to alert users of new devices
+	securityEventRepo := authrepo.NewSecurityEventRepository(db)
+	securityEvents := usecase.NewSecurityEventRecorder(securityEventRepo)
+	// Claims the modules add to access tokens, under their namespace. An
+	// invalid namespace is a programming error.
+	claimsRegistry, err := usecase.NewClaimsRegistry()
+	if err != nil {
+		panic(err)
+	}
+	// Flags the documents users must accept in login responses
+	consentUsecase, err := consentusecase.NewConsentUsecase(consentrepo.NewAcceptanceRepository(db), consentDocuments)
+	if err != nil {
+		panic(err)
+	}
 	authUsecase := usecase.NewAuthUsecase(
 		authRepo,
 		userRepo,
+		invitationRepo,
+		identityRepo,
 		usecase.AuthUsecaseConfig{
 			ClientID:     cfg.GoogleClientID,
 			ClientSecret: cfg.GoogleClientSecret,
@@ -34,15 +113,113 @@ func SetupRouter(db *gorm.DB, cfg *config.Config) *gin.Engine {
 				AccessTTL:  cfg.AccessTokenTTL,
 				RefreshTTL: cfg.RefreshTokenTTL,
 			},
+			SignInPolicy: usecase.SignInPolicy{
+				AllowedHostedDomains: cfg.AllowedHostedDomains,
+				AllowedEmailPatterns: cfg.AllowedEmailPatterns,
+				DeniedEmailPatterns:  cfg.DeniedEmailPatterns,
+				RequireVerifiedEmail: cfg.RequireVerifiedEmail,
+			},
+			ProvisioningMode: cfg.ProvisioningMode,
+			ProfileSync: usecase.ProfileSyncPolicy{
+				ProviderWins: cfg.ProfileSyncProviderWins,
+			},
+			Clients: clients,
+			Apple: usecase.AppleConfig{
+				TeamID:     cfg.AppleTeamID,
+				KeyID:      cfg.AppleKeyID,
+				PrivateKey: cfg.ApplePrivateKey,
+				BaseURL:    cfg.AppleBaseURL,
+			},
+			OIDCProviders: oidcProviders,
+			OIDCLogins:    authrepo.NewOIDCLoginRepository(db),
+			SAML: usecase.SAMLConfig{
+				EntityID:          cfg.SAMLEntityID,
+				BaseURL:           cfg.SAMLBaseURL,
+				Certificate:       cfg.SAMLCertificate,
+				PrivateKey:        cfg.SAMLPrivateKey,
+				IdentityProviders: samlIdPs,
+				Messages:          authrepo.NewSAMLMessageRepository(db),
+			},
+			Scopes:         scopes,
+			DPoP:           dpopVerifier,
+			Organizations:  orgUsecase,
+			GuestTTL:       cfg.GuestTTL,
+			Claims:         claimsRegistry,
+			SecurityEvents: securityEvents,
+			Consents:       consentUsecase,
 		},
 	)
 	authHandler := authhandler.NewAuthHandler(authUsecase)
-	authMiddleware := middleware.NewAuthMiddleware(cfg.JWTSecret)
+	accountStatuses := usecase.NewAccountStatusCache(userRepo, cfg.AccountStatusCacheTTL)
+	oauthUsecase := usecase.NewOAuthUsecase(authRepo, cfg.JWTSecret, securityEvents, accountStatuses)
+	oauthHandler := authhandler.NewOAuthHandler(oauthUsecase)
+	authorizationServer := usecase.NewAuthorizationServerUsecase(
+		authRepo,
+		authrepo.NewOAuthRepository(db),
+		userRepo,
+		usecase.AuthorizationServerConfig{
+			Issuer:     cfg.OAuthIssuer,
+			ConsentURL: cfg.OAuthConsentURL,
+			SigningKey: cfg.OAuthSigningKey,
+			JWTSecret:  cfg.JWTSecret,
+			TokenConfig: usecase.TokenConfig{
+				AccessTTL:  cfg.AccessTokenTTL,
+				RefreshTTL: cfg.RefreshTokenTTL,
+			},
+			Scopes:                scopes,
+			TokenExchange:         tokenExchangePolicies,
+			DeviceVerificationURL: cfg.OAuthDeviceVerificationURL,
+			SecurityEvents:        securityEvents,
+		},
+	)
+	authorizationHandler := authhandler.NewAuthorizationHandler(authorizationServer)
+	invitationUsecase := usecase.NewInvitationUsecase(invitationRepo, cfg.InvitationTTL)
+	invitationHandler := authhandler.NewInvitationHandler(invitationUsecase)
+	securityEventHandler := authhandler.NewSecurityEventHandler(usecase.NewSecurityEventUsecase(securityEventRepo))
+	authMiddleware := middleware.NewAuthMiddleware(cfg.JWTSecret, dpopVerifier, authdomain.StepUpPolicy{
+		MaxAge: cfg.StepUpMaxAge,
+		ACR:    cfg.StepUpACR,
+	}, accountStatuses, usecase.NewSessionCache(authRepo, cfg.SessionCacheTTL))
 
 	// User module manual wiring
 	userUsecase := userusecase.NewUserUsecase(userRepo)
 	userHandler := userhandler.NewUserHandler(userUsecase)
 
+	// Organization module manual wiring
+	orgHandler := orghandler.NewOrganizationHandler(orgUsecase)
+
+	// SCIM module manual wiring
+	scimUsecase := scimusecase.NewSCIMUsecase(
+		scimrepo.NewClientRepository(db),
+		scimrepo.NewGroupRepository(db),
+		userUsecase,
+		authRepo,
+		cfg.SCIMBaseURL,
+	)
+	scimHandler := scimhandler.NewSCIMHandler(scimUsecase)
+	scimMiddleware := scimmiddleware.NewSCIMMiddleware(scimUsecase)
+
+	// Consent module manual wiring
+	consentHandler := consenthandler.NewConsentHandler(consentUsecase)
+	consentMiddleware := consentmiddleware.NewConsentMiddleware(consentUsecase)
+
+	// Privacy module manual wiring
+	privacyUsecase := privacyusecase.NewPrivacyUsecase(
+		// Modules export and erase the rows they keep for users
+		privacyrepo.NewExportRepository(db, userrepo.ExportHooks, authrepo.ExportHooks, orgrepo.ExportHooks, scimrepo.ExportHooks, consentrepo.ExportHooks),
+		privacyrepo.NewErasureRepository(db, userrepo.ErasureHooks, authrepo.ErasureHooks, orgrepo.ErasureHooks, scimrepo.ErasureHooks, consentrepo.ErasureHooks, privacyrepo.ErasureHooks),
+		authRepo,
+		privacyusecase.PrivacyConfig{
+			SigningKey:  usecase.DeriveKey([]byte(cfg.JWTSecret), "data-export"),
+			ExportTTL:   cfg.DataExportTTL,
+			LinkTTL:     cfg.DataExportLinkTTL,
+			GracePeriod: cfg.ErasureGracePeriod,
+		},
+	)
+	// Builds requested exports and erases accounts at the end of their grace period
+	go privacyUsecase.Run(context.Background(), time.Minute)
+	privacyHandler := privacyhandler.NewPrivacyHandler(privacyUsecase)
+
 	// Setup router with handlers
-	return v1.SetupRouter(userHandler, authHandler, authMiddleware)
-} 
\ No newline at end of file
+	return v1.SetupRouter(trustedProxies, userHandler, authHandler, oauthHandler, authorizationHandler, invitationHandler, securityEventHandler, orgHandler, authMiddleware, scimHandler, scimMiddleware, consentHandler, consentMiddleware, privacyHandler)
+}
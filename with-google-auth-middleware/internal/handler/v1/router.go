@@ -5,31 +5,104 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/tyobaskara/jeki-backend/internal/modules/auth/handler"
 	"github.com/tyobaskara/jeki-backend/internal/modules/auth/middleware"
+	consenthandler "github.com/tyobaskara/jeki-backend/internal/modules/consent/handler"
+	consentmiddleware "github.com/tyobaskara/jeki-backend/internal/modules/consent/middleware"
+	orghandler "github.com/tyobaskara/jeki-backend/internal/modules/organization/handler"
+	privacyhandler "github.com/tyobaskara/jeki-backend/internal/modules/privacy/handler"
+	scimhandler "github.com/tyobaskara/jeki-backend/internal/modules/scim/handler"
+	scimmiddleware "github.com/tyobaskara/jeki-backend/internal/modules/scim/middleware"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
 	userhandler "github.com/tyobaskara/jeki-backend/internal/modules/user/handler"
 )
 
-// SetupRouter configures the router with all routes
-func SetupRouter(userHandler *userhandler.UserHandler, authHandler *handler.AuthHandler, authMiddleware *middleware.AuthMiddleware) *gin.Engine {
+// SetupRouter configures the router with all routes. The client IP is read
+// from X-Forwarded-For only behind the trusted proxies, IPs or CIDRs.
+func SetupRouter(
+	trustedProxies []string,
+	userHandler *userhandler.UserHandler,
+	authHandler *handler.AuthHandler,
+	oauthHandler *handler.OAuthHandler,
+	authorizationHandler *handler.AuthorizationHandler,
+	invitationHandler *handler.InvitationHandler,
+	securityEventHandler *handler.SecurityEventHandler,
+	orgHandler *orghandler.OrganizationHandler,
+	authMiddleware *middleware.AuthMiddleware,
+	scimHandler *scimhandler.SCIMHandler,
+	scimMiddleware *scimmiddleware.SCIMMiddleware,
+	consentHandler *consenthandler.ConsentHandler,
+	consentMiddleware *consentmiddleware.ConsentMiddleware,
+	privacyHandler *privacyhandler.PrivacyHandler,
+) (*gin.Engine, error) {
 	router := gin.Default()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+	// Client IP and user agent, recorded with security events
+	router.Use(middleware.RequestInfo())
 
 	// Health check
 	router.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "pong"})
 	})
 
+	// OpenID Connect discovery
+	authorizationHandler.RegisterDiscoveryRoutes(&router.RouterGroup)
+
 	// API v1 routes
 	v1 := router.Group("/v1")
 	{
 		// Register auth routes
 		authHandler.RegisterRoutes(v1)
 
+		// Register OAuth routes (authenticated with client credentials)
+		oauthHandler.RegisterRoutes(v1)
+
+		// Register the authorization server routes used by third-party apps
+		authorizationHandler.RegisterRoutes(v1)
+
+		// Register the download of data exports (authenticated with a signed link)
+		privacyHandler.RegisterDownloadRoutes(v1)
+
 		// Protected routes
 		v1.Use(authMiddleware.AuthRequired())
 		{
+			// Register consent routes, reachable with pending consents so that users can accept them
+			consentHandler.RegisterRoutes(v1.Group("", authMiddleware.FirstPartyRequired()))
+
+			// Register logout and organization switching, users may sign out without accepting the documents
+			authHandler.RegisterSessionRoutes(v1.Group("", authMiddleware.FirstPartyRequired()))
+
+			// Register data export and account erasure routes, users may exercise these rights without accepting the documents
+			privacyHandler.RegisterRoutes(v1.Group("", authMiddleware.FirstPartyRequired()), authMiddleware.StepUpRequired())
+
+			// The routes below require the current version of every required document to be accepted
+			v1.Use(consentMiddleware.ConsentRequired())
+
 			// Register user routes
-			userHandler.RegisterRoutes(v1)
+			userHandler.RegisterRoutes(v1, authMiddleware.RequireScope, authMiddleware.StepUpRequired())
+
+			// OAuth consent, grant and organization routes, not available to third-party apps
+			firstParty := v1.Group("", authMiddleware.FirstPartyRequired())
+			{
+				authorizationHandler.RegisterUserRoutes(firstParty)
+				securityEventHandler.RegisterUserRoutes(firstParty)
+				orgHandler.RegisterRoutes(firstParty, authMiddleware.RequireOrg, authMiddleware.StepUpRequired())
+			}
+
+			// Admin routes
+			admin := v1.Group("/admin", authMiddleware.RequireRole(userdomain.RoleAdmin))
+			{
+				invitationHandler.RegisterRoutes(admin)
+				securityEventHandler.RegisterAdminRoutes(admin)
+				authorizationHandler.RegisterAdminRoutes(admin, authMiddleware.StepUpRequired())
+				userHandler.RegisterAdminRoutes(admin, authMiddleware.StepUpRequired())
+			}
 		}
 	}
 
-	return router
+	// SCIM 2.0 provisioning routes (authenticated with a provisioning client token)
+	scim := router.Group("/scim/v2", scimMiddleware.ClientRequired())
+	scimHandler.RegisterRoutes(scim)
+
+	return router, nil
 }
@@ -0,0 +1,87 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/handler"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/middleware"
+	consenthandler "github.com/tyobaskara/jeki-backend/internal/modules/consent/handler"
+	consentmiddleware "github.com/tyobaskara/jeki-backend/internal/modules/consent/middleware"
+	orghandler "github.com/tyobaskara/jeki-backend/internal/modules/organization/handler"
+	privacyhandler "github.com/tyobaskara/jeki-backend/internal/modules/privacy/handler"
+	scimhandler "github.com/tyobaskara/jeki-backend/internal/modules/scim/handler"
+	scimmiddleware "github.com/tyobaskara/jeki-backend/internal/modules/scim/middleware"
+	userhandler "github.com/tyobaskara/jeki-backend/internal/modules/user/handler"
+)
+
+// logoutUsecase records the users it logs out, the other methods are unused
+type logoutUsecase struct {
+	domain.AuthUsecase
+	loggedOut []uuid.UUID
+}
+
+func (u *logoutUsecase) Logout(ctx context.Context, userID uuid.UUID) error {
+	u.loggedOut = append(u.loggedOut, userID)
+	return nil
+}
+
+func TestLogoutRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth := &logoutUsecase{}
+	router, err := SetupRouter(
+		nil,
+		userhandler.NewUserHandler(nil),
+		handler.NewAuthHandler(auth),
+		handler.NewOAuthHandler(nil),
+		handler.NewAuthorizationHandler(nil),
+		handler.NewInvitationHandler(nil),
+		handler.NewSecurityEventHandler(nil),
+		orghandler.NewOrganizationHandler(nil),
+		middleware.NewAuthMiddleware("test-secret", nil, domain.StepUpPolicy{}, nil, nil),
+		scimhandler.NewSCIMHandler(nil),
+		scimmiddleware.NewSCIMMiddleware(nil),
+		consenthandler.NewConsentHandler(nil),
+		consentmiddleware.NewConsentMiddleware(nil),
+		privacyhandler.NewPrivacyHandler(nil),
+	)
+	require.NoError(t, err)
+
+	logout := func(t *testing.T, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/auth/logout", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("signed in", func(t *testing.T) {
+		userID := uuid.New()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": userID.String(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}).SignedString([]byte("test-secret"))
+		require.NoError(t, err)
+
+		w := logout(t, "Bearer "+token)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []uuid.UUID{userID}, auth.loggedOut)
+	})
+
+	t.Run("without token", func(t *testing.T) {
+		w := logout(t, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Len(t, auth.loggedOut, 1)
+	})
+}
@@ -5,9 +5,122 @@ import "time"
 type Config struct {
 	GoogleClientID     string
 	GoogleClientSecret string
-	JWTSecret         string
-	AccessTokenTTL    time.Duration
-	RefreshTokenTTL   time.Duration
+	JWTSecret          string
+	AccessTokenTTL     time.Duration
+	RefreshTokenTTL    time.Duration
+
+	// DPoP sender-constrained tokens: disabled, optional or required
+	DPoPMode string
+
+	// Step-up authentication of sensitive routes, see domain.StepUpPolicy
+	StepUpMaxAge time.Duration
+	StepUpACR    string
+
+	// Sign-in policy, see usecase.SignInPolicy
+	AllowedHostedDomains []string
+	AllowedEmailPatterns []string
+	DeniedEmailPatterns  []string
+	RequireVerifiedEmail bool
+
+	// Provisioning of users signing in for the first time
+	ProvisioningMode string
+	InvitationTTL    time.Duration
+	// How long a guest that doesn't sign in is kept, 0 disables guests
+	GuestTTL time.Duration
+
+	// How long AuthMiddleware caches a user's account status
+	AccountStatusCacheTTL time.Duration
+
+	// How long AuthMiddleware caches whether a token's session still exists
+	SessionCacheTTL time.Duration
+
+	// Profile fields where the provider value overwrites user edits
+	ProfileSyncProviderWins []string
+
+	// Apps with their own Google OAuth client ID and optional token TTLs
+	Clients []ClientConfig
+
+	// Sign in with Apple
+	AppleTeamID     string
+	AppleKeyID      string
+	ApplePrivateKey string
+	AppleBaseURL    string
+
+	// OpenID Connect providers
+	OIDCProviders []OIDCProviderConfig
+
+	// SAML service provider and identity providers
+	SAMLEntityID    string
+	SAMLBaseURL     string
+	SAMLCertificate string
+	SAMLPrivateKey  string
+	SAMLIdPs        []SAMLIdPConfig
+
+	// Public URL of the SCIM provisioning routes
+	SCIMBaseURL string
+
+	// Authorization server for third-party apps
+	OAuthIssuer     string
+	OAuthConsentURL string
+	OAuthSigningKey string
+
+	// Verification page of the device authorization grant
+	OAuthDeviceVerificationURL string
+
+	// Clients allowed to exchange tokens for downstream services
+	TokenExchangePolicies []TokenExchangePolicyConfig
+
+	// Documents users consent to, with their current versions
+	ConsentDocuments []ConsentDocumentConfig
+
+	// Data exports and account erasure
+	DataExportTTL      time.Duration
+	DataExportLinkTTL  time.Duration
+	ErasureGracePeriod time.Duration
+}
+
+// ClientConfig holds the configuration of one app (platform)
+type ClientConfig struct {
+	Name            string
+	GoogleClientID  string
+	AppleClientID   string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// OIDCProviderConfig holds the configuration of one OpenID Connect provider
+type OIDCProviderConfig struct {
+	Name          string
+	IssuerURL     string
+	ClientID      string
+	ClientSecret  string
+	Scopes        []string
+	ClaimMappings map[string]string
+}
+
+// TokenExchangePolicyConfig holds the audiences and scopes one OAuth client
+// may exchange user tokens for
+type TokenExchangePolicyConfig struct {
+	ClientID  string
+	Audiences []string
+	Scopes    []string
+	TokenTTL  time.Duration
+}
+
+// ConsentDocumentConfig holds the current version of one document users consent to
+type ConsentDocumentConfig struct {
+	Name     string
+	Version  string
+	URL      string
+	Required bool
+}
+
+// SAMLIdPConfig holds the configuration of one SAML identity provider
+type SAMLIdPConfig struct {
+	Name              string
+	MetadataXML       string
+	AttributeMappings map[string]string
+	TrustEmail        bool
 }
 
 func NewConfig(
@@ -20,8 +133,8 @@ func NewConfig(
 	return &Config{
 		GoogleClientID:     googleClientID,
 		GoogleClientSecret: googleClientSecret,
-		JWTSecret:         jwtSecret,
-		AccessTokenTTL:    accessTokenTTL,
-		RefreshTokenTTL:   refreshTokenTTL,
+		JWTSecret:          jwtSecret,
+		AccessTokenTTL:     accessTokenTTL,
+		RefreshTokenTTL:    refreshTokenTTL,
 	}
-} 
\ No newline at end of file
+}
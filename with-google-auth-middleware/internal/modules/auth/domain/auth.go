@@ -2,9 +2,14 @@ package domain
 
 import (
 	"context"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
 )
 
 // AuthToken represents the JWT token structure
@@ -14,6 +19,8 @@ type AuthToken struct {
 	ExpiresIn    int64     `json:"expires_in"`
 	RefreshToken string    `json:"refresh_token,omitempty"`
 	ExpiresAt    time.Time `json:"expires_at"`
+	// PendingConsents lists the documents the user must accept, set on login
+	PendingConsents []string `json:"pending_consents,omitempty"`
 }
 
 // GoogleUserInfo represents the user information from Google OAuth
@@ -21,6 +28,8 @@ type GoogleUserInfo struct {
 	ID            string `json:"id"`
 	Email         string `json:"email"`
 	VerifiedEmail bool   `json:"verified_email"`
+	EmailVerified bool   `json:"email_verified"`
+	HostedDomain  string `json:"hd"`
 	Name          string `json:"name"`
 	GivenName     string `json:"given_name"`
 	FamilyName    string `json:"family_name"`
@@ -28,28 +37,874 @@ type GoogleUserInfo struct {
 	Locale        string `json:"locale"`
 }
 
+// Identity providers
+const (
+	ProviderGoogle = "google"
+	ProviderApple  = "apple"
+)
+
+// ExternalIdentity is an identity asserted and verified by an identity provider
+type ExternalIdentity struct {
+	Provider       string
+	Subject        string
+	Email          string
+	EmailVerified  bool
+	HostedDomain   string
+	IsPrivateEmail bool
+	// RefreshToken is the provider's refresh token, if the login produced one
+	RefreshToken string
+	// Profile holds provider values for userdomain.ProfileFields
+	Profile map[string]string
+	// AuthTime is when the user authenticated at the provider, if it says so
+	AuthTime *time.Time
+	// AMR lists the authentication methods (RFC 8176) the provider asserted
+	AMR []string
+}
+
+// Authentication context classes (acr claim), from weakest to strongest
+const (
+	ACRSingleFactor = "single_factor"
+	ACRMultiFactor  = "multi_factor"
+)
+
+// ACRSatisfies reports whether a token of the given acr meets the minimum
+// class. Unknown classes satisfy nothing, an empty minimum is always met.
+func ACRSatisfies(acr, minimum string) bool {
+	levels := []string{ACRSingleFactor, ACRMultiFactor}
+	level, required := -1, -1
+	for i, class := range levels {
+		if class == acr {
+			level = i
+		}
+		if class == minimum {
+			required = i
+		}
+	}
+	return minimum == "" || (required >= 0 && level >= required)
+}
+
+// StepUpPolicy describes the authentication a sensitive route demands. A
+// token that doesn't meet it gets a "reauthentication required" error, and
+// the app signs the user in again (RFC 9470).
+type StepUpPolicy struct {
+	// MaxAge is how long ago the user may have authenticated, no limit when zero
+	MaxAge time.Duration
+	// ACR is the minimum authentication context class, any when empty
+	ACR string
+}
+
+// Principal is the caller of a request, set by AuthMiddleware from the claims
+// of the access token. It is the Principal services read with pkg/authn.
+type Principal = authn.Principal
+
+// ClaimsProvider lets a module add claims to the access tokens of our own
+// apps, e.g. the user's subscription tier. The claims are nested under the
+// provider's namespace, a top-level claim of its own.
+type ClaimsProvider struct {
+	Namespace string
+	// Claims returns the claims of the user's session, nil for none. It runs
+	// whenever an access token is issued, including refreshes.
+	Claims func(ctx context.Context, user *userdomain.User, session *Session) (map[string]interface{}, error)
+}
+
+// OIDCLoginRequest carries either the authorization code of a web login or
+// the ID token a native app obtained from an OpenID Connect provider
+type OIDCLoginRequest struct {
+	IDToken      string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	// State is the state of the authorization request, it looks up the nonce
+	// the ID token's nonce claim must match
+	State string
+}
+
+// SAML bindings used to send AuthnRequests
+const (
+	SAMLBindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	SAMLBindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+)
+
+// SAMLAuthnRequest is an AuthnRequest ready to be sent to the identity provider.
+// With the redirect binding URL already carries the request; with the POST
+// binding SAMLRequest and RelayState are posted to URL by the browser.
+type SAMLAuthnRequest struct {
+	Binding     string
+	URL         string
+	SAMLRequest string
+	RelayState  string
+}
+
+// SAMLMessage is the ID of a SAML message remembered until it expires: an
+// AuthnRequest we sent or an assertion we consumed
+type SAMLMessage struct {
+	Provider  string `gorm:"primaryKey"`
+	ID        string `gorm:"primaryKey"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// OIDCLogin is an OpenID Connect login started with an authorization URL,
+// remembered until the client logs in with the ID token or it expires
+type OIDCLogin struct {
+	Provider  string `gorm:"primaryKey"`
+	StateHash string `gorm:"primaryKey"` // SHA-256 of the state, hex encoded
+	Nonce     string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// TableName overrides the GORM default of "o_id_c_logins"
+func (OIDCLogin) TableName() string {
+	return "oidc_logins"
+}
+
+// GuestCredentials identify the guest a device used before signing in
+type GuestCredentials struct {
+	RefreshToken string // Refresh token of the guest session
+	DeviceID     string // Device the guest session was created for
+}
+
+// GuestUpgrade is what a guest signing in with a new account stores: the guest
+// becomes the user, the identity is linked to it and the guest session ends
+type GuestUpgrade struct {
+	User     *userdomain.User
+	Identity *Identity // The identity link to create
+	// StaleIdentityID is a link of the identity to a user that no longer exists
+	StaleIdentityID *uuid.UUID
+	// InvitationID is the pending invitation consumed, nil without one
+	InvitationID   *uuid.UUID
+	GuestSessionID uuid.UUID
+}
+
+// AppleLoginRequest carries what the app received from Sign in with Apple
+type AppleLoginRequest struct {
+	IdentityToken     string
+	AuthorizationCode string
+	// Apple only shares the user's name with the app on the first authorization
+	GivenName  string
+	FamilyName string
+}
+
+// Identity links a user to an account at an identity provider
+type Identity struct {
+	ID             uuid.UUID `json:"id"`
+	UserID         uuid.UUID `json:"user_id"`
+	Provider       string    `json:"provider"`
+	Subject        string    `json:"subject"`
+	Email          string    `json:"email"`
+	IsPrivateEmail bool      `json:"is_private_email"`
+	EmailDisabled  bool      `json:"email_disabled"` // The user stopped forwarding from their private relay email
+	RefreshToken   string    `json:"-"`              // Provider refresh token, used to revoke our access at the provider
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
 // Session represents a user's active session
 type Session struct {
-	ID           uuid.UUID `json:"id"`
-	UserID       uuid.UUID `json:"user_id"`
-	RefreshToken string    `json:"refresh_token"`
-	ExpiresAt    time.Time `json:"expires_at"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           uuid.UUID  `json:"id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	Client       string     `json:"client"`             // Name of the app (platform) or OAuth client ID the session was created from
+	GrantID      *uuid.UUID `json:"grant_id,omitempty"` // Set on sessions of third-party apps, revoking the grant deletes them
+	Scope        string     `json:"scope,omitempty"`    // Space separated scopes granted to a third-party app
+	RefreshToken string     `json:"refresh_token"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	// DPoPKeyThumbprint binds the session's tokens to the client's DPoP key, empty for bearer tokens
+	DPoPKeyThumbprint string `json:"-" gorm:"column:dpop_jkt"`
+
+	// How the user signed in, the session's access tokens carry it until the user signs in again
+	AuthTime time.Time `json:"auth_time"`
+	ACR      string    `json:"acr,omitempty"`
+	AMR      []string  `json:"amr,omitempty" gorm:"serializer:json"`
+
+	// OrganizationID is the organization the session's access tokens are switched to
+	OrganizationID *uuid.UUID `json:"org_id,omitempty" gorm:"column:org_id"`
+
+	// DeviceID identifies the device of a guest session, empty on other sessions
+	DeviceID string `json:"device_id,omitempty"`
+}
+
+// OAuthClient represents an application that authenticates at the OAuth
+// endpoints. Internal clients are our own services (API gateway, ...) and may
+// introspect tokens; third-party apps sign users in with the authorization
+// code grant.
+type OAuthClient struct {
+	ID           uuid.UUID  `json:"id"`
+	ClientID     string     `json:"client_id"`
+	SecretHash   string     `json:"-"`
+	Name         string     `json:"name"`
+	RedirectURIs []string   `json:"redirect_uris" gorm:"serializer:json"`
+	Public       bool       `json:"public"`   // Public clients (native and single-page apps) have no secret
+	Internal     bool       `json:"internal"` // Internal clients may introspect tokens and skip the consent step
+	CreatedBy    *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// HasRedirectURI reports whether uri exactly matches one of the client's redirect URIs
+func (c *OAuthClient) HasRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// TableName overrides the GORM default of "o_auth_clients"
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// Token type hints accepted by the introspection and revocation endpoints
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// TokenIntrospection represents an RFC 7662 introspection response
+type TokenIntrospection struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	// Cnf holds the DPoP key thumbprint of sender-constrained tokens
+	Cnf *TokenConfirmation `json:"cnf,omitempty"`
+}
+
+// TokenConfirmation is the cnf claim of a DPoP-bound token (RFC 9449)
+type TokenConfirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// DPoP modes
+const (
+	DPoPModeDisabled = "disabled" // Only bearer tokens are issued, DPoP proofs are ignored at sign-in
+	DPoPModeOptional = "optional" // Tokens are bound when the client signs in with a DPoP proof
+	DPoPModeRequired = "required" // Tokens of our own apps must be bound
+)
+
+// DPoPSigningAlgorithms are the asymmetric algorithms accepted for DPoP proofs
+var DPoPSigningAlgorithms = []string{"ES256", "ES384", "ES512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+
+// DPoPProofRequest is the part of a request a DPoP proof (RFC 9449) is checked against
+type DPoPProofRequest struct {
+	Proof  string // The DPoP header
+	Method string
+	URL    string // Request URL without query and fragment, compared with the htu claim
+	// Path is the request path. With a public base URL configured, the htu
+	// claim is compared with the base URL followed by the path instead of URL.
+	Path string
+	// AccessToken is the token presented with the proof at protected routes,
+	// compared with the ath claim
+	AccessToken string
+}
+
+// NewDPoPProofRequest describes the DPoP proof of an HTTP request. The URL is
+// the one the request was received at, X-Forwarded-* headers are ignored
+// because any client can set them.
+func NewDPoPProofRequest(r *http.Request, accessToken string) DPoPProofRequest {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return DPoPProofRequest{
+		// More than one DPoP header makes the proof invalid
+		Proof:       strings.Join(r.Header.Values("DPoP"), ","),
+		Method:      r.Method,
+		URL:         scheme + "://" + r.Host + r.URL.Path,
+		Path:        r.URL.Path,
+		AccessToken: accessToken,
+	}
+}
+
+// Scopes of the authorization server
+const (
+	ScopeOpenID        = "openid"
+	ScopeProfile       = "profile"
+	ScopeEmail         = "email"
+	ScopeOfflineAccess = "offline_access"
+)
+
+// ScopeCatalog maps the scopes an access token can carry to the description
+// shown on the consent page. Modules declare the scopes their routes require
+// and the catalog is assembled when the application is wired.
+type ScopeCatalog map[string]string
+
+// NewScopeCatalog merges the scopes declared by modules into one catalog
+func NewScopeCatalog(modules ...map[string]string) ScopeCatalog {
+	catalog := ScopeCatalog{}
+	for _, scopes := range modules {
+		for scope, description := range scopes {
+			catalog[scope] = description
+		}
+	}
+	return catalog
+}
+
+// Has reports whether the scope is in the catalog
+func (c ScopeCatalog) Has(scope string) bool {
+	_, ok := c[scope]
+	return ok
+}
+
+// Names returns the scopes of the catalog in alphabetical order
+func (c ScopeCatalog) Names() []string {
+	names := make([]string, 0, len(c))
+	for scope := range c {
+		names = append(names, scope)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Grant types accepted by the token endpoint
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// TokenTypeAccessToken identifies access tokens in token exchange requests (RFC 8693)
+const TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// RegisterClientRequest describes a third-party app to register
+type RegisterClientRequest struct {
+	Name         string   `json:"name" binding:"required"`
+	RedirectURIs []string `json:"redirect_uris" binding:"required"`
+	Public       bool     `json:"public"`
+}
+
+// RegisteredClient is a newly registered client. The secret is only returned once.
+type RegisteredClient struct {
+	*OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// OAuthGrant records the scopes a user has granted to a third-party app
+type OAuthGrant struct {
+	ID        uuid.UUID    `json:"id"`
+	UserID    uuid.UUID    `json:"user_id"`
+	ClientID  string       `json:"client_id"`
+	Client    *OAuthClient `json:"client,omitempty" gorm:"foreignKey:ClientID;references:ClientID"`
+	Scope     string       `json:"scope"` // Space separated
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// OAuthAuthorizationCode is an authorization code issued after the user's
+// consent. Only a hash of the code is stored.
+type OAuthAuthorizationCode struct {
+	ID            uuid.UUID
+	CodeHash      string
+	ClientID      string
+	UserID        uuid.UUID
+	GrantID       uuid.UUID
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string // S256 PKCE challenge
+	Nonce         string
+	AuthTime      time.Time
+	SessionID     *uuid.UUID // Session created when the code was exchanged
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
+	CreatedAt     time.Time
+}
+
+// TableName overrides the GORM default of "o_auth_authorization_codes"
+func (OAuthAuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
+
+// OAuthDeviceCode is a device authorization request (RFC 8628) of an app that
+// can't open a browser, waiting for the user to enter its user code
+type OAuthDeviceCode struct {
+	ID             uuid.UUID
+	DeviceCodeHash string
+	UserCode       string // Upper case letters without the dash
+	ClientID       string
+	Scope          string
+	UserID         *uuid.UUID // Set when the user approved
+	GrantID        *uuid.UUID // Set when the user approved
+	AuthTime       *time.Time
+	DeniedAt       *time.Time
+	PollInterval   int // Seconds the app has to wait between polls
+	LastPolledAt   *time.Time
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+}
+
+// TableName overrides the GORM default of "o_auth_device_codes"
+func (OAuthDeviceCode) TableName() string {
+	return "oauth_device_codes"
+}
+
+// OAuthDeviceAttempts counts the user codes a user entered that matched no
+// pending device, so that user codes can't be guessed
+type OAuthDeviceAttempts struct {
+	UserID      uuid.UUID `gorm:"primaryKey"`
+	Failures    int       // Failures since the count last restarted
+	FailedAt    time.Time // Time of the last failure
+	LockedUntil *time.Time
+}
+
+// TableName overrides the GORM default of "o_auth_device_attempts"
+func (OAuthDeviceAttempts) TableName() string {
+	return "oauth_device_attempts"
+}
+
+// DeviceAuthorizationRequest starts the device flow
+type DeviceAuthorizationRequest struct {
+	ClientID     string
+	ClientSecret string
+	Scope        string
+}
+
+// DeviceAuthorizationResponse tells the app which code the user enters where (RFC 8628 section 3.2)
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceVerification describes a pending device authorization to the user
+type DeviceVerification struct {
+	ClientID          string            `json:"client_id"`
+	ClientName        string            `json:"client_name"`
+	Scopes            []string          `json:"scopes"`
+	ScopeDescriptions map[string]string `json:"scope_descriptions"`
+}
+
+// DeviceDecision is the user's answer to a device authorization request
+type DeviceDecision struct {
+	UserID    uuid.UUID
+	SessionID uuid.UUID
+	UserCode  string
+	Approved  bool
+}
+
+// AuthorizationRequest holds the parameters of an authorization request
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	Prompt              string
+}
+
+// ConsentRequest describes a pending authorization request to the consent page
+type ConsentRequest struct {
+	ClientID    string   `json:"client_id"`
+	ClientName  string   `json:"client_name"`
+	RedirectURI string   `json:"redirect_uri"`
+	Scopes      []string `json:"scopes"`
+	// ScopeDescriptions explains each requested scope to the user
+	ScopeDescriptions map[string]string `json:"scope_descriptions"`
+	// ConsentRequired is false when the user already granted every scope, the
+	// consent page may then approve the request without asking
+	ConsentRequired bool `json:"consent_required"`
+}
+
+// ConsentDecision is the user's answer to an authorization request
+type ConsentDecision struct {
+	UserID       uuid.UUID
+	SessionID    uuid.UUID // The user's session, its auth_time is reported to the client
+	RequestToken string
+	Approved     bool
+}
+
+// TokenRequest holds the parameters of a token request
+type TokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	DeviceCode   string
+
+	// Token exchange (RFC 8693)
+	SubjectToken       string
+	SubjectTokenType   string
+	RequestedTokenType string
+	Audience           string
+	Scope              string
+}
+
+// TokenResponse represents an RFC 6749 access token response
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IssuedTokenType is set in token exchange responses
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// OpenIDConfiguration is the OpenID Connect discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint,omitempty"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// JSONWebKey is a single key of a JSON Web Key Set (RFC 7517)
+type JSONWebKey = authn.JSONWebKey
+
+// JSONWebKeySet is a JSON Web Key Set (RFC 7517)
+type JSONWebKeySet = authn.JSONWebKeySet
+
+// Provisioning modes decide what happens when an unknown account signs in
+const (
+	// ProvisioningOpen creates a user for every account that passes the sign-in policy
+	ProvisioningOpen = "open"
+	// ProvisioningInviteOnly only creates users that have a pending invitation
+	ProvisioningInviteOnly = "invite_only"
+	// ProvisioningClosed only lets existing users sign in
+	ProvisioningClosed = "closed"
+)
+
+// Invitation allows an email address to register with a preassigned role
+type Invitation struct {
+	ID         uuid.UUID  `json:"id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	InvitedBy  *uuid.UUID `json:"invited_by,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	AcceptedBy *uuid.UUID `json:"accepted_by,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// IsPending reports whether the invitation can still be accepted
+func (i *Invitation) IsPending() bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && time.Now().Before(i.ExpiresAt)
+}
+
+// Security event types
+const (
+	SecurityEventLogin          = "login"
+	SecurityEventRefresh        = "refresh"
+	SecurityEventLogout         = "logout"
+	SecurityEventSessionRevoked = "session_revoked"
+)
+
+// Security event outcomes
+const (
+	SecurityOutcomeSuccess = "success"
+	SecurityOutcomeFailure = "failure"
+)
+
+// ProviderGuest is the provider recorded for guest sign-ins
+const ProviderGuest = "guest"
+
+// SecurityEvent is an entry of a user's login history: a sign-in, refresh,
+// logout or session revocation, successful or not
+type SecurityEvent struct {
+	ID        uuid.UUID  `json:"id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	SessionID *uuid.UUID `json:"session_id,omitempty"`
+	Type      string     `json:"type"`
+	Outcome   string     `json:"outcome"`
+	// Reason is the error code of a failure, or why a session was revoked
+	Reason    string `json:"reason,omitempty"`
+	Provider  string `json:"provider,omitempty"` // Identity provider of a sign-in
+	Client    string `json:"client,omitempty"`   // App or OAuth client ID of the session
+	IPAddress string `json:"ip_address,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	DeviceID  string `json:"device_id,omitempty"`
+	// Device is the hash of the device ID, or of the user agent without one,
+	// sign-ins from a device the user hadn't signed in from are NewDevice
+	Device    string    `json:"-"`
+	NewDevice bool      `json:"new_device"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SecurityEventCursor is the position of the last event of a page, the next
+// page starts after it
+type SecurityEventCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// SecurityEventPage is a page of a user's security events, newest first
+type SecurityEventPage struct {
+	Events []*SecurityEvent `json:"events"`
+	// NextCursor is passed as cursor to get the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// SecurityEventSubscriber lets a module react to the security events of
+// users, e.g. email them about a sign-in from a new device. Notify runs in
+// the request after the event is stored, so slow work belongs in a goroutine,
+// and can't fail the sign-in.
+type SecurityEventSubscriber struct {
+	Name   string
+	Notify func(ctx context.Context, event *SecurityEvent)
+}
+
+// RequestInfo is the client of a request, recorded with security events
+type RequestInfo struct {
+	IPAddress string
+	UserAgent string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a context carrying the client of the request
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext returns the client of the request, empty outside of requests
+func RequestInfoFromContext(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
 }
 
 // AuthRepository defines the interface for auth data access
 type AuthRepository interface {
 	CreateSession(session *Session) error
+	GetSessionByID(id uuid.UUID) (*Session, error)
 	GetSessionByRefreshToken(refreshToken string) (*Session, error)
 	DeleteSession(id uuid.UUID) error
 	DeleteUserSessions(userID uuid.UUID) error
+	// SetSessionOrganization switches the session to an organization, nil switches it back to the personal account
+	SetSessionOrganization(id uuid.UUID, orgID *uuid.UUID) error
+	// UpgradeGuest stores a guest upgrade in one transaction. It returns
+	// gorm.ErrRecordNotFound and stores nothing if the invitation was accepted
+	// or revoked in the meantime.
+	UpgradeGuest(upgrade *GuestUpgrade) error
+	GetClientByClientID(clientID string) (*OAuthClient, error)
+}
+
+// IdentityRepository defines the interface for identity data access
+type IdentityRepository interface {
+	Create(identity *Identity) error
+	FindByProviderSubject(provider, subject string) (*Identity, error)
+	Update(identity *Identity) error
+	Delete(id uuid.UUID) error
+}
+
+// InvitationRepository defines the interface for invitation data access
+type InvitationRepository interface {
+	Create(invitation *Invitation) error
+	FindPendingByEmail(email string) (*Invitation, error)
+	GetAll(page, limit int) ([]*Invitation, error)
+	// Accept consumes a pending invitation for the user and stores the user in
+	// the same transaction, creating it if create is set. It returns
+	// gorm.ErrRecordNotFound and stores nothing if the invitation was accepted
+	// or revoked in the meantime.
+	Accept(id uuid.UUID, user *userdomain.User, create bool) error
+	Revoke(id uuid.UUID) error
+}
+
+// OAuthRepository defines the interface for authorization server data access
+type OAuthRepository interface {
+	CreateClient(client *OAuthClient) error
+	GetClients(page, limit int) ([]*OAuthClient, error)
+	DeleteClient(clientID string) error
+	CreateGrant(grant *OAuthGrant) error
+	UpdateGrant(grant *OAuthGrant) error
+	FindGrant(userID uuid.UUID, clientID string) (*OAuthGrant, error)
+	FindGrantByID(id uuid.UUID) (*OAuthGrant, error)
+	GetUserGrants(userID uuid.UUID) ([]*OAuthGrant, error)
+	DeleteGrant(id uuid.UUID) error
+	CreateAuthorizationCode(code *OAuthAuthorizationCode) error
+	FindAuthorizationCode(codeHash string) (*OAuthAuthorizationCode, error)
+	// MarkAuthorizationCodeUsed reports false if the code was already used
+	MarkAuthorizationCodeUsed(id uuid.UUID) (bool, error)
+	SetAuthorizationCodeSession(id, sessionID uuid.UUID) error
+	CreateDeviceCode(code *OAuthDeviceCode) error
+	FindDeviceCode(deviceCodeHash string) (*OAuthDeviceCode, error)
+	FindDeviceCodeByUserCode(userCode string) (*OAuthDeviceCode, error)
+	// DecideDeviceCode stores the user's decision and reports false if the code was already decided
+	DecideDeviceCode(code *OAuthDeviceCode) (bool, error)
+	UpdateDeviceCodePoll(id uuid.UUID, polledAt time.Time, interval int) error
+	// DeleteDeviceCode reports false if the code was already deleted
+	DeleteDeviceCode(id uuid.UUID) (bool, error)
+	DeleteExpiredDeviceCodes(before time.Time) error
+	// FindDeviceAttempts returns gorm.ErrRecordNotFound for users without failures
+	FindDeviceAttempts(userID uuid.UUID) (*OAuthDeviceAttempts, error)
+	// AddDeviceFailure counts a failure of the user, restarting the count when
+	// the last failure is before since, and returns the updated attempts
+	AddDeviceFailure(userID uuid.UUID, failedAt, since time.Time) (*OAuthDeviceAttempts, error)
+	// LockDeviceAttempts locks the user out until the time and restarts the count
+	LockDeviceAttempts(userID uuid.UUID, until time.Time) error
+	DeleteDeviceAttempts(userID uuid.UUID) error
+}
+
+// SAMLMessageRepository persists the IDs of SAML messages, so that every
+// instance knows the AuthnRequests sent and the assertions consumed by the others
+type SAMLMessageRepository interface {
+	CreateRequest(request *SAMLMessage) error
+	// TakeRequest deletes the unexpired AuthnRequest and reports false if there was none
+	TakeRequest(provider, id string, now time.Time) (bool, error)
+	// UseAssertion stores the assertion and reports false if it was used before
+	UseAssertion(assertion *SAMLMessage) (bool, error)
+	DeleteExpired(before time.Time) error
+}
+
+// OIDCLoginRepository persists the pending OpenID Connect logins, so that a
+// login started on one instance can finish on another
+type OIDCLoginRepository interface {
+	Create(login *OIDCLogin) error
+	// Take deletes the unexpired login and returns it, nil if there was none
+	Take(provider, stateHash string, now time.Time) (*OIDCLogin, error)
+	DeleteExpired(before time.Time) error
+}
+
+// DPoPVerifier verifies DPoP proofs. It is shared by the token endpoints and
+// AuthMiddleware so that a proof can only be used once.
+type DPoPVerifier interface {
+	Mode() string
+	// VerifyProof verifies a DPoP proof and returns the JWK thumbprint of its key
+	VerifyProof(req DPoPProofRequest) (string, error)
+}
+
+// SecurityEventRepository defines the interface for security event data access
+type SecurityEventRepository interface {
+	Create(event *SecurityEvent) error
+	// ListByUser returns up to limit events of the user, newest first, after the cursor if any
+	ListByUser(userID uuid.UUID, after *SecurityEventCursor, limit int) ([]*SecurityEvent, error)
+	// HasLogin reports whether the user signed in successfully before, from the
+	// device if it isn't empty
+	HasLogin(userID uuid.UUID, device string) (bool, error)
+}
+
+// AccountDeactivated is the account status AccountStatusLookup reports for
+// users deactivated by a provisioning client or deleted. Other statuses are
+// the user module's.
+const AccountDeactivated = "deactivated"
+
+// AccountStatusLookup looks up the account status of users presenting access
+// tokens, so that suspending a user takes effect before their tokens expire
+type AccountStatusLookup interface {
+	AccountStatus(ctx context.Context, userID uuid.UUID) (string, error)
+}
+
+// SessionLookup reports whether the session of an access token still exists,
+// so that logging out or revoking a session takes effect before its tokens
+// expire
+type SessionLookup interface {
+	SessionActive(ctx context.Context, sessionID uuid.UUID) (bool, error)
+}
+
+// OrgMemberships looks up the user's role in an organization for org-scoped
+// access tokens. It is implemented by the organization module.
+type OrgMemberships interface {
+	// MemberRole returns an empty role if the user isn't a member
+	MemberRole(ctx context.Context, orgID, userID uuid.UUID) (string, error)
+}
+
+// ConsentLookup finds the required documents a user hasn't accepted the
+// current version of. It is implemented by the consent module.
+type ConsentLookup interface {
+	PendingConsents(ctx context.Context, userID uuid.UUID) ([]string, error)
 }
 
 // AuthUsecase defines the interface for auth business logic
 type AuthUsecase interface {
-	LoginWithGoogleIDToken(ctx context.Context, idToken string) (*AuthToken, error)
-	RefreshToken(ctx context.Context, refreshToken string) (*AuthToken, error)
+	// LoginWithGoogleIDToken binds the session to the key of the DPoP proof, if
+	// the request has one. With the credentials of the device's guest, the guest
+	// is upgraded to the Google account, or merged into its existing user.
+	LoginWithGoogleIDToken(ctx context.Context, idToken string, guest GuestCredentials, dpop DPoPProofRequest) (*AuthToken, error)
+	// LoginAsGuest creates an anonymous user and session for a device
+	LoginAsGuest(ctx context.Context, deviceID string, dpop DPoPProofRequest) (*AuthToken, error)
+	LoginWithApple(ctx context.Context, req AppleLoginRequest) (*AuthToken, error)
+	HandleAppleNotification(ctx context.Context, payload string) error
+	OIDCAuthorizationURL(ctx context.Context, provider, redirectURI, state, codeChallenge string) (string, error)
+	LoginWithOIDC(ctx context.Context, provider string, req OIDCLoginRequest) (*AuthToken, error)
+	SAMLMetadata(ctx context.Context, provider string) ([]byte, error)
+	StartSAMLLogin(ctx context.Context, provider, relayState string) (*SAMLAuthnRequest, error)
+	LoginWithSAML(ctx context.Context, provider, samlResponse string) (*AuthToken, error)
+	// RefreshToken requires a DPoP proof of the session's key for bound sessions
+	RefreshToken(ctx context.Context, refreshToken string, dpop DPoPProofRequest) (*AuthToken, error)
 	Logout(ctx context.Context, userID uuid.UUID) error
+	// SwitchOrganization switches the session to an organization the user is a
+	// member of, or back to the personal account if orgID is nil, and returns a
+	// new access token carrying it
+	SwitchOrganization(ctx context.Context, userID, sessionID uuid.UUID, orgID *uuid.UUID) (*AuthToken, error)
 	ValidateToken(ctx context.Context, token string) (*AuthToken, error)
-} 
\ No newline at end of file
+}
+
+// OAuthUsecase defines the interface for the OAuth endpoints used by other services
+type OAuthUsecase interface {
+	AuthenticateClient(ctx context.Context, clientID, clientSecret string) (*OAuthClient, error)
+	Introspect(ctx context.Context, token, tokenTypeHint string) (*TokenIntrospection, error)
+	// Revoke revokes a token. Clients that aren't internal may only revoke their own tokens.
+	Revoke(ctx context.Context, client *OAuthClient, token, tokenTypeHint string) error
+}
+
+// AuthorizationServerUsecase defines the interface for the OAuth 2.0 / OpenID
+// Connect authorization server used by third-party apps
+type AuthorizationServerUsecase interface {
+	RegisterClient(ctx context.Context, createdBy uuid.UUID, req RegisterClientRequest) (*RegisteredClient, error)
+	ListClients(ctx context.Context, page, limit int) ([]*OAuthClient, error)
+	DeleteClient(ctx context.Context, clientID string) error
+	// Authorize validates an authorization request and returns the URL the user
+	// agent is redirected to: the consent page, or the client's redirect URI
+	// with an error. Requests with an unknown client or redirect URI fail.
+	Authorize(ctx context.Context, req AuthorizationRequest) (string, error)
+	ConsentRequest(ctx context.Context, userID uuid.UUID, requestToken string) (*ConsentRequest, error)
+	// Consent records the user's decision and returns the client redirect URI
+	// with the authorization code or an access_denied error
+	Consent(ctx context.Context, decision ConsentDecision) (string, error)
+	Token(ctx context.Context, req TokenRequest) (*TokenResponse, error)
+	// DeviceAuthorization starts the device flow for apps that can't open a browser
+	DeviceAuthorization(ctx context.Context, req DeviceAuthorizationRequest) (*DeviceAuthorizationResponse, error)
+	DeviceVerification(ctx context.Context, userID uuid.UUID, userCode string) (*DeviceVerification, error)
+	// VerifyDevice records the user's decision, the app receives tokens on its next poll
+	VerifyDevice(ctx context.Context, decision DeviceDecision) error
+	UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error)
+	ListGrants(ctx context.Context, userID uuid.UUID) ([]*OAuthGrant, error)
+	RevokeGrant(ctx context.Context, userID, grantID uuid.UUID) error
+	OpenIDConfiguration() *OpenIDConfiguration
+	JSONWebKeySet() (*JSONWebKeySet, error)
+}
+
+// SecurityEventUsecase defines the interface for reading users' login history
+type SecurityEventUsecase interface {
+	// ListEvents returns a page of the user's security events, newest first.
+	// An empty cursor starts at the newest event.
+	ListEvents(ctx context.Context, userID uuid.UUID, cursor string, limit int) (*SecurityEventPage, error)
+}
+
+// InvitationUsecase defines the interface for managing invitations
+type InvitationUsecase interface {
+	CreateInvitation(ctx context.Context, invitedBy uuid.UUID, email, role string) (*Invitation, error)
+	ListInvitations(ctx context.Context, page, limit int) ([]*Invitation, error)
+	RevokeInvitation(ctx context.Context, id uuid.UUID) error
+}
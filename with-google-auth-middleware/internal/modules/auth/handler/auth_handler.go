@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/usecase"
 )
 
 type AuthHandler struct {
@@ -20,14 +22,19 @@ func NewAuthHandler(authUsecase domain.AuthUsecase) *AuthHandler {
 
 // LoginWithGoogle handles Google OAuth login for mobile applications only
 // @Summary Login with Google (Mobile)
-// @Description Authenticate user with Google OAuth using ID token
+// @Description Authenticate user with Google OAuth using ID token. With a DPoP proof the issued tokens are bound to the proof's key. With the refresh token of the device's guest, the guest is upgraded to the Google account or merged into its existing user.
 // @Tags auth
 // @Accept application/x-www-form-urlencoded
 // @Produce json
 // @Param id_token formData string true "Google ID token"
+// @Param guest_refresh_token formData string false "Refresh token of the device's guest session"
+// @Param device_id formData string false "Device ID the guest session was created for"
+// @Param DPoP header string false "DPoP proof (RFC 9449)"
 // @Success 200 {object} domain.AuthToken
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /auth/google [post]
 func (h *AuthHandler) LoginWithGoogle(c *gin.Context) {
@@ -39,27 +46,355 @@ func (h *AuthHandler) LoginWithGoogle(c *gin.Context) {
 		return
 	}
 
-	token, err := h.authUsecase.LoginWithGoogleIDToken(c.Request.Context(), idToken)
+	guest := domain.GuestCredentials{
+		RefreshToken: c.PostForm("guest_refresh_token"),
+		DeviceID:     c.PostForm("device_id"),
+	}
+	token, err := h.authUsecase.LoginWithGoogleIDToken(c.Request.Context(), idToken, guest, domain.NewDPoPProofRequest(c.Request, ""))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Error: "Failed to authenticate with Google",
+		if respondDPoPError(c, err) {
+			return
+		}
+		if errors.Is(err, usecase.ErrInvalidGuestToken) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "Invalid guest token",
+				Code:  ErrCodeInvalidGuestToken,
+			})
+			return
+		}
+		if !respondSignInError(c, err) {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Failed to authenticate with Google",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// LoginAsGuest handles anonymous sign-in
+// @Summary Login as guest
+// @Description Create an anonymous user and session for a device. Signing in with Google later, with the guest's refresh token and device ID, keeps the guest's data.
+// @Tags auth
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Param device_id formData string true "ID of the device, stable across app launches"
+// @Param DPoP header string false "DPoP proof (RFC 9449)"
+// @Success 200 {object} domain.AuthToken
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /auth/guest [post]
+func (h *AuthHandler) LoginAsGuest(c *gin.Context) {
+	token, err := h.authUsecase.LoginAsGuest(c.Request.Context(), c.PostForm("device_id"), domain.NewDPoPProofRequest(c.Request, ""))
+	if err != nil {
+		if respondDPoPError(c, err) || respondSignInError(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, usecase.ErrGuestsDisabled):
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "Guest accounts are not available",
+			})
+		case errors.Is(err, usecase.ErrInvalidDeviceID):
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "A device ID of at most 255 characters is required",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Failed to create guest",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// LoginWithApple handles Sign in with Apple
+// @Summary Login with Apple
+// @Description Authenticate user with the identity token and authorization code returned by Sign in with Apple
+// @Tags auth
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Param id_token formData string true "Apple identity token"
+// @Param code formData string false "Apple authorization code"
+// @Param given_name formData string false "Given name, only shared by Apple on the first authorization"
+// @Param family_name formData string false "Family name, only shared by Apple on the first authorization"
+// @Success 200 {object} domain.AuthToken
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /auth/apple [post]
+func (h *AuthHandler) LoginWithApple(c *gin.Context) {
+	req := domain.AppleLoginRequest{
+		IdentityToken:     c.PostForm("id_token"),
+		AuthorizationCode: c.PostForm("code"),
+		GivenName:         c.PostForm("given_name"),
+		FamilyName:        c.PostForm("family_name"),
+	}
+	if req.IdentityToken == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "ID token is required",
 		})
 		return
 	}
 
+	token, err := h.authUsecase.LoginWithApple(c.Request.Context(), req)
+	if err != nil {
+		if errors.Is(err, usecase.ErrAppleNotConfigured) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "Sign in with Apple is not available",
+			})
+			return
+		}
+		if !respondSignInError(c, err) {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Failed to authenticate with Apple",
+			})
+		}
+		return
+	}
+
 	c.JSON(http.StatusOK, token)
 }
 
+// AuthorizeWithOIDC starts the authorization code flow at an OpenID Connect provider
+// @Summary Start OpenID Connect login
+// @Description Redirect to the authorization endpoint of a configured OpenID Connect provider
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Param redirect_uri query string true "Redirect URI registered at the provider"
+// @Param state query string true "Random value returned to the redirect URI, sent again on login"
+// @Param code_challenge query string false "PKCE S256 code challenge"
+// @Success 302
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /auth/oidc/{provider}/authorize [get]
+func (h *AuthHandler) AuthorizeWithOIDC(c *gin.Context) {
+	redirectURI := c.Query("redirect_uri")
+	if redirectURI == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Redirect URI is required",
+		})
+		return
+	}
+	state := c.Query("state")
+	if state == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "State is required",
+		})
+		return
+	}
+
+	authURL, err := h.authUsecase.OIDCAuthorizationURL(c.Request.Context(), c.Param("provider"), redirectURI, state, c.Query("code_challenge"))
+	if err != nil {
+		if errors.Is(err, usecase.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "Identity provider not found",
+			})
+			return
+		}
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error: "Identity provider is unavailable",
+		})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// LoginWithOIDC handles login with an OpenID Connect provider
+// @Summary Login with OpenID Connect
+// @Description Authenticate user with an authorization code or ID token from a configured OpenID Connect provider
+// @Tags auth
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code formData string false "Authorization code, requires redirect_uri"
+// @Param redirect_uri formData string false "Redirect URI the code was issued for"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param id_token formData string false "ID token obtained by a native app"
+// @Param state formData string true "State of the authorization request"
+// @Success 200 {object} domain.AuthToken
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /auth/oidc/{provider} [post]
+func (h *AuthHandler) LoginWithOIDC(c *gin.Context) {
+	req := domain.OIDCLoginRequest{
+		IDToken:      c.PostForm("id_token"),
+		Code:         c.PostForm("code"),
+		RedirectURI:  c.PostForm("redirect_uri"),
+		CodeVerifier: c.PostForm("code_verifier"),
+		State:        c.PostForm("state"),
+	}
+	if req.Code == "" && req.IDToken == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Authorization code or ID token is required",
+		})
+		return
+	}
+	if req.State == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "State is required",
+		})
+		return
+	}
+	if req.Code != "" && req.RedirectURI == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Redirect URI is required",
+		})
+		return
+	}
+
+	token, err := h.authUsecase.LoginWithOIDC(c.Request.Context(), c.Param("provider"), req)
+	if err != nil {
+		if errors.Is(err, usecase.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "Identity provider not found",
+			})
+			return
+		}
+		if !respondSignInError(c, err) {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Failed to authenticate with identity provider",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// AppleNotificationRequest is the body of an Apple server-to-server notification
+type AppleNotificationRequest struct {
+	Payload string `json:"payload" binding:"required"`
+}
+
+// AppleNotification handles Sign in with Apple server-to-server notifications
+// @Summary Apple server-to-server notification
+// @Description Receives email forwarding changes, consent revocations and account deletions from Apple
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body AppleNotificationRequest true "Signed notification payload"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /auth/apple/notifications [post]
+func (h *AuthHandler) AppleNotification(c *gin.Context) {
+	var req AppleNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Payload is required",
+		})
+		return
+	}
+
+	if err := h.authUsecase.HandleAppleNotification(c.Request.Context(), req.Payload); err != nil {
+		if errors.Is(err, usecase.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "Invalid notification payload",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to process notification",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Message: "Notification processed",
+	})
+}
+
+// respondSignInError writes the 403 response for sign-in and provisioning
+// rejections, or the 409 response for an email taken by another account, and
+// reports whether err was one of them
+func respondSignInError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, usecase.ErrSignInNotAllowed):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: "Your account is not allowed to sign in",
+			Code:  ErrCodeSignInNotAllowed,
+		})
+	case errors.Is(err, usecase.ErrRegistrationClosed):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: "Registration is closed",
+			Code:  ErrCodeRegistrationClosed,
+		})
+	case errors.Is(err, usecase.ErrInvitationRequired):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: "An invitation is required to register",
+			Code:  ErrCodeInvitationRequired,
+		})
+	case errors.Is(err, usecase.ErrAccountDeactivated):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: "Your account is deactivated",
+			Code:  ErrCodeAccountDeactivated,
+		})
+	case errors.Is(err, usecase.ErrAccountSuspended):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: "Your account is suspended",
+			Code:  ErrCodeAccountSuspended,
+		})
+	case errors.Is(err, usecase.ErrAccountBanned):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: "Your account is banned",
+			Code:  ErrCodeAccountBanned,
+		})
+	case errors.Is(err, usecase.ErrAccountPending):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: "Your account is awaiting approval",
+			Code:  ErrCodeAccountPending,
+		})
+	case errors.Is(err, usecase.ErrEmailInUse):
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error: "An account with this email already exists, verify your email with the provider to sign in",
+			Code:  ErrCodeEmailInUse,
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// respondDPoPError writes the 400 response for missing or invalid DPoP proofs
+// and reports whether err was one of them
+func respondDPoPError(c *gin.Context, err error) bool {
+	if !errors.Is(err, usecase.ErrInvalidDPoPProof) && !errors.Is(err, usecase.ErrDPoPProofRequired) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, ErrorResponse{
+		Error: "Invalid DPoP proof",
+		Code:  ErrCodeInvalidDPoPProof,
+	})
+	return true
+}
+
 // RefreshToken handles token refresh
 // @Summary Refresh access token
-// @Description Get a new access token using refresh token
+// @Description Get a new access token using refresh token. Refresh tokens bound with DPoP require a proof of the same key.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param refresh_token query string true "Refresh token"
+// @Param DPoP header string false "DPoP proof (RFC 9449)"
 // @Success 200 {object} domain.AuthToken
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /auth/refresh [post]
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
@@ -71,8 +406,11 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	token, err := h.authUsecase.RefreshToken(c.Request.Context(), refreshToken)
+	token, err := h.authUsecase.RefreshToken(c.Request.Context(), refreshToken, domain.NewDPoPProofRequest(c.Request, ""))
 	if err != nil {
+		if respondDPoPError(c, err) || respondSignInError(c, err) {
+			return
+		}
 		c.JSON(http.StatusUnauthorized, ErrorResponse{
 			Error: "Invalid refresh token",
 		})
@@ -114,9 +452,91 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 	})
 }
 
+// SwitchOrganizationRequest represents the body of an organization switch. A
+// missing org_id switches back to the personal account.
+type SwitchOrganizationRequest struct {
+	OrgID *uuid.UUID `json:"org_id"`
+}
+
+// SwitchOrganization handles switching the session to an organization
+// @Summary Switch organization
+// @Description Switch the current session to an organization the user is a member of, or back to the personal account without an org_id. The new access token carries the org_id and org_role claims, and so do the session's refreshed tokens.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body SwitchOrganizationRequest false "Organization"
+// @Success 200 {object} domain.AuthToken
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /auth/switch-org [post]
+func (h *AuthHandler) SwitchOrganization(c *gin.Context) {
+	var req SwitchOrganizationRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: err.Error(),
+			})
+			return
+		}
+	}
+
+	userID, _ := c.Get("user_id")
+	sessionID, ok := c.Get("session_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Token has no session",
+		})
+		return
+	}
+
+	token, err := h.authUsecase.SwitchOrganization(c.Request.Context(), userID.(uuid.UUID), sessionID.(uuid.UUID), req.OrgID)
+	if err != nil {
+		if respondSignInError(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, usecase.ErrNotOrgMember):
+			c.JSON(http.StatusForbidden, ErrorResponse{
+				Error: "You are not a member of this organization",
+				Code:  ErrCodeNotOrgMember,
+			})
+		case errors.Is(err, usecase.ErrInvalidToken):
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Invalid session",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Failed to switch organization",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// Machine readable error codes returned in ErrorResponse.Code
+const (
+	ErrCodeSignInNotAllowed   = "sign_in_not_allowed"
+	ErrCodeRegistrationClosed = "registration_closed"
+	ErrCodeInvitationRequired = "invitation_required"
+	ErrCodeAccountDeactivated = "account_deactivated"
+	ErrCodeAccountSuspended   = "account_suspended"
+	ErrCodeAccountBanned      = "account_banned"
+	ErrCodeAccountPending     = "account_pending"
+	ErrCodeInvalidDPoPProof   = "invalid_dpop_proof"
+	ErrCodeNotOrgMember       = "not_org_member"
+	ErrCodeInvalidGuestToken  = "invalid_guest_token"
+	ErrCodeEmailInUse         = "email_in_use"
+)
+
 // ErrorResponse represents an error response
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
 }
 
 // SuccessResponse represents a success response
@@ -129,7 +549,21 @@ func (h *AuthHandler) RegisterRoutes(router *gin.RouterGroup) {
 	group := router.Group("/auth")
 	{
 		group.POST("/google", h.LoginWithGoogle)
+		group.POST("/guest", h.LoginAsGuest)
+		group.POST("/apple", h.LoginWithApple)
+		group.POST("/apple/notifications", h.AppleNotification)
+		group.GET("/oidc/:provider/authorize", h.AuthorizeWithOIDC)
+		group.POST("/oidc/:provider", h.LoginWithOIDC)
+		group.GET("/saml/:provider/metadata", h.SAMLMetadata)
+		group.GET("/saml/:provider/login", h.StartSAMLLogin)
+		group.POST("/saml/:provider/acs", h.SAMLAssertionConsumerService)
 		group.POST("/refresh", h.RefreshToken)
-		group.POST("/logout", h.Logout)
 	}
-} 
\ No newline at end of file
+}
+
+// RegisterSessionRoutes registers the auth routes of signed-in users. The
+// router group must require a first-party access token.
+func (h *AuthHandler) RegisterSessionRoutes(router *gin.RouterGroup) {
+	router.POST("/auth/logout", h.Logout)
+	router.POST("/auth/switch-org", h.SwitchOrganization)
+}
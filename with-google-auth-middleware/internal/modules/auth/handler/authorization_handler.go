@@ -0,0 +1,656 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/usecase"
+)
+
+// AuthorizationHandler serves the OAuth 2.0 / OpenID Connect authorization
+// server used by third-party apps
+type AuthorizationHandler struct {
+	authorizationServer domain.AuthorizationServerUsecase
+}
+
+func NewAuthorizationHandler(authorizationServer domain.AuthorizationServerUsecase) *AuthorizationHandler {
+	return &AuthorizationHandler{
+		authorizationServer: authorizationServer,
+	}
+}
+
+// Authorize handles authorization requests of third-party apps
+// @Summary Start an authorization request
+// @Description Validate an authorization code request (PKCE with S256 is required) and redirect to the consent page. Errors are redirected to the client's redirect_uri, unless the client or redirect_uri is unknown.
+// @Tags oauth
+// @Param response_type query string true "code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string true "Registered redirect URI"
+// @Param scope query string false "Space separated scopes, defaults to openid"
+// @Param state query string false "Opaque value returned to the client"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "S256"
+// @Param nonce query string false "Value copied into the ID token"
+// @Param prompt query string false "consent to ask the user again"
+// @Success 302
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 500 {object} OAuthErrorResponse
+// @Router /oauth/authorize [get]
+func (h *AuthorizationHandler) Authorize(c *gin.Context) {
+	redirectURL, err := h.authorizationServer.Authorize(c.Request.Context(), domain.AuthorizationRequest{
+		ResponseType:        c.Query("response_type"),
+		ClientID:            c.Query("client_id"),
+		RedirectURI:         c.Query("redirect_uri"),
+		Scope:               c.Query("scope"),
+		State:               c.Query("state"),
+		CodeChallenge:       c.Query("code_challenge"),
+		CodeChallengeMethod: c.Query("code_challenge_method"),
+		Nonce:               c.Query("nonce"),
+		Prompt:              c.Query("prompt"),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrInvalidClient):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{
+				Error:            "invalid_request",
+				ErrorDescription: "unknown client_id",
+			})
+		case errors.Is(err, usecase.ErrInvalidRedirectURI):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{
+				Error:            "invalid_request",
+				ErrorDescription: "redirect_uri is not registered for the client",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, OAuthErrorResponse{
+				Error: "server_error",
+			})
+		}
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// GetConsentRequest handles loading an authorization request for the consent page
+// @Summary Get an authorization request
+// @Description Describe a pending authorization request to the consent page. consent_required is false when the user already granted every scope.
+// @Tags oauth
+// @Produce json
+// @Security BearerAuth
+// @Param request query string true "Request parameter the consent page was opened with"
+// @Success 200 {object} domain.ConsentRequest
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /oauth/consent [get]
+func (h *AuthorizationHandler) GetConsentRequest(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	req, err := h.authorizationServer.ConsentRequest(c.Request.Context(), userID.(uuid.UUID), c.Query("request"))
+	if err != nil {
+		h.respondConsentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+// ConsentDecisionRequest represents the user's answer on the consent page
+type ConsentDecisionRequest struct {
+	Request  string `json:"request" binding:"required"`
+	Approved bool   `json:"approved"`
+}
+
+// ConsentResponse tells the consent page where to send the user agent
+type ConsentResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// Consent handles the user's decision on an authorization request
+// @Summary Approve or deny an authorization request
+// @Description Record the user's decision. The consent page redirects the user agent to redirect_to, which carries the authorization code or an access_denied error.
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body ConsentDecisionRequest true "Decision"
+// @Success 200 {object} ConsentResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /oauth/consent [post]
+func (h *AuthorizationHandler) Consent(c *gin.Context) {
+	var req ConsentDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	sessionID, _ := c.Get("session_id")
+	decision := domain.ConsentDecision{
+		UserID:       userID.(uuid.UUID),
+		RequestToken: req.Request,
+		Approved:     req.Approved,
+	}
+	if sessionID, ok := sessionID.(uuid.UUID); ok {
+		decision.SessionID = sessionID
+	}
+
+	redirectTo, err := h.authorizationServer.Consent(c.Request.Context(), decision)
+	if err != nil {
+		h.respondConsentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ConsentResponse{
+		RedirectTo: redirectTo,
+	})
+}
+
+func (h *AuthorizationHandler) respondConsentError(c *gin.Context, err error) {
+	if errors.Is(err, usecase.ErrInvalidAuthorizationRequest) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid or expired authorization request",
+		})
+		return
+	}
+	if respondSignInError(c, err) {
+		return
+	}
+	c.JSON(http.StatusInternalServerError, ErrorResponse{
+		Error: "Failed to process authorization request",
+	})
+}
+
+// Token handles token requests of third-party apps
+// @Summary Exchange an authorization code, device code, refresh token or access token
+// @Description Issue an access token, an ID token for the openid scope and a refresh token for the offline_access scope. Confidential clients authenticate with HTTP Basic or client_secret_post, public clients send their client_id. Clients with a token exchange policy exchange access tokens for short-lived tokens of a downstream service (RFC 8693). Devices poll with their device code until the user decides, getting authorization_pending or slow_down meanwhile (RFC 8628).
+// @Tags oauth
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Security BasicAuth
+// @Param grant_type formData string true "authorization_code, refresh_token, urn:ietf:params:oauth:grant-type:token-exchange or urn:ietf:params:oauth:grant-type:device_code"
+// @Param code formData string false "Authorization code"
+// @Param device_code formData string false "Device code"
+// @Param redirect_uri formData string false "Redirect URI of the authorization request"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "Refresh token"
+// @Param subject_token formData string false "Token to exchange"
+// @Param subject_token_type formData string false "urn:ietf:params:oauth:token-type:access_token"
+// @Param requested_token_type formData string false "urn:ietf:params:oauth:token-type:access_token"
+// @Param audience formData string false "Service the exchanged token is for"
+// @Param scope formData string false "Space separated scopes of the exchanged token"
+// @Param client_id formData string false "Client ID"
+// @Param client_secret formData string false "Client secret"
+// @Success 200 {object} domain.TokenResponse
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Failure 500 {object} OAuthErrorResponse
+// @Router /oauth/token [post]
+func (h *AuthorizationHandler) Token(c *gin.Context) {
+	clientID, clientSecret := clientCredentials(c)
+	token, err := h.authorizationServer.Token(c.Request.Context(), domain.TokenRequest{
+		GrantType:    c.PostForm("grant_type"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Code:         c.PostForm("code"),
+		RedirectURI:  c.PostForm("redirect_uri"),
+		CodeVerifier: c.PostForm("code_verifier"),
+		RefreshToken: c.PostForm("refresh_token"),
+		DeviceCode:   c.PostForm("device_code"),
+
+		SubjectToken:       c.PostForm("subject_token"),
+		SubjectTokenType:   c.PostForm("subject_token_type"),
+		RequestedTokenType: c.PostForm("requested_token_type"),
+		Audience:           c.PostForm("audience"),
+		Scope:              c.PostForm("scope"),
+	})
+	c.Header("Cache-Control", "no-store")
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrInvalidClient):
+			respondInvalidClient(c)
+		case errors.Is(err, usecase.ErrInvalidRequest):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		case errors.Is(err, usecase.ErrInvalidGrant):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "invalid_grant", ErrorDescription: err.Error()})
+		case errors.Is(err, usecase.ErrInvalidScope):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "invalid_scope", ErrorDescription: err.Error()})
+		case errors.Is(err, usecase.ErrInvalidTarget):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "invalid_target", ErrorDescription: err.Error()})
+		case errors.Is(err, usecase.ErrUnauthorizedClient):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "unauthorized_client", ErrorDescription: err.Error()})
+		case errors.Is(err, usecase.ErrUnsupportedGrantType):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "unsupported_grant_type"})
+		case errors.Is(err, usecase.ErrAuthorizationPending):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "authorization_pending"})
+		case errors.Is(err, usecase.ErrSlowDown):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "slow_down"})
+		case errors.Is(err, usecase.ErrExpiredToken):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "expired_token"})
+		case errors.Is(err, usecase.ErrAccessDenied):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "access_denied"})
+		default:
+			c.JSON(http.StatusInternalServerError, OAuthErrorResponse{Error: "server_error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// DeviceAuthorization handles device authorization requests of CLIs and TV apps
+// @Summary Start a device authorization request
+// @Description Issue a device code and a user code (RFC 8628). The device shows the user code and verification_uri, then polls the token endpoint with the device code every interval seconds.
+// @Tags oauth
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Security BasicAuth
+// @Param client_id formData string false "Client ID"
+// @Param client_secret formData string false "Client secret"
+// @Param scope formData string false "Space separated scopes, defaults to openid"
+// @Success 200 {object} domain.DeviceAuthorizationResponse
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Failure 500 {object} OAuthErrorResponse
+// @Router /oauth/device/code [post]
+func (h *AuthorizationHandler) DeviceAuthorization(c *gin.Context) {
+	clientID, clientSecret := clientCredentials(c)
+	res, err := h.authorizationServer.DeviceAuthorization(c.Request.Context(), domain.DeviceAuthorizationRequest{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scope:        c.PostForm("scope"),
+	})
+	c.Header("Cache-Control", "no-store")
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrInvalidClient):
+			respondInvalidClient(c)
+		case errors.Is(err, usecase.ErrInvalidScope):
+			c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "invalid_scope", ErrorDescription: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, OAuthErrorResponse{Error: "server_error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GetDeviceVerification handles loading a device authorization request for the verification page
+// @Summary Get a device authorization request
+// @Description Describe the app and scopes of the device that shows the user code, so the verification page can ask the user to approve it.
+// @Tags oauth
+// @Produce json
+// @Security BearerAuth
+// @Param user_code query string true "User code shown by the device, dashes and case are ignored"
+// @Success 200 {object} domain.DeviceVerification
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /oauth/device/verify [get]
+func (h *AuthorizationHandler) GetDeviceVerification(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	verification, err := h.authorizationServer.DeviceVerification(c.Request.Context(), userID.(uuid.UUID), c.Query("user_code"))
+	if err != nil {
+		h.respondDeviceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, verification)
+}
+
+// DeviceDecisionRequest represents the user's answer on the verification page
+type DeviceDecisionRequest struct {
+	UserCode string `json:"user_code" binding:"required"`
+	Approved bool   `json:"approved"`
+}
+
+// VerifyDevice handles the user's decision on a device authorization request
+// @Summary Approve or deny a device
+// @Description Record the user's decision. The device gets its tokens, or access_denied, on its next poll.
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body DeviceDecisionRequest true "Decision"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /oauth/device/verify [post]
+func (h *AuthorizationHandler) VerifyDevice(c *gin.Context) {
+	var req DeviceDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	sessionID, _ := c.Get("session_id")
+	decision := domain.DeviceDecision{
+		UserID:   userID.(uuid.UUID),
+		UserCode: req.UserCode,
+		Approved: req.Approved,
+	}
+	if sessionID, ok := sessionID.(uuid.UUID); ok {
+		decision.SessionID = sessionID
+	}
+
+	if err := h.authorizationServer.VerifyDevice(c.Request.Context(), decision); err != nil {
+		h.respondDeviceError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthorizationHandler) respondDeviceError(c *gin.Context, err error) {
+	if errors.Is(err, usecase.ErrInvalidUserCode) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid or expired user code",
+		})
+		return
+	}
+	if errors.Is(err, usecase.ErrTooManyUserCodes) {
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{
+			Error: "Too many invalid user codes, try again later",
+		})
+		return
+	}
+	if respondSignInError(c, err) {
+		return
+	}
+	c.JSON(http.StatusInternalServerError, ErrorResponse{
+		Error: "Failed to process device authorization",
+	})
+}
+
+// UserInfo handles the OpenID Connect userinfo endpoint
+// @Summary Get the user's claims
+// @Description Return the claims of the user released by the scopes of the access token. Requires the openid scope.
+// @Tags oauth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} OAuthErrorResponse
+// @Failure 403 {object} OAuthErrorResponse
+// @Failure 500 {object} OAuthErrorResponse
+// @Router /oauth/userinfo [get]
+func (h *AuthorizationHandler) UserInfo(c *gin.Context) {
+	accessToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		accessToken = c.PostForm("access_token")
+	}
+	if accessToken == "" {
+		c.Header("WWW-Authenticate", `Bearer realm="oauth"`)
+		c.JSON(http.StatusUnauthorized, OAuthErrorResponse{Error: "invalid_request"})
+		return
+	}
+
+	claims, err := h.authorizationServer.UserInfo(c.Request.Context(), accessToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrInsufficientScope):
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			c.JSON(http.StatusForbidden, OAuthErrorResponse{Error: "insufficient_scope"})
+		case errors.Is(err, usecase.ErrInvalidToken), errors.Is(err, usecase.ErrTokenExpired), errors.Is(err, usecase.ErrInvalidUserID):
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, OAuthErrorResponse{Error: "invalid_token"})
+		default:
+			c.JSON(http.StatusInternalServerError, OAuthErrorResponse{Error: "server_error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, claims)
+}
+
+// ListGrants handles listing the apps the user has authorized
+// @Summary List authorized apps
+// @Description List the third-party apps the user has granted access, with their scopes
+// @Tags me
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} domain.OAuthGrant
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/grants [get]
+func (h *AuthorizationHandler) ListGrants(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	grants, err := h.authorizationServer.ListGrants(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to list grants",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, grants)
+}
+
+// RevokeGrant handles revoking an app's access
+// @Summary Revoke an authorized app
+// @Description Revoke a grant and every token the app received under it
+// @Tags me
+// @Security BearerAuth
+// @Param id path string true "Grant ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/grants/{id} [delete]
+func (h *AuthorizationHandler) RevokeGrant(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid grant ID",
+		})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if err := h.authorizationServer.RevokeGrant(c.Request.Context(), userID.(uuid.UUID), id); err != nil {
+		if errors.Is(err, usecase.ErrGrantNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "Grant not found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to revoke grant",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RegisterClient handles registering a third-party app
+// @Summary Register an OAuth client
+// @Description Register a third-party app. The client secret of confidential clients is only returned once. Admin only, requires a recent sign-in.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body domain.RegisterClientRequest true "Client"
+// @Success 201 {object} domain.RegisteredClient
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/oauth/clients [post]
+func (h *AuthorizationHandler) RegisterClient(c *gin.Context) {
+	var req domain.RegisterClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	createdBy, _ := c.Get("user_id")
+	client, err := h.authorizationServer.RegisterClient(c.Request.Context(), createdBy.(uuid.UUID), req)
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidClientMetadata) || errors.Is(err, usecase.ErrInvalidRedirectURI) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to register client",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, client)
+}
+
+// ListClients handles listing OAuth clients
+// @Summary List OAuth clients
+// @Description List OAuth clients, newest first. Admin only.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number"
+// @Param limit query int false "Page size"
+// @Success 200 {array} domain.OAuthClient
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/oauth/clients [get]
+func (h *AuthorizationHandler) ListClients(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	clients, err := h.authorizationServer.ListClients(c.Request.Context(), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to list clients",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, clients)
+}
+
+// DeleteClient handles deleting an OAuth client
+// @Summary Delete an OAuth client
+// @Description Delete a client with its grants and every token issued to it. Admin only.
+// @Tags admin
+// @Security BearerAuth
+// @Param client_id path string true "Client ID"
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/oauth/clients/{client_id} [delete]
+func (h *AuthorizationHandler) DeleteClient(c *gin.Context) {
+	if err := h.authorizationServer.DeleteClient(c.Request.Context(), c.Param("client_id")); err != nil {
+		if errors.Is(err, usecase.ErrClientNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "Client not found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to delete client",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// OpenIDConfiguration handles OpenID Connect discovery
+// @Summary OpenID Connect discovery document
+// @Tags oauth
+// @Produce json
+// @Success 200 {object} domain.OpenIDConfiguration
+// @Router /.well-known/openid-configuration [get]
+func (h *AuthorizationHandler) OpenIDConfiguration(c *gin.Context) {
+	c.JSON(http.StatusOK, h.authorizationServer.OpenIDConfiguration())
+}
+
+// JSONWebKeySet handles publishing the keys ID tokens are signed with
+// @Summary ID token signing keys
+// @Tags oauth
+// @Produce json
+// @Success 200 {object} domain.JSONWebKeySet
+// @Failure 500 {object} ErrorResponse
+// @Router /.well-known/jwks.json [get]
+func (h *AuthorizationHandler) JSONWebKeySet(c *gin.Context) {
+	keys, err := h.authorizationServer.JSONWebKeySet()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Signing key is not available",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RegisterRoutes registers the public authorization server routes
+func (h *AuthorizationHandler) RegisterRoutes(router *gin.RouterGroup) {
+	group := router.Group("/oauth")
+	{
+		group.GET("/authorize", h.Authorize)
+		group.POST("/token", h.Token)
+		group.POST("/device/code", h.DeviceAuthorization)
+		group.GET("/userinfo", h.UserInfo)
+		group.POST("/userinfo", h.UserInfo)
+	}
+}
+
+// RegisterUserRoutes registers the consent, device verification and grant
+// routes. The router group must require a first-party access token.
+func (h *AuthorizationHandler) RegisterUserRoutes(router *gin.RouterGroup) {
+	router.GET("/oauth/consent", h.GetConsentRequest)
+	router.POST("/oauth/consent", h.Consent)
+	router.GET("/oauth/device/verify", h.GetDeviceVerification)
+	router.POST("/oauth/device/verify", h.VerifyDevice)
+	router.GET("/me/grants", h.ListGrants)
+	router.DELETE("/me/grants/:id", h.RevokeGrant)
+}
+
+// RegisterAdminRoutes registers the client management routes. The router
+// group must be restricted to admins. stepUp demands a recent sign-in before
+// a client and its secret are created.
+func (h *AuthorizationHandler) RegisterAdminRoutes(router *gin.RouterGroup, stepUp gin.HandlerFunc) {
+	group := router.Group("/oauth/clients")
+	{
+		group.POST("", stepUp, h.RegisterClient)
+		group.GET("", h.ListClients)
+		group.DELETE("/:client_id", h.DeleteClient)
+	}
+}
+
+// RegisterDiscoveryRoutes registers the OpenID Connect discovery routes at the root of the API
+func (h *AuthorizationHandler) RegisterDiscoveryRoutes(router *gin.RouterGroup) {
+	group := router.Group("/.well-known")
+	{
+		group.GET("/openid-configuration", h.OpenIDConfiguration)
+		group.GET("/jwks.json", h.JSONWebKeySet)
+	}
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/usecase"
+)
+
+type InvitationHandler struct {
+	invitationUsecase domain.InvitationUsecase
+}
+
+func NewInvitationHandler(invitationUsecase domain.InvitationUsecase) *InvitationHandler {
+	return &InvitationHandler{
+		invitationUsecase: invitationUsecase,
+	}
+}
+
+// CreateInvitationRequest represents the body of an invitation request
+type CreateInvitationRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role"`
+}
+
+// CreateInvitation handles invitation creation
+// @Summary Invite a user
+// @Description Allow an email address to register, optionally with a preassigned role. Admin only.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body CreateInvitationRequest true "Invitation"
+// @Success 201 {object} domain.Invitation
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/invitations [post]
+func (h *InvitationHandler) CreateInvitation(c *gin.Context) {
+	var req CreateInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	invitedBy, _ := c.Get("user_id")
+	invitation, err := h.invitationUsecase.CreateInvitation(c.Request.Context(), invitedBy.(uuid.UUID), req.Email, req.Role)
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidEmail) || errors.Is(err, usecase.ErrInvalidRole) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to create invitation",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// ListInvitations handles listing invitations
+// @Summary List invitations
+// @Description List invitations, newest first. Admin only.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number"
+// @Param limit query int false "Page size"
+// @Success 200 {array} domain.Invitation
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/invitations [get]
+func (h *InvitationHandler) ListInvitations(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	invitations, err := h.invitationUsecase.ListInvitations(c.Request.Context(), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to list invitations",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// RevokeInvitation handles invitation revocation
+// @Summary Revoke an invitation
+// @Description Revoke a pending invitation. Admin only.
+// @Tags admin
+// @Security BearerAuth
+// @Param id path string true "Invitation ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/invitations/{id} [delete]
+func (h *InvitationHandler) RevokeInvitation(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid invitation ID",
+		})
+		return
+	}
+
+	if err := h.invitationUsecase.RevokeInvitation(c.Request.Context(), id); err != nil {
+		if errors.Is(err, usecase.ErrInvitationNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "Pending invitation not found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to revoke invitation",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RegisterRoutes registers the invitation routes. The router group must be
+// restricted to admins.
+func (h *InvitationHandler) RegisterRoutes(router *gin.RouterGroup) {
+	group := router.Group("/invitations")
+	{
+		group.POST("", h.CreateInvitation)
+		group.GET("", h.ListInvitations)
+		group.DELETE("/:id", h.RevokeInvitation)
+	}
+}
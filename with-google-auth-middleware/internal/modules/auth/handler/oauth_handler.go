@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/usecase"
+)
+
+type OAuthHandler struct {
+	oauthUsecase domain.OAuthUsecase
+}
+
+func NewOAuthHandler(oauthUsecase domain.OAuthUsecase) *OAuthHandler {
+	return &OAuthHandler{
+		oauthUsecase: oauthUsecase,
+	}
+}
+
+// Introspect handles token introspection (RFC 7662)
+// @Summary Introspect a token
+// @Description Report whether an access or refresh token is active. Requires credentials of an internal client.
+// @Tags oauth
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Security BasicAuth
+// @Param token formData string true "Token to introspect"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {object} domain.TokenIntrospection
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Failure 403 {object} OAuthErrorResponse
+// @Failure 500 {object} OAuthErrorResponse
+// @Router /oauth/introspect [post]
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	client := h.authenticateClient(c)
+	if client == nil {
+		return
+	}
+	if !client.Internal {
+		c.JSON(http.StatusForbidden, OAuthErrorResponse{
+			Error:            "unauthorized_client",
+			ErrorDescription: "only internal clients may introspect tokens",
+		})
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, OAuthErrorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "token is required",
+		})
+		return
+	}
+
+	result, err := h.oauthUsecase.Introspect(c.Request.Context(), token, c.PostForm("token_type_hint"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, OAuthErrorResponse{
+			Error: "server_error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Revoke handles token revocation (RFC 7009)
+// @Summary Revoke a token
+// @Description Revoke an access or refresh token and the session it belongs to. Requires client credentials, third-party apps may only revoke their own tokens.
+// @Tags oauth
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Security BasicAuth
+// @Param token formData string true "Token to revoke"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Failure 500 {object} OAuthErrorResponse
+// @Router /oauth/revoke [post]
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	client := h.authenticateClient(c)
+	if client == nil {
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, OAuthErrorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "token is required",
+		})
+		return
+	}
+
+	if err := h.oauthUsecase.Revoke(c.Request.Context(), client, token, c.PostForm("token_type_hint")); err != nil {
+		c.JSON(http.StatusInternalServerError, OAuthErrorResponse{
+			Error: "server_error",
+		})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// authenticateClient checks HTTP Basic or client_secret_post credentials and
+// writes an invalid_client response when they are missing or wrong
+func (h *OAuthHandler) authenticateClient(c *gin.Context) *domain.OAuthClient {
+	clientID, clientSecret := clientCredentials(c)
+	client, err := h.oauthUsecase.AuthenticateClient(c.Request.Context(), clientID, clientSecret)
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidClient) {
+			respondInvalidClient(c)
+			return nil
+		}
+		c.JSON(http.StatusInternalServerError, OAuthErrorResponse{
+			Error: "server_error",
+		})
+		return nil
+	}
+	return client
+}
+
+// clientCredentials returns the HTTP Basic credentials, or the client_id and
+// client_secret form values
+func clientCredentials(c *gin.Context) (string, string) {
+	if clientID, clientSecret, ok := c.Request.BasicAuth(); ok {
+		return clientID, clientSecret
+	}
+	return c.PostForm("client_id"), c.PostForm("client_secret")
+}
+
+func respondInvalidClient(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	c.JSON(http.StatusUnauthorized, OAuthErrorResponse{
+		Error: "invalid_client",
+	})
+}
+
+// OAuthErrorResponse represents an RFC 6749 error response
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// RegisterRoutes registers all OAuth routes
+func (h *OAuthHandler) RegisterRoutes(router *gin.RouterGroup) {
+	group := router.Group("/oauth")
+	{
+		group.POST("/introspect", h.Introspect)
+		group.POST("/revoke", h.Revoke)
+	}
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/usecase"
+)
+
+// samlPostForm submits an AuthnRequest with the HTTP-POST binding
+var samlPostForm = template.Must(template.New("saml").Parse(`<!DOCTYPE html>
+<html>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.URL}}">
+<input type="hidden" name="SAMLRequest" value="{{.SAMLRequest}}">
+{{if .RelayState}}<input type="hidden" name="RelayState" value="{{.RelayState}}">{{end}}
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>`))
+
+// SAMLMetadata serves our service provider metadata for an identity provider
+// @Summary SAML service provider metadata
+// @Description Metadata to register this service with a SAML identity provider
+// @Tags auth
+// @Produce xml
+// @Param provider path string true "Identity provider name"
+// @Success 200 {string} string "SP metadata XML"
+// @Failure 404 {object} ErrorResponse
+// @Router /auth/saml/{provider}/metadata [get]
+func (h *AuthHandler) SAMLMetadata(c *gin.Context) {
+	metadata, err := h.authUsecase.SAMLMetadata(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		respondSAMLError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// StartSAMLLogin sends the browser to the identity provider with an AuthnRequest
+// @Summary Start SAML login
+// @Description Redirect (or POST) the browser to the SAML identity provider
+// @Tags auth
+// @Produce html
+// @Param provider path string true "Identity provider name"
+// @Param relay_state query string false "Opaque value returned with the SAML response"
+// @Success 200 {string} string "Auto-submitting form for the HTTP-POST binding"
+// @Success 302
+// @Failure 404 {object} ErrorResponse
+// @Router /auth/saml/{provider}/login [get]
+func (h *AuthHandler) StartSAMLLogin(c *gin.Context) {
+	request, err := h.authUsecase.StartSAMLLogin(c.Request.Context(), c.Param("provider"), c.Query("relay_state"))
+	if err != nil {
+		respondSAMLError(c, err)
+		return
+	}
+
+	if request.Binding == domain.SAMLBindingHTTPPost {
+		c.Status(http.StatusOK)
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Header("Cache-Control", "no-store")
+		samlPostForm.Execute(c.Writer, request)
+		return
+	}
+	c.Redirect(http.StatusFound, request.URL)
+}
+
+// SAMLAssertionConsumerService handles the SAML response posted by the identity provider
+// @Summary SAML assertion consumer service
+// @Description Validate the signed SAML response and issue tokens
+// @Tags auth
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Param provider path string true "Identity provider name"
+// @Param SAMLResponse formData string true "Base64 encoded SAML response"
+// @Param RelayState formData string false "Relay state of the login"
+// @Success 200 {object} domain.AuthToken
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /auth/saml/{provider}/acs [post]
+func (h *AuthHandler) SAMLAssertionConsumerService(c *gin.Context) {
+	samlResponse := c.PostForm("SAMLResponse")
+	if samlResponse == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "SAML response is required",
+		})
+		return
+	}
+
+	token, err := h.authUsecase.LoginWithSAML(c.Request.Context(), c.Param("provider"), samlResponse)
+	if err != nil {
+		if errors.Is(err, usecase.ErrSAMLAuthFailed) {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Failed to authenticate with identity provider",
+			})
+			return
+		}
+		if !respondSignInError(c, err) {
+			respondSAMLError(c, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// respondSAMLError maps SAML configuration errors to responses
+func respondSAMLError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrUnknownProvider), errors.Is(err, usecase.ErrSAMLNotConfigured):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Identity provider not found",
+		})
+	case errors.Is(err, usecase.ErrSAMLAuthFailed):
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error: "Identity provider is misconfigured",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to process SAML request",
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/usecase"
+)
+
+type SecurityEventHandler struct {
+	securityEventUsecase domain.SecurityEventUsecase
+}
+
+func NewSecurityEventHandler(securityEventUsecase domain.SecurityEventUsecase) *SecurityEventHandler {
+	return &SecurityEventHandler{
+		securityEventUsecase: securityEventUsecase,
+	}
+}
+
+// ListMyEvents handles listing the signed-in user's security events
+// @Summary List my security events
+// @Description List the sign-ins, refreshes, logouts and session revocations of the signed-in user, newest first
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "Page size, 20 by default and at most 100"
+// @Success 200 {object} domain.SecurityEventPage
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/security-events [get]
+func (h *SecurityEventHandler) ListMyEvents(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	h.listEvents(c, userID.(uuid.UUID))
+}
+
+// ListUserEvents handles listing a user's security events
+// @Summary List a user's security events
+// @Description List the sign-ins, refreshes, logouts and session revocations of a user, newest first. Admin only.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "Page size, 20 by default and at most 100"
+// @Success 200 {object} domain.SecurityEventPage
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/security-events [get]
+func (h *SecurityEventHandler) ListUserEvents(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid user ID",
+		})
+		return
+	}
+	h.listEvents(c, userID)
+}
+
+func (h *SecurityEventHandler) listEvents(c *gin.Context, userID uuid.UUID) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	page, err := h.securityEventUsecase.ListEvents(c.Request.Context(), userID, c.Query("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "Invalid cursor",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to list security events",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// RegisterUserRoutes registers the security event feed of signed-in users.
+// The router group must require a first-party access token.
+func (h *SecurityEventHandler) RegisterUserRoutes(router *gin.RouterGroup) {
+	router.GET("/me/security-events", h.ListMyEvents)
+}
+
+// RegisterAdminRoutes registers the security event feed of any user. The
+// router group must be restricted to admins.
+func (h *SecurityEventHandler) RegisterAdminRoutes(router *gin.RouterGroup) {
+	router.GET("/users/:id/security-events", h.ListUserEvents)
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -8,19 +9,48 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
 )
 
+// PrincipalKey is the context key of the *domain.Principal set by AuthRequired
+const PrincipalKey = "principal"
+
 type AuthMiddleware struct {
 	jwtSecret []byte
+	dpop      domain.DPoPVerifier
+	stepUp    domain.StepUpPolicy
+	accounts  domain.AccountStatusLookup
+	sessions  domain.SessionLookup
 }
 
-func NewAuthMiddleware(jwtSecret string) *AuthMiddleware {
+// NewAuthMiddleware returns the middleware of protected routes. Without a DPoP
+// verifier tokens bound with DPoP are rejected. stepUp is the policy of
+// StepUpRequired. accounts looks up the account status of token holders, nil
+// accepts the tokens of every account until they expire. sessions looks up the
+// session of the token, nil accepts tokens of revoked sessions until they expire.
+func NewAuthMiddleware(jwtSecret string, dpop domain.DPoPVerifier, stepUp domain.StepUpPolicy, accounts domain.AccountStatusLookup, sessions domain.SessionLookup) *AuthMiddleware {
 	return &AuthMiddleware{
 		jwtSecret: []byte(jwtSecret),
+		dpop:      dpop,
+		stepUp:    stepUp,
+		accounts:  accounts,
+		sessions:  sessions,
 	}
 }
 
-// AuthRequired is a middleware that checks for a valid JWT token
+// accountStatusErrors are the responses to tokens of accounts that may no longer use them
+var accountStatusErrors = map[string]gin.H{
+	userdomain.StatusSuspended: {"error": "Your account is suspended", "code": "account_suspended"},
+	userdomain.StatusBanned:    {"error": "Your account is banned", "code": "account_banned"},
+	userdomain.StatusPending:   {"error": "Your account is awaiting approval", "code": "account_pending"},
+	domain.AccountDeactivated:  {"error": "Your account is deactivated", "code": "account_deactivated"},
+}
+
+// AuthRequired is a middleware that checks for a valid JWT token. Tokens bound
+// with DPoP (cnf.jkt claim) must be sent with the DPoP scheme and a proof of
+// their key, bearer tokens with the Bearer scheme.
 func (m *AuthMiddleware) AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -32,7 +62,7 @@ func (m *AuthMiddleware) AuthRequired() gin.HandlerFunc {
 
 		// Check if the Authorization header has the correct format
 		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "DPoP") {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
 			c.Abort()
 			return
@@ -62,6 +92,11 @@ func (m *AuthMiddleware) AuthRequired() gin.HandlerFunc {
 				}
 			}
 
+			if !m.checkDPoP(c, parts[0], parts[1], claims) {
+				c.Abort()
+				return
+			}
+
 			// Get user ID from claims
 			if sub, ok := claims["sub"].(string); ok {
 				userID, err := uuid.Parse(sub)
@@ -70,7 +105,12 @@ func (m *AuthMiddleware) AuthRequired() gin.HandlerFunc {
 					c.Abort()
 					return
 				}
-				c.Set("user_id", userID)
+				principal := authn.NewPrincipal(userID, claims)
+				if !m.checkSession(c, principal.SessionID) || !m.checkAccount(c, userID) {
+					c.Abort()
+					return
+				}
+				setPrincipal(c, principal)
 				c.Next()
 				return
 			}
@@ -79,4 +119,244 @@ func (m *AuthMiddleware) AuthRequired() gin.HandlerFunc {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 		c.Abort()
 	}
-} 
\ No newline at end of file
+}
+
+// setPrincipal stores the principal in the context, and its fields under the
+// keys handlers read them from individually
+func setPrincipal(c *gin.Context, principal *domain.Principal) {
+	c.Set(PrincipalKey, principal)
+	c.Set("user_id", principal.UserID)
+	if principal.Role != "" {
+		c.Set("role", principal.Role)
+	}
+	if principal.SessionID != uuid.Nil {
+		c.Set("session_id", principal.SessionID)
+	}
+	if principal.GrantID != nil {
+		c.Set("grant_id", *principal.GrantID)
+	}
+	c.Set("scopes", principal.Scopes)
+	if !principal.AuthTime.IsZero() {
+		c.Set("auth_time", principal.AuthTime)
+	}
+	c.Set("acr", principal.ACR)
+	if principal.OrgID != nil {
+		c.Set("org_id", *principal.OrgID)
+		c.Set("org_role", principal.OrgRole)
+	}
+}
+
+// GetPrincipal returns the caller AuthRequired authenticated, false on routes
+// without it
+func GetPrincipal(c *gin.Context) (*domain.Principal, bool) {
+	value, ok := c.Get(PrincipalKey)
+	if !ok {
+		return nil, false
+	}
+	principal, ok := value.(*domain.Principal)
+	return principal, ok
+}
+
+// checkAccount rejects tokens of suspended, banned, pending and deactivated
+// accounts and writes the 403 response
+func (m *AuthMiddleware) checkAccount(c *gin.Context, userID uuid.UUID) bool {
+	if m.accounts == nil {
+		return true
+	}
+	status, err := m.accounts.AccountStatus(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check account status"})
+		return false
+	}
+	if response, rejected := accountStatusErrors[status]; rejected {
+		c.JSON(http.StatusForbidden, response)
+		return false
+	}
+	return true
+}
+
+// checkSession rejects tokens whose session was logged out or revoked and
+// writes the 401 response
+func (m *AuthMiddleware) checkSession(c *gin.Context, sessionID uuid.UUID) bool {
+	if m.sessions == nil {
+		return true
+	}
+	active := false
+	if sessionID != uuid.Nil {
+		var err error
+		active, err = m.sessions.SessionActive(c.Request.Context(), sessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
+			return false
+		}
+	}
+	if !active {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked", "code": "session_revoked"})
+		return false
+	}
+	return true
+}
+
+// checkDPoP verifies the sender constraint of a token and writes the 401
+// response if it fails
+func (m *AuthMiddleware) checkDPoP(c *gin.Context, scheme, token string, claims jwt.MapClaims) bool {
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	jkt, _ := cnf["jkt"].(string)
+	if jkt == "" {
+		switch {
+		case scheme != "Bearer":
+			c.Header("WWW-Authenticate", `DPoP error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is not bound to a DPoP key"})
+			return false
+		// Tokens of third-party apps are never bound
+		case m.dpop != nil && m.dpop.Mode() == domain.DPoPModeRequired && authn.StringClaim(claims, "grant_id") == "":
+			c.Header("WWW-Authenticate", m.dpopChallenge("invalid_token"))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "A DPoP-bound token is required"})
+			return false
+		}
+		return true
+	}
+
+	if scheme != "DPoP" || m.dpop == nil {
+		c.Header("WWW-Authenticate", m.dpopChallenge("invalid_token"))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is bound to a DPoP key"})
+		return false
+	}
+	proofKey, err := m.dpop.VerifyProof(domain.NewDPoPProofRequest(c.Request, token))
+	if err != nil || proofKey != jkt {
+		c.Header("WWW-Authenticate", m.dpopChallenge("invalid_dpop_proof"))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid DPoP proof"})
+		return false
+	}
+	return true
+}
+
+// dpopChallenge returns the WWW-Authenticate challenge of a DPoP error
+func (m *AuthMiddleware) dpopChallenge(errorCode string) string {
+	return fmt.Sprintf(`DPoP error="%s", algs="%s"`, errorCode, strings.Join(domain.DPoPSigningAlgorithms, " "))
+}
+
+// RequireRole is a middleware that only lets users with one of the given roles through.
+// It must run after AuthRequired.
+func (m *AuthMiddleware) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		c.Abort()
+	}
+}
+
+// RequireScope is a middleware that only lets access tokens with every given
+// scope through. It must run after AuthRequired.
+func (m *AuthMiddleware) RequireScope(scopes ...string) gin.HandlerFunc {
+	required := strings.Join(scopes, " ")
+	return func(c *gin.Context) {
+		granted := c.GetStringSlice("scopes")
+		for _, scope := range scopes {
+			if !hasScope(granted, scope) {
+				c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, required))
+				c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient scope", "scope": required})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// StepUpRequired is RequireStepUp with the configured step-up policy
+func (m *AuthMiddleware) StepUpRequired() gin.HandlerFunc {
+	return m.RequireStepUp(m.stepUp)
+}
+
+// RequireStepUp is a middleware for sensitive routes that demands a recent or
+// strong enough sign-in. Other tokens get a 401 with a reauthentication_required
+// error and an insufficient_user_authentication challenge (RFC 9470); the app
+// signs the user in again and retries. It must run after AuthRequired.
+func (m *AuthMiddleware) RequireStepUp(policy domain.StepUpPolicy) gin.HandlerFunc {
+	maxAge := int64(policy.MaxAge.Seconds())
+	return func(c *gin.Context) {
+		authTime := c.GetTime("auth_time")
+		recent := policy.MaxAge == 0 || (!authTime.IsZero() && time.Since(authTime) <= policy.MaxAge)
+		if recent && domain.ACRSatisfies(c.GetString("acr"), policy.ACR) {
+			c.Next()
+			return
+		}
+
+		challenge := `Bearer error="insufficient_user_authentication", error_description="Reauthentication required"`
+		response := gin.H{"error": "Reauthentication required", "code": "reauthentication_required"}
+		if policy.MaxAge > 0 {
+			challenge += fmt.Sprintf(", max_age=%d", maxAge)
+			response["max_age"] = maxAge
+		}
+		if policy.ACR != "" {
+			challenge += fmt.Sprintf(`, acr_values="%s"`, policy.ACR)
+			response["acr_values"] = policy.ACR
+		}
+		c.Header("WWW-Authenticate", challenge)
+		c.JSON(http.StatusUnauthorized, response)
+		c.Abort()
+	}
+}
+
+// RequireOrg is a middleware for organization routes. It only lets through
+// access tokens switched to the organization in the :org_id path parameter,
+// with one of the given roles in it, or any role if none are given. It must
+// run after AuthRequired.
+func (m *AuthMiddleware) RequireOrg(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := c.Get("org_id")
+		if !ok || orgID.(uuid.UUID).String() != c.Param("org_id") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token is not switched to this organization", "code": "org_mismatch"})
+			c.Abort()
+			return
+		}
+
+		if len(roles) > 0 {
+			role := c.GetString("org_role")
+			allowed := false
+			for _, r := range roles {
+				if role == r {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient organization role", "code": "insufficient_org_role"})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// FirstPartyRequired is a middleware that rejects tokens issued to third-party
+// apps, for routes that manage the user's account and grants. It must run
+// after AuthRequired.
+func (m *AuthMiddleware) FirstPartyRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, delegated := c.Get("grant_id"); delegated {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not available to third-party apps"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,404 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/usecase"
+)
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewAuthMiddleware("test-secret", nil, domain.StepUpPolicy{}, nil, nil)
+	router := gin.New()
+	router.GET("/users", m.AuthRequired(), m.RequireScope("users:read"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(t *testing.T, claims jwt.MapClaims) *httptest.ResponseRecorder {
+		claims["sub"] = uuid.New().String()
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("granted", func(t *testing.T) {
+		w := request(t, jwt.MapClaims{"scope": "openid users:read"})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("missing scope", func(t *testing.T) {
+		w := request(t, jwt.MapClaims{"scope": "openid users:write"})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, `Bearer error="insufficient_scope", scope="users:read"`, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("no scope claim", func(t *testing.T) {
+		w := request(t, jwt.MapClaims{})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestAuthRequiredDPoP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const url = "http://api.example.com/users"
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwk := map[string]interface{}{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	sum := sha256.Sum256([]byte(`{"crv":"P-256","kty":"EC","x":"` + jwk["x"].(string) + `","y":"` + jwk["y"].(string) + `"}`))
+	thumbprint := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	accessToken := func(t *testing.T, claims jwt.MapClaims) string {
+		claims["sub"] = uuid.New().String()
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+		require.NoError(t, err)
+		return token
+	}
+	proof := func(t *testing.T, token string) string {
+		ath := sha256.Sum256([]byte(token))
+		proof := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"jti": uuid.NewString(),
+			"htm": http.MethodGet,
+			"htu": url,
+			"iat": time.Now().Unix(),
+			"ath": base64.RawURLEncoding.EncodeToString(ath[:]),
+		})
+		proof.Header["typ"] = "dpop+jwt"
+		proof.Header["jwk"] = jwk
+		signed, err := proof.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	newRouter := func(mode string) *gin.Engine {
+		m := NewAuthMiddleware("test-secret", usecase.NewDPoPVerifier(mode, ""), domain.StepUpPolicy{}, nil, nil)
+		router := gin.New()
+		router.GET("/users", m.AuthRequired(), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		return router
+	}
+	request := func(router *gin.Engine, authorization, dpop string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Authorization", authorization)
+		if dpop != "" {
+			req.Header.Set("DPoP", dpop)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	router := newRouter(domain.DPoPModeOptional)
+	bound := accessToken(t, jwt.MapClaims{"cnf": map[string]interface{}{"jkt": thumbprint}})
+
+	t.Run("bound token with proof", func(t *testing.T) {
+		w := request(router, "DPoP "+bound, proof(t, bound))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("replayed proof", func(t *testing.T) {
+		replayed := proof(t, bound)
+		assert.Equal(t, http.StatusOK, request(router, "DPoP "+bound, replayed).Code)
+		w := request(router, "DPoP "+bound, replayed)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), `DPoP error="invalid_dpop_proof"`)
+	})
+
+	t.Run("bound token as bearer token", func(t *testing.T) {
+		w := request(router, "Bearer "+bound, proof(t, bound))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("proof for another token", func(t *testing.T) {
+		other := accessToken(t, jwt.MapClaims{"cnf": map[string]interface{}{"jkt": thumbprint}})
+		w := request(router, "DPoP "+bound, proof(t, other))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("bearer token", func(t *testing.T) {
+		bearer := accessToken(t, jwt.MapClaims{})
+		assert.Equal(t, http.StatusOK, request(router, "Bearer "+bearer, "").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(router, "DPoP "+bearer, proof(t, bearer)).Code)
+	})
+
+	t.Run("required mode", func(t *testing.T) {
+		router := newRouter(domain.DPoPModeRequired)
+		assert.Equal(t, http.StatusUnauthorized, request(router, "Bearer "+accessToken(t, jwt.MapClaims{}), "").Code)
+		// Third-party apps get bearer tokens from the authorization server
+		delegated := accessToken(t, jwt.MapClaims{"grant_id": uuid.NewString()})
+		assert.Equal(t, http.StatusOK, request(router, "Bearer "+delegated, "").Code)
+	})
+}
+
+func TestRequireStepUp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewAuthMiddleware("test-secret", nil, domain.StepUpPolicy{MaxAge: 5 * time.Minute}, nil, nil)
+	router := gin.New()
+	router.DELETE("/users/1", m.AuthRequired(), m.StepUpRequired(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.POST("/keys", m.AuthRequired(), m.RequireStepUp(domain.StepUpPolicy{ACR: domain.ACRMultiFactor}), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	request := func(t *testing.T, method, path string, claims jwt.MapClaims) *httptest.ResponseRecorder {
+		claims["sub"] = uuid.New().String()
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+		require.NoError(t, err)
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("recent sign-in", func(t *testing.T) {
+		w := request(t, http.MethodDelete, "/users/1", jwt.MapClaims{"auth_time": time.Now().Add(-time.Minute).Unix()})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("sign-in too long ago", func(t *testing.T) {
+		w := request(t, http.MethodDelete, "/users/1", jwt.MapClaims{"auth_time": time.Now().Add(-time.Hour).Unix()})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, `Bearer error="insufficient_user_authentication", error_description="Reauthentication required", max_age=300`, w.Header().Get("WWW-Authenticate"))
+		assert.JSONEq(t, `{"error":"Reauthentication required","code":"reauthentication_required","max_age":300}`, w.Body.String())
+	})
+
+	t.Run("token without auth_time", func(t *testing.T) {
+		w := request(t, http.MethodDelete, "/users/1", jwt.MapClaims{})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("multi-factor sign-in", func(t *testing.T) {
+		w := request(t, http.MethodPost, "/keys", jwt.MapClaims{"acr": domain.ACRMultiFactor})
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("single-factor sign-in", func(t *testing.T) {
+		w := request(t, http.MethodPost, "/keys", jwt.MapClaims{"acr": domain.ACRSingleFactor})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.JSONEq(t, `{"error":"Reauthentication required","code":"reauthentication_required","acr_values":"multi_factor"}`, w.Body.String())
+	})
+}
+
+func TestRequireOrg(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewAuthMiddleware("test-secret", nil, domain.StepUpPolicy{}, nil, nil)
+	router := gin.New()
+	router.GET("/orgs/:org_id/members", m.AuthRequired(), m.RequireOrg(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.POST("/orgs/:org_id/invitations", m.AuthRequired(), m.RequireOrg("admin", "owner"), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	orgID := uuid.New()
+	request := func(t *testing.T, method, path string, claims jwt.MapClaims) *httptest.ResponseRecorder {
+		claims["sub"] = uuid.New().String()
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+		require.NoError(t, err)
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("active organization", func(t *testing.T) {
+		w := request(t, http.MethodGet, "/orgs/"+orgID.String()+"/members", jwt.MapClaims{"org_id": orgID.String(), "org_role": "member"})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("another organization", func(t *testing.T) {
+		w := request(t, http.MethodGet, "/orgs/"+uuid.NewString()+"/members", jwt.MapClaims{"org_id": orgID.String(), "org_role": "owner"})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "org_mismatch")
+	})
+
+	t.Run("token without organization", func(t *testing.T) {
+		w := request(t, http.MethodGet, "/orgs/"+orgID.String()+"/members", jwt.MapClaims{})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("allowed role", func(t *testing.T) {
+		w := request(t, http.MethodPost, "/orgs/"+orgID.String()+"/invitations", jwt.MapClaims{"org_id": orgID.String(), "org_role": "admin"})
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("insufficient role", func(t *testing.T) {
+		w := request(t, http.MethodPost, "/orgs/"+orgID.String()+"/invitations", jwt.MapClaims{"org_id": orgID.String(), "org_role": "member"})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "insufficient_org_role")
+	})
+}
+
+// fakeAccountStatuses maps users to their account status
+type fakeAccountStatuses map[uuid.UUID]string
+
+func (f fakeAccountStatuses) AccountStatus(ctx context.Context, userID uuid.UUID) (string, error) {
+	return f[userID], nil
+}
+
+func TestAuthRequiredAccountStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	statuses := fakeAccountStatuses{}
+	m := NewAuthMiddleware("test-secret", nil, domain.StepUpPolicy{}, statuses, nil)
+	router := gin.New()
+	router.GET("/users", m.AuthRequired(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(t *testing.T, status string) *httptest.ResponseRecorder {
+		userID := uuid.New()
+		statuses[userID] = status
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": userID.String(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}).SignedString([]byte("test-secret"))
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("active", func(t *testing.T) {
+		w := request(t, "active")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("suspended", func(t *testing.T) {
+		w := request(t, "suspended")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "account_suspended")
+	})
+
+	t.Run("deactivated", func(t *testing.T) {
+		w := request(t, domain.AccountDeactivated)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "account_deactivated")
+	})
+}
+
+// fakeSessions holds the sessions that weren't revoked
+type fakeSessions map[uuid.UUID]bool
+
+func (f fakeSessions) SessionActive(ctx context.Context, sessionID uuid.UUID) (bool, error) {
+	return f[sessionID], nil
+}
+
+func TestAuthRequiredSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sessions := fakeSessions{}
+	m := NewAuthMiddleware("test-secret", nil, domain.StepUpPolicy{}, nil, sessions)
+	router := gin.New()
+	router.GET("/users", m.AuthRequired(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(t *testing.T, claims jwt.MapClaims) *httptest.ResponseRecorder {
+		claims["sub"] = uuid.NewString()
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	sessionID := uuid.New()
+	sessions[sessionID] = true
+	w := request(t, jwt.MapClaims{"sid": sessionID.String()})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Run("revoked session", func(t *testing.T) {
+		delete(sessions, sessionID)
+		w := request(t, jwt.MapClaims{"sid": sessionID.String()})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "session_revoked")
+	})
+
+	t.Run("no session", func(t *testing.T) {
+		w := request(t, jwt.MapClaims{})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "session_revoked")
+	})
+}
+
+func TestAuthRequiredPrincipal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewAuthMiddleware("test-secret", nil, domain.StepUpPolicy{}, nil, nil)
+	var principal *domain.Principal
+	router := gin.New()
+	router.GET("/me", m.AuthRequired(), func(c *gin.Context) {
+		principal, _ = GetPrincipal(c)
+		c.Status(http.StatusOK)
+	})
+
+	userID, sessionID, orgID := uuid.New(), uuid.New(), uuid.New()
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":       userID.String(),
+		"exp":       time.Now().Add(time.Minute).Unix(),
+		"sid":       sessionID.String(),
+		"role":      "admin",
+		"scope":     "openid users:read",
+		"auth_time": authTime.Unix(),
+		"acr":       domain.ACRMultiFactor,
+		"org_id":    orgID.String(),
+		"org_role":  "owner",
+		"billing":   map[string]interface{}{"tier": "pro"},
+	}).SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.NotNil(t, principal)
+	assert.Equal(t, userID, principal.UserID)
+	assert.Equal(t, sessionID, principal.SessionID)
+	assert.Equal(t, "admin", principal.Role)
+	assert.True(t, principal.HasScope("users:read"))
+	assert.Equal(t, authTime, principal.AuthTime)
+	assert.Equal(t, domain.ACRMultiFactor, principal.ACR)
+	require.NotNil(t, principal.OrgID)
+	assert.Equal(t, orgID, *principal.OrgID)
+	assert.Equal(t, "owner", principal.OrgRole)
+	assert.Nil(t, principal.GrantID)
+	assert.False(t, principal.Guest)
+	assert.Equal(t, map[string]interface{}{"tier": "pro"}, principal.CustomClaims("billing"))
+	assert.Nil(t, principal.CustomClaims("cohorts"))
+}
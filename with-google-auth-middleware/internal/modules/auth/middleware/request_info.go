@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+)
+
+// RequestInfo puts the client IP and user agent of the request on its
+// context, where the usecases recording security events read them. The
+// client IP is taken from X-Forwarded-For only if the request comes from one
+// of the engine's trusted proxies (TRUSTED_PROXIES), otherwise it is the
+// remote address of the connection.
+func RequestInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := domain.WithRequestInfo(c.Request.Context(), domain.RequestInfo{
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
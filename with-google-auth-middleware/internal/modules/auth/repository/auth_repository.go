@@ -20,6 +20,15 @@ func (r *authRepository) CreateSession(session *domain.Session) error {
 	return r.db.Create(session).Error
 }
 
+func (r *authRepository) GetSessionByID(id uuid.UUID) (*domain.Session, error) {
+	var session domain.Session
+	err := r.db.Where("id = ? AND expires_at > ?", id, time.Now()).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
 func (r *authRepository) GetSessionByRefreshToken(refreshToken string) (*domain.Session, error) {
 	var session domain.Session
 	err := r.db.Where("refresh_token = ? AND expires_at > ?", refreshToken, time.Now()).First(&session).Error
@@ -35,4 +44,43 @@ func (r *authRepository) DeleteSession(id uuid.UUID) error {
 
 func (r *authRepository) DeleteUserSessions(userID uuid.UUID) error {
 	return r.db.Delete(&domain.Session{}, "user_id = ?", userID).Error
-} 
\ No newline at end of file
+}
+
+func (r *authRepository) SetSessionOrganization(id uuid.UUID, orgID *uuid.UUID) error {
+	return r.db.Model(&domain.Session{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"org_id":     orgID,
+		"updated_at": time.Now(),
+	}).Error
+}
+
+// UpgradeGuest consumes the invitation first, like invitationRepository.Accept
+func (r *authRepository) UpgradeGuest(upgrade *domain.GuestUpgrade) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if upgrade.InvitationID != nil {
+			if err := acceptInvitation(tx, *upgrade.InvitationID, upgrade.User.ID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(upgrade.User).Error; err != nil {
+			return err
+		}
+		if upgrade.StaleIdentityID != nil {
+			if err := tx.Delete(&domain.Identity{}, "id = ?", *upgrade.StaleIdentityID).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(upgrade.Identity).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&domain.Session{}, "id = ?", upgrade.GuestSessionID).Error
+	})
+}
+
+func (r *authRepository) GetClientByClientID(clientID string) (*domain.OAuthClient, error) {
+	var client domain.OAuthClient
+	err := r.db.Where("client_id = ?", clientID).First(&client).Error
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"gorm.io/gorm"
+)
+
+type identityRepository struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepository(db *gorm.DB) domain.IdentityRepository {
+	return &identityRepository{db: db}
+}
+
+func (r *identityRepository) Create(identity *domain.Identity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *identityRepository) FindByProviderSubject(provider, subject string) (*domain.Identity, error) {
+	var identity domain.Identity
+	err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *identityRepository) Update(identity *domain.Identity) error {
+	identity.UpdatedAt = time.Now()
+	return r.db.Save(identity).Error
+}
+
+func (r *identityRepository) Delete(id uuid.UUID) error {
+	return r.db.Delete(&domain.Identity{}, "id = ?", id).Error
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/gorm"
+)
+
+type invitationRepository struct {
+	db *gorm.DB
+}
+
+func NewInvitationRepository(db *gorm.DB) domain.InvitationRepository {
+	return &invitationRepository{db: db}
+}
+
+func (r *invitationRepository) Create(invitation *domain.Invitation) error {
+	return r.db.Create(invitation).Error
+}
+
+func (r *invitationRepository) FindPendingByEmail(email string) (*domain.Invitation, error) {
+	var invitation domain.Invitation
+	err := r.db.
+		Where("LOWER(email) = LOWER(?) AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", email, time.Now()).
+		Order("created_at DESC").
+		First(&invitation).Error
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (r *invitationRepository) GetAll(page, limit int) ([]*domain.Invitation, error) {
+	var invitations []*domain.Invitation
+	offset := (page - 1) * limit
+	err := r.db.Order("created_at DESC").Offset(offset).Limit(limit).Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// Accept consumes the invitation first, so that of two logins racing for it
+// only one gets its role; the other's user isn't stored.
+func (r *invitationRepository) Accept(id uuid.UUID, user *userdomain.User, create bool) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := acceptInvitation(tx, id, user.ID); err != nil {
+			return err
+		}
+		if create {
+			return tx.Create(user).Error
+		}
+		return tx.Save(user).Error
+	})
+}
+
+// acceptInvitation marks a pending invitation accepted by the user, or returns
+// gorm.ErrRecordNotFound if it was accepted or revoked
+func acceptInvitation(tx *gorm.DB, id, userID uuid.UUID) error {
+	now := time.Now()
+	result := tx.Model(&domain.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"accepted_at": now,
+			"accepted_by": userID,
+			"updated_at":  now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *invitationRepository) Revoke(id uuid.UUID) error {
+	now := time.Now()
+	result := r.db.Model(&domain.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"revoked_at": now,
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
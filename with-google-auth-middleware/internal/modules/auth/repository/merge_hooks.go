@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	userrepo "github.com/tyobaskara/jeki-backend/internal/modules/user/repository"
+	"gorm.io/gorm"
+)
+
+// MergeHooks move the auth module's rows of a user merged into another user:
+// the sessions stay signed in, and linked identities, third-party grants and
+// the login history follow the user
+var MergeHooks = []userrepo.MergeHook{
+	{Name: "identities", Merge: mergeIdentities},
+	{Name: "oauth_grants", Merge: mergeGrants},
+	{Name: "sessions", Merge: mergeSessions},
+	{Name: "security_events", Merge: mergeSecurityEvents},
+}
+
+func mergeIdentities(tx *gorm.DB, sourceID, targetID uuid.UUID) (int64, error) {
+	result := tx.Exec("UPDATE identities SET user_id = ?, updated_at = NOW() WHERE user_id = ?", targetID, sourceID)
+	return result.RowsAffected, result.Error
+}
+
+// mergeGrants keeps the target's grant of a client both users authorized, the
+// source's grant is deleted with its sessions and codes
+func mergeGrants(tx *gorm.DB, sourceID, targetID uuid.UUID) (int64, error) {
+	err := tx.Exec(`DELETE FROM oauth_grants s WHERE s.user_id = ? AND EXISTS (
+		SELECT 1 FROM oauth_grants t WHERE t.user_id = ? AND t.client_id = s.client_id
+	)`, sourceID, targetID).Error
+	if err != nil {
+		return 0, err
+	}
+	result := tx.Exec("UPDATE oauth_grants SET user_id = ?, updated_at = NOW() WHERE user_id = ?", targetID, sourceID)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if err := tx.Exec("UPDATE oauth_authorization_codes SET user_id = ? WHERE user_id = ?", targetID, sourceID).Error; err != nil {
+		return 0, err
+	}
+	return result.RowsAffected, nil
+}
+
+func mergeSessions(tx *gorm.DB, sourceID, targetID uuid.UUID) (int64, error) {
+	result := tx.Exec("UPDATE sessions SET user_id = ?, updated_at = NOW() WHERE user_id = ?", targetID, sourceID)
+	return result.RowsAffected, result.Error
+}
+
+func mergeSecurityEvents(tx *gorm.DB, sourceID, targetID uuid.UUID) (int64, error) {
+	result := tx.Exec("UPDATE security_events SET user_id = ? WHERE user_id = ?", targetID, sourceID)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id UUID PRIMARY KEY,
+    client_id VARCHAR(255) NOT NULL UNIQUE,
+    secret_hash VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_oauth_clients_client_id ON oauth_clients(client_id);
@@ -0,0 +1 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS client VARCHAR(64) NOT NULL DEFAULT '';
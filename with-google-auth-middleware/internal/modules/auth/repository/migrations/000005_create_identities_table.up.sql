@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS identities (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    is_private_email BOOLEAN NOT NULL DEFAULT FALSE,
+    email_disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    refresh_token TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_identities_user_id ON identities(user_id);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_sessions_grant_id;
+ALTER TABLE sessions DROP COLUMN IF EXISTS scope;
+ALTER TABLE sessions DROP COLUMN IF EXISTS grant_id;
+DROP TABLE IF EXISTS oauth_authorization_codes;
+DROP TABLE IF EXISTS oauth_grants;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS created_by;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS internal;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS public;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS redirect_uris;
\ No newline at end of file
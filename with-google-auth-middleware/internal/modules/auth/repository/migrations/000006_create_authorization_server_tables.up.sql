@@ -0,0 +1,41 @@
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS redirect_uris JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS public BOOLEAN NOT NULL DEFAULT FALSE;
+-- Clients registered before third-party apps were supported are our own services
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS internal BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS created_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS oauth_grants (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id VARCHAR(255) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    scope TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, client_id)
+);
+
+CREATE INDEX idx_oauth_grants_client_id ON oauth_grants(client_id);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+    id UUID PRIMARY KEY,
+    code_hash VARCHAR(64) NOT NULL UNIQUE,
+    client_id VARCHAR(255) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    grant_id UUID NOT NULL REFERENCES oauth_grants(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128) NOT NULL,
+    nonce TEXT NOT NULL DEFAULT '',
+    auth_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    session_id UUID,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_oauth_authorization_codes_expires_at ON oauth_authorization_codes(expires_at);
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS grant_id UUID REFERENCES oauth_grants(id) ON DELETE CASCADE;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_sessions_grant_id ON sessions(grant_id);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS oauth_device_codes (
+    id UUID PRIMARY KEY,
+    device_code_hash VARCHAR(64) NOT NULL UNIQUE,
+    user_code VARCHAR(16) NOT NULL UNIQUE,
+    client_id VARCHAR(255) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    scope TEXT NOT NULL DEFAULT '',
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    grant_id UUID REFERENCES oauth_grants(id) ON DELETE CASCADE,
+    auth_time TIMESTAMP WITH TIME ZONE,
+    denied_at TIMESTAMP WITH TIME ZONE,
+    poll_interval INTEGER NOT NULL,
+    last_polled_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_oauth_device_codes_expires_at ON oauth_device_codes(expires_at);
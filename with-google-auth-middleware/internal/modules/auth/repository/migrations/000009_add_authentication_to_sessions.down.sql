@@ -0,0 +1,3 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS amr;
+ALTER TABLE sessions DROP COLUMN IF EXISTS acr;
+ALTER TABLE sessions DROP COLUMN IF EXISTS auth_time;
\ No newline at end of file
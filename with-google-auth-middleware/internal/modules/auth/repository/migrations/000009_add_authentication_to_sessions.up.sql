@@ -0,0 +1,6 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP WITH TIME ZONE;
+-- Sessions started before sign-ins were recorded were authenticated when they were created
+UPDATE sessions SET auth_time = created_at WHERE auth_time IS NULL;
+ALTER TABLE sessions ALTER COLUMN auth_time SET NOT NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS acr VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS amr JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS org_id;
\ No newline at end of file
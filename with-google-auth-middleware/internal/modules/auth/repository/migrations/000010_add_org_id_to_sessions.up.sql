@@ -0,0 +1,4 @@
+-- The organization the session is switched to. Not a foreign key, the
+-- organizations table belongs to the organization module and its migrations;
+-- sessions of deleted organizations switch back on their next refresh.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS org_id UUID;
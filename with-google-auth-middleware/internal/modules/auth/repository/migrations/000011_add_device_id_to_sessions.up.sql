@@ -0,0 +1,3 @@
+-- The device a guest session was created for, Google sign-in only upgrades
+-- the guest of the device it's called from
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_id VARCHAR(255) NOT NULL DEFAULT '';
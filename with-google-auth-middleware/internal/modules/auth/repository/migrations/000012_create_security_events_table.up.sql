@@ -0,0 +1,23 @@
+-- Login history of users: sign-ins, refreshes, logouts and session revocations
+CREATE TABLE IF NOT EXISTS security_events (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id UUID,
+    type VARCHAR(32) NOT NULL,
+    outcome VARCHAR(16) NOT NULL,
+    reason VARCHAR(64) NOT NULL DEFAULT '',
+    provider VARCHAR(255) NOT NULL DEFAULT '',
+    client VARCHAR(255) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    device_id VARCHAR(255) NOT NULL DEFAULT '',
+    device VARCHAR(64) NOT NULL DEFAULT '',
+    new_device BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Pages of a user's events, newest first
+CREATE INDEX idx_security_events_user_id_created_at ON security_events(user_id, created_at DESC, id DESC);
+-- Devices a user signed in from
+CREATE INDEX idx_security_events_user_id_device ON security_events(user_id, device)
+    WHERE type = 'login' AND outcome = 'success';
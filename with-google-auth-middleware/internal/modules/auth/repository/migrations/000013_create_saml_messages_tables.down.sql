@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS saml_assertions;
+DROP TABLE IF EXISTS saml_requests;
\ No newline at end of file
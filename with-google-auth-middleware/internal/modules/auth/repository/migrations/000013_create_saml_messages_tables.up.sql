@@ -0,0 +1,21 @@
+-- IDs of the AuthnRequests we sent, consumed by the response answering them
+CREATE TABLE IF NOT EXISTS saml_requests (
+    provider VARCHAR(255) NOT NULL,
+    id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, id)
+);
+
+CREATE INDEX idx_saml_requests_expires_at ON saml_requests(expires_at);
+
+-- IDs of the assertions we consumed, remembered until they expire so they can't be replayed
+CREATE TABLE IF NOT EXISTS saml_assertions (
+    provider VARCHAR(255) NOT NULL,
+    id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, id)
+);
+
+CREATE INDEX idx_saml_assertions_expires_at ON saml_assertions(expires_at);
@@ -0,0 +1,12 @@
+-- OpenID Connect logins started with an authorization URL, consumed by the login
+-- they were started for
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    provider VARCHAR(255) NOT NULL,
+    state_hash VARCHAR(64) NOT NULL,
+    nonce VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, state_hash)
+);
+
+CREATE INDEX idx_oidc_logins_expires_at ON oidc_logins(expires_at);
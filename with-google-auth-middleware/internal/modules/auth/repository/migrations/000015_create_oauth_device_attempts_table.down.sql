@@ -0,0 +1 @@
+DROP TABLE IF EXISTS oauth_device_attempts;
\ No newline at end of file
@@ -0,0 +1,8 @@
+-- Invalid user codes entered per user on the device verification page, users
+-- are locked out after too many
+CREATE TABLE IF NOT EXISTS oauth_device_attempts (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    failures INTEGER NOT NULL,
+    failed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"gorm.io/gorm"
+)
+
+type oauthRepository struct {
+	db *gorm.DB
+}
+
+func NewOAuthRepository(db *gorm.DB) domain.OAuthRepository {
+	return &oauthRepository{db: db}
+}
+
+func (r *oauthRepository) CreateClient(client *domain.OAuthClient) error {
+	return r.db.Create(client).Error
+}
+
+func (r *oauthRepository) GetClients(page, limit int) ([]*domain.OAuthClient, error) {
+	var clients []*domain.OAuthClient
+	offset := (page - 1) * limit
+	err := r.db.Order("created_at DESC").Offset(offset).Limit(limit).Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// DeleteClient deletes a client. Its grants, authorization codes and sessions
+// are deleted by the database.
+func (r *oauthRepository) DeleteClient(clientID string) error {
+	result := r.db.Delete(&domain.OAuthClient{}, "client_id = ?", clientID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *oauthRepository) CreateGrant(grant *domain.OAuthGrant) error {
+	return r.db.Omit("Client").Create(grant).Error
+}
+
+func (r *oauthRepository) UpdateGrant(grant *domain.OAuthGrant) error {
+	return r.db.Omit("Client").Save(grant).Error
+}
+
+func (r *oauthRepository) FindGrant(userID uuid.UUID, clientID string) (*domain.OAuthGrant, error) {
+	var grant domain.OAuthGrant
+	err := r.db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&grant).Error
+	if err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func (r *oauthRepository) FindGrantByID(id uuid.UUID) (*domain.OAuthGrant, error) {
+	var grant domain.OAuthGrant
+	err := r.db.Where("id = ?", id).First(&grant).Error
+	if err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func (r *oauthRepository) GetUserGrants(userID uuid.UUID) ([]*domain.OAuthGrant, error) {
+	var grants []*domain.OAuthGrant
+	err := r.db.Preload("Client").Where("user_id = ?", userID).Order("created_at DESC").Find(&grants).Error
+	if err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// DeleteGrant deletes a grant. Sessions and authorization codes issued under
+// the grant are deleted by the database.
+func (r *oauthRepository) DeleteGrant(id uuid.UUID) error {
+	return r.db.Delete(&domain.OAuthGrant{}, "id = ?", id).Error
+}
+
+func (r *oauthRepository) CreateAuthorizationCode(code *domain.OAuthAuthorizationCode) error {
+	return r.db.Create(code).Error
+}
+
+func (r *oauthRepository) FindAuthorizationCode(codeHash string) (*domain.OAuthAuthorizationCode, error) {
+	var code domain.OAuthAuthorizationCode
+	err := r.db.Where("code_hash = ?", codeHash).First(&code).Error
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (r *oauthRepository) MarkAuthorizationCodeUsed(id uuid.UUID) (bool, error) {
+	result := r.db.Model(&domain.OAuthAuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *oauthRepository) SetAuthorizationCodeSession(id, sessionID uuid.UUID) error {
+	return r.db.Model(&domain.OAuthAuthorizationCode{}).Where("id = ?", id).Update("session_id", sessionID).Error
+}
+
+func (r *oauthRepository) CreateDeviceCode(code *domain.OAuthDeviceCode) error {
+	return r.db.Create(code).Error
+}
+
+func (r *oauthRepository) FindDeviceCode(deviceCodeHash string) (*domain.OAuthDeviceCode, error) {
+	var code domain.OAuthDeviceCode
+	err := r.db.Where("device_code_hash = ?", deviceCodeHash).First(&code).Error
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (r *oauthRepository) FindDeviceCodeByUserCode(userCode string) (*domain.OAuthDeviceCode, error) {
+	var code domain.OAuthDeviceCode
+	err := r.db.Where("user_code = ?", userCode).First(&code).Error
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (r *oauthRepository) DecideDeviceCode(code *domain.OAuthDeviceCode) (bool, error) {
+	result := r.db.Model(&domain.OAuthDeviceCode{}).
+		Where("id = ? AND user_id IS NULL AND denied_at IS NULL", code.ID).
+		Updates(map[string]interface{}{
+			"user_id":   code.UserID,
+			"grant_id":  code.GrantID,
+			"auth_time": code.AuthTime,
+			"denied_at": code.DeniedAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *oauthRepository) UpdateDeviceCodePoll(id uuid.UUID, polledAt time.Time, interval int) error {
+	return r.db.Model(&domain.OAuthDeviceCode{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"last_polled_at": polledAt,
+		"poll_interval":  interval,
+	}).Error
+}
+
+func (r *oauthRepository) DeleteDeviceCode(id uuid.UUID) (bool, error) {
+	result := r.db.Delete(&domain.OAuthDeviceCode{}, "id = ?", id)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *oauthRepository) DeleteExpiredDeviceCodes(before time.Time) error {
+	return r.db.Delete(&domain.OAuthDeviceCode{}, "expires_at < ?", before).Error
+}
+
+func (r *oauthRepository) FindDeviceAttempts(userID uuid.UUID) (*domain.OAuthDeviceAttempts, error) {
+	var attempts domain.OAuthDeviceAttempts
+	if err := r.db.Where("user_id = ?", userID).First(&attempts).Error; err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+func (r *oauthRepository) AddDeviceFailure(userID uuid.UUID, failedAt, since time.Time) (*domain.OAuthDeviceAttempts, error) {
+	var attempts domain.OAuthDeviceAttempts
+	err := r.db.Raw(`INSERT INTO oauth_device_attempts (user_id, failures, failed_at) VALUES (?, 1, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			failures = CASE WHEN oauth_device_attempts.failed_at < ? THEN 1 ELSE oauth_device_attempts.failures + 1 END,
+			failed_at = EXCLUDED.failed_at
+		RETURNING *`, userID, failedAt, since).Scan(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+func (r *oauthRepository) LockDeviceAttempts(userID uuid.UUID, until time.Time) error {
+	return r.db.Model(&domain.OAuthDeviceAttempts{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"failures":     0,
+		"locked_until": until,
+	}).Error
+}
+
+func (r *oauthRepository) DeleteDeviceAttempts(userID uuid.UUID) error {
+	return r.db.Delete(&domain.OAuthDeviceAttempts{}, "user_id = ?", userID).Error
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type oidcLoginRepository struct {
+	db *gorm.DB
+}
+
+func NewOIDCLoginRepository(db *gorm.DB) domain.OIDCLoginRepository {
+	return &oidcLoginRepository{db: db}
+}
+
+func (r *oidcLoginRepository) Create(login *domain.OIDCLogin) error {
+	return r.db.Create(login).Error
+}
+
+func (r *oidcLoginRepository) Take(provider, stateHash string, now time.Time) (*domain.OIDCLogin, error) {
+	var logins []domain.OIDCLogin
+	result := r.db.Model(&logins).Clauses(clause.Returning{}).
+		Where("provider = ? AND state_hash = ? AND expires_at > ?", provider, stateHash, now).
+		Delete(&logins)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(logins) == 0 {
+		return nil, nil
+	}
+	return &logins[0], nil
+}
+
+func (r *oidcLoginRepository) DeleteExpired(before time.Time) error {
+	return r.db.Delete(&domain.OIDCLogin{}, "expires_at < ?", before).Error
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	privacyrepo "github.com/tyobaskara/jeki-backend/internal/modules/privacy/repository"
+	"gorm.io/gorm"
+)
+
+// ExportHooks export the auth module's rows of a user. Refresh tokens and
+// code hashes are left out.
+var ExportHooks = []privacyrepo.ExportHook{
+	{Name: "identities", Export: exportIdentities},
+	{Name: "sessions", Export: exportSessions},
+	{Name: "oauth_grants", Export: exportGrants},
+	{Name: "security_events", Export: exportSecurityEvents},
+	{Name: "invitations", Export: exportInvitations},
+}
+
+// ErasureHooks delete the auth module's rows of a user. Most would go with the
+// user, they're deleted here to be counted; the invitation the user accepted
+// would outlive them with their email.
+var ErasureHooks = []privacyrepo.ErasureHook{
+	{Name: "sessions", Erase: eraseSessions},
+	{Name: "identities", Erase: eraseIdentities},
+	{Name: "oauth_grants", Erase: eraseGrants},
+	{Name: "oauth_device_codes", Erase: eraseDeviceCodes},
+	{Name: "oauth_device_attempts", Erase: eraseDeviceAttempts},
+	{Name: "security_events", Erase: eraseSecurityEvents},
+	{Name: "invitations", Erase: eraseInvitations},
+}
+
+func exportIdentities(db *gorm.DB, userID uuid.UUID) (interface{}, error) {
+	identities := []*domain.Identity{}
+	err := db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+func exportSessions(db *gorm.DB, userID uuid.UUID) (interface{}, error) {
+	sessions := []*domain.Session{}
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		session.RefreshToken = ""
+	}
+	return sessions, nil
+}
+
+func exportGrants(db *gorm.DB, userID uuid.UUID) (interface{}, error) {
+	grants := []*domain.OAuthGrant{}
+	err := db.Where("user_id = ?", userID).Order("created_at").Find(&grants).Error
+	return grants, err
+}
+
+func exportSecurityEvents(db *gorm.DB, userID uuid.UUID) (interface{}, error) {
+	events := []*domain.SecurityEvent{}
+	err := db.Where("user_id = ?", userID).Order("created_at, id").Find(&events).Error
+	return events, err
+}
+
+func exportInvitations(db *gorm.DB, userID uuid.UUID) (interface{}, error) {
+	invitations := []*domain.Invitation{}
+	err := db.Where("accepted_by = ?", userID).Order("created_at").Find(&invitations).Error
+	return invitations, err
+}
+
+func eraseSessions(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	return result.RowsAffected, result.Error
+}
+
+func eraseIdentities(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("DELETE FROM identities WHERE user_id = ?", userID)
+	return result.RowsAffected, result.Error
+}
+
+func eraseGrants(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	if err := tx.Exec("DELETE FROM oauth_authorization_codes WHERE user_id = ?", userID).Error; err != nil {
+		return 0, err
+	}
+	result := tx.Exec("DELETE FROM oauth_grants WHERE user_id = ?", userID)
+	return result.RowsAffected, result.Error
+}
+
+func eraseDeviceCodes(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("DELETE FROM oauth_device_codes WHERE user_id = ?", userID)
+	return result.RowsAffected, result.Error
+}
+
+func eraseDeviceAttempts(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("DELETE FROM oauth_device_attempts WHERE user_id = ?", userID)
+	return result.RowsAffected, result.Error
+}
+
+func eraseSecurityEvents(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("DELETE FROM security_events WHERE user_id = ?", userID)
+	return result.RowsAffected, result.Error
+}
+
+func eraseInvitations(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("DELETE FROM invitations WHERE accepted_by = ?", userID)
+	return result.RowsAffected, result.Error
+}
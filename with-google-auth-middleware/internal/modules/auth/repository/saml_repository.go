@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type samlMessageRepository struct {
+	db *gorm.DB
+}
+
+func NewSAMLMessageRepository(db *gorm.DB) domain.SAMLMessageRepository {
+	return &samlMessageRepository{db: db}
+}
+
+func (r *samlMessageRepository) CreateRequest(request *domain.SAMLMessage) error {
+	return r.db.Table("saml_requests").Create(request).Error
+}
+
+func (r *samlMessageRepository) TakeRequest(provider, id string, now time.Time) (bool, error) {
+	result := r.db.Table("saml_requests").
+		Where("provider = ? AND id = ? AND expires_at > ?", provider, id, now).
+		Delete(&domain.SAMLMessage{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *samlMessageRepository) UseAssertion(assertion *domain.SAMLMessage) (bool, error) {
+	result := r.db.Table("saml_assertions").Clauses(clause.OnConflict{DoNothing: true}).Create(assertion)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *samlMessageRepository) DeleteExpired(before time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("saml_requests").Delete(&domain.SAMLMessage{}, "expires_at < ?", before).Error; err != nil {
+			return err
+		}
+		return tx.Table("saml_assertions").Delete(&domain.SAMLMessage{}, "expires_at < ?", before).Error
+	})
+}
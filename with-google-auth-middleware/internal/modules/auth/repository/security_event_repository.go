@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"gorm.io/gorm"
+)
+
+type securityEventRepository struct {
+	db *gorm.DB
+}
+
+func NewSecurityEventRepository(db *gorm.DB) domain.SecurityEventRepository {
+	return &securityEventRepository{db: db}
+}
+
+func (r *securityEventRepository) Create(event *domain.SecurityEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *securityEventRepository) ListByUser(userID uuid.UUID, after *domain.SecurityEventCursor, limit int) ([]*domain.SecurityEvent, error) {
+	query := r.db.Where("user_id = ?", userID)
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.CreatedAt, after.ID)
+	}
+
+	var events []*domain.SecurityEvent
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *securityEventRepository) HasLogin(userID uuid.UUID, device string) (bool, error) {
+	query := r.db.Model(&domain.SecurityEvent{}).
+		Where("user_id = ? AND type = ? AND outcome = ?", userID, domain.SecurityEventLogin, domain.SecurityOutcomeSuccess)
+	if device != "" {
+		query = query.Where("device = ?", device)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
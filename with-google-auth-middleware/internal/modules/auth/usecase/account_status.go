@@ -0,0 +1,111 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// defaultAccountStatusTTL is how long a looked up status is used when no TTL is configured
+const defaultAccountStatusTTL = 30 * time.Second
+
+// accountStatusCache looks up account statuses for AuthMiddleware and keeps
+// them for a short time, so that not every request queries the users table. A
+// status change takes effect at the latest after the TTL. The cache lives in
+// memory, each instance looks the status up on its own.
+type accountStatusCache struct {
+	userRepo userdomain.UserRepository
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	entries   map[uuid.UUID]accountStatusEntry
+	nextPrune time.Time
+}
+
+type accountStatusEntry struct {
+	status    string
+	expiresAt time.Time
+}
+
+// NewAccountStatusCache returns the account status lookup of AuthMiddleware.
+// Statuses are cached for ttl, 30 seconds when zero.
+func NewAccountStatusCache(userRepo userdomain.UserRepository, ttl time.Duration) domain.AccountStatusLookup {
+	if ttl <= 0 {
+		ttl = defaultAccountStatusTTL
+	}
+	return &accountStatusCache{
+		userRepo: userRepo,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  map[uuid.UUID]accountStatusEntry{},
+	}
+}
+
+func (c *accountStatusCache) AccountStatus(ctx context.Context, userID uuid.UUID) (string, error) {
+	now := c.now()
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.status, nil
+	}
+
+	user, err := c.userRepo.FindByID(userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to find user: %w", err)
+	}
+	status := accountStatus(user, now)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.After(c.nextPrune) {
+		for id, cached := range c.entries {
+			if !now.Before(cached.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+		c.nextPrune = now.Add(time.Minute)
+	}
+	expiresAt := now.Add(c.ttl)
+	// A suspension that runs out sooner is looked up again when it ends
+	if status == userdomain.StatusSuspended && user.SuspendedUntil != nil && user.SuspendedUntil.Before(expiresAt) {
+		expiresAt = *user.SuspendedUntil
+	}
+	// So does the expiry of a guest
+	if status == userdomain.StatusActive && user.Guest && user.GuestExpiresAt != nil && user.GuestExpiresAt.Before(expiresAt) {
+		expiresAt = *user.GuestExpiresAt
+	}
+	c.entries[userID] = accountStatusEntry{status: status, expiresAt: expiresAt}
+	return status, nil
+}
+
+// accountStatus returns the status of a user at the given time, domain.AccountDeactivated
+// for deleted users, users deactivated by a provisioning client and expired guests
+func accountStatus(user *userdomain.User, now time.Time) string {
+	if user == nil || !user.Active || user.GuestExpired(now) {
+		return domain.AccountDeactivated
+	}
+	return user.EffectiveStatus(now)
+}
+
+// checkAccount returns the error for users who may not sign in or use their
+// tokens, nil for active users
+func checkAccount(user *userdomain.User) error {
+	switch accountStatus(user, time.Now()) {
+	case userdomain.StatusActive:
+		return nil
+	case userdomain.StatusSuspended:
+		return ErrAccountSuspended
+	case userdomain.StatusBanned:
+		return ErrAccountBanned
+	case userdomain.StatusPending:
+		return ErrAccountPending
+	}
+	return ErrAccountDeactivated
+}
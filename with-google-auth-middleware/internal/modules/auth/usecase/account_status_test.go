@@ -0,0 +1,127 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+func TestCheckAccount(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		user *userdomain.User
+		want error
+	}{
+		{"active", &userdomain.User{Active: true, Status: userdomain.StatusActive}, nil},
+		{"status not set", &userdomain.User{Active: true}, nil},
+		{"deleted", nil, ErrAccountDeactivated},
+		{"deactivated by provisioning", &userdomain.User{Active: false, Status: userdomain.StatusActive}, ErrAccountDeactivated},
+		{"suspended", &userdomain.User{Active: true, Status: userdomain.StatusSuspended, SuspendedUntil: &future}, ErrAccountSuspended},
+		{"suspension over", &userdomain.User{Active: true, Status: userdomain.StatusSuspended, SuspendedUntil: &past}, nil},
+		{"banned", &userdomain.User{Active: true, Status: userdomain.StatusBanned}, ErrAccountBanned},
+		{"pending", &userdomain.User{Active: true, Status: userdomain.StatusPending}, ErrAccountPending},
+		{"guest", &userdomain.User{Active: true, Guest: true, GuestExpiresAt: &future}, nil},
+		{"expired guest", &userdomain.User{Active: true, Guest: true, GuestExpiresAt: &past}, ErrAccountDeactivated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, checkAccount(tt.user))
+		})
+	}
+}
+
+func TestLoginRejectsSuspendedUser(t *testing.T) {
+	authRepo := &fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{}}
+	until := time.Now().Add(time.Hour)
+	user := &userdomain.User{ID: uuid.New(), Role: userdomain.RoleUser, Active: true}
+	u := NewAuthUsecase(authRepo, &fakeUserRepository{users: map[uuid.UUID]*userdomain.User{user.ID: user}}, nil, nil, AuthUsecaseConfig{
+		JWTSecret:   "test-secret",
+		TokenConfig: TokenConfig{AccessTTL: 15 * time.Minute, RefreshTTL: time.Hour},
+	})
+	session := &domain.Session{ID: uuid.New(), UserID: user.ID, RefreshToken: uuid.NewString(), ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, authRepo.CreateSession(session))
+	ctx := context.Background()
+
+	_, err := u.RefreshToken(ctx, session.RefreshToken, domain.DPoPProofRequest{})
+	require.NoError(t, err)
+
+	user.Status = userdomain.StatusSuspended
+	user.SuspendedUntil = &until
+	_, err = u.RefreshToken(ctx, session.RefreshToken, domain.DPoPProofRequest{})
+	assert.ErrorIs(t, err, ErrAccountSuspended)
+
+	user.Status = userdomain.StatusBanned
+	user.SuspendedUntil = nil
+	_, err = u.RefreshToken(ctx, session.RefreshToken, domain.DPoPProofRequest{})
+	assert.ErrorIs(t, err, ErrAccountBanned)
+}
+
+// countingUserRepository counts the lookups of the account status cache
+type countingUserRepository struct {
+	fakeUserRepository
+	lookups int
+}
+
+func (r *countingUserRepository) FindByID(id uuid.UUID) (*userdomain.User, error) {
+	r.lookups++
+	return r.fakeUserRepository.FindByID(id)
+}
+
+func TestAccountStatusCache(t *testing.T) {
+	user := &userdomain.User{ID: uuid.New(), Active: true, Status: userdomain.StatusActive}
+	repo := &countingUserRepository{fakeUserRepository: fakeUserRepository{users: map[uuid.UUID]*userdomain.User{user.ID: user}}}
+	now := time.Now()
+	cache := NewAccountStatusCache(repo, time.Minute).(*accountStatusCache)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	status, err := cache.AccountStatus(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, userdomain.StatusActive, status)
+
+	t.Run("cached", func(t *testing.T) {
+		user.Status = userdomain.StatusBanned
+		status, err := cache.AccountStatus(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, userdomain.StatusActive, status)
+		assert.Equal(t, 1, repo.lookups)
+	})
+
+	t.Run("looked up again after the TTL", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		status, err := cache.AccountStatus(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, userdomain.StatusBanned, status)
+		assert.Equal(t, 2, repo.lookups)
+	})
+
+	t.Run("suspension ending before the TTL", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		until := now.Add(10 * time.Second)
+		user.Status = userdomain.StatusSuspended
+		user.SuspendedUntil = &until
+		status, err := cache.AccountStatus(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, userdomain.StatusSuspended, status)
+
+		now = until
+		status, err = cache.AccountStatus(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, userdomain.StatusActive, status)
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		status, err := cache.AccountStatus(ctx, uuid.New())
+		require.NoError(t, err)
+		assert.Equal(t, domain.AccountDeactivated, status)
+	})
+}
@@ -0,0 +1,342 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/gorm"
+)
+
+// Custom errors
+var (
+	ErrAppleAuthFailed    = errors.New("failed to authenticate with Apple")
+	ErrAppleNotConfigured = errors.New("sign in with Apple is not configured")
+)
+
+// defaultAppleBaseURL is Apple's issuer and the base URL of its endpoints
+const defaultAppleBaseURL = "https://appleid.apple.com"
+
+// appleClientSecretTTL is the lifetime of the client secret JWT; Apple allows up to six months
+const appleClientSecretTTL = 5 * time.Minute
+
+// Apple server-to-server notification event types
+const (
+	appleEventEmailDisabled  = "email-disabled"
+	appleEventEmailEnabled   = "email-enabled"
+	appleEventConsentRevoked = "consent-revoked"
+	appleEventAccountDelete  = "account-delete"
+)
+
+// AppleConfig configures Sign in with Apple
+type AppleConfig struct {
+	// TeamID is the Apple Developer team ID, the issuer of the client secret
+	TeamID string
+	// KeyID identifies the Sign in with Apple private key
+	KeyID string
+	// PrivateKey is the PEM encoded .p8 key the client secret is signed with
+	PrivateKey string
+	// BaseURL is Apple's issuer and endpoint base URL. Tests point it at a local stub.
+	BaseURL string
+}
+
+// appleBool decodes Apple claims that are sent either as booleans or as "true"/"false" strings
+type appleBool bool
+
+func (b *appleBool) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	*b = appleBool(value == "true")
+	return nil
+}
+
+// appleIdentityClaims are the claims of an Apple identity token
+type appleIdentityClaims struct {
+	jwt.RegisteredClaims
+	Email          string    `json:"email"`
+	EmailVerified  appleBool `json:"email_verified"`
+	IsPrivateEmail appleBool `json:"is_private_email"`
+}
+
+// appleTokenResponse is the response of Apple's token endpoint
+type appleTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+}
+
+// appleNotificationClaims are the claims of a server-to-server notification payload
+type appleNotificationClaims struct {
+	jwt.RegisteredClaims
+	Events json.RawMessage `json:"events"`
+}
+
+// appleNotificationEvent is the event carried by a server-to-server notification
+type appleNotificationEvent struct {
+	Type           string    `json:"type"`
+	Subject        string    `json:"sub"`
+	Email          string    `json:"email"`
+	IsPrivateEmail appleBool `json:"is_private_email"`
+	EventTime      int64     `json:"event_time"`
+}
+
+// appleProvider verifies Apple identity tokens and talks to Apple's endpoints
+type appleProvider struct {
+	cfg        AppleConfig
+	keys       *jwksCache
+	httpClient *http.Client
+}
+
+func newAppleProvider(cfg AppleConfig) *appleProvider {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultAppleBaseURL
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	return &appleProvider{
+		cfg:        cfg,
+		keys:       newJWKSCache(cfg.BaseURL+"/auth/keys", httpClient),
+		httpClient: httpClient,
+	}
+}
+
+// verifyIdentityToken checks an identity token signed by Apple for one of the audiences
+func (p *appleProvider) verifyIdentityToken(ctx context.Context, token string, audiences []string) (*appleIdentityClaims, error) {
+	var claims appleIdentityClaims
+	_, err := jwt.ParseWithClaims(token, &claims, p.keys.Keyfunc(ctx),
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(p.cfg.BaseURL),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity token: %w", err)
+	}
+
+	for _, aud := range claims.Audience {
+		if containsString(audiences, aud) {
+			return &claims, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid identity token audience %v", claims.Audience)
+}
+
+// clientSecret generates the ES256 JWT Apple expects as client_secret
+func (p *appleProvider) clientSecret(clientID string) (string, error) {
+	if p.cfg.TeamID == "" || p.cfg.KeyID == "" || p.cfg.PrivateKey == "" {
+		return "", ErrAppleNotConfigured
+	}
+
+	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(p.cfg.PrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid Apple private key: %w", err)
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    p.cfg.TeamID,
+		Subject:   clientID,
+		Audience:  jwt.ClaimStrings{p.cfg.BaseURL},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(appleClientSecretTTL)),
+	})
+	token.Header["kid"] = p.cfg.KeyID
+	return token.SignedString(key)
+}
+
+// exchangeCode redeems an authorization code at Apple's token endpoint
+func (p *appleProvider) exchangeCode(ctx context.Context, clientID, code string) (*appleTokenResponse, error) {
+	secret, err := p.clientSecret(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"client_id":     {clientID},
+		"client_secret": {secret},
+		"code":          {code},
+		"grant_type":    {"authorization_code"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.BaseURL+"/auth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokens appleTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	return &tokens, nil
+}
+
+// parseNotification verifies a server-to-server notification payload and returns its event
+func (p *appleProvider) parseNotification(ctx context.Context, payload string, audiences []string) (*appleNotificationEvent, error) {
+	var claims appleNotificationClaims
+	_, err := jwt.ParseWithClaims(payload, &claims, p.keys.Keyfunc(ctx),
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(p.cfg.BaseURL),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification payload: %w", err)
+	}
+
+	audienceOK := false
+	for _, aud := range claims.Audience {
+		audienceOK = audienceOK || containsString(audiences, aud)
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("invalid notification audience %v", claims.Audience)
+	}
+
+	// Apple sends the event as a JSON encoded string inside the JWT
+	events := []byte(claims.Events)
+	if len(events) > 0 && events[0] == '"' {
+		var encoded string
+		if err := json.Unmarshal(events, &encoded); err != nil {
+			return nil, fmt.Errorf("invalid notification events: %w", err)
+		}
+		events = []byte(encoded)
+	}
+
+	var event appleNotificationEvent
+	if err := json.NewDecoder(bytes.NewReader(events)).Decode(&event); err != nil {
+		return nil, fmt.Errorf("invalid notification events: %w", err)
+	}
+	return &event, nil
+}
+
+// appleAudiences returns the Apple client IDs (bundle or services IDs) of the configured apps
+func (u *authUsecase) appleAudiences() []string {
+	var audiences []string
+	for _, client := range u.clients {
+		if client.AppleClientID != "" {
+			audiences = append(audiences, client.AppleClientID)
+		}
+	}
+	return audiences
+}
+
+func (u *authUsecase) clientByAppleID(appleClientID string) *ClientConfig {
+	if appleClientID == "" {
+		return nil
+	}
+	for i := range u.clients {
+		if u.clients[i].AppleClientID == appleClientID {
+			return &u.clients[i]
+		}
+	}
+	return nil
+}
+
+func (u *authUsecase) LoginWithApple(ctx context.Context, req domain.AppleLoginRequest) (*domain.AuthToken, error) {
+	audiences := u.appleAudiences()
+	if len(audiences) == 0 {
+		return nil, ErrAppleNotConfigured
+	}
+
+	claims, err := u.apple.verifyIdentityToken(ctx, req.IdentityToken, audiences)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleAuthFailed, err)
+	}
+	var client *ClientConfig
+	for _, aud := range claims.Audience {
+		if client = u.clientByAppleID(aud); client != nil {
+			break
+		}
+	}
+
+	// Redeeming the authorization code proves the app received it from Apple
+	// and gives us the refresh token needed to revoke access later
+	var appleRefreshToken string
+	if req.AuthorizationCode != "" {
+		tokens, err := u.apple.exchangeCode(ctx, client.AppleClientID, req.AuthorizationCode)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrAppleAuthFailed, err)
+		}
+		exchanged, err := u.apple.verifyIdentityToken(ctx, tokens.IDToken, audiences)
+		if err != nil || exchanged.Subject != claims.Subject {
+			return nil, fmt.Errorf("%w: authorization code belongs to another user", ErrAppleAuthFailed)
+		}
+		appleRefreshToken = tokens.RefreshToken
+	}
+
+	// Apple only sends the user's name to the app on the first authorization,
+	// so it comes from the request instead of the token and may be empty
+	name := strings.TrimSpace(req.GivenName + " " + req.FamilyName)
+	identity := &domain.ExternalIdentity{
+		Provider:       domain.ProviderApple,
+		Subject:        claims.Subject,
+		Email:          claims.Email,
+		EmailVerified:  bool(claims.EmailVerified),
+		IsPrivateEmail: bool(claims.IsPrivateEmail),
+		RefreshToken:   appleRefreshToken,
+		Profile: map[string]string{
+			userdomain.ProfileFieldName:       name,
+			userdomain.ProfileFieldGivenName:  req.GivenName,
+			userdomain.ProfileFieldFamilyName: req.FamilyName,
+		},
+	}
+	return u.loginWithIdentity(ctx, identity, client, "", nil)
+}
+
+func (u *authUsecase) HandleAppleNotification(ctx context.Context, payload string) error {
+	event, err := u.apple.parseNotification(ctx, payload, u.appleAudiences())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	identity, err := u.identityRepo.FindByProviderSubject(domain.ProviderApple, event.Subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Events for users we don't know about are acknowledged and ignored
+			return nil
+		}
+		return fmt.Errorf("failed to find identity: %w", err)
+	}
+
+	switch event.Type {
+	case appleEventEmailDisabled, appleEventEmailEnabled:
+		identity.EmailDisabled = event.Type == appleEventEmailDisabled
+		if err := u.identityRepo.Update(identity); err != nil {
+			return fmt.Errorf("failed to update identity: %w", err)
+		}
+	case appleEventConsentRevoked, appleEventAccountDelete:
+		// The user stopped using their Apple ID with our app: sign them out
+		// everywhere and unlink the Apple ID
+		if err := u.authRepo.DeleteUserSessions(identity.UserID); err != nil {
+			return fmt.Errorf("failed to delete user sessions: %w", err)
+		}
+		if err := u.identityRepo.Delete(identity.ID); err != nil {
+			return fmt.Errorf("failed to delete identity: %w", err)
+		}
+		// Apple's servers sent the notification, not the user's device
+		return u.events.Record(domain.WithRequestInfo(ctx, domain.RequestInfo{}), &domain.SecurityEvent{
+			UserID:   identity.UserID,
+			Type:     domain.SecurityEventSessionRevoked,
+			Outcome:  domain.SecurityOutcomeSuccess,
+			Reason:   "apple_" + strings.ReplaceAll(event.Type, "-", "_"),
+			Provider: domain.ProviderApple,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package usecase
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppleProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks := newTestJWKSServer(t, "apple-key", key)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	require.NoError(t, err)
+	p8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	var baseURL string
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "apple-key"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	identityClaims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":              baseURL,
+			"sub":              "001234.abcdef",
+			"aud":              "com.example.app",
+			"exp":              time.Now().Add(time.Hour).Unix(),
+			"email":            "abc123@privaterelay.appleid.com",
+			"email_verified":   "true",
+			"is_private_email": true,
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/auth/keys", jwks.Config.Handler)
+	mux.HandleFunc("/auth/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.PostForm.Get("code") != "valid-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// The client secret must be signed with the configured key
+		secret, err := jwt.Parse(r.PostForm.Get("client_secret"), func(*jwt.Token) (interface{}, error) {
+			return &clientKey.PublicKey, nil
+		}, jwt.WithIssuer("TEAM123"), jwt.WithSubject(r.PostForm.Get("client_id")))
+		if err != nil || secret.Header["kid"] != "KEY123" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(appleTokenResponse{
+			RefreshToken: "apple-refresh-token",
+			IDToken:      sign(identityClaims(nil)),
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	baseURL = server.URL
+
+	p := newAppleProvider(AppleConfig{
+		TeamID:     "TEAM123",
+		KeyID:      "KEY123",
+		PrivateKey: string(p8),
+		BaseURL:    server.URL,
+	})
+	audiences := []string{"com.example.app"}
+
+	t.Run("identity token", func(t *testing.T) {
+		claims, err := p.verifyIdentityToken(context.Background(), sign(identityClaims(nil)), audiences)
+		require.NoError(t, err)
+		assert.Equal(t, "001234.abcdef", claims.Subject)
+		assert.True(t, bool(claims.EmailVerified))
+		assert.True(t, bool(claims.IsPrivateEmail))
+	})
+
+	t.Run("identity token for another app", func(t *testing.T) {
+		_, err := p.verifyIdentityToken(context.Background(), sign(identityClaims(jwt.MapClaims{"aud": "com.other.app"})), audiences)
+		assert.Error(t, err)
+	})
+
+	t.Run("identity token from another issuer", func(t *testing.T) {
+		_, err := p.verifyIdentityToken(context.Background(), sign(identityClaims(jwt.MapClaims{"iss": "https://evil.example.com"})), audiences)
+		assert.Error(t, err)
+	})
+
+	t.Run("exchange code", func(t *testing.T) {
+		tokens, err := p.exchangeCode(context.Background(), "com.example.app", "valid-code")
+		require.NoError(t, err)
+		assert.Equal(t, "apple-refresh-token", tokens.RefreshToken)
+	})
+
+	t.Run("exchange invalid code", func(t *testing.T) {
+		_, err := p.exchangeCode(context.Background(), "com.example.app", "invalid-code")
+		assert.Error(t, err)
+	})
+
+	t.Run("exchange without private key", func(t *testing.T) {
+		unconfigured := newAppleProvider(AppleConfig{BaseURL: server.URL})
+		_, err := unconfigured.exchangeCode(context.Background(), "com.example.app", "valid-code")
+		assert.ErrorIs(t, err, ErrAppleNotConfigured)
+	})
+
+	t.Run("notification", func(t *testing.T) {
+		payload := sign(jwt.MapClaims{
+			"iss":    baseURL,
+			"aud":    "com.example.app",
+			"iat":    time.Now().Unix(),
+			"events": `{"type":"email-disabled","sub":"001234.abcdef","email":"abc123@privaterelay.appleid.com","is_private_email":"true","event_time":1700000000000}`,
+		})
+		event, err := p.parseNotification(context.Background(), payload, audiences)
+		require.NoError(t, err)
+		assert.Equal(t, appleEventEmailDisabled, event.Type)
+		assert.Equal(t, "001234.abcdef", event.Subject)
+		assert.True(t, bool(event.IsPrivateEmail))
+	})
+
+	t.Run("forged notification", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": baseURL, "aud": "com.example.app", "events": `{}`})
+		token.Header["kid"] = "apple-key"
+		payload, err := token.SignedString(other)
+		require.NoError(t, err)
+		_, err = p.parseNotification(context.Background(), payload, audiences)
+		assert.Error(t, err)
+	})
+}
@@ -7,22 +7,33 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
 	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
 	"golang.org/x/oauth2"
 	"gorm.io/gorm"
 )
 
 // Custom errors
 var (
-	ErrInvalidToken     = errors.New("invalid token")
-	ErrTokenExpired     = errors.New("token has expired")
-	ErrInvalidUserID    = errors.New("invalid user ID")
-	ErrGoogleAuthFailed = errors.New("failed to authenticate with Google")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrTokenExpired       = errors.New("token has expired")
+	ErrInvalidUserID      = errors.New("invalid user ID")
+	ErrGoogleAuthFailed   = errors.New("failed to authenticate with Google")
+	ErrSignInNotAllowed   = errors.New("account is not allowed to sign in")
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrInvitationRequired = errors.New("an invitation is required to register")
+	ErrAccountDeactivated = errors.New("account is deactivated")
+	ErrAccountSuspended   = errors.New("account is suspended")
+	ErrAccountBanned      = errors.New("account is banned")
+	ErrAccountPending     = errors.New("account is pending approval")
+	ErrNotOrgMember       = errors.New("not a member of the organization")
+	ErrEmailInUse         = errors.New("email belongs to another account, verify it to sign in")
 )
 
 type TokenConfig struct {
@@ -35,6 +46,37 @@ type AuthUsecaseConfig struct {
 	ClientSecret string
 	JWTSecret    string
 	TokenConfig  TokenConfig
+	SignInPolicy SignInPolicy
+	// ProfileSync decides which profile values the provider may overwrite on login
+	ProfileSync ProfileSyncPolicy
+	// ProvisioningMode is one of domain.ProvisioningOpen, ProvisioningInviteOnly or ProvisioningClosed
+	ProvisioningMode string
+	// Clients lists the apps whose Google ID tokens are accepted. ClientID is
+	// added as the "web" client if no client uses it.
+	Clients []ClientConfig
+	// Apple configures Sign in with Apple for clients with an AppleClientID
+	Apple AppleConfig
+	// OIDCProviders lists the OpenID Connect providers users may sign in with
+	OIDCProviders []OIDCProviderConfig
+	// OIDCLogins remembers the nonces of the OpenID Connect logins we started
+	OIDCLogins domain.OIDCLoginRepository
+	// SAML configures the SAML service provider and its identity providers
+	SAML SAMLConfig
+	// Scopes is the catalog of API scopes, tokens of our own apps carry all of them
+	Scopes domain.ScopeCatalog
+	// DPoP verifies the DPoP proofs sessions are bound with, nil disables DPoP
+	DPoP domain.DPoPVerifier
+	// Organizations looks up org roles for sessions switched to an organization,
+	// nil disables switching
+	Organizations domain.OrgMemberships
+	// GuestTTL is how long a guest that isn't upgraded is kept, 0 disables guests
+	GuestTTL time.Duration
+	// Claims adds the claims of the modules' claim providers to access tokens, nil for none
+	Claims *ClaimsRegistry
+	// SecurityEvents records sign-ins, refreshes and logouts, nil records nothing
+	SecurityEvents *SecurityEventRecorder
+	// Consents flags the documents users must accept in login responses, nil flags none
+	Consents domain.ConsentLookup
 }
 
 // GoogleClient interface for mocking in tests
@@ -62,146 +104,549 @@ func (c *googleClient) GetUserInfo(ctx context.Context, token *oauth2.Token) (*d
 }
 
 type authUsecase struct {
-	authRepo     domain.AuthRepository
-	userRepo     userdomain.UserRepository
-	jwtSecret    []byte
-	accessTTL    time.Duration
-	refreshTTL   time.Duration
+	authRepo         domain.AuthRepository
+	userRepo         userdomain.UserRepository
+	invitationRepo   domain.InvitationRepository
+	identityRepo     domain.IdentityRepository
+	jwtSecret        []byte
+	accessTTL        time.Duration
+	refreshTTL       time.Duration
+	signInPolicy     SignInPolicy
+	provisioningMode string
+	profileSync      ProfileSyncPolicy
+	clients          []ClientConfig
+	googleKeys       *jwksCache
+	apple            *appleProvider
+	oidcProviders    map[string]*oidcProvider
+	oidcLogins       domain.OIDCLoginRepository
+	saml             *samlServiceProvider
+	scope            string
+	dpop             domain.DPoPVerifier
+	organizations    domain.OrgMemberships
+	guestTTL         time.Duration
+	claims           *ClaimsRegistry
+	events           *SecurityEventRecorder
+	consents         domain.ConsentLookup
 }
 
 func NewAuthUsecase(
 	authRepo domain.AuthRepository,
 	userRepo userdomain.UserRepository,
+	invitationRepo domain.InvitationRepository,
+	identityRepo domain.IdentityRepository,
 	cfg AuthUsecaseConfig,
 ) domain.AuthUsecase {
+	provisioningMode := cfg.ProvisioningMode
+	if provisioningMode == "" {
+		provisioningMode = domain.ProvisioningOpen
+	}
+
+	clients := cfg.Clients
+	if cfg.ClientID != "" && !hasGoogleClientID(clients, cfg.ClientID) {
+		clients = append(clients, ClientConfig{Name: "web", GoogleClientID: cfg.ClientID})
+	}
+
+	oidcProviders := make(map[string]*oidcProvider, len(cfg.OIDCProviders))
+	for _, provider := range cfg.OIDCProviders {
+		oidcProviders[provider.Name] = newOIDCProvider(provider, nil)
+	}
+
 	return &authUsecase{
-		authRepo:   authRepo,
-		userRepo:   userRepo,
-		jwtSecret:  []byte(cfg.JWTSecret),
-		accessTTL:  cfg.TokenConfig.AccessTTL,
-		refreshTTL: cfg.TokenConfig.RefreshTTL,
+		authRepo:         authRepo,
+		userRepo:         userRepo,
+		invitationRepo:   invitationRepo,
+		identityRepo:     identityRepo,
+		jwtSecret:        []byte(cfg.JWTSecret),
+		accessTTL:        cfg.TokenConfig.AccessTTL,
+		refreshTTL:       cfg.TokenConfig.RefreshTTL,
+		signInPolicy:     cfg.SignInPolicy,
+		provisioningMode: provisioningMode,
+		profileSync:      cfg.ProfileSync,
+		clients:          clients,
+		googleKeys:       newJWKSCache(googleCertsURL, nil),
+		apple:            newAppleProvider(cfg.Apple),
+		oidcProviders:    oidcProviders,
+		oidcLogins:       cfg.OIDCLogins,
+		saml:             newSAMLServiceProvider(cfg.SAML),
+		scope:            strings.Join(cfg.Scopes.Names(), " "),
+		dpop:             cfg.DPoP,
+		organizations:    cfg.Organizations,
+		guestTTL:         cfg.GuestTTL,
+		claims:           cfg.Claims,
+		events:           cfg.SecurityEvents,
+		consents:         cfg.Consents,
 	}
 }
 
-func (u *authUsecase) LoginWithGoogleIDToken(ctx context.Context, idToken string) (*domain.AuthToken, error) {
+func hasGoogleClientID(clients []ClientConfig, googleClientID string) bool {
+	for _, client := range clients {
+		if client.GoogleClientID == googleClientID {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *authUsecase) LoginWithGoogleIDToken(ctx context.Context, idToken string, guestCreds domain.GuestCredentials, dpop domain.DPoPProofRequest) (*domain.AuthToken, error) {
+	// Verify the proof first, it is cheaper than the ID token
+	dpopKey, err := u.dpopKey(dpop)
+	if err != nil {
+		return nil, err
+	}
+
 	// Verify the ID token
-	tokenInfo, err := u.verifyGoogleIDToken(ctx, idToken)
+	tokenInfo, client, err := u.verifyGoogleIDToken(ctx, idToken)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrGoogleAuthFailed, err)
 	}
 
-	// Find or create user
-	user, err := u.userRepo.FindByEmail(tokenInfo.Email)
+	guest, err := u.findGuest(guestCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.loginWithIdentity(ctx, googleIdentity(tokenInfo), client, dpopKey, guest)
+}
+
+// dpopKey verifies the DPoP proof of a sign-in and returns the thumbprint of
+// the key the session is bound to, empty for a session of bearer tokens
+func (u *authUsecase) dpopKey(dpop domain.DPoPProofRequest) (string, error) {
+	switch {
+	case u.dpop == nil || u.dpop.Mode() == domain.DPoPModeDisabled:
+		return "", nil
+	case dpop.Proof == "" && u.dpop.Mode() == domain.DPoPModeRequired:
+		return "", ErrDPoPProofRequired
+	case dpop.Proof == "":
+		return "", nil
+	}
+	return u.dpop.VerifyProof(dpop)
+}
+
+// loginWithIdentity signs in the user behind a verified external identity and
+// starts a session for the client the identity was verified for. The guest
+// the device used before, if any, is upgraded or merged into the user and its
+// session ends.
+func (u *authUsecase) loginWithIdentity(ctx context.Context, identity *domain.ExternalIdentity, client *ClientConfig, dpopKey string, guest *guestSession) (*domain.AuthToken, error) {
+	// Enforce the configured sign-in policy before any user is created
+	if err := u.signInPolicy.Check(identity); err != nil {
+		return nil, u.loginFailed(ctx, u.linkedUserID(identity), identity.Provider, err)
+	}
+
+	user, err := u.resolveUser(identity, guest)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			user = &userdomain.User{
-				ID:        uuid.New(),
-				Email:     tokenInfo.Email,
-				Name:      tokenInfo.Name,
-				CreatedAt: time.Now(),
-				UpdatedAt: time.Now(),
+		return nil, err
+	}
+	if err := checkAccount(user); err != nil {
+		return nil, u.loginFailed(ctx, user.ID, identity.Provider, err)
+	}
+
+	return u.createSession(ctx, user, client, identity, dpopKey)
+}
+
+// loginFailed records the rejected sign-in of a known user and returns err
+func (u *authUsecase) loginFailed(ctx context.Context, userID uuid.UUID, provider string, err error) error {
+	return u.events.recordFailure(ctx, &domain.SecurityEvent{
+		UserID:   userID,
+		Type:     domain.SecurityEventLogin,
+		Provider: provider,
+	}, err)
+}
+
+// linkedUserID returns the user an identity is linked to, uuid.Nil for
+// identities seen for the first time
+func (u *authUsecase) linkedUserID(identity *domain.ExternalIdentity) uuid.UUID {
+	linked, err := u.identityRepo.FindByProviderSubject(identity.Provider, identity.Subject)
+	if err != nil {
+		return uuid.Nil
+	}
+	return linked.UserID
+}
+
+// resolveUser returns the user linked to the identity. Identities seen for the
+// first time are linked to the user with the same verified email, or to a
+// newly provisioned user. A guest becomes that new user, or is merged into
+// the existing one.
+func (u *authUsecase) resolveUser(identity *domain.ExternalIdentity, guest *guestSession) (*userdomain.User, error) {
+	linked, err := u.identityRepo.FindByProviderSubject(identity.Provider, identity.Subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to find identity: %w", err)
+	}
+
+	var user *userdomain.User
+	if linked != nil {
+		user, err = u.userRepo.FindByID(linked.UserID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to find user: %w", err)
+		}
+	}
+	if user == nil && identity.Email != "" {
+		user, err = u.userRepo.FindByEmail(identity.Email)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to find user: %w", err)
+		}
+		// Only a verified email proves the identity belongs to the existing
+		// user, and the email can't be registered a second time
+		if user != nil && !identity.EmailVerified {
+			return nil, ErrEmailInUse
+		}
+	}
+
+	if user == nil && guest != nil {
+		return u.upgradeGuest(guest, linked, identity)
+	}
+	if user == nil {
+		user, err = u.provisionUser(identity.Email, identity.Profile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if guest != nil {
+			if err := u.mergeGuest(guest, user); err != nil {
+				return nil, err
 			}
-			if err := u.userRepo.Create(user); err != nil {
-				return nil, fmt.Errorf("failed to create user: %w", err)
+		}
+		if err := u.syncProfile(user, identity.Profile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := u.linkIdentity(linked, user, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// linkIdentity creates the identity link, or refreshes the provider data of an existing one
+func (u *authUsecase) linkIdentity(linked *domain.Identity, user *userdomain.User, identity *domain.ExternalIdentity) error {
+	if linked == nil || linked.UserID != user.ID {
+		if linked != nil {
+			// The previously linked user no longer exists
+			if err := u.identityRepo.Delete(linked.ID); err != nil {
+				return fmt.Errorf("failed to delete identity: %w", err)
 			}
-		} else {
-			return nil, fmt.Errorf("failed to find user: %w", err)
 		}
+		if err := u.identityRepo.Create(newIdentity(user, identity)); err != nil {
+			return fmt.Errorf("failed to create identity: %w", err)
+		}
+		return nil
 	}
 
-	// Generate tokens
-	refreshToken, err := generateRefreshToken()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	if identity.Email != "" {
+		linked.Email = identity.Email
+		linked.IsPrivateEmail = identity.IsPrivateEmail
+	}
+	if identity.RefreshToken != "" {
+		linked.RefreshToken = identity.RefreshToken
 	}
+	if err := u.identityRepo.Update(linked); err != nil {
+		return fmt.Errorf("failed to update identity: %w", err)
+	}
+	return nil
+}
+
+// newIdentity returns the link of an external identity to the user
+func newIdentity(user *userdomain.User, identity *domain.ExternalIdentity) *domain.Identity {
+	now := time.Now()
+	return &domain.Identity{
+		ID:             uuid.New(),
+		UserID:         user.ID,
+		Provider:       identity.Provider,
+		Subject:        identity.Subject,
+		Email:          identity.Email,
+		IsPrivateEmail: identity.IsPrivateEmail,
+		RefreshToken:   identity.RefreshToken,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+}
 
+// createSession starts a session for the user and issues its tokens. The
+// session records how the identity authenticated, for step-up checks. With a
+// DPoP key the tokens can only be used together with proofs of that key.
+func (u *authUsecase) createSession(ctx context.Context, user *userdomain.User, client *ClientConfig, identity *domain.ExternalIdentity, dpopKey string) (*domain.AuthToken, error) {
+	tokenCfg := u.tokenConfig(client)
+	authTime, acr, amr := authenticationContext(identity, time.Now())
 	session := &domain.Session{
-		ID:           uuid.New(),
-		UserID:       user.ID,
-		RefreshToken: refreshToken,
-		ExpiresAt:    time.Now().Add(u.refreshTTL),
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(tokenCfg.RefreshTTL),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+
+		DPoPKeyThumbprint: dpopKey,
+
+		AuthTime: authTime,
+		ACR:      acr,
+		AMR:      amr,
 	}
+	if client != nil {
+		session.Client = client.Name
+	}
+	return u.startSession(ctx, user, session, tokenCfg, identity.Provider)
+}
+
+// startSession stores the session with a new refresh token, records the
+// sign-in with the provider and issues the session's tokens
+func (u *authUsecase) startSession(ctx context.Context, user *userdomain.User, session *domain.Session, tokenCfg TokenConfig, provider string) (*domain.AuthToken, error) {
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	session.RefreshToken = refreshToken
 	if err := u.authRepo.CreateSession(session); err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
 
-	accessToken, err := u.generateAccessToken(user)
+	accessToken, err := u.generateAccessToken(ctx, user, session)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	return u.createAuthToken(accessToken, refreshToken), nil
-}
+	err = u.events.Record(ctx, &domain.SecurityEvent{
+		UserID:    user.ID,
+		SessionID: &session.ID,
+		Type:      domain.SecurityEventLogin,
+		Outcome:   domain.SecurityOutcomeSuccess,
+		Provider:  provider,
+		Client:    session.Client,
+		DeviceID:  session.DeviceID,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-func (u *authUsecase) verifyGoogleIDToken(ctx context.Context, idToken string) (*domain.GoogleUserInfo, error) {
-	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: idToken})
-	client := oauth2.NewClient(ctx, tokenSource)
+	token := u.createAuthToken(session, accessToken, refreshToken, tokenCfg.AccessTTL)
+	if u.consents != nil {
+		// Apps ask users to accept these before requests fail with consent_required
+		token.PendingConsents, err = u.consents.PendingConsents(ctx, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find pending consents: %w", err)
+		}
+	}
+	return token, nil
+}
 
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v3/userinfo")
+// provisionUser creates a user for an account signing in for the first time,
+// according to the configured provisioning mode. A pending invitation for the
+// email is consumed and its role assigned, even in open mode, in the
+// transaction storing the user.
+func (u *authUsecase) provisionUser(email string, claims map[string]string) (*userdomain.User, error) {
+	invitation, err := u.provisioningInvitation(email)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user info: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var userInfo domain.GoogleUserInfo
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		return nil, fmt.Errorf("failed to decode user info: %w", err)
+	user := &userdomain.User{
+		ID:        uuid.New(),
+		Role:      userdomain.RoleUser,
+		Active:    true,
+		Status:    userdomain.StatusActive,
+		CreatedAt: time.Now(),
+	}
+	u.registerUser(user, email, claims, invitation)
+	if invitation != nil {
+		if err := u.invitationRepo.Accept(invitation.ID, user, true); err != nil {
+			// Another login consumed the invitation first
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrInvitationRequired
+			}
+			return nil, fmt.Errorf("failed to accept invitation: %w", err)
+		}
+		return user, nil
+	}
+	if err := u.userRepo.Create(user); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return user, nil
+}
+
+// provisioningInvitation checks that the provisioning mode lets the email
+// register and returns its pending invitation, nil without one
+func (u *authUsecase) provisioningInvitation(email string) (*domain.Invitation, error) {
+	if u.provisioningMode == domain.ProvisioningClosed {
+		return nil, ErrRegistrationClosed
+	}
+
+	invitation, err := u.invitationRepo.FindPendingByEmail(email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to find invitation: %w", err)
+	}
+	if invitation == nil && u.provisioningMode == domain.ProvisioningInviteOnly {
+		return nil, ErrInvitationRequired
+	}
+	return invitation, nil
+}
+
+// registerUser sets the fields of a new or upgraded user from the account
+// signing in and its invitation
+func (u *authUsecase) registerUser(user *userdomain.User, email string, claims map[string]string, invitation *domain.Invitation) {
+	now := time.Now()
+	user.Email = email
+	user.Guest = false
+	user.GuestExpiresAt = nil
+	user.LastLoginAt = &now
+	user.UpdatedAt = now
+	u.profileSync.Apply(user, claims)
+	if invitation != nil {
+		user.Role = invitation.Role
 	}
+}
 
-	return &userInfo, nil
+// syncProfile updates an existing user with the provider's profile claims and
+// records the login time
+func (u *authUsecase) syncProfile(user *userdomain.User, claims map[string]string) error {
+	u.profileSync.Apply(user, claims)
+	now := time.Now()
+	user.LastLoginAt = &now
+	if err := u.userRepo.Update(user); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	return nil
 }
 
-func (u *authUsecase) RefreshToken(ctx context.Context, refreshToken string) (*domain.AuthToken, error) {
+// RefreshToken issues a new access token for the session of the refresh
+// token. Refreshes are recorded, including the failures of a known session.
+func (u *authUsecase) RefreshToken(ctx context.Context, refreshToken string, dpop domain.DPoPProofRequest) (*domain.AuthToken, error) {
 	session, err := u.authRepo.GetSessionByRefreshToken(refreshToken)
 	if err != nil {
 		return nil, fmt.Errorf("invalid refresh token: %w", err)
 	}
 
+	event := &domain.SecurityEvent{
+		UserID:    session.UserID,
+		SessionID: &session.ID,
+		Type:      domain.SecurityEventRefresh,
+		Client:    session.Client,
+		DeviceID:  session.DeviceID,
+	}
+	token, err := u.refreshSession(ctx, session, refreshToken, dpop)
+	if err != nil {
+		return nil, u.events.recordFailure(ctx, event, err)
+	}
+	event.Outcome = domain.SecurityOutcomeSuccess
+	if err := u.events.Record(ctx, event); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (u *authUsecase) refreshSession(ctx context.Context, session *domain.Session, refreshToken string, dpop domain.DPoPProofRequest) (*domain.AuthToken, error) {
+	if err := u.checkSessionDPoP(session, dpop); err != nil {
+		return nil, err
+	}
+
 	// Check if session is expired
 	if time.Now().After(session.ExpiresAt) {
 		return nil, ErrTokenExpired
 	}
+	// Sessions of third-party apps are refreshed at the OAuth token endpoint
+	if session.GrantID != nil {
+		return nil, fmt.Errorf("%w: refresh token of a third-party app", ErrInvalidToken)
+	}
 
 	user, err := u.userRepo.FindByID(session.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
+	if err := checkAccount(user); err != nil {
+		return nil, err
+	}
 
 	// Generate new access token
-	accessToken, err := u.generateAccessToken(user)
+	accessToken, err := u.generateAccessToken(ctx, user, session)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	return u.createAuthToken(accessToken, refreshToken), nil
+	tokenCfg := u.tokenConfig(u.clientByName(session.Client))
+	return u.createAuthToken(session, accessToken, refreshToken, tokenCfg.AccessTTL), nil
+}
+
+// checkSessionDPoP requires a proof of the session's key to refresh a bound
+// session. Sessions are bound at sign-in, so in required mode unbound
+// sessions can no longer be refreshed and their users sign in again.
+func (u *authUsecase) checkSessionDPoP(session *domain.Session, dpop domain.DPoPProofRequest) error {
+	if session.DPoPKeyThumbprint == "" {
+		if u.dpop != nil && u.dpop.Mode() == domain.DPoPModeRequired && session.GrantID == nil {
+			return ErrDPoPProofRequired
+		}
+		return nil
+	}
+	if u.dpop == nil {
+		return ErrInvalidDPoPProof
+	}
+	dpopKey, err := u.dpop.VerifyProof(dpop)
+	if err != nil {
+		return err
+	}
+	if dpopKey != session.DPoPKeyThumbprint {
+		return fmt.Errorf("%w: proof of another key", ErrInvalidDPoPProof)
+	}
+	return nil
 }
 
 func (u *authUsecase) Logout(ctx context.Context, userID uuid.UUID) error {
 	if err := u.authRepo.DeleteUserSessions(userID); err != nil {
 		return fmt.Errorf("failed to delete user sessions: %w", err)
 	}
-	return nil
+	return u.events.Record(ctx, &domain.SecurityEvent{
+		UserID:  userID,
+		Type:    domain.SecurityEventLogout,
+		Outcome: domain.SecurityOutcomeSuccess,
+	})
 }
 
-func (u *authUsecase) ValidateToken(ctx context.Context, token string) (*domain.AuthToken, error) {
-	claims := jwt.MapClaims{}
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return u.jwtSecret, nil
-	})
+func (u *authUsecase) SwitchOrganization(ctx context.Context, userID, sessionID uuid.UUID, orgID *uuid.UUID) (*domain.AuthToken, error) {
+	session, err := u.authRepo.GetSessionByID(sessionID)
+	if err != nil || session.UserID != userID {
+		return nil, fmt.Errorf("%w: session not found", ErrInvalidToken)
+	}
+	if session.GrantID != nil {
+		return nil, fmt.Errorf("%w: token of a third-party app", ErrInvalidToken)
+	}
+
+	user, err := u.userRepo.FindByID(userID)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if err := checkAccount(user); err != nil {
+		return nil, err
 	}
 
-	// Check token expiration
-	if exp, ok := claims["exp"].(float64); ok {
-		if time.Now().Unix() > int64(exp) {
-			return nil, ErrTokenExpired
+	if orgID != nil {
+		if u.organizations == nil {
+			return nil, ErrNotOrgMember
+		}
+		role, err := u.organizations.MemberRole(ctx, *orgID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find membership: %w", err)
 		}
+		if role == "" {
+			return nil, ErrNotOrgMember
+		}
+	}
+	if err := u.authRepo.SetSessionOrganization(session.ID, orgID); err != nil {
+		return nil, fmt.Errorf("failed to switch organization: %w", err)
+	}
+	session.OrganizationID = orgID
+
+	accessToken, err := u.generateAccessToken(ctx, user, session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	tokenCfg := u.tokenConfig(u.clientByName(session.Client))
+	return u.createAuthToken(session, accessToken, "", tokenCfg.AccessTTL), nil
+}
+
+func (u *authUsecase) ValidateToken(ctx context.Context, token string) (*domain.AuthToken, error) {
+	claims, err := parseAccessToken(u.jwtSecret, token)
+	if err != nil {
+		return nil, err
 	}
 
-	userID, err := uuid.Parse(claims["sub"].(string))
+	sub, _ := claims["sub"].(string)
+	userID, err := uuid.Parse(sub)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrInvalidUserID, err)
 	}
@@ -210,38 +655,147 @@ func (u *authUsecase) ValidateToken(ctx context.Context, token string) (*domain.
 	if err != nil {
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
+	if err := checkAccount(user); err != nil {
+		return nil, err
+	}
+
+	sessionID, err := uuid.Parse(authn.StringClaim(claims, "sid"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing session", ErrInvalidToken)
+	}
+	session, err := u.authRepo.GetSessionByID(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: session not found", ErrInvalidToken)
+	}
+	if session.GrantID != nil {
+		return nil, fmt.Errorf("%w: token of a third-party app", ErrInvalidToken)
+	}
 
-	accessToken, err := u.generateAccessToken(user)
+	accessToken, err := u.generateAccessToken(ctx, user, session)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	return &domain.AuthToken{
-		AccessToken: accessToken,
-		TokenType:   "Bearer",
-		ExpiresIn:   int64(u.accessTTL.Seconds()),
-		ExpiresAt:   time.Now().Add(u.accessTTL),
-	}, nil
+	tokenCfg := u.tokenConfig(u.clientByName(session.Client))
+	return u.createAuthToken(session, accessToken, "", tokenCfg.AccessTTL), nil
 }
 
-func (u *authUsecase) generateAccessToken(user *userdomain.User) (string, error) {
+// generateAccessToken signs an access token for the user. The sid claim ties
+// the token to its session, AuthMiddleware and introspection reject it once
+// the session is revoked, and client_id records the app the session was created from. Our own apps
+// get every scope of the catalog, roles still limit what the user may do.
+// Tokens of bound sessions carry the DPoP key thumbprint in cnf.jkt.
+// auth_time, acr and amr describe the sign-in that started the session, a
+// refresh doesn't renew them. Sessions switched to an organization get its
+// org_id and the user's current org_role. Tokens of guests are marked with
+// guest, so that APIs can ask them to sign in. The claim providers add their
+// claims under their namespaces, they can't replace the claims above.
+func (u *authUsecase) generateAccessToken(ctx context.Context, user *userdomain.User, session *domain.Session) (string, error) {
+	orgRole, err := u.organizationRole(session)
+	if err != nil {
+		return "", err
+	}
+	customClaims, err := u.claims.Claims(ctx, user, session)
+	if err != nil {
+		return "", err
+	}
+
+	tokenCfg := u.tokenConfig(u.clientByName(session.Client))
 	claims := jwt.MapClaims{
-		"sub": user.ID.String(),
-		"exp": time.Now().Add(u.accessTTL).Unix(),
-		"iat": time.Now().Unix(),
+		"sub":  user.ID.String(),
+		"exp":  time.Now().Add(tokenCfg.AccessTTL).Unix(),
+		"iat":  time.Now().Unix(),
+		"jti":  uuid.New().String(),
+		"sid":  session.ID.String(),
+		"role": user.Role,
+	}
+	if u.scope != "" {
+		claims["scope"] = u.scope
+	}
+	if session.Client != "" {
+		claims["client_id"] = session.Client
+	}
+	if session.DPoPKeyThumbprint != "" {
+		claims["cnf"] = map[string]interface{}{"jkt": session.DPoPKeyThumbprint}
+	}
+	if !session.AuthTime.IsZero() {
+		claims["auth_time"] = session.AuthTime.Unix()
+	}
+	if session.ACR != "" {
+		claims["acr"] = session.ACR
+	}
+	if len(session.AMR) > 0 {
+		claims["amr"] = session.AMR
+	}
+	if orgRole != "" {
+		claims["org_id"] = session.OrganizationID.String()
+		claims["org_role"] = orgRole
+	}
+	if user.Guest {
+		claims["guest"] = true
+	}
+	for namespace, values := range customClaims {
+		claims[namespace] = values
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(u.jwtSecret)
 }
 
-func (u *authUsecase) createAuthToken(accessToken, refreshToken string) *domain.AuthToken {
+// organizationRole returns the user's role in the organization the session is
+// switched to. Sessions of users who left the organization are switched back
+// to the personal account.
+func (u *authUsecase) organizationRole(session *domain.Session) (string, error) {
+	if session.OrganizationID == nil {
+		return "", nil
+	}
+
+	role := ""
+	if u.organizations != nil {
+		var err error
+		role, err = u.organizations.MemberRole(context.Background(), *session.OrganizationID, session.UserID)
+		if err != nil {
+			return "", fmt.Errorf("failed to find membership: %w", err)
+		}
+	}
+	if role == "" {
+		if err := u.authRepo.SetSessionOrganization(session.ID, nil); err != nil {
+			return "", fmt.Errorf("failed to switch organization: %w", err)
+		}
+		session.OrganizationID = nil
+	}
+	return role, nil
+}
+
+// parseAccessToken verifies the signature and expiry of an access token and returns its claims
+func parseAccessToken(jwtSecret []byte, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return jwtSecret, nil
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrTokenExpired
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+func (u *authUsecase) createAuthToken(session *domain.Session, accessToken, refreshToken string, accessTTL time.Duration) *domain.AuthToken {
+	tokenType := "Bearer"
+	if session.DPoPKeyThumbprint != "" {
+		tokenType = "DPoP"
+	}
 	return &domain.AuthToken{
 		AccessToken:  accessToken,
-		TokenType:    "Bearer",
-		ExpiresIn:    int64(u.accessTTL.Seconds()),
+		TokenType:    tokenType,
+		ExpiresIn:    int64(accessTTL.Seconds()),
 		RefreshToken: refreshToken,
-		ExpiresAt:    time.Now().Add(u.accessTTL),
+		ExpiresAt:    time.Now().Add(accessTTL),
 	}
 }
 
@@ -251,4 +805,4 @@ func generateRefreshToken() (string, error) {
 		return "", fmt.Errorf("failed to generate random bytes: %w", err)
 	}
 	return base64.URLEncoding.EncodeToString(b), nil
-} 
\ No newline at end of file
+}
@@ -0,0 +1,121 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// fakeOrgMemberships maps organizations to the roles of their members
+type fakeOrgMemberships map[uuid.UUID]map[uuid.UUID]string
+
+func (f fakeOrgMemberships) MemberRole(ctx context.Context, orgID, userID uuid.UUID) (string, error) {
+	return f[orgID][userID], nil
+}
+
+// fakeConsentLookup flags the same documents for every user
+type fakeConsentLookup []string
+
+func (f fakeConsentLookup) PendingConsents(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	return f, nil
+}
+
+func TestSwitchOrganization(t *testing.T) {
+	authRepo := &fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{}}
+	user := &userdomain.User{ID: uuid.New(), Role: userdomain.RoleUser, Active: true}
+	orgID := uuid.New()
+	memberships := fakeOrgMemberships{orgID: {user.ID: "admin"}}
+	u := NewAuthUsecase(authRepo, &fakeUserRepository{users: map[uuid.UUID]*userdomain.User{user.ID: user}}, nil, nil, AuthUsecaseConfig{
+		JWTSecret:     "test-secret",
+		TokenConfig:   TokenConfig{AccessTTL: 15 * time.Minute, RefreshTTL: time.Hour},
+		Organizations: memberships,
+	})
+
+	session := &domain.Session{
+		ID:           uuid.New(),
+		UserID:       user.ID,
+		RefreshToken: uuid.NewString(),
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}
+	require.NoError(t, authRepo.CreateSession(session))
+	ctx := context.Background()
+
+	t.Run("not a member", func(t *testing.T) {
+		other := uuid.New()
+		_, err := u.SwitchOrganization(ctx, user.ID, session.ID, &other)
+		assert.ErrorIs(t, err, ErrNotOrgMember)
+	})
+
+	t.Run("another user's session", func(t *testing.T) {
+		_, err := u.SwitchOrganization(ctx, uuid.New(), session.ID, &orgID)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("member", func(t *testing.T) {
+		token, err := u.SwitchOrganization(ctx, user.ID, session.ID, &orgID)
+		require.NoError(t, err)
+		claims, err := parseAccessToken([]byte("test-secret"), token.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, orgID.String(), claims["org_id"])
+		assert.Equal(t, "admin", claims["org_role"])
+
+		// Refreshed tokens stay switched
+		token, err = u.RefreshToken(ctx, session.RefreshToken, domain.DPoPProofRequest{})
+		require.NoError(t, err)
+		claims, err = parseAccessToken([]byte("test-secret"), token.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, orgID.String(), claims["org_id"])
+	})
+
+	t.Run("left the organization", func(t *testing.T) {
+		delete(memberships[orgID], user.ID)
+		token, err := u.RefreshToken(ctx, session.RefreshToken, domain.DPoPProofRequest{})
+		require.NoError(t, err)
+		claims, err := parseAccessToken([]byte("test-secret"), token.AccessToken)
+		require.NoError(t, err)
+		assert.NotContains(t, claims, "org_id")
+		assert.Nil(t, session.OrganizationID)
+	})
+
+	t.Run("personal account", func(t *testing.T) {
+		memberships[orgID][user.ID] = "member"
+		_, err := u.SwitchOrganization(ctx, user.ID, session.ID, &orgID)
+		require.NoError(t, err)
+		token, err := u.SwitchOrganization(ctx, user.ID, session.ID, nil)
+		require.NoError(t, err)
+		claims, err := parseAccessToken([]byte("test-secret"), token.AccessToken)
+		require.NoError(t, err)
+		assert.NotContains(t, claims, "org_id")
+		assert.NotContains(t, claims, "org_role")
+	})
+}
+
+func TestLoginFlagsPendingConsents(t *testing.T) {
+	login := func(consents domain.ConsentLookup) *domain.AuthToken {
+		t.Helper()
+		userRepo := &guestUserRepository{fakeUserRepository: fakeUserRepository{users: map[uuid.UUID]*userdomain.User{}}}
+		u := NewAuthUsecase(&fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{}}, userRepo, noInvitations{}, &fakeIdentityRepository{}, AuthUsecaseConfig{
+			JWTSecret:   "test-secret",
+			TokenConfig: TokenConfig{AccessTTL: 15 * time.Minute, RefreshTTL: 24 * time.Hour},
+			Consents:    consents,
+		}).(*authUsecase)
+		token, err := u.loginWithIdentity(context.Background(), &domain.ExternalIdentity{
+			Provider:      domain.ProviderGoogle,
+			Subject:       "google-alice",
+			Email:         "alice@example.com",
+			EmailVerified: true,
+		}, nil, "", nil)
+		require.NoError(t, err)
+		return token
+	}
+
+	assert.Equal(t, []string{"terms"}, login(fakeConsentLookup{"terms"}).PendingConsents)
+	assert.Empty(t, login(fakeConsentLookup{}).PendingConsents)
+	assert.Empty(t, login(nil).PendingConsents)
+}
@@ -0,0 +1,43 @@
+package usecase
+
+import (
+	"time"
+
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+)
+
+const (
+	// amrFederated is the amr of sign-ins at a provider that doesn't say how
+	// the user authenticated
+	amrFederated = "fed"
+	// amrMultiFactor is the RFC 8176 amr value of multiple-factor authentication
+	amrMultiFactor = "mfa"
+)
+
+// authenticationContext returns when and how the user behind an identity
+// authenticated: the provider's auth_time unless it is missing or in the
+// future, the amr the provider asserted, and the acr derived from it. A
+// sign-in counts as multi-factor if the provider says so with "mfa" or used
+// two different methods, e.g. ["pwd", "otp"].
+func authenticationContext(identity *domain.ExternalIdentity, now time.Time) (time.Time, string, []string) {
+	authTime := now
+	if identity.AuthTime != nil && identity.AuthTime.Before(now) {
+		authTime = *identity.AuthTime
+	}
+
+	var amr []string
+	for _, method := range identity.AMR {
+		if method != "" && !containsString(amr, method) {
+			amr = append(amr, method)
+		}
+	}
+	if len(amr) == 0 {
+		return authTime, domain.ACRSingleFactor, []string{amrFederated}
+	}
+
+	acr := domain.ACRSingleFactor
+	if containsString(amr, amrMultiFactor) || len(amr) >= 2 {
+		acr = domain.ACRMultiFactor
+	}
+	return authTime, acr, amr
+}
@@ -0,0 +1,79 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+func TestAuthenticationContext(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		identity domain.ExternalIdentity
+		authTime time.Time
+		acr      string
+		amr      []string
+	}{
+		{"provider doesn't say", domain.ExternalIdentity{}, now, domain.ACRSingleFactor, []string{"fed"}},
+		{"password", domain.ExternalIdentity{AuthTime: &earlier, AMR: []string{"pwd"}}, earlier, domain.ACRSingleFactor, []string{"pwd"}},
+		{"mfa", domain.ExternalIdentity{AMR: []string{"pwd", "mfa"}}, now, domain.ACRMultiFactor, []string{"pwd", "mfa"}},
+		{"two methods", domain.ExternalIdentity{AMR: []string{"pwd", "otp"}}, now, domain.ACRMultiFactor, []string{"pwd", "otp"}},
+		{"same method twice", domain.ExternalIdentity{AMR: []string{"pwd", "pwd"}}, now, domain.ACRSingleFactor, []string{"pwd"}},
+		{"auth_time in the future", domain.ExternalIdentity{AuthTime: &later}, now, domain.ACRSingleFactor, []string{"fed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authTime, acr, amr := authenticationContext(&tt.identity, now)
+			assert.Equal(t, tt.authTime, authTime)
+			assert.Equal(t, tt.acr, acr)
+			assert.Equal(t, tt.amr, amr)
+		})
+	}
+}
+
+func TestACRSatisfies(t *testing.T) {
+	assert.True(t, domain.ACRSatisfies(domain.ACRMultiFactor, domain.ACRSingleFactor))
+	assert.True(t, domain.ACRSatisfies(domain.ACRMultiFactor, domain.ACRMultiFactor))
+	assert.False(t, domain.ACRSatisfies(domain.ACRSingleFactor, domain.ACRMultiFactor))
+	assert.False(t, domain.ACRSatisfies("", domain.ACRSingleFactor))
+	assert.True(t, domain.ACRSatisfies("", ""))
+}
+
+func TestRefreshTokenKeepsAuthentication(t *testing.T) {
+	authRepo := &fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{}}
+	user := &userdomain.User{ID: uuid.New(), Role: userdomain.RoleUser, Active: true}
+	u := NewAuthUsecase(authRepo, &fakeUserRepository{users: map[uuid.UUID]*userdomain.User{user.ID: user}}, nil, nil, AuthUsecaseConfig{
+		JWTSecret:   "test-secret",
+		TokenConfig: TokenConfig{AccessTTL: 15 * time.Minute, RefreshTTL: time.Hour},
+	})
+
+	authTime := time.Now().Add(-30 * time.Minute).Truncate(time.Second)
+	session := &domain.Session{
+		ID:           uuid.New(),
+		UserID:       user.ID,
+		RefreshToken: uuid.NewString(),
+		ExpiresAt:    time.Now().Add(time.Hour),
+		AuthTime:     authTime,
+		ACR:          domain.ACRMultiFactor,
+		AMR:          []string{"pwd", "otp"},
+	}
+	require.NoError(t, authRepo.CreateSession(session))
+
+	token, err := u.RefreshToken(context.Background(), session.RefreshToken, domain.DPoPProofRequest{})
+	require.NoError(t, err)
+	claims, err := parseAccessToken([]byte("test-secret"), token.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, float64(authTime.Unix()), claims["auth_time"])
+	assert.Equal(t, domain.ACRMultiFactor, claims["acr"])
+	assert.Equal(t, []interface{}{"pwd", "otp"}, claims["amr"])
+}
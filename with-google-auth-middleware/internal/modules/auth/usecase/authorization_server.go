@@ -0,0 +1,976 @@
+package usecase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
+	"gorm.io/gorm"
+)
+
+// Custom errors
+var (
+	ErrInvalidRedirectURI          = errors.New("invalid redirect URI")
+	ErrInvalidClientMetadata       = errors.New("invalid client metadata")
+	ErrClientNotFound              = errors.New("client not found")
+	ErrInvalidAuthorizationRequest = errors.New("invalid or expired authorization request")
+	ErrInvalidRequest              = errors.New("invalid request")
+	ErrInvalidScope                = errors.New("invalid scope")
+	ErrInvalidGrant                = errors.New("invalid grant")
+	ErrUnsupportedGrantType        = errors.New("unsupported grant type")
+	ErrInsufficientScope           = errors.New("insufficient scope")
+	ErrGrantNotFound               = errors.New("grant not found")
+	ErrConsentNotConfigured        = errors.New("consent URL is not configured")
+)
+
+const (
+	// authorizationRequestTTL is how long the user has to answer the consent page
+	authorizationRequestTTL = 10 * time.Minute
+	// authorizationCodeTTL is how long an authorization code can be exchanged
+	authorizationCodeTTL = 10 * time.Minute
+)
+
+// oidcScopes are the OpenID Connect scopes third-party apps may request in
+// addition to the API scopes of the catalog
+var oidcScopes = map[string]string{
+	domain.ScopeOpenID:        "Sign you in with your account",
+	domain.ScopeProfile:       "View your name, picture and locale",
+	domain.ScopeEmail:         "View your email address",
+	domain.ScopeOfflineAccess: "Keep access while you are not using the app",
+}
+
+// AuthorizationServerConfig configures the authorization server for third-party apps
+type AuthorizationServerConfig struct {
+	// Issuer is the public base URL of the API, e.g. https://api.example.com
+	Issuer string
+	// ConsentURL is the page of our web app that asks the user to approve an
+	// authorization request, it receives the request in the "request" parameter
+	ConsentURL string
+	// SigningKey is the PEM encoded RSA key ID tokens are signed with. Without
+	// it a key is generated on startup and ID tokens can't be verified after a restart.
+	SigningKey  string
+	JWTSecret   string
+	TokenConfig TokenConfig
+	// Scopes is the catalog of API scopes third-party apps may request
+	Scopes domain.ScopeCatalog
+	// TokenExchange lists the clients that may exchange tokens for downstream services
+	TokenExchange []TokenExchangePolicy
+	// DeviceVerificationURL is the page of our web app where users enter the
+	// user code of the device flow, the device flow is disabled without it
+	DeviceVerificationURL string
+	// SecurityEvents records the sessions ended by revoking a grant, nil records nothing
+	SecurityEvents *SecurityEventRecorder
+}
+
+type authorizationServer struct {
+	authRepo   domain.AuthRepository
+	oauthRepo  domain.OAuthRepository
+	userRepo   userdomain.UserRepository
+	issuer     string
+	consentURL string
+	jwtSecret  []byte
+	requestKey []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	signingKey *rsa.PrivateKey
+	keyID      string
+	keyErr     error
+	scopes     domain.ScopeCatalog
+	now        func() time.Time
+
+	exchangePolicies      map[string]TokenExchangePolicy
+	deviceVerificationURL string
+	events                *SecurityEventRecorder
+}
+
+// NewAuthorizationServerUsecase creates the OAuth 2.0 / OpenID Connect
+// authorization server that lets third-party apps act on behalf of our users
+func NewAuthorizationServerUsecase(
+	authRepo domain.AuthRepository,
+	oauthRepo domain.OAuthRepository,
+	userRepo userdomain.UserRepository,
+	cfg AuthorizationServerConfig,
+) domain.AuthorizationServerUsecase {
+	s := &authorizationServer{
+		authRepo:   authRepo,
+		oauthRepo:  oauthRepo,
+		userRepo:   userRepo,
+		issuer:     strings.TrimSuffix(cfg.Issuer, "/"),
+		consentURL: cfg.ConsentURL,
+		jwtSecret:  []byte(cfg.JWTSecret),
+		requestKey: DeriveKey([]byte(cfg.JWTSecret), "oauth-authorization-request"),
+		accessTTL:  cfg.TokenConfig.AccessTTL,
+		refreshTTL: cfg.TokenConfig.RefreshTTL,
+		scopes:     domain.NewScopeCatalog(oidcScopes, cfg.Scopes),
+		now:        time.Now,
+
+		exchangePolicies:      make(map[string]TokenExchangePolicy, len(cfg.TokenExchange)),
+		deviceVerificationURL: cfg.DeviceVerificationURL,
+		events:                cfg.SecurityEvents,
+	}
+	for _, policy := range cfg.TokenExchange {
+		s.exchangePolicies[policy.ClientID] = policy
+	}
+	// An unusable key makes ID token issuance fail on use instead of taking down
+	// the API. Without a key, which the config only allows in development, ID
+	// tokens are signed with a key generated here.
+	if cfg.SigningKey != "" {
+		s.signingKey, s.keyErr = parseRSAPrivateKey(cfg.SigningKey)
+	} else {
+		s.signingKey, s.keyErr = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if s.keyErr == nil {
+		s.keyID = rsaThumbprint(&s.signingKey.PublicKey)
+	}
+	return s
+}
+
+func (s *authorizationServer) RegisterClient(ctx context.Context, createdBy uuid.UUID, req domain.RegisterClientRequest) (*domain.RegisteredClient, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidClientMetadata)
+	}
+	if len(req.RedirectURIs) == 0 {
+		return nil, fmt.Errorf("%w: at least one redirect URI is required", ErrInvalidClientMetadata)
+	}
+	for _, uri := range req.RedirectURIs {
+		if err := validateRedirectURI(uri, req.Public); err != nil {
+			return nil, err
+		}
+	}
+
+	clientID, err := randomToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate client ID: %w", err)
+	}
+	var secret, secretHash string
+	if !req.Public {
+		if secret, err = randomToken(32); err != nil {
+			return nil, fmt.Errorf("failed to generate client secret: %w", err)
+		}
+		secretHash = HashClientSecret(secret)
+	}
+
+	now := s.now()
+	client := &domain.OAuthClient{
+		ID:           uuid.New(),
+		ClientID:     clientID,
+		SecretHash:   secretHash,
+		Name:         name,
+		RedirectURIs: req.RedirectURIs,
+		Public:       req.Public,
+		CreatedBy:    &createdBy,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.oauthRepo.CreateClient(client); err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return &domain.RegisteredClient{OAuthClient: client, ClientSecret: secret}, nil
+}
+
+func (s *authorizationServer) ListClients(ctx context.Context, page, limit int) ([]*domain.OAuthClient, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+	return s.oauthRepo.GetClients(page, limit)
+}
+
+func (s *authorizationServer) DeleteClient(ctx context.Context, clientID string) error {
+	if err := s.oauthRepo.DeleteClient(clientID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrClientNotFound
+		}
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+	return nil
+}
+
+func (s *authorizationServer) Authorize(ctx context.Context, req domain.AuthorizationRequest) (string, error) {
+	if s.consentURL == "" {
+		return "", ErrConsentNotConfigured
+	}
+
+	client, err := s.findClient(req.ClientID)
+	if err != nil {
+		return "", err
+	}
+	// Without a registered redirect URI the error can't be sent back to the client
+	if req.RedirectURI == "" || !client.HasRedirectURI(req.RedirectURI) {
+		return "", ErrInvalidRedirectURI
+	}
+
+	if req.ResponseType != "code" {
+		return authorizationError(req.RedirectURI, req.State, "unsupported_response_type", "only the code response type is supported"), nil
+	}
+	if req.CodeChallengeMethod != "S256" || !validCodeChallenge(req.CodeChallenge) {
+		return authorizationError(req.RedirectURI, req.State, "invalid_request", "a PKCE code_challenge with the S256 method is required"), nil
+	}
+	scopes, err := parseScope(s.scopes, req.Scope)
+	if err != nil {
+		return authorizationError(req.RedirectURI, req.State, "invalid_scope", err.Error()), nil
+	}
+
+	now := s.now()
+	requestToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"client_id":      client.ClientID,
+		"redirect_uri":   req.RedirectURI,
+		"scope":          strings.Join(scopes, " "),
+		"state":          req.State,
+		"code_challenge": req.CodeChallenge,
+		"nonce":          req.Nonce,
+		"prompt":         req.Prompt,
+		"iat":            now.Unix(),
+		"exp":            now.Add(authorizationRequestTTL).Unix(),
+	}).SignedString(s.requestKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign authorization request: %w", err)
+	}
+	return addQuery(s.consentURL, url.Values{"request": {requestToken}}), nil
+}
+
+func (s *authorizationServer) ConsentRequest(ctx context.Context, userID uuid.UUID, requestToken string) (*domain.ConsentRequest, error) {
+	req, err := s.parseAuthorizationRequest(requestToken)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.findClient(req.ClientID)
+	if err != nil {
+		return nil, ErrInvalidAuthorizationRequest
+	}
+
+	scopes := strings.Fields(req.Scope)
+	grant, err := s.findGrant(userID, client.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	descriptions := make(map[string]string, len(scopes))
+	for _, scope := range scopes {
+		descriptions[scope] = s.scopes[scope]
+	}
+	return &domain.ConsentRequest{
+		ClientID:          client.ClientID,
+		ClientName:        client.Name,
+		RedirectURI:       req.RedirectURI,
+		Scopes:            scopes,
+		ScopeDescriptions: descriptions,
+		ConsentRequired:   consentRequired(client, grant, scopes, req.Prompt),
+	}, nil
+}
+
+func (s *authorizationServer) Consent(ctx context.Context, decision domain.ConsentDecision) (string, error) {
+	req, err := s.parseAuthorizationRequest(decision.RequestToken)
+	if err != nil {
+		return "", err
+	}
+	client, err := s.findClient(req.ClientID)
+	if err != nil {
+		return "", ErrInvalidAuthorizationRequest
+	}
+	if !decision.Approved {
+		return authorizationError(req.RedirectURI, req.State, "access_denied", "the user denied the request"), nil
+	}
+
+	user, err := s.userRepo.FindByID(decision.UserID)
+	if err != nil {
+		return "", fmt.Errorf("failed to find user: %w", err)
+	}
+	if err := checkAccount(user); err != nil {
+		return "", err
+	}
+
+	grant, err := s.saveGrant(decision.UserID, client.ClientID, strings.Fields(req.Scope))
+	if err != nil {
+		return "", err
+	}
+	authTime, err := s.authTime(decision.SessionID)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate authorization code: %w", err)
+	}
+	now := s.now()
+	err = s.oauthRepo.CreateAuthorizationCode(&domain.OAuthAuthorizationCode{
+		ID:            uuid.New(),
+		CodeHash:      hashToken(code),
+		ClientID:      client.ClientID,
+		UserID:        user.ID,
+		GrantID:       grant.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      authTime,
+		ExpiresAt:     now.Add(authorizationCodeTTL),
+		CreatedAt:     now,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create authorization code: %w", err)
+	}
+
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	return addQuery(req.RedirectURI, params), nil
+}
+
+func (s *authorizationServer) Token(ctx context.Context, req domain.TokenRequest) (*domain.TokenResponse, error) {
+	client, err := s.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case domain.GrantTypeAuthorizationCode:
+		return s.exchangeAuthorizationCode(client, req)
+	case domain.GrantTypeRefreshToken:
+		return s.refresh(client, req.RefreshToken)
+	case domain.GrantTypeTokenExchange:
+		return s.exchangeToken(client, req)
+	case domain.GrantTypeDeviceCode:
+		return s.pollDeviceCode(client, req.DeviceCode)
+	}
+	return nil, ErrUnsupportedGrantType
+}
+
+func (s *authorizationServer) UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	claims, err := parseAccessToken(s.jwtSecret, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	scopes := strings.Fields(authn.StringClaim(claims, "scope"))
+	if !hasScope(scopes, domain.ScopeOpenID) {
+		return nil, ErrInsufficientScope
+	}
+
+	sessionID, err := uuid.Parse(authn.StringClaim(claims, "sid"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing session", ErrInvalidToken)
+	}
+	if _, err := s.authRepo.GetSessionByID(sessionID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: session not found", ErrInvalidToken)
+		}
+		return nil, fmt.Errorf("failed to find session: %w", err)
+	}
+
+	userID, err := uuid.Parse(authn.StringClaim(claims, "sub"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUserID, err)
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if checkAccount(user) != nil {
+		return nil, ErrInvalidToken
+	}
+	return userClaims(user, scopes), nil
+}
+
+func (s *authorizationServer) ListGrants(ctx context.Context, userID uuid.UUID) ([]*domain.OAuthGrant, error) {
+	grants, err := s.oauthRepo.GetUserGrants(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grants: %w", err)
+	}
+	return grants, nil
+}
+
+func (s *authorizationServer) RevokeGrant(ctx context.Context, userID, grantID uuid.UUID) error {
+	grant, err := s.oauthRepo.FindGrantByID(grantID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrGrantNotFound
+		}
+		return fmt.Errorf("failed to find grant: %w", err)
+	}
+	if grant.UserID != userID {
+		return ErrGrantNotFound
+	}
+
+	if err := s.oauthRepo.DeleteGrant(grant.ID); err != nil {
+		return fmt.Errorf("failed to delete grant: %w", err)
+	}
+	// Deleting the grant deleted the app's sessions
+	return s.events.Record(ctx, &domain.SecurityEvent{
+		UserID:  userID,
+		Type:    domain.SecurityEventSessionRevoked,
+		Outcome: domain.SecurityOutcomeSuccess,
+		Reason:  "grant_revoked",
+		Client:  grant.ClientID,
+	})
+}
+
+func (s *authorizationServer) OpenIDConfiguration() *domain.OpenIDConfiguration {
+	grantTypes := []string{domain.GrantTypeAuthorizationCode, domain.GrantTypeRefreshToken}
+	if len(s.exchangePolicies) > 0 {
+		grantTypes = append(grantTypes, domain.GrantTypeTokenExchange)
+	}
+	var deviceAuthorizationEndpoint string
+	if s.deviceVerificationURL != "" {
+		grantTypes = append(grantTypes, domain.GrantTypeDeviceCode)
+		deviceAuthorizationEndpoint = s.issuer + "/v1/oauth/device/code"
+	}
+	return &domain.OpenIDConfiguration{
+		Issuer:                            s.issuer,
+		AuthorizationEndpoint:             s.issuer + "/v1/oauth/authorize",
+		TokenEndpoint:                     s.issuer + "/v1/oauth/token",
+		UserInfoEndpoint:                  s.issuer + "/v1/oauth/userinfo",
+		JWKSURI:                           s.issuer + "/.well-known/jwks.json",
+		RevocationEndpoint:                s.issuer + "/v1/oauth/revoke",
+		IntrospectionEndpoint:             s.issuer + "/v1/oauth/introspect",
+		DeviceAuthorizationEndpoint:       deviceAuthorizationEndpoint,
+		ScopesSupported:                   s.scopes.Names(),
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               grantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+			"email", "name", "given_name", "family_name", "picture", "locale", "updated_at",
+		},
+	}
+}
+
+func (s *authorizationServer) JSONWebKeySet() (*domain.JSONWebKeySet, error) {
+	if s.keyErr != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", s.keyErr)
+	}
+	return &domain.JSONWebKeySet{Keys: []domain.JSONWebKey{rsaJSONWebKey(&s.signingKey.PublicKey, s.keyID)}}, nil
+}
+
+// exchangeAuthorizationCode redeems an authorization code for tokens. A code
+// that is used twice revokes the tokens issued for it (RFC 6749 section 4.1.2).
+func (s *authorizationServer) exchangeAuthorizationCode(client *domain.OAuthClient, req domain.TokenRequest) (*domain.TokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, fmt.Errorf("%w: code and code_verifier are required", ErrInvalidRequest)
+	}
+
+	code, err := s.oauthRepo.FindAuthorizationCode(hashToken(req.Code))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: unknown authorization code", ErrInvalidGrant)
+		}
+		return nil, fmt.Errorf("failed to find authorization code: %w", err)
+	}
+	if code.ClientID != client.ClientID {
+		return nil, fmt.Errorf("%w: authorization code was issued to another client", ErrInvalidGrant)
+	}
+	if code.UsedAt != nil {
+		s.revokeCodeSession(code)
+		return nil, fmt.Errorf("%w: authorization code was already used", ErrInvalidGrant)
+	}
+	if s.now().After(code.ExpiresAt) {
+		return nil, fmt.Errorf("%w: authorization code has expired", ErrInvalidGrant)
+	}
+	if code.RedirectURI != req.RedirectURI {
+		return nil, fmt.Errorf("%w: redirect_uri doesn't match the authorization request", ErrInvalidGrant)
+	}
+	if !verifyCodeChallenge(code.CodeChallenge, req.CodeVerifier) {
+		return nil, fmt.Errorf("%w: code_verifier doesn't match the code_challenge", ErrInvalidGrant)
+	}
+
+	marked, err := s.oauthRepo.MarkAuthorizationCodeUsed(code.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use authorization code: %w", err)
+	}
+	if !marked {
+		return nil, fmt.Errorf("%w: authorization code was already used", ErrInvalidGrant)
+	}
+
+	user, err := s.activeUser(code.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	now := s.now()
+	session := &domain.Session{
+		ID:           uuid.New(),
+		UserID:       user.ID,
+		Client:       client.ClientID,
+		GrantID:      &code.GrantID,
+		Scope:        code.Scope,
+		RefreshToken: refreshToken,
+		ExpiresAt:    now.Add(s.refreshTTL),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		AuthTime:     code.AuthTime,
+	}
+	if err := s.authRepo.CreateSession(session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	if err := s.oauthRepo.SetAuthorizationCodeSession(code.ID, session.ID); err != nil {
+		return nil, fmt.Errorf("failed to update authorization code: %w", err)
+	}
+
+	return s.issueTokens(user, session, code.Nonce, &code.AuthTime, true)
+}
+
+// refresh issues new tokens for the session of a refresh token. Only clients
+// that were granted offline_access receive refresh tokens.
+func (s *authorizationServer) refresh(client *domain.OAuthClient, refreshToken string) (*domain.TokenResponse, error) {
+	if refreshToken == "" {
+		return nil, fmt.Errorf("%w: refresh_token is required", ErrInvalidRequest)
+	}
+
+	session, err := s.authRepo.GetSessionByRefreshToken(refreshToken)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: unknown refresh token", ErrInvalidGrant)
+		}
+		return nil, fmt.Errorf("failed to find session: %w", err)
+	}
+	if session.GrantID == nil || session.Client != client.ClientID || !hasScope(strings.Fields(session.Scope), domain.ScopeOfflineAccess) {
+		return nil, fmt.Errorf("%w: unknown refresh token", ErrInvalidGrant)
+	}
+
+	user, err := s.activeUser(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokens(user, session, "", nil, false)
+}
+
+// issueTokens signs an access token for the session, an ID token if the
+// openid scope was granted and returns the refresh token if requested and
+// offline_access was granted
+func (s *authorizationServer) issueTokens(user *userdomain.User, session *domain.Session, nonce string, authTime *time.Time, includeRefreshToken bool) (*domain.TokenResponse, error) {
+	now := s.now()
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":       user.ID.String(),
+		"exp":       now.Add(s.accessTTL).Unix(),
+		"iat":       now.Unix(),
+		"jti":       uuid.New().String(),
+		"sid":       session.ID.String(),
+		"client_id": session.Client,
+		"grant_id":  session.GrantID.String(),
+		"scope":     session.Scope,
+	}).SignedString(s.jwtSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	scopes := strings.Fields(session.Scope)
+	response := &domain.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.accessTTL.Seconds()),
+		Scope:       session.Scope,
+	}
+	if includeRefreshToken && hasScope(scopes, domain.ScopeOfflineAccess) {
+		response.RefreshToken = session.RefreshToken
+	}
+	if hasScope(scopes, domain.ScopeOpenID) {
+		if response.IDToken, err = s.signIDToken(user, session.Client, scopes, nonce, authTime); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// signIDToken signs an OpenID Connect ID token for the client
+func (s *authorizationServer) signIDToken(user *userdomain.User, clientID string, scopes []string, nonce string, authTime *time.Time) (string, error) {
+	if s.keyErr != nil {
+		return "", fmt.Errorf("invalid signing key: %w", s.keyErr)
+	}
+
+	now := s.now()
+	claims := jwt.MapClaims{
+		"iss": s.issuer,
+		"aud": clientID,
+		"exp": now.Add(s.accessTTL).Unix(),
+		"iat": now.Unix(),
+	}
+	for name, value := range userClaims(user, scopes) {
+		claims[name] = value
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if authTime != nil {
+		claims["auth_time"] = authTime.Unix()
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	idToken, err := token.SignedString(s.signingKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign ID token: %w", err)
+	}
+	return idToken, nil
+}
+
+// authorizationRequest is an authorization request waiting for the user's consent
+type authorizationRequest struct {
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	State         string `json:"state"`
+	CodeChallenge string `json:"code_challenge"`
+	Nonce         string `json:"nonce"`
+	Prompt        string `json:"prompt"`
+	jwt.RegisteredClaims
+}
+
+func (s *authorizationServer) parseAuthorizationRequest(requestToken string) (*authorizationRequest, error) {
+	var req authorizationRequest
+	_, err := jwt.ParseWithClaims(requestToken, &req, func(token *jwt.Token) (interface{}, error) {
+		return s.requestKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithTimeFunc(s.now))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAuthorizationRequest, err)
+	}
+	return &req, nil
+}
+
+// findClient returns ErrInvalidClient for unknown clients
+func (s *authorizationServer) findClient(clientID string) (*domain.OAuthClient, error) {
+	if clientID == "" {
+		return nil, ErrInvalidClient
+	}
+	client, err := s.authRepo.GetClientByClientID(clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, fmt.Errorf("failed to find client: %w", err)
+	}
+	return client, nil
+}
+
+// authenticateClient checks the secret of confidential clients. Public clients
+// only identify themselves, PKCE proves they started the authorization.
+func (s *authorizationServer) authenticateClient(clientID, clientSecret string) (*domain.OAuthClient, error) {
+	client, err := s.findClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		return client, nil
+	}
+	if clientSecret == "" || subtle.ConstantTimeCompare([]byte(HashClientSecret(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// findGrant returns nil if the user hasn't authorized the client yet
+func (s *authorizationServer) findGrant(userID uuid.UUID, clientID string) (*domain.OAuthGrant, error) {
+	grant, err := s.oauthRepo.FindGrant(userID, clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find grant: %w", err)
+	}
+	return grant, nil
+}
+
+// saveGrant creates the user's grant for the client or adds the scopes to it
+func (s *authorizationServer) saveGrant(userID uuid.UUID, clientID string, scopes []string) (*domain.OAuthGrant, error) {
+	grant, err := s.findGrant(userID, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	if grant == nil {
+		grant = &domain.OAuthGrant{
+			ID:        uuid.New(),
+			UserID:    userID,
+			ClientID:  clientID,
+			Scope:     strings.Join(scopes, " "),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := s.oauthRepo.CreateGrant(grant); err != nil {
+			return nil, fmt.Errorf("failed to create grant: %w", err)
+		}
+		return grant, nil
+	}
+
+	granted := strings.Fields(grant.Scope)
+	if hasScopes(granted, scopes) {
+		return grant, nil
+	}
+	for _, scope := range scopes {
+		if !hasScope(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	grant.Scope = strings.Join(granted, " ")
+	grant.UpdatedAt = now
+	if err := s.oauthRepo.UpdateGrant(grant); err != nil {
+		return nil, fmt.Errorf("failed to update grant: %w", err)
+	}
+	return grant, nil
+}
+
+// authTime returns when the user signed in to the session that approved the request
+func (s *authorizationServer) authTime(sessionID uuid.UUID) (time.Time, error) {
+	session, err := s.authRepo.GetSessionByID(sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.now(), nil
+		}
+		return time.Time{}, fmt.Errorf("failed to find session: %w", err)
+	}
+	return session.AuthTime, nil
+}
+
+func (s *authorizationServer) activeUser(userID uuid.UUID) (*userdomain.User, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if err := checkAccount(user); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidGrant, err)
+	}
+	return user, nil
+}
+
+// revokeCodeSession deletes the session created with a replayed authorization code
+func (s *authorizationServer) revokeCodeSession(code *domain.OAuthAuthorizationCode) {
+	if code.SessionID != nil {
+		// Best effort, the replayed request fails either way
+		_ = s.authRepo.DeleteSession(*code.SessionID)
+	}
+}
+
+// consentRequired reports whether the user has to approve the request. Internal
+// clients and requests covered by an earlier grant are approved without asking,
+// unless the client asks for consent with prompt=consent.
+func consentRequired(client *domain.OAuthClient, grant *domain.OAuthGrant, scopes []string, prompt string) bool {
+	if hasScope(strings.Fields(prompt), "consent") {
+		return true
+	}
+	if client.Internal {
+		return false
+	}
+	return grant == nil || !hasScopes(strings.Fields(grant.Scope), scopes)
+}
+
+// userClaims returns the OpenID Connect claims of the user released by the scopes
+func userClaims(user *userdomain.User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": user.ID.String()}
+	if hasScope(scopes, domain.ScopeEmail) && user.Email != "" {
+		claims["email"] = user.Email
+	}
+	if hasScope(scopes, domain.ScopeProfile) {
+		for claim, value := range map[string]string{
+			"name":        user.Name,
+			"given_name":  user.GivenName,
+			"family_name": user.FamilyName,
+			"picture":     user.AvatarURL,
+			"locale":      user.Locale,
+		} {
+			if value != "" {
+				claims[claim] = value
+			}
+		}
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+	return claims
+}
+
+// parseScope splits a scope parameter and rejects scopes missing from the
+// catalog. An empty scope requests openid.
+func parseScope(catalog domain.ScopeCatalog, scope string) ([]string, error) {
+	var scopes []string
+	for _, value := range strings.Fields(scope) {
+		if !catalog.Has(value) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, value)
+		}
+		if !hasScope(scopes, value) {
+			scopes = append(scopes, value)
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = []string{domain.ScopeOpenID}
+	}
+	return scopes, nil
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hasScopes reports whether granted contains every requested scope
+func hasScopes(granted, requested []string) bool {
+	for _, scope := range requested {
+		if !hasScope(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateRedirectURI accepts https URIs, http URIs on the loopback interface
+// and, for native apps, private-use schemes (RFC 8252)
+func validateRedirectURI(uri string, public bool) error {
+	parsed, err := url.Parse(uri)
+	if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidRedirectURI, uri)
+	}
+	switch parsed.Scheme {
+	case "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("%w: %s", ErrInvalidRedirectURI, uri)
+		}
+	case "http":
+		switch parsed.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+		default:
+			return fmt.Errorf("%w: http is only allowed for loopback addresses: %s", ErrInvalidRedirectURI, uri)
+		}
+	default:
+		if !public || !strings.Contains(parsed.Scheme, ".") {
+			return fmt.Errorf("%w: custom schemes must be reverse domain names of public clients: %s", ErrInvalidRedirectURI, uri)
+		}
+	}
+	return nil
+}
+
+// validCodeChallenge checks that a challenge is a base64url encoded SHA-256 digest
+func validCodeChallenge(challenge string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against its S256 challenge (RFC 7636)
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+// authorizationError returns the redirect URI with an RFC 6749 error response
+func authorizationError(redirectURI, state, code, description string) string {
+	params := url.Values{"error": {code}, "error_description": {description}}
+	if state != "" {
+		params.Set("state", state)
+	}
+	return addQuery(redirectURI, params)
+}
+
+// addQuery adds parameters to the query of a URL
+func addQuery(rawURL string, params url.Values) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := parsed.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// hashToken returns the hex encoded SHA-256 digest stored instead of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// DeriveKey derives a signing key for one purpose from the JWT secret, so
+// tokens signed with it are never accepted as access tokens. Other modules
+// sign their own tokens, such as data export links, with a derived key too.
+func DeriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// randomToken returns size random bytes, base64url encoded
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// parseRSAPrivateKey parses a PEM encoded PKCS #8 or PKCS #1 RSA private key
+func parseRSAPrivateKey(keyPEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private key is not an RSA key")
+		}
+		return key, nil
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	return key, nil
+}
+
+// rsaJSONWebKey returns the JSON Web Key of an RSA public key
+func rsaJSONWebKey(key *rsa.PublicKey, kid string) domain.JSONWebKey {
+	return domain.JSONWebKey{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// rsaThumbprint returns the JWK thumbprint (RFC 7638) of an RSA public key
+func rsaThumbprint(key *rsa.PublicKey) string {
+	jwk := rsaJSONWebKey(key, "")
+	// The members are required in lexicographic order without whitespace
+	data, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{jwk.E, jwk.Kty, jwk.N})
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
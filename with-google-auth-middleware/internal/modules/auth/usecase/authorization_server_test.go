@@ -0,0 +1,676 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/gorm"
+)
+
+// fakeAuthRepository keeps sessions and clients in memory
+type fakeAuthRepository struct {
+	domain.AuthRepository
+	sessions map[uuid.UUID]*domain.Session
+	clients  map[string]*domain.OAuthClient
+}
+
+func (r *fakeAuthRepository) CreateSession(session *domain.Session) error {
+	r.sessions[session.ID] = session
+	return nil
+}
+
+func (r *fakeAuthRepository) GetSessionByID(id uuid.UUID) (*domain.Session, error) {
+	if session, ok := r.sessions[id]; ok {
+		return session, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeAuthRepository) GetSessionByRefreshToken(refreshToken string) (*domain.Session, error) {
+	for _, session := range r.sessions {
+		if session.RefreshToken == refreshToken {
+			return session, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeAuthRepository) DeleteSession(id uuid.UUID) error {
+	delete(r.sessions, id)
+	return nil
+}
+
+func (r *fakeAuthRepository) DeleteUserSessions(userID uuid.UUID) error {
+	for id, session := range r.sessions {
+		if session.UserID == userID {
+			delete(r.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (r *fakeAuthRepository) SetSessionOrganization(id uuid.UUID, orgID *uuid.UUID) error {
+	if session, ok := r.sessions[id]; ok {
+		session.OrganizationID = orgID
+	}
+	return nil
+}
+
+func (r *fakeAuthRepository) GetClientByClientID(clientID string) (*domain.OAuthClient, error) {
+	if client, ok := r.clients[clientID]; ok {
+		return client, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// fakeOAuthRepository keeps grants and codes in memory and shares clients
+// and sessions with the auth repository, deleting them like the database would
+type fakeOAuthRepository struct {
+	auth    *fakeAuthRepository
+	grants  map[uuid.UUID]*domain.OAuthGrant
+	codes   map[string]*domain.OAuthAuthorizationCode
+	devices map[uuid.UUID]domain.OAuthDeviceCode
+	// attempts are the user code failures per user
+	attempts map[uuid.UUID]domain.OAuthDeviceAttempts
+}
+
+func (r *fakeOAuthRepository) CreateClient(client *domain.OAuthClient) error {
+	r.auth.clients[client.ClientID] = client
+	return nil
+}
+
+func (r *fakeOAuthRepository) GetClients(page, limit int) ([]*domain.OAuthClient, error) {
+	var clients []*domain.OAuthClient
+	for _, client := range r.auth.clients {
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+func (r *fakeOAuthRepository) DeleteClient(clientID string) error {
+	if _, ok := r.auth.clients[clientID]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	delete(r.auth.clients, clientID)
+	return nil
+}
+
+func (r *fakeOAuthRepository) CreateGrant(grant *domain.OAuthGrant) error {
+	r.grants[grant.ID] = grant
+	return nil
+}
+
+func (r *fakeOAuthRepository) UpdateGrant(grant *domain.OAuthGrant) error {
+	r.grants[grant.ID] = grant
+	return nil
+}
+
+func (r *fakeOAuthRepository) FindGrant(userID uuid.UUID, clientID string) (*domain.OAuthGrant, error) {
+	for _, grant := range r.grants {
+		if grant.UserID == userID && grant.ClientID == clientID {
+			return grant, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeOAuthRepository) FindGrantByID(id uuid.UUID) (*domain.OAuthGrant, error) {
+	if grant, ok := r.grants[id]; ok {
+		return grant, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeOAuthRepository) GetUserGrants(userID uuid.UUID) ([]*domain.OAuthGrant, error) {
+	var grants []*domain.OAuthGrant
+	for _, grant := range r.grants {
+		if grant.UserID == userID {
+			grants = append(grants, grant)
+		}
+	}
+	return grants, nil
+}
+
+func (r *fakeOAuthRepository) DeleteGrant(id uuid.UUID) error {
+	delete(r.grants, id)
+	for sessionID, session := range r.auth.sessions {
+		if session.GrantID != nil && *session.GrantID == id {
+			delete(r.auth.sessions, sessionID)
+		}
+	}
+	return nil
+}
+
+func (r *fakeOAuthRepository) CreateAuthorizationCode(code *domain.OAuthAuthorizationCode) error {
+	r.codes[code.CodeHash] = code
+	return nil
+}
+
+func (r *fakeOAuthRepository) FindAuthorizationCode(codeHash string) (*domain.OAuthAuthorizationCode, error) {
+	if code, ok := r.codes[codeHash]; ok {
+		return code, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeOAuthRepository) MarkAuthorizationCodeUsed(id uuid.UUID) (bool, error) {
+	for _, code := range r.codes {
+		if code.ID == id && code.UsedAt == nil {
+			now := time.Now()
+			code.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeOAuthRepository) SetAuthorizationCodeSession(id, sessionID uuid.UUID) error {
+	for _, code := range r.codes {
+		if code.ID == id {
+			code.SessionID = &sessionID
+		}
+	}
+	return nil
+}
+
+func (r *fakeOAuthRepository) CreateDeviceCode(code *domain.OAuthDeviceCode) error {
+	r.devices[code.ID] = *code
+	return nil
+}
+
+// findDeviceCode returns a copy, like rows loaded from the database
+func (r *fakeOAuthRepository) findDeviceCode(match func(domain.OAuthDeviceCode) bool) (*domain.OAuthDeviceCode, error) {
+	for _, code := range r.devices {
+		if match(code) {
+			return &code, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeOAuthRepository) FindDeviceCode(deviceCodeHash string) (*domain.OAuthDeviceCode, error) {
+	return r.findDeviceCode(func(code domain.OAuthDeviceCode) bool { return code.DeviceCodeHash == deviceCodeHash })
+}
+
+func (r *fakeOAuthRepository) FindDeviceCodeByUserCode(userCode string) (*domain.OAuthDeviceCode, error) {
+	return r.findDeviceCode(func(code domain.OAuthDeviceCode) bool { return code.UserCode == userCode })
+}
+
+func (r *fakeOAuthRepository) DecideDeviceCode(decided *domain.OAuthDeviceCode) (bool, error) {
+	code, ok := r.devices[decided.ID]
+	if !ok || code.UserID != nil || code.DeniedAt != nil {
+		return false, nil
+	}
+	code.UserID, code.GrantID, code.AuthTime, code.DeniedAt = decided.UserID, decided.GrantID, decided.AuthTime, decided.DeniedAt
+	r.devices[code.ID] = code
+	return true, nil
+}
+
+func (r *fakeOAuthRepository) UpdateDeviceCodePoll(id uuid.UUID, polledAt time.Time, interval int) error {
+	if code, ok := r.devices[id]; ok {
+		code.LastPolledAt, code.PollInterval = &polledAt, interval
+		r.devices[id] = code
+	}
+	return nil
+}
+
+func (r *fakeOAuthRepository) DeleteDeviceCode(id uuid.UUID) (bool, error) {
+	_, ok := r.devices[id]
+	delete(r.devices, id)
+	return ok, nil
+}
+
+func (r *fakeOAuthRepository) DeleteExpiredDeviceCodes(before time.Time) error {
+	for id, code := range r.devices {
+		if code.ExpiresAt.Before(before) {
+			delete(r.devices, id)
+		}
+	}
+	return nil
+}
+
+func (r *fakeOAuthRepository) FindDeviceAttempts(userID uuid.UUID) (*domain.OAuthDeviceAttempts, error) {
+	attempts, ok := r.attempts[userID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &attempts, nil
+}
+
+func (r *fakeOAuthRepository) AddDeviceFailure(userID uuid.UUID, failedAt, since time.Time) (*domain.OAuthDeviceAttempts, error) {
+	attempts, ok := r.attempts[userID]
+	if !ok || attempts.FailedAt.Before(since) {
+		attempts.UserID, attempts.Failures = userID, 0
+	}
+	attempts.Failures++
+	attempts.FailedAt = failedAt
+	r.attempts[userID] = attempts
+	return &attempts, nil
+}
+
+func (r *fakeOAuthRepository) LockDeviceAttempts(userID uuid.UUID, until time.Time) error {
+	if attempts, ok := r.attempts[userID]; ok {
+		attempts.Failures, attempts.LockedUntil = 0, &until
+		r.attempts[userID] = attempts
+	}
+	return nil
+}
+
+func (r *fakeOAuthRepository) DeleteDeviceAttempts(userID uuid.UUID) error {
+	delete(r.attempts, userID)
+	return nil
+}
+
+// fakeUserRepository only finds users by ID
+type fakeUserRepository struct {
+	userdomain.UserRepository
+	users map[uuid.UUID]*userdomain.User
+}
+
+func (r *fakeUserRepository) FindByID(id uuid.UUID) (*userdomain.User, error) {
+	return r.users[id], nil
+}
+
+type testAuthorizationServer struct {
+	*authorizationServer
+	authRepo  *fakeAuthRepository
+	oauthRepo *fakeOAuthRepository
+	user      *userdomain.User
+}
+
+func newTestAuthorizationServer(t *testing.T) *testAuthorizationServer {
+	t.Helper()
+	authRepo := &fakeAuthRepository{
+		sessions: map[uuid.UUID]*domain.Session{},
+		clients:  map[string]*domain.OAuthClient{},
+	}
+	oauthRepo := &fakeOAuthRepository{
+		auth:     authRepo,
+		grants:   map[uuid.UUID]*domain.OAuthGrant{},
+		codes:    map[string]*domain.OAuthAuthorizationCode{},
+		devices:  map[uuid.UUID]domain.OAuthDeviceCode{},
+		attempts: map[uuid.UUID]domain.OAuthDeviceAttempts{},
+	}
+	user := &userdomain.User{
+		ID:        uuid.New(),
+		Email:     "alice@example.com",
+		Name:      "Alice Example",
+		GivenName: "Alice",
+		Role:      userdomain.RoleUser,
+		Active:    true,
+	}
+	userRepo := &fakeUserRepository{users: map[uuid.UUID]*userdomain.User{user.ID: user}}
+
+	server := NewAuthorizationServerUsecase(authRepo, oauthRepo, userRepo, AuthorizationServerConfig{
+		Issuer:     "https://api.example.com/",
+		ConsentURL: "https://app.example.com/consent",
+		JWTSecret:  "test-secret",
+		TokenConfig: TokenConfig{
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: 24 * time.Hour,
+		},
+		Scopes: domain.ScopeCatalog{"users:read": "View user profiles"},
+	}).(*authorizationServer)
+	return &testAuthorizationServer{authorizationServer: server, authRepo: authRepo, oauthRepo: oauthRepo, user: user}
+}
+
+const testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func testCodeChallenge() string {
+	sum := sha256.Sum256([]byte(testCodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (s *testAuthorizationServer) authorizationRequest(clientID, scope string) domain.AuthorizationRequest {
+	return domain.AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            clientID,
+		RedirectURI:         "https://partner.example.com/callback",
+		Scope:               scope,
+		State:               "xyz",
+		CodeChallenge:       testCodeChallenge(),
+		CodeChallengeMethod: "S256",
+		Nonce:               "n-0S6",
+	}
+}
+
+// authorize runs an authorization request through consent and returns the code
+func (s *testAuthorizationServer) authorize(t *testing.T, clientID, scope string) string {
+	t.Helper()
+	ctx := context.Background()
+	consentURL, err := s.Authorize(ctx, s.authorizationRequest(clientID, scope))
+	require.NoError(t, err)
+	parsed, err := url.Parse(consentURL)
+	require.NoError(t, err)
+	require.Equal(t, "app.example.com", parsed.Host)
+
+	redirect, err := s.Consent(ctx, domain.ConsentDecision{
+		UserID:       s.user.ID,
+		RequestToken: parsed.Query().Get("request"),
+		Approved:     true,
+	})
+	require.NoError(t, err)
+	parsed, err = url.Parse(redirect)
+	require.NoError(t, err)
+	assert.Equal(t, "xyz", parsed.Query().Get("state"))
+	require.NotEmpty(t, parsed.Query().Get("code"))
+	return parsed.Query().Get("code")
+}
+
+func (s *testAuthorizationServer) registerClient(t *testing.T, public bool) *domain.RegisteredClient {
+	t.Helper()
+	client, err := s.RegisterClient(context.Background(), uuid.New(), domain.RegisterClientRequest{
+		Name:         "Partner",
+		RedirectURIs: []string{"https://partner.example.com/callback"},
+		Public:       public,
+	})
+	require.NoError(t, err)
+	return client
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthorizationServer(t)
+	client := s.registerClient(t, false)
+	require.NotEmpty(t, client.ClientSecret)
+
+	code := s.authorize(t, client.ClientID, "openid email offline_access")
+	token, err := s.Token(ctx, domain.TokenRequest{
+		GrantType:    domain.GrantTypeAuthorizationCode,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		Code:         code,
+		RedirectURI:  "https://partner.example.com/callback",
+		CodeVerifier: testCodeVerifier,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "openid email offline_access", token.Scope)
+	assert.NotEmpty(t, token.RefreshToken)
+
+	t.Run("ID token is signed with the published key", func(t *testing.T) {
+		keys, err := s.JSONWebKeySet()
+		require.NoError(t, err)
+		require.Len(t, keys.Keys, 1)
+		publicKey, err := jwkPublicKey(keys.Keys[0])
+		require.NoError(t, err)
+
+		claims := jwt.MapClaims{}
+		_, err = jwt.ParseWithClaims(token.IDToken, claims, func(*jwt.Token) (interface{}, error) {
+			return publicKey, nil
+		}, jwt.WithIssuer("https://api.example.com"), jwt.WithAudience(client.ClientID))
+		require.NoError(t, err)
+		assert.Equal(t, s.user.ID.String(), claims["sub"])
+		assert.Equal(t, "n-0S6", claims["nonce"])
+		assert.Equal(t, "alice@example.com", claims["email"])
+		assert.NotContains(t, claims, "name")
+		assert.Contains(t, claims, "auth_time")
+	})
+
+	t.Run("userinfo releases the granted claims", func(t *testing.T) {
+		claims, err := s.UserInfo(ctx, token.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"sub": s.user.ID.String(), "email": "alice@example.com"}, claims)
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		refreshed, err := s.Token(ctx, domain.TokenRequest{
+			GrantType:    domain.GrantTypeRefreshToken,
+			ClientID:     client.ClientID,
+			ClientSecret: client.ClientSecret,
+			RefreshToken: token.RefreshToken,
+		})
+		require.NoError(t, err)
+		assert.NotEmpty(t, refreshed.AccessToken)
+		assert.NotEmpty(t, refreshed.IDToken)
+	})
+
+	t.Run("replayed code revokes the tokens", func(t *testing.T) {
+		_, err := s.Token(ctx, domain.TokenRequest{
+			GrantType:    domain.GrantTypeAuthorizationCode,
+			ClientID:     client.ClientID,
+			ClientSecret: client.ClientSecret,
+			Code:         code,
+			RedirectURI:  "https://partner.example.com/callback",
+			CodeVerifier: testCodeVerifier,
+		})
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+		_, err = s.UserInfo(ctx, token.AccessToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
+
+func TestAuthorizationCodeExchangeErrors(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthorizationServer(t)
+	client := s.registerClient(t, true)
+	assert.Empty(t, client.ClientSecret)
+
+	exchange := func(code, verifier, redirectURI string) error {
+		_, err := s.Token(ctx, domain.TokenRequest{
+			GrantType:    domain.GrantTypeAuthorizationCode,
+			ClientID:     client.ClientID,
+			Code:         code,
+			RedirectURI:  redirectURI,
+			CodeVerifier: verifier,
+		})
+		return err
+	}
+
+	t.Run("wrong code verifier", func(t *testing.T) {
+		code := s.authorize(t, client.ClientID, "openid")
+		assert.ErrorIs(t, exchange(code, strings.Repeat("a", 43), "https://partner.example.com/callback"), ErrInvalidGrant)
+	})
+
+	t.Run("wrong redirect URI", func(t *testing.T) {
+		code := s.authorize(t, client.ClientID, "openid")
+		assert.ErrorIs(t, exchange(code, testCodeVerifier, "https://partner.example.com/other"), ErrInvalidGrant)
+	})
+
+	t.Run("expired code", func(t *testing.T) {
+		code := s.authorize(t, client.ClientID, "openid")
+		s.now = func() time.Time { return time.Now().Add(authorizationCodeTTL + time.Second) }
+		defer func() { s.now = time.Now }()
+		assert.ErrorIs(t, exchange(code, testCodeVerifier, "https://partner.example.com/callback"), ErrInvalidGrant)
+	})
+
+	t.Run("no refresh token without offline_access", func(t *testing.T) {
+		code := s.authorize(t, client.ClientID, "openid")
+		token, err := s.Token(ctx, domain.TokenRequest{
+			GrantType:    domain.GrantTypeAuthorizationCode,
+			ClientID:     client.ClientID,
+			Code:         code,
+			RedirectURI:  "https://partner.example.com/callback",
+			CodeVerifier: testCodeVerifier,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, token.RefreshToken)
+	})
+
+	t.Run("confidential client without secret", func(t *testing.T) {
+		confidential := s.registerClient(t, false)
+		_, err := s.Token(ctx, domain.TokenRequest{GrantType: domain.GrantTypeAuthorizationCode, ClientID: confidential.ClientID})
+		assert.ErrorIs(t, err, ErrInvalidClient)
+	})
+
+	t.Run("unsupported grant type", func(t *testing.T) {
+		_, err := s.Token(ctx, domain.TokenRequest{GrantType: "password", ClientID: client.ClientID})
+		assert.ErrorIs(t, err, ErrUnsupportedGrantType)
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthorizationServer(t)
+	client := s.registerClient(t, false)
+
+	t.Run("unknown client", func(t *testing.T) {
+		_, err := s.Authorize(ctx, s.authorizationRequest("unknown", "openid"))
+		assert.ErrorIs(t, err, ErrInvalidClient)
+	})
+
+	t.Run("unregistered redirect URI", func(t *testing.T) {
+		req := s.authorizationRequest(client.ClientID, "openid")
+		req.RedirectURI = "https://attacker.example.com/callback"
+		_, err := s.Authorize(ctx, req)
+		assert.ErrorIs(t, err, ErrInvalidRedirectURI)
+	})
+
+	redirectError := func(t *testing.T, req domain.AuthorizationRequest) string {
+		redirect, err := s.Authorize(ctx, req)
+		require.NoError(t, err)
+		parsed, err := url.Parse(redirect)
+		require.NoError(t, err)
+		assert.Equal(t, "partner.example.com", parsed.Host)
+		assert.Equal(t, "xyz", parsed.Query().Get("state"))
+		return parsed.Query().Get("error")
+	}
+
+	t.Run("missing PKCE", func(t *testing.T) {
+		req := s.authorizationRequest(client.ClientID, "openid")
+		req.CodeChallenge = ""
+		assert.Equal(t, "invalid_request", redirectError(t, req))
+	})
+
+	t.Run("plain PKCE", func(t *testing.T) {
+		req := s.authorizationRequest(client.ClientID, "openid")
+		req.CodeChallengeMethod = "plain"
+		assert.Equal(t, "invalid_request", redirectError(t, req))
+	})
+
+	t.Run("unknown scope", func(t *testing.T) {
+		assert.Equal(t, "invalid_scope", redirectError(t, s.authorizationRequest(client.ClientID, "openid admin")))
+	})
+
+	t.Run("token response type", func(t *testing.T) {
+		req := s.authorizationRequest(client.ClientID, "openid")
+		req.ResponseType = "token"
+		assert.Equal(t, "unsupported_response_type", redirectError(t, req))
+	})
+}
+
+func TestConsent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthorizationServer(t)
+	client := s.registerClient(t, false)
+
+	requestToken := func(scope, prompt string) string {
+		req := s.authorizationRequest(client.ClientID, scope)
+		req.Prompt = prompt
+		consentURL, err := s.Authorize(ctx, req)
+		require.NoError(t, err)
+		parsed, err := url.Parse(consentURL)
+		require.NoError(t, err)
+		return parsed.Query().Get("request")
+	}
+
+	t.Run("denied", func(t *testing.T) {
+		redirect, err := s.Consent(ctx, domain.ConsentDecision{UserID: s.user.ID, RequestToken: requestToken("openid", ""), Approved: false})
+		require.NoError(t, err)
+		parsed, err := url.Parse(redirect)
+		require.NoError(t, err)
+		assert.Equal(t, "access_denied", parsed.Query().Get("error"))
+		assert.Empty(t, s.oauthRepo.grants)
+	})
+
+	t.Run("granted scopes are not asked again", func(t *testing.T) {
+		req, err := s.ConsentRequest(ctx, s.user.ID, requestToken("openid email", ""))
+		require.NoError(t, err)
+		assert.True(t, req.ConsentRequired)
+		assert.Equal(t, "Partner", req.ClientName)
+		assert.Equal(t, []string{"openid", "email"}, req.Scopes)
+
+		s.authorize(t, client.ClientID, "openid email")
+
+		req, err = s.ConsentRequest(ctx, s.user.ID, requestToken("email", ""))
+		require.NoError(t, err)
+		assert.False(t, req.ConsentRequired)
+
+		req, err = s.ConsentRequest(ctx, s.user.ID, requestToken("email", "consent"))
+		require.NoError(t, err)
+		assert.True(t, req.ConsentRequired)
+
+		req, err = s.ConsentRequest(ctx, s.user.ID, requestToken("openid profile", ""))
+		require.NoError(t, err)
+		assert.True(t, req.ConsentRequired)
+	})
+
+	t.Run("API scopes of the catalog", func(t *testing.T) {
+		req, err := s.ConsentRequest(ctx, s.user.ID, requestToken("openid users:read", ""))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"openid", "users:read"}, req.Scopes)
+		assert.Equal(t, "View user profiles", req.ScopeDescriptions["users:read"])
+		assert.Contains(t, s.OpenIDConfiguration().ScopesSupported, "users:read")
+	})
+
+	t.Run("tampered request", func(t *testing.T) {
+		_, err := s.ConsentRequest(ctx, s.user.ID, requestToken("openid", "")+"x")
+		assert.ErrorIs(t, err, ErrInvalidAuthorizationRequest)
+	})
+
+	t.Run("request is not an access token", func(t *testing.T) {
+		_, err := parseAccessToken(s.jwtSecret, requestToken("openid", ""))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
+
+func TestRevokeGrant(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthorizationServer(t)
+	client := s.registerClient(t, false)
+	code := s.authorize(t, client.ClientID, "openid users:read")
+	token, err := s.Token(ctx, domain.TokenRequest{
+		GrantType:    domain.GrantTypeAuthorizationCode,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		Code:         code,
+		RedirectURI:  "https://partner.example.com/callback",
+		CodeVerifier: testCodeVerifier,
+	})
+	require.NoError(t, err)
+	claims, err := parseAccessToken(s.jwtSecret, token.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "openid users:read", claims["scope"])
+
+	grants, err := s.ListGrants(ctx, s.user.ID)
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+
+	assert.ErrorIs(t, s.RevokeGrant(ctx, uuid.New(), grants[0].ID), ErrGrantNotFound)
+	require.NoError(t, s.RevokeGrant(ctx, s.user.ID, grants[0].ID))
+
+	_, err = s.UserInfo(ctx, token.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri    string
+		public bool
+		valid  bool
+	}{
+		{"https://partner.example.com/callback", false, true},
+		{"http://localhost:8080/callback", false, true},
+		{"http://127.0.0.1/callback", true, true},
+		{"com.example.app:/callback", true, true},
+		{"com.example.app:/callback", false, false},
+		{"http://partner.example.com/callback", false, false},
+		{"https://partner.example.com/callback#fragment", false, false},
+		{"/callback", false, false},
+		{"javascript:alert(1)", true, false},
+	}
+	for _, tt := range tests {
+		err := validateRedirectURI(tt.uri, tt.public)
+		assert.Equal(t, tt.valid, err == nil, "%s public=%v: %v", tt.uri, tt.public, err)
+	}
+}
@@ -0,0 +1,100 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// Custom errors
+var (
+	ErrInvalidClaimNamespace = errors.New("invalid claim namespace")
+	ErrClaimsTooLarge        = errors.New("custom claims are too large")
+)
+
+// Size limits of the JSON encoded custom claims, access tokens are sent with
+// every request and headers of more than 8 KB are rejected by many proxies
+const (
+	maxNamespaceClaimsSize = 1024
+	maxCustomClaimsSize    = 4096
+)
+
+// reservedClaims are the claims our tokens and the JWT, OAuth and OpenID
+// Connect specifications define. Providers can't use them as namespaces.
+var reservedClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+	"sid": true, "role": true, "scope": true, "client_id": true, "azp": true, "cnf": true,
+	"auth_time": true, "acr": true, "amr": true, "nonce": true, "act": true, "may_act": true,
+	"grant_id": true, "org_id": true, "org_role": true, "guest": true,
+	"email": true, "email_verified": true, "name": true, "given_name": true, "family_name": true,
+	"picture": true, "locale": true, "updated_at": true,
+}
+
+var claimNamespacePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// ClaimsRegistry holds the claim providers of the modules and adds their
+// claims to access tokens
+type ClaimsRegistry struct {
+	providers []domain.ClaimsProvider
+}
+
+// NewClaimsRegistry registers the claim providers of the modules. Namespaces
+// must be lowercase identifiers, unique, and not a reserved claim.
+func NewClaimsRegistry(modules ...[]domain.ClaimsProvider) (*ClaimsRegistry, error) {
+	registry := &ClaimsRegistry{}
+	namespaces := map[string]bool{}
+	for _, providers := range modules {
+		for _, provider := range providers {
+			switch {
+			case !claimNamespacePattern.MatchString(provider.Namespace):
+				return nil, fmt.Errorf("%w: %q", ErrInvalidClaimNamespace, provider.Namespace)
+			case reservedClaims[provider.Namespace]:
+				return nil, fmt.Errorf("%w: %q is a reserved claim", ErrInvalidClaimNamespace, provider.Namespace)
+			case namespaces[provider.Namespace]:
+				return nil, fmt.Errorf("%w: %q is registered twice", ErrInvalidClaimNamespace, provider.Namespace)
+			}
+			namespaces[provider.Namespace] = true
+			registry.providers = append(registry.providers, provider)
+		}
+	}
+	return registry, nil
+}
+
+// Claims returns the claims of every provider, keyed by namespace. A provider
+// error or claims over the size limits fail the token, rather than issuing
+// one that lacks claims APIs rely on.
+func (r *ClaimsRegistry) Claims(ctx context.Context, user *userdomain.User, session *domain.Session) (map[string]interface{}, error) {
+	if r == nil || len(r.providers) == 0 {
+		return nil, nil
+	}
+
+	claims := map[string]interface{}{}
+	total := 0
+	for _, provider := range r.providers {
+		values, err := provider.Claims(ctx, user, session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s claims: %w", provider.Namespace, err)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		encoded, err := json.Marshal(values)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s claims: %w", provider.Namespace, err)
+		}
+		if len(encoded) > maxNamespaceClaimsSize {
+			return nil, fmt.Errorf("%w: %s claims are %d bytes, the limit is %d", ErrClaimsTooLarge, provider.Namespace, len(encoded), maxNamespaceClaimsSize)
+		}
+		total += len(encoded)
+		if total > maxCustomClaimsSize {
+			return nil, fmt.Errorf("%w: over %d bytes", ErrClaimsTooLarge, maxCustomClaimsSize)
+		}
+		claims[provider.Namespace] = values
+	}
+	return claims, nil
+}
@@ -0,0 +1,116 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// staticClaims is a provider returning the same claims for every user
+func staticClaims(namespace string, claims map[string]interface{}) domain.ClaimsProvider {
+	return domain.ClaimsProvider{
+		Namespace: namespace,
+		Claims: func(ctx context.Context, user *userdomain.User, session *domain.Session) (map[string]interface{}, error) {
+			return claims, nil
+		},
+	}
+}
+
+func TestNewClaimsRegistry(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers []domain.ClaimsProvider
+		wantErr   bool
+	}{
+		{"valid", []domain.ClaimsProvider{staticClaims("billing", nil), staticClaims("feature_cohorts", nil)}, false},
+		{"reserved claim", []domain.ClaimsProvider{staticClaims("sub", nil)}, true},
+		{"claim of our tokens", []domain.ClaimsProvider{staticClaims("org_role", nil)}, true},
+		{"uppercase", []domain.ClaimsProvider{staticClaims("Billing", nil)}, true},
+		{"empty", []domain.ClaimsProvider{staticClaims("", nil)}, true},
+		{"registered twice", []domain.ClaimsProvider{staticClaims("billing", nil), staticClaims("billing", nil)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClaimsRegistry(tt.providers)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidClaimNamespace)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAccessTokenCustomClaims(t *testing.T) {
+	user := &userdomain.User{ID: uuid.New(), Role: userdomain.RoleUser, Active: true}
+	refresh := func(t *testing.T, providers ...domain.ClaimsProvider) (map[string]interface{}, error) {
+		t.Helper()
+		registry, err := NewClaimsRegistry(providers)
+		require.NoError(t, err)
+		authRepo := &fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{}}
+		u := NewAuthUsecase(authRepo, &fakeUserRepository{users: map[uuid.UUID]*userdomain.User{user.ID: user}}, nil, nil, AuthUsecaseConfig{
+			JWTSecret:   "test-secret",
+			TokenConfig: TokenConfig{AccessTTL: 15 * time.Minute, RefreshTTL: time.Hour},
+			Claims:      registry,
+		})
+		session := &domain.Session{ID: uuid.New(), UserID: user.ID, RefreshToken: uuid.NewString(), ExpiresAt: time.Now().Add(time.Hour)}
+		require.NoError(t, authRepo.CreateSession(session))
+
+		token, err := u.RefreshToken(context.Background(), session.RefreshToken, domain.DPoPProofRequest{})
+		if err != nil {
+			return nil, err
+		}
+		return parseAccessToken([]byte("test-secret"), token.AccessToken)
+	}
+
+	t.Run("namespaced claims", func(t *testing.T) {
+		var gotUser *userdomain.User
+		cohorts := domain.ClaimsProvider{
+			Namespace: "cohorts",
+			Claims: func(ctx context.Context, u *userdomain.User, session *domain.Session) (map[string]interface{}, error) {
+				gotUser = u
+				return map[string]interface{}{"checkout": "b", "sub": "not-the-user"}, nil
+			},
+		}
+		claims, err := refresh(t, staticClaims("billing", map[string]interface{}{"tier": "pro"}), cohorts, staticClaims("empty", nil))
+		require.NoError(t, err)
+		assert.Equal(t, user, gotUser)
+		assert.Equal(t, map[string]interface{}{"tier": "pro"}, claims["billing"])
+		assert.Equal(t, map[string]interface{}{"checkout": "b", "sub": "not-the-user"}, claims["cohorts"])
+		assert.Equal(t, user.ID.String(), claims["sub"], "nested claims don't replace reserved claims")
+		assert.NotContains(t, claims, "empty")
+	})
+
+	t.Run("namespace over the size limit", func(t *testing.T) {
+		_, err := refresh(t, staticClaims("billing", map[string]interface{}{"blob": strings.Repeat("x", maxNamespaceClaimsSize)}))
+		assert.ErrorIs(t, err, ErrClaimsTooLarge)
+	})
+
+	t.Run("all namespaces over the size limit", func(t *testing.T) {
+		var providers []domain.ClaimsProvider
+		for _, namespace := range []string{"a", "b", "c", "d", "e"} {
+			providers = append(providers, staticClaims(namespace, map[string]interface{}{"blob": strings.Repeat("x", maxNamespaceClaimsSize-20)}))
+		}
+		_, err := refresh(t, providers...)
+		assert.ErrorIs(t, err, ErrClaimsTooLarge)
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		failing := domain.ClaimsProvider{
+			Namespace: "billing",
+			Claims: func(ctx context.Context, user *userdomain.User, session *domain.Session) (map[string]interface{}, error) {
+				return nil, errors.New("billing is down")
+			},
+		}
+		_, err := refresh(t, failing)
+		assert.ErrorContains(t, err, "billing is down")
+	})
+}
@@ -0,0 +1,319 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"gorm.io/gorm"
+)
+
+// Custom errors
+var (
+	ErrAuthorizationPending    = errors.New("the user hasn't approved the device yet")
+	ErrSlowDown                = errors.New("polling too frequently")
+	ErrExpiredToken            = errors.New("device code has expired")
+	ErrAccessDenied            = errors.New("the user denied the request")
+	ErrInvalidUserCode         = errors.New("invalid or expired user code")
+	ErrTooManyUserCodes        = errors.New("too many invalid user codes")
+	ErrDeviceFlowNotConfigured = errors.New("device verification URL is not configured")
+)
+
+const (
+	// deviceCodeTTL is how long the user has to enter the user code
+	deviceCodeTTL = 10 * time.Minute
+	// devicePollInterval is the minimum number of seconds between two polls
+	devicePollInterval = 5
+	// deviceSlowDownStep is added to the interval of an app that polls too fast (RFC 8628 section 3.5)
+	deviceSlowDownStep = 5
+	// userCodeAlphabet has no vowels, so user codes never spell words, and no
+	// characters that are easily confused (RFC 8628 section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+	// maxUserCodeFailures invalid user codes within userCodeFailureWindow lock
+	// the user out of device verification for userCodeLockout
+	maxUserCodeFailures   = 5
+	userCodeFailureWindow = 15 * time.Minute
+	userCodeLockout       = 15 * time.Minute
+)
+
+func (s *authorizationServer) DeviceAuthorization(ctx context.Context, req domain.DeviceAuthorizationRequest) (*domain.DeviceAuthorizationResponse, error) {
+	if s.deviceVerificationURL == "" {
+		return nil, ErrDeviceFlowNotConfigured
+	}
+	client, err := s.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	scopes, err := parseScope(s.scopes, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	// Expired codes are kept for a while so that late polls get expired_token
+	if err := s.oauthRepo.DeleteExpiredDeviceCodes(now.Add(-deviceCodeTTL)); err != nil {
+		return nil, fmt.Errorf("failed to delete expired device codes: %w", err)
+	}
+
+	deviceCode, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate device code: %w", err)
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate user code: %w", err)
+	}
+	err = s.oauthRepo.CreateDeviceCode(&domain.OAuthDeviceCode{
+		ID:             uuid.New(),
+		DeviceCodeHash: hashToken(deviceCode),
+		UserCode:       userCode,
+		ClientID:       client.ClientID,
+		Scope:          strings.Join(scopes, " "),
+		PollInterval:   devicePollInterval,
+		ExpiresAt:      now.Add(deviceCodeTTL),
+		CreatedAt:      now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device code: %w", err)
+	}
+
+	displayCode := formatUserCode(userCode)
+	return &domain.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                displayCode,
+		VerificationURI:         s.deviceVerificationURL,
+		VerificationURIComplete: addQuery(s.deviceVerificationURL, url.Values{"user_code": {displayCode}}),
+		ExpiresIn:               int64(deviceCodeTTL.Seconds()),
+		Interval:                devicePollInterval,
+	}, nil
+}
+
+func (s *authorizationServer) DeviceVerification(ctx context.Context, userID uuid.UUID, userCode string) (*domain.DeviceVerification, error) {
+	code, err := s.pendingDeviceCode(userID, userCode)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.findClient(code.ClientID)
+	if err != nil {
+		return nil, ErrInvalidUserCode
+	}
+
+	scopes := strings.Fields(code.Scope)
+	descriptions := make(map[string]string, len(scopes))
+	for _, scope := range scopes {
+		descriptions[scope] = s.scopes[scope]
+	}
+	return &domain.DeviceVerification{
+		ClientID:          client.ClientID,
+		ClientName:        client.Name,
+		Scopes:            scopes,
+		ScopeDescriptions: descriptions,
+	}, nil
+}
+
+func (s *authorizationServer) VerifyDevice(ctx context.Context, decision domain.DeviceDecision) error {
+	code, err := s.pendingDeviceCode(decision.UserID, decision.UserCode)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	if !decision.Approved {
+		code.DeniedAt = &now
+	} else {
+		user, err := s.userRepo.FindByID(decision.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to find user: %w", err)
+		}
+		if err := checkAccount(user); err != nil {
+			return err
+		}
+		grant, err := s.saveGrant(user.ID, code.ClientID, strings.Fields(code.Scope))
+		if err != nil {
+			return err
+		}
+		authTime, err := s.authTime(decision.SessionID)
+		if err != nil {
+			return err
+		}
+		code.UserID = &user.ID
+		code.GrantID = &grant.ID
+		code.AuthTime = &authTime
+	}
+
+	decided, err := s.oauthRepo.DecideDeviceCode(code)
+	if err != nil {
+		return fmt.Errorf("failed to update device code: %w", err)
+	}
+	if !decided {
+		return ErrInvalidUserCode
+	}
+	if err := s.oauthRepo.DeleteDeviceAttempts(decision.UserID); err != nil {
+		return fmt.Errorf("failed to reset user code failures: %w", err)
+	}
+	return nil
+}
+
+// pollDeviceCode answers the token requests of an app waiting for the user.
+// Until the user decides the app gets authorization_pending, or slow_down if
+// it polls faster than its interval, which then grows by 5 seconds.
+func (s *authorizationServer) pollDeviceCode(client *domain.OAuthClient, deviceCode string) (*domain.TokenResponse, error) {
+	if deviceCode == "" {
+		return nil, fmt.Errorf("%w: device_code is required", ErrInvalidRequest)
+	}
+
+	code, err := s.oauthRepo.FindDeviceCode(hashToken(deviceCode))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: unknown device code", ErrInvalidGrant)
+		}
+		return nil, fmt.Errorf("failed to find device code: %w", err)
+	}
+	if code.ClientID != client.ClientID {
+		return nil, fmt.Errorf("%w: device code was issued to another client", ErrInvalidGrant)
+	}
+	now := s.now()
+	if now.After(code.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+	if code.DeniedAt != nil {
+		if _, err := s.oauthRepo.DeleteDeviceCode(code.ID); err != nil {
+			return nil, fmt.Errorf("failed to delete device code: %w", err)
+		}
+		return nil, ErrAccessDenied
+	}
+
+	if code.UserID == nil {
+		interval := code.PollInterval
+		tooFast := code.LastPolledAt != nil && now.Sub(*code.LastPolledAt) < time.Duration(interval)*time.Second
+		if tooFast {
+			interval += deviceSlowDownStep
+		}
+		if err := s.oauthRepo.UpdateDeviceCodePoll(code.ID, now, interval); err != nil {
+			return nil, fmt.Errorf("failed to update device code: %w", err)
+		}
+		if tooFast {
+			return nil, ErrSlowDown
+		}
+		return nil, ErrAuthorizationPending
+	}
+
+	// Deleting the code redeems it, a concurrent poll finds nothing to delete
+	deleted, err := s.oauthRepo.DeleteDeviceCode(code.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete device code: %w", err)
+	}
+	if !deleted {
+		return nil, fmt.Errorf("%w: device code was already used", ErrInvalidGrant)
+	}
+
+	user, err := s.activeUser(*code.UserID)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	session := &domain.Session{
+		ID:           uuid.New(),
+		UserID:       user.ID,
+		Client:       client.ClientID,
+		GrantID:      code.GrantID,
+		Scope:        code.Scope,
+		RefreshToken: refreshToken,
+		ExpiresAt:    now.Add(s.refreshTTL),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if code.AuthTime != nil {
+		session.AuthTime = *code.AuthTime
+	}
+	if err := s.authRepo.CreateSession(session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return s.issueTokens(user, session, "", code.AuthTime, true)
+}
+
+// pendingDeviceCode finds the device code of a user code the user hasn't
+// decided yet. Users who entered too many invalid user codes are locked out,
+// so that the codes of other users' devices can't be guessed.
+func (s *authorizationServer) pendingDeviceCode(userID uuid.UUID, userCode string) (*domain.OAuthDeviceCode, error) {
+	now := s.now()
+	attempts, err := s.oauthRepo.FindDeviceAttempts(userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to find user code failures: %w", err)
+	}
+	if attempts != nil && attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil) {
+		return nil, ErrTooManyUserCodes
+	}
+
+	normalized := normalizeUserCode(userCode)
+	if len(normalized) != userCodeLength {
+		return nil, s.userCodeFailure(userID, now)
+	}
+	code, err := s.oauthRepo.FindDeviceCodeByUserCode(normalized)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, s.userCodeFailure(userID, now)
+		}
+		return nil, fmt.Errorf("failed to find device code: %w", err)
+	}
+	if now.After(code.ExpiresAt) || code.UserID != nil || code.DeniedAt != nil {
+		return nil, s.userCodeFailure(userID, now)
+	}
+	return code, nil
+}
+
+// userCodeFailure counts an invalid user code of the user, locks the user out
+// once there are too many and returns ErrInvalidUserCode
+func (s *authorizationServer) userCodeFailure(userID uuid.UUID, now time.Time) error {
+	attempts, err := s.oauthRepo.AddDeviceFailure(userID, now, now.Add(-userCodeFailureWindow))
+	if err != nil {
+		return fmt.Errorf("failed to count user code failure: %w", err)
+	}
+	if attempts.Failures >= maxUserCodeFailures {
+		if err := s.oauthRepo.LockDeviceAttempts(userID, now.Add(userCodeLockout)); err != nil {
+			return fmt.Errorf("failed to lock device verification: %w", err)
+		}
+	}
+	return ErrInvalidUserCode
+}
+
+// generateUserCode returns a random user code of userCodeLength characters
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// formatUserCode inserts a dash in the middle of a user code for readability
+func formatUserCode(code string) string {
+	return code[:len(code)/2] + "-" + code[len(code)/2:]
+}
+
+// normalizeUserCode upper-cases what the user typed and drops dashes and spaces
+func normalizeUserCode(input string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(input) {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
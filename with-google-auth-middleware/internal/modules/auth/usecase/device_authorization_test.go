@@ -0,0 +1,241 @@
+package usecase
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+)
+
+// newTestDeviceFlow returns a server with the device flow enabled and a clock the test moves
+func newTestDeviceFlow(t *testing.T) (*testAuthorizationServer, *time.Time) {
+	s := newTestAuthorizationServer(t)
+	s.deviceVerificationURL = "https://app.example.com/device"
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func (s *testAuthorizationServer) pollDevice(client *domain.RegisteredClient, deviceCode string) (*domain.TokenResponse, error) {
+	return s.Token(context.Background(), domain.TokenRequest{
+		GrantType:    domain.GrantTypeDeviceCode,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		DeviceCode:   deviceCode,
+	})
+}
+
+func (s *testAuthorizationServer) startDeviceFlow(t *testing.T, client *domain.RegisteredClient, scope string) *domain.DeviceAuthorizationResponse {
+	t.Helper()
+	res, err := s.DeviceAuthorization(context.Background(), domain.DeviceAuthorizationRequest{
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		Scope:        scope,
+	})
+	require.NoError(t, err)
+	return res
+}
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	ctx := context.Background()
+	s, now := newTestDeviceFlow(t)
+	client := s.registerClient(t, true)
+
+	res := s.startDeviceFlow(t, client, "openid offline_access users:read")
+	assert.Regexp(t, regexp.MustCompile(`^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`), res.UserCode)
+	assert.Equal(t, "https://app.example.com/device", res.VerificationURI)
+	assert.Equal(t, "https://app.example.com/device?user_code="+res.UserCode, res.VerificationURIComplete)
+	assert.Equal(t, int64(600), res.ExpiresIn)
+	assert.Equal(t, 5, res.Interval)
+
+	_, err := s.pollDevice(client, res.DeviceCode)
+	assert.ErrorIs(t, err, ErrAuthorizationPending)
+
+	// Users type the code however they like
+	typed := strings.ToLower(strings.ReplaceAll(res.UserCode, "-", " "))
+	verification, err := s.DeviceVerification(ctx, s.user.ID, typed)
+	require.NoError(t, err)
+	assert.Equal(t, "Partner", verification.ClientName)
+	assert.Equal(t, []string{"openid", "offline_access", "users:read"}, verification.Scopes)
+	assert.Equal(t, "View user profiles", verification.ScopeDescriptions["users:read"])
+
+	require.NoError(t, s.VerifyDevice(ctx, domain.DeviceDecision{UserID: s.user.ID, SessionID: uuid.New(), UserCode: typed, Approved: true}))
+
+	*now = now.Add(5 * time.Second)
+	token, err := s.pollDevice(client, res.DeviceCode)
+	require.NoError(t, err)
+	assert.Equal(t, "openid offline_access users:read", token.Scope)
+	assert.NotEmpty(t, token.AccessToken)
+	assert.NotEmpty(t, token.IDToken)
+	assert.NotEmpty(t, token.RefreshToken)
+
+	t.Run("grant is listed under connected apps", func(t *testing.T) {
+		grants, err := s.ListGrants(ctx, s.user.ID)
+		require.NoError(t, err)
+		require.Len(t, grants, 1)
+		assert.Equal(t, client.ClientID, grants[0].ClientID)
+	})
+
+	t.Run("device code is single use", func(t *testing.T) {
+		_, err := s.pollDevice(client, res.DeviceCode)
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+
+	t.Run("user code can't be approved twice", func(t *testing.T) {
+		err := s.VerifyDevice(ctx, domain.DeviceDecision{UserID: s.user.ID, UserCode: res.UserCode, Approved: true})
+		assert.ErrorIs(t, err, ErrInvalidUserCode)
+	})
+}
+
+func TestDevicePolling(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("slow down", func(t *testing.T) {
+		s, now := newTestDeviceFlow(t)
+		client := s.registerClient(t, true)
+		res := s.startDeviceFlow(t, client, "")
+
+		_, err := s.pollDevice(client, res.DeviceCode)
+		assert.ErrorIs(t, err, ErrAuthorizationPending)
+		*now = now.Add(2 * time.Second)
+		_, err = s.pollDevice(client, res.DeviceCode)
+		assert.ErrorIs(t, err, ErrSlowDown)
+
+		// The interval grew to 10 seconds
+		*now = now.Add(5 * time.Second)
+		_, err = s.pollDevice(client, res.DeviceCode)
+		assert.ErrorIs(t, err, ErrSlowDown)
+		*now = now.Add(20 * time.Second)
+		_, err = s.pollDevice(client, res.DeviceCode)
+		assert.ErrorIs(t, err, ErrAuthorizationPending)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		s, now := newTestDeviceFlow(t)
+		client := s.registerClient(t, true)
+		res := s.startDeviceFlow(t, client, "")
+
+		*now = now.Add(deviceCodeTTL + time.Second)
+		_, err := s.pollDevice(client, res.DeviceCode)
+		assert.ErrorIs(t, err, ErrExpiredToken)
+		err = s.VerifyDevice(ctx, domain.DeviceDecision{UserID: s.user.ID, UserCode: res.UserCode, Approved: true})
+		assert.ErrorIs(t, err, ErrInvalidUserCode)
+	})
+
+	t.Run("expired codes are deleted", func(t *testing.T) {
+		s, now := newTestDeviceFlow(t)
+		client := s.registerClient(t, true)
+		s.startDeviceFlow(t, client, "")
+
+		*now = now.Add(2*deviceCodeTTL + time.Second)
+		s.startDeviceFlow(t, client, "")
+		assert.Len(t, s.oauthRepo.devices, 1)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		s, _ := newTestDeviceFlow(t)
+		client := s.registerClient(t, true)
+		res := s.startDeviceFlow(t, client, "")
+
+		require.NoError(t, s.VerifyDevice(ctx, domain.DeviceDecision{UserID: s.user.ID, UserCode: res.UserCode}))
+		_, err := s.pollDevice(client, res.DeviceCode)
+		assert.ErrorIs(t, err, ErrAccessDenied)
+		_, err = s.pollDevice(client, res.DeviceCode)
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+
+	t.Run("device code of another client", func(t *testing.T) {
+		s, _ := newTestDeviceFlow(t)
+		res := s.startDeviceFlow(t, s.registerClient(t, true), "")
+
+		_, err := s.pollDevice(s.registerClient(t, true), res.DeviceCode)
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+
+	t.Run("deactivated user", func(t *testing.T) {
+		s, _ := newTestDeviceFlow(t)
+		client := s.registerClient(t, true)
+		res := s.startDeviceFlow(t, client, "")
+
+		s.user.Active = false
+		err := s.VerifyDevice(ctx, domain.DeviceDecision{UserID: s.user.ID, UserCode: res.UserCode, Approved: true})
+		assert.ErrorIs(t, err, ErrAccountDeactivated)
+	})
+}
+
+func TestDeviceAuthorizationErrors(t *testing.T) {
+	t.Run("not configured", func(t *testing.T) {
+		s := newTestAuthorizationServer(t)
+		_, err := s.DeviceAuthorization(context.Background(), domain.DeviceAuthorizationRequest{ClientID: s.registerClient(t, true).ClientID})
+		assert.ErrorIs(t, err, ErrDeviceFlowNotConfigured)
+	})
+
+	t.Run("unknown scope", func(t *testing.T) {
+		s, _ := newTestDeviceFlow(t)
+		_, err := s.DeviceAuthorization(context.Background(), domain.DeviceAuthorizationRequest{ClientID: s.registerClient(t, true).ClientID, Scope: "admin"})
+		assert.ErrorIs(t, err, ErrInvalidScope)
+	})
+
+	t.Run("unknown user code", func(t *testing.T) {
+		s, _ := newTestDeviceFlow(t)
+		_, err := s.DeviceVerification(context.Background(), s.user.ID, "BCDF-GHJK")
+		assert.ErrorIs(t, err, ErrInvalidUserCode)
+	})
+}
+
+func TestDeviceVerificationLockout(t *testing.T) {
+	ctx := context.Background()
+	s, now := newTestDeviceFlow(t)
+	client := s.registerClient(t, true)
+	var res *domain.DeviceAuthorizationResponse
+
+	guess := func(t *testing.T) error {
+		t.Helper()
+		return s.VerifyDevice(ctx, domain.DeviceDecision{UserID: s.user.ID, UserCode: "BCDF-GHJK", Approved: true})
+	}
+
+	t.Run("failures outside the window don't add up", func(t *testing.T) {
+		for i := 0; i < maxUserCodeFailures-1; i++ {
+			assert.ErrorIs(t, guess(t), ErrInvalidUserCode)
+		}
+		*now = now.Add(userCodeFailureWindow + time.Second)
+		assert.ErrorIs(t, guess(t), ErrInvalidUserCode)
+		res = s.startDeviceFlow(t, client, "openid")
+		_, err := s.DeviceVerification(ctx, s.user.ID, res.UserCode)
+		assert.NoError(t, err)
+	})
+
+	t.Run("too many failures lock the user out", func(t *testing.T) {
+		for i := 0; i < maxUserCodeFailures-1; i++ {
+			assert.ErrorIs(t, guess(t), ErrInvalidUserCode)
+		}
+		// The right code is refused as well while the user is locked out
+		_, err := s.DeviceVerification(ctx, s.user.ID, res.UserCode)
+		assert.ErrorIs(t, err, ErrTooManyUserCodes)
+		err = s.VerifyDevice(ctx, domain.DeviceDecision{UserID: s.user.ID, UserCode: res.UserCode, Approved: true})
+		assert.ErrorIs(t, err, ErrTooManyUserCodes)
+
+		// Other users aren't affected
+		_, err = s.DeviceVerification(ctx, uuid.New(), res.UserCode)
+		assert.NoError(t, err)
+	})
+
+	t.Run("lockout ends", func(t *testing.T) {
+		*now = now.Add(userCodeLockout + time.Second)
+		res = s.startDeviceFlow(t, client, "openid")
+		require.NoError(t, s.VerifyDevice(ctx, domain.DeviceDecision{UserID: s.user.ID, SessionID: uuid.New(), UserCode: res.UserCode, Approved: true}))
+		assert.NotContains(t, s.oauthRepo.attempts, s.user.ID)
+	})
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	assert.Equal(t, "BCDFGHJK", normalizeUserCode("bcdf-ghjk"))
+	assert.Equal(t, "BCDFGHJK", normalizeUserCode(" BCDF GHJK "))
+	assert.Equal(t, "BCDF", normalizeUserCode("BCDF-1234"))
+}
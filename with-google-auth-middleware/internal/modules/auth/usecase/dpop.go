@@ -0,0 +1,197 @@
+package usecase
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
+)
+
+// Custom errors
+var (
+	ErrInvalidDPoPProof  = errors.New("invalid DPoP proof")
+	ErrDPoPProofRequired = errors.New("a DPoP proof is required")
+)
+
+const (
+	// dpopProofType is the JWT typ of DPoP proofs
+	dpopProofType = "dpop+jwt"
+	// dpopProofMaxAge is how long after its iat a proof is accepted
+	dpopProofMaxAge = 5 * time.Minute
+	// dpopClockSkew tolerates clients whose clock is slightly ahead
+	dpopClockSkew = time.Minute
+)
+
+// dpopVerifier verifies DPoP proofs (RFC 9449) and remembers the jti of every
+// proof it accepted until the proof expires, so that proofs can't be replayed.
+// The replay cache lives in memory: with several instances a proof captured at
+// one instance could be replayed once at each other instance within its
+// lifetime, which still requires the matching access token.
+type dpopVerifier struct {
+	mode      string
+	publicURL string
+	now       func() time.Time
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextPrune time.Time
+}
+
+// NewDPoPVerifier returns the verifier of DPoP proofs for one of the
+// domain.DPoPMode values, optional when empty. publicURL is the base URL apps
+// call the API at, e.g. https://api.example.com. It must be set behind a proxy,
+// without it htu is compared with the URL the request was received at.
+func NewDPoPVerifier(mode, publicURL string) domain.DPoPVerifier {
+	if mode == "" {
+		mode = domain.DPoPModeOptional
+	}
+	return &dpopVerifier{
+		mode:      mode,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		now:       time.Now,
+		seen:      map[string]time.Time{},
+	}
+}
+
+func (v *dpopVerifier) Mode() string {
+	return v.mode
+}
+
+func (v *dpopVerifier) VerifyProof(req domain.DPoPProofRequest) (string, error) {
+	if req.Proof == "" {
+		return "", ErrDPoPProofRequired
+	}
+
+	var jwk domain.JSONWebKey
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(req.Proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["typ"] != dpopProofType {
+			return nil, fmt.Errorf("typ must be %s", dpopProofType)
+		}
+		header, ok := token.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("missing jwk header")
+		}
+		// The header must carry the public key, never the private one
+		if _, private := header["d"]; private {
+			return nil, errors.New("jwk header contains a private key")
+		}
+		raw, err := json.Marshal(header)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &jwk); err != nil {
+			return nil, err
+		}
+		return jwkPublicKey(jwk)
+	}, jwt.WithValidMethods(domain.DPoPSigningAlgorithms), jwt.WithIssuedAt(), jwt.WithLeeway(dpopClockSkew), jwt.WithTimeFunc(v.now))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidDPoPProof, err)
+	}
+
+	jti := authn.StringClaim(claims, "jti")
+	if jti == "" {
+		return "", fmt.Errorf("%w: missing jti", ErrInvalidDPoPProof)
+	}
+	if authn.StringClaim(claims, "htm") != req.Method {
+		return "", fmt.Errorf("%w: htm doesn't match the request method", ErrInvalidDPoPProof)
+	}
+	requestURL := req.URL
+	if v.publicURL != "" {
+		requestURL = v.publicURL + req.Path
+	}
+	if !sameHTU(authn.StringClaim(claims, "htu"), requestURL) {
+		return "", fmt.Errorf("%w: htu doesn't match the request URL", ErrInvalidDPoPProof)
+	}
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil {
+		return "", fmt.Errorf("%w: missing iat", ErrInvalidDPoPProof)
+	}
+	expiresAt := iat.Add(dpopProofMaxAge)
+	if v.now().After(expiresAt) {
+		return "", fmt.Errorf("%w: proof is too old", ErrInvalidDPoPProof)
+	}
+	if req.AccessToken != "" && authn.StringClaim(claims, "ath") != accessTokenHash(req.AccessToken) {
+		return "", fmt.Errorf("%w: ath doesn't match the access token", ErrInvalidDPoPProof)
+	}
+
+	thumbprint, err := jwkThumbprint(jwk)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidDPoPProof, err)
+	}
+	if !v.remember(thumbprint+":"+jti, expiresAt) {
+		return "", fmt.Errorf("%w: proof was already used", ErrInvalidDPoPProof)
+	}
+	return thumbprint, nil
+}
+
+// remember records a proof and reports false if it was seen before
+func (v *dpopVerifier) remember(key string, expiresAt time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	if now.After(v.nextPrune) {
+		for seenKey, seenUntil := range v.seen {
+			if now.After(seenUntil) {
+				delete(v.seen, seenKey)
+			}
+		}
+		v.nextPrune = now.Add(time.Minute)
+	}
+
+	if _, ok := v.seen[key]; ok {
+		return false
+	}
+	v.seen[key] = expiresAt
+	return true
+}
+
+// sameHTU compares the htu claim with the request URL, ignoring the query,
+// the fragment and the case of the scheme and host (RFC 9449 section 4.3)
+func sameHTU(htu, requestURL string) bool {
+	if i := strings.IndexAny(htu, "?#"); i >= 0 {
+		htu = htu[:i]
+	}
+	return normalizeHTU(htu) == normalizeHTU(requestURL)
+}
+
+func normalizeHTU(u string) string {
+	scheme, rest, ok := strings.Cut(u, "://")
+	if !ok {
+		return u
+	}
+	host, path, _ := strings.Cut(rest, "/")
+	return strings.ToLower(scheme) + "://" + strings.ToLower(host) + "/" + path
+}
+
+// jwkThumbprint returns the base64url SHA-256 JWK thumbprint (RFC 7638) of a
+// public key, the value of the jkt confirmation claim
+func jwkThumbprint(k domain.JSONWebKey) (string, error) {
+	// The required members in lexicographic order, without whitespace
+	var members string
+	switch k.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	default:
+		return "", fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// accessTokenHash returns the ath claim of a proof presented with the access token
+func accessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
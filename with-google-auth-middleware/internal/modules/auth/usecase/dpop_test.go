@@ -0,0 +1,250 @@
+package usecase
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// dpopTestKey is the key pair of a test client
+type dpopTestKey struct {
+	private *ecdsa.PrivateKey
+	jwk     map[string]interface{}
+}
+
+func newDPoPTestKey(t *testing.T) *dpopTestKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &dpopTestKey{
+		private: private,
+		jwk: map[string]interface{}{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(private.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(private.Y.FillBytes(make([]byte, 32))),
+		},
+	}
+}
+
+func (k *dpopTestKey) thumbprint(t *testing.T) string {
+	t.Helper()
+	thumbprint, err := jwkThumbprint(domain.JSONWebKey{Kty: "EC", Crv: "P-256", X: k.jwk["x"].(string), Y: k.jwk["y"].(string)})
+	require.NoError(t, err)
+	return thumbprint
+}
+
+// proof signs a DPoP proof, claims overwrite the defaults
+func (k *dpopTestKey) proof(t *testing.T, method, url string, claims jwt.MapClaims) string {
+	t.Helper()
+	proofClaims := jwt.MapClaims{
+		"jti": uuid.NewString(),
+		"htm": method,
+		"htu": url,
+		"iat": time.Now().Unix(),
+	}
+	for name, value := range claims {
+		proofClaims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, proofClaims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = k.jwk
+	proof, err := token.SignedString(k.private)
+	require.NoError(t, err)
+	return proof
+}
+
+const testRefreshURL = "https://api.example.com/v1/auth/refresh"
+
+func TestDPoPVerifier(t *testing.T) {
+	key := newDPoPTestKey(t)
+	verify := func(proof string, accessToken string) (string, error) {
+		return NewDPoPVerifier("", "").VerifyProof(domain.DPoPProofRequest{
+			Proof:       proof,
+			Method:      "POST",
+			URL:         testRefreshURL,
+			AccessToken: accessToken,
+		})
+	}
+
+	t.Run("valid proof", func(t *testing.T) {
+		thumbprint, err := verify(key.proof(t, "POST", testRefreshURL, nil), "")
+		require.NoError(t, err)
+		assert.Equal(t, key.thumbprint(t), thumbprint)
+	})
+
+	t.Run("htu ignores query and case of the host", func(t *testing.T) {
+		_, err := verify(key.proof(t, "POST", "https://API.example.com/v1/auth/refresh?x=1", nil), "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("replayed proof", func(t *testing.T) {
+		verifier := NewDPoPVerifier("", "")
+		req := domain.DPoPProofRequest{Proof: key.proof(t, "POST", testRefreshURL, nil), Method: "POST", URL: testRefreshURL}
+		_, err := verifier.VerifyProof(req)
+		require.NoError(t, err)
+		_, err = verifier.VerifyProof(req)
+		assert.ErrorIs(t, err, ErrInvalidDPoPProof)
+	})
+
+	t.Run("access token hash", func(t *testing.T) {
+		proof := key.proof(t, "POST", testRefreshURL, jwt.MapClaims{"ath": accessTokenHash("access-token")})
+		_, err := verify(proof, "access-token")
+		assert.NoError(t, err)
+
+		proof = key.proof(t, "POST", testRefreshURL, jwt.MapClaims{"ath": accessTokenHash("access-token")})
+		_, err = verify(proof, "another-token")
+		assert.ErrorIs(t, err, ErrInvalidDPoPProof)
+	})
+
+	invalid := map[string]string{
+		"wrong method":   key.proof(t, "GET", testRefreshURL, nil),
+		"wrong URL":      key.proof(t, "POST", "https://api.example.com/v1/auth/google", nil),
+		"too old":        key.proof(t, "POST", testRefreshURL, jwt.MapClaims{"iat": time.Now().Add(-10 * time.Minute).Unix()}),
+		"in the future":  key.proof(t, "POST", testRefreshURL, jwt.MapClaims{"iat": time.Now().Add(10 * time.Minute).Unix()}),
+		"missing jti":    key.proof(t, "POST", testRefreshURL, jwt.MapClaims{"jti": ""}),
+		"not a JWT":      "proof",
+		"missing header": "",
+	}
+	for name, proof := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := verify(proof, "")
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("private key in the header", func(t *testing.T) {
+		leaky := *key
+		leaky.jwk = map[string]interface{}{"d": "secret"}
+		for name, value := range key.jwk {
+			leaky.jwk[name] = value
+		}
+		_, err := verify(leaky.proof(t, "POST", testRefreshURL, nil), "")
+		assert.ErrorIs(t, err, ErrInvalidDPoPProof)
+	})
+
+	t.Run("symmetric algorithm", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"jti": "1", "htm": "POST", "htu": testRefreshURL, "iat": time.Now().Unix()})
+		token.Header["typ"] = "dpop+jwt"
+		token.Header["jwk"] = key.jwk
+		proof, err := token.SignedString([]byte("secret"))
+		require.NoError(t, err)
+		_, err = verify(proof, "")
+		assert.ErrorIs(t, err, ErrInvalidDPoPProof)
+	})
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// Example of RFC 7638 section 3.1
+	thumbprint, err := jwkThumbprint(domain.JSONWebKey{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint)
+}
+
+func TestRefreshTokenWithDPoP(t *testing.T) {
+	ctx := context.Background()
+	authRepo := &fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{}}
+	user := &userdomain.User{ID: uuid.New(), Role: userdomain.RoleUser, Active: true}
+	newUsecase := func(mode string) domain.AuthUsecase {
+		return NewAuthUsecase(authRepo, &fakeUserRepository{users: map[uuid.UUID]*userdomain.User{user.ID: user}}, nil, nil, AuthUsecaseConfig{
+			JWTSecret:   "test-secret",
+			TokenConfig: TokenConfig{AccessTTL: 15 * time.Minute, RefreshTTL: time.Hour},
+			DPoP:        NewDPoPVerifier(mode, ""),
+		})
+	}
+	newSession := func(thumbprint string) *domain.Session {
+		session := &domain.Session{ID: uuid.New(), UserID: user.ID, RefreshToken: uuid.NewString(), ExpiresAt: time.Now().Add(time.Hour), DPoPKeyThumbprint: thumbprint}
+		require.NoError(t, authRepo.CreateSession(session))
+		return session
+	}
+	proofRequest := func(key *dpopTestKey) domain.DPoPProofRequest {
+		return domain.DPoPProofRequest{Proof: key.proof(t, "POST", testRefreshURL, nil), Method: "POST", URL: testRefreshURL}
+	}
+
+	key := newDPoPTestKey(t)
+	session := newSession(key.thumbprint(t))
+	u := newUsecase(domain.DPoPModeOptional)
+
+	t.Run("bound session", func(t *testing.T) {
+		token, err := u.RefreshToken(ctx, session.RefreshToken, proofRequest(key))
+		require.NoError(t, err)
+		assert.Equal(t, "DPoP", token.TokenType)
+
+		claims, err := parseAccessToken([]byte("test-secret"), token.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"jkt": key.thumbprint(t)}, claims["cnf"])
+	})
+
+	t.Run("bound session without proof", func(t *testing.T) {
+		_, err := u.RefreshToken(ctx, session.RefreshToken, domain.DPoPProofRequest{Method: "POST", URL: testRefreshURL})
+		assert.ErrorIs(t, err, ErrDPoPProofRequired)
+	})
+
+	t.Run("proof of another key", func(t *testing.T) {
+		_, err := u.RefreshToken(ctx, session.RefreshToken, proofRequest(newDPoPTestKey(t)))
+		assert.ErrorIs(t, err, ErrInvalidDPoPProof)
+	})
+
+	t.Run("bound session after DPoP was disabled", func(t *testing.T) {
+		_, err := newUsecase(domain.DPoPModeDisabled).RefreshToken(ctx, session.RefreshToken, domain.DPoPProofRequest{})
+		assert.ErrorIs(t, err, ErrDPoPProofRequired)
+	})
+
+	t.Run("bearer session", func(t *testing.T) {
+		bearer := newSession("")
+		token, err := u.RefreshToken(ctx, bearer.RefreshToken, domain.DPoPProofRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, "Bearer", token.TokenType)
+
+		_, err = newUsecase(domain.DPoPModeRequired).RefreshToken(ctx, bearer.RefreshToken, domain.DPoPProofRequest{})
+		assert.ErrorIs(t, err, ErrDPoPProofRequired)
+	})
+}
+
+func TestNewDPoPProofRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "http://10.0.0.1:8080/v1/auth/refresh?refresh_token=x", nil)
+	// Forwarded headers are set by any client, they don't change the URL
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "api.example.com")
+	r.Header.Set("DPoP", "proof")
+	req := domain.NewDPoPProofRequest(r, "")
+	assert.Equal(t, domain.DPoPProofRequest{
+		Proof:  "proof",
+		Method: "POST",
+		URL:    "http://10.0.0.1:8080/v1/auth/refresh",
+		Path:   "/v1/auth/refresh",
+	}, req)
+}
+
+func TestDPoPVerifierPublicURL(t *testing.T) {
+	key := newDPoPTestKey(t)
+	verifier := NewDPoPVerifier("", "https://api.example.com/")
+	// Received from the proxy at an internal address
+	r := httptest.NewRequest(http.MethodPost, "http://10.0.0.1:8080/v1/auth/refresh", nil)
+
+	r.Header.Set("DPoP", key.proof(t, "POST", testRefreshURL, nil))
+	_, err := verifier.VerifyProof(domain.NewDPoPProofRequest(r, ""))
+	assert.NoError(t, err)
+
+	// A proof for another host doesn't match the public URL
+	r.Header.Set("DPoP", key.proof(t, "POST", "https://evil.example.com/v1/auth/refresh", nil))
+	_, err = verifier.VerifyProof(domain.NewDPoPProofRequest(r, ""))
+	assert.ErrorIs(t, err, ErrInvalidDPoPProof)
+}
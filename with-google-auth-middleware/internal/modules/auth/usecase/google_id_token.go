@@ -0,0 +1,155 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// googleCertsURL is the JWKS Google signs its ID tokens with
+const googleCertsURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// googleIssuers are the valid values of the iss claim of a Google ID token
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// ClientConfig describes an app (web, iOS, Android, ...) whose Google ID tokens are accepted
+type ClientConfig struct {
+	// Name identifies the platform and is recorded on sessions, e.g. "ios"
+	Name string
+	// GoogleClientID is the OAuth client ID of the app, the aud or azp of its ID tokens
+	GoogleClientID string
+	// AppleClientID is the bundle ID (native apps) or services ID (web) used with Sign in with Apple
+	AppleClientID string
+	// TokenConfig overrides the default token TTLs, zero values use the defaults
+	TokenConfig TokenConfig
+}
+
+// googleIDTokenClaims are the claims of a Google ID token
+type googleIDTokenClaims struct {
+	jwt.RegisteredClaims
+	AuthorizedParty string `json:"azp"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+	Name            string `json:"name"`
+	GivenName       string `json:"given_name"`
+	FamilyName      string `json:"family_name"`
+	Picture         string `json:"picture"`
+	Locale          string `json:"locale"`
+	HostedDomain    string `json:"hd"`
+}
+
+// verifyGoogleIDToken checks the signature, issuer, expiry and audience of a
+// Google ID token and returns its user info and the client it was issued to
+func (u *authUsecase) verifyGoogleIDToken(ctx context.Context, idToken string) (*domain.GoogleUserInfo, *ClientConfig, error) {
+	var claims googleIDTokenClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, u.googleKeys.Keyfunc(ctx),
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if !containsString(googleIssuers, claims.Issuer) {
+		return nil, nil, fmt.Errorf("invalid ID token issuer %q", claims.Issuer)
+	}
+
+	// The audience must be one of our apps. Android and iOS apps that request
+	// a token for the backend get the web client as aud and themselves as azp,
+	// so azp decides which platform the session belongs to.
+	var audClient *ClientConfig
+	for _, aud := range claims.Audience {
+		if client := u.clientByGoogleID(aud); client != nil {
+			audClient = client
+			break
+		}
+	}
+	if audClient == nil {
+		return nil, nil, fmt.Errorf("invalid ID token audience %v", claims.Audience)
+	}
+	client := audClient
+	if azpClient := u.clientByGoogleID(claims.AuthorizedParty); azpClient != nil {
+		client = azpClient
+	}
+
+	return &domain.GoogleUserInfo{
+		ID:            claims.Subject,
+		Email:         claims.Email,
+		VerifiedEmail: claims.EmailVerified,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+		Picture:       claims.Picture,
+		Locale:        claims.Locale,
+		HostedDomain:  claims.HostedDomain,
+	}, client, nil
+}
+
+// googleIdentity converts verified Google user info into an external identity
+func googleIdentity(info *domain.GoogleUserInfo) *domain.ExternalIdentity {
+	return &domain.ExternalIdentity{
+		Provider:      domain.ProviderGoogle,
+		Subject:       info.ID,
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+		HostedDomain:  info.HostedDomain,
+		Profile: map[string]string{
+			userdomain.ProfileFieldName:       info.Name,
+			userdomain.ProfileFieldGivenName:  info.GivenName,
+			userdomain.ProfileFieldFamilyName: info.FamilyName,
+			userdomain.ProfileFieldAvatarURL:  info.Picture,
+			userdomain.ProfileFieldLocale:     info.Locale,
+		},
+	}
+}
+
+func (u *authUsecase) clientByGoogleID(googleClientID string) *ClientConfig {
+	if googleClientID == "" {
+		return nil
+	}
+	for i := range u.clients {
+		if u.clients[i].GoogleClientID == googleClientID {
+			return &u.clients[i]
+		}
+	}
+	return nil
+}
+
+// clientByName returns the client a session was created for, or nil for
+// sessions created before clients were recorded
+func (u *authUsecase) clientByName(name string) *ClientConfig {
+	for i := range u.clients {
+		if u.clients[i].Name == name {
+			return &u.clients[i]
+		}
+	}
+	return nil
+}
+
+// tokenConfig returns the token TTLs for a client, falling back to the defaults
+func (u *authUsecase) tokenConfig(client *ClientConfig) TokenConfig {
+	cfg := TokenConfig{AccessTTL: u.accessTTL, RefreshTTL: u.refreshTTL}
+	if client == nil {
+		return cfg
+	}
+	if client.TokenConfig.AccessTTL > 0 {
+		cfg.AccessTTL = client.TokenConfig.AccessTTL
+	}
+	if client.TokenConfig.RefreshTTL > 0 {
+		cfg.RefreshTTL = client.TokenConfig.RefreshTTL
+	}
+	return cfg
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
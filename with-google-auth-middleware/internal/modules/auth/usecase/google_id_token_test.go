@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestJWKSServer serves the public part of key as a JWKS with the given kid
+func newTestJWKSServer(t *testing.T, kid string, key *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVerifyGoogleIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server := newTestJWKSServer(t, "test-key", key)
+
+	u := &authUsecase{
+		clients: []ClientConfig{
+			{Name: "web", GoogleClientID: "web-client"},
+			{Name: "android", GoogleClientID: "android-client"},
+		},
+		googleKeys: newJWKSCache(server.URL, nil),
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":            "https://accounts.google.com",
+			"sub":            "1234567890",
+			"aud":            "web-client",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"email":          "alice@example.com",
+			"email_verified": true,
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	t.Run("web client", func(t *testing.T) {
+		info, client, err := u.verifyGoogleIDToken(context.Background(), sign(claims(nil)))
+		require.NoError(t, err)
+		assert.Equal(t, "alice@example.com", info.Email)
+		assert.Equal(t, "web", client.Name)
+	})
+
+	t.Run("authorized party decides the platform", func(t *testing.T) {
+		_, client, err := u.verifyGoogleIDToken(context.Background(), sign(claims(jwt.MapClaims{"azp": "android-client"})))
+		require.NoError(t, err)
+		assert.Equal(t, "android", client.Name)
+	})
+
+	t.Run("unknown audience", func(t *testing.T) {
+		_, _, err := u.verifyGoogleIDToken(context.Background(), sign(claims(jwt.MapClaims{"aud": "someone-else"})))
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		_, _, err := u.verifyGoogleIDToken(context.Background(), sign(claims(jwt.MapClaims{"iss": "https://evil.example.com"})))
+		assert.Error(t, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		_, _, err := u.verifyGoogleIDToken(context.Background(), sign(claims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})))
+		assert.Error(t, err)
+	})
+}
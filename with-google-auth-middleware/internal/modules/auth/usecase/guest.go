@@ -0,0 +1,174 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/gorm"
+)
+
+// Custom errors
+var (
+	ErrGuestsDisabled    = errors.New("guest accounts are disabled")
+	ErrInvalidDeviceID   = errors.New("invalid device ID")
+	ErrInvalidGuestToken = errors.New("invalid guest token")
+)
+
+// maxDeviceIDLength is the size of the sessions.device_id column
+const maxDeviceIDLength = 255
+
+// guestSession is the guest a device used before signing in, and its session
+type guestSession struct {
+	user    *userdomain.User
+	session *domain.Session
+}
+
+// LoginAsGuest creates an anonymous user for the device and starts a session
+// that ends when the guest expires. Guests are a way to register, so they are
+// only available when anyone may register. Expired guests are deleted here,
+// there's no other job that would.
+func (u *authUsecase) LoginAsGuest(ctx context.Context, deviceID string, dpop domain.DPoPProofRequest) (*domain.AuthToken, error) {
+	if u.guestTTL <= 0 {
+		return nil, ErrGuestsDisabled
+	}
+	switch u.provisioningMode {
+	case domain.ProvisioningClosed:
+		return nil, ErrRegistrationClosed
+	case domain.ProvisioningInviteOnly:
+		return nil, ErrInvitationRequired
+	}
+	if deviceID == "" || len(deviceID) > maxDeviceIDLength {
+		return nil, ErrInvalidDeviceID
+	}
+	dpopKey, err := u.dpopKey(dpop)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := u.userRepo.DeleteExpiredGuests(now); err != nil {
+		return nil, fmt.Errorf("failed to delete expired guests: %w", err)
+	}
+
+	expiresAt := now.Add(u.guestTTL)
+	user := &userdomain.User{
+		ID:             uuid.New(),
+		Role:           userdomain.RoleUser,
+		Active:         true,
+		Guest:          true,
+		GuestExpiresAt: &expiresAt,
+		LastLoginAt:    &now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := u.userRepo.Create(user); err != nil {
+		return nil, fmt.Errorf("failed to create guest: %w", err)
+	}
+
+	// Guests haven't authenticated, the session has no acr and amr for step-up
+	tokenCfg := u.tokenConfig(nil)
+	session := &domain.Session{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		ExpiresAt: now.Add(tokenCfg.RefreshTTL),
+		CreatedAt: now,
+		UpdatedAt: now,
+		DeviceID:  deviceID,
+		AuthTime:  now,
+
+		DPoPKeyThumbprint: dpopKey,
+	}
+	if expiresAt.Before(session.ExpiresAt) {
+		session.ExpiresAt = expiresAt
+	}
+	return u.startSession(ctx, user, session, tokenCfg, domain.ProviderGuest)
+}
+
+// findGuest returns the guest of the credentials a device signs in with, nil
+// without credentials. The refresh token must be of a guest session created
+// for the same device.
+func (u *authUsecase) findGuest(creds domain.GuestCredentials) (*guestSession, error) {
+	if creds.RefreshToken == "" {
+		return nil, nil
+	}
+
+	session, err := u.authRepo.GetSessionByRefreshToken(creds.RefreshToken)
+	if err != nil {
+		return nil, ErrInvalidGuestToken
+	}
+	if session.DeviceID == "" || session.DeviceID != creds.DeviceID {
+		return nil, fmt.Errorf("%w: session of another device", ErrInvalidGuestToken)
+	}
+
+	user, err := u.userRepo.FindByID(session.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if user == nil || !user.Guest || user.GuestExpired(time.Now()) {
+		return nil, ErrInvalidGuestToken
+	}
+	return &guestSession{user: user, session: session}, nil
+}
+
+// mergeGuest merges the guest into the existing user signing in. The user
+// repository moves the guest's rows and tombstones it in one transaction, the
+// tombstone is deleted with the other expired guests.
+func (u *authUsecase) mergeGuest(guest *guestSession, user *userdomain.User) error {
+	// Don't hand a guest's data to an account that can't sign in
+	if err := checkAccount(user); err != nil {
+		return err
+	}
+	err := u.userRepo.Merge(&userdomain.UserMerge{
+		ID:        uuid.New(),
+		SourceID:  guest.user.ID,
+		TargetID:  user.ID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to merge guest: %w", err)
+	}
+	if err := u.authRepo.DeleteSession(guest.session.ID); err != nil {
+		return fmt.Errorf("failed to delete guest session: %w", err)
+	}
+	return nil
+}
+
+// upgradeGuest turns the guest into the user of an account signing in for the
+// first time, keeping its ID and data. The user, the identity link and the
+// end of the guest session are stored in one transaction, together with the
+// acceptance of a pending invitation, so a failed upgrade leaves the guest
+// as it was.
+func (u *authUsecase) upgradeGuest(guest *guestSession, linked *domain.Identity, identity *domain.ExternalIdentity) (*userdomain.User, error) {
+	invitation, err := u.provisioningInvitation(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	user := guest.user
+	u.registerUser(user, identity.Email, identity.Profile, invitation)
+	upgrade := &domain.GuestUpgrade{
+		User:           user,
+		Identity:       newIdentity(user, identity),
+		GuestSessionID: guest.session.ID,
+	}
+	if linked != nil {
+		// The previously linked user no longer exists
+		upgrade.StaleIdentityID = &linked.ID
+	}
+	if invitation != nil {
+		upgrade.InvitationID = &invitation.ID
+	}
+	if err := u.authRepo.UpgradeGuest(upgrade); err != nil {
+		// Another login consumed the invitation first
+		if invitation != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvitationRequired
+		}
+		return nil, fmt.Errorf("failed to upgrade guest: %w", err)
+	}
+	return user, nil
+}
@@ -0,0 +1,319 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
+	"gorm.io/gorm"
+)
+
+// guestUserRepository keeps users in memory and records merges
+type guestUserRepository struct {
+	fakeUserRepository
+	merges [][2]uuid.UUID
+}
+
+func (r *guestUserRepository) Create(user *userdomain.User) error {
+	r.users[user.ID] = user
+	return nil
+}
+
+func (r *guestUserRepository) Update(user *userdomain.User) error {
+	r.users[user.ID] = user
+	return nil
+}
+
+func (r *guestUserRepository) FindByEmail(email string) (*userdomain.User, error) {
+	for _, user := range r.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *guestUserRepository) Merge(merge *userdomain.UserMerge) error {
+	r.merges = append(r.merges, [2]uuid.UUID{merge.SourceID, merge.TargetID})
+	delete(r.users, merge.SourceID)
+	return nil
+}
+
+func (r *guestUserRepository) DeleteExpiredGuests(now time.Time) error {
+	for id, user := range r.users {
+		if user.GuestExpired(now) {
+			delete(r.users, id)
+		}
+	}
+	return nil
+}
+
+// fakeIdentityRepository keeps identities in memory
+type fakeIdentityRepository struct {
+	domain.IdentityRepository
+	identities []*domain.Identity
+}
+
+func (r *fakeIdentityRepository) Create(identity *domain.Identity) error {
+	r.identities = append(r.identities, identity)
+	return nil
+}
+
+func (r *fakeIdentityRepository) Update(identity *domain.Identity) error {
+	return nil
+}
+
+func (r *fakeIdentityRepository) FindByProviderSubject(provider, subject string) (*domain.Identity, error) {
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// guestAuthRepository stores guest upgrades in the user and identity
+// repositories, or fails them with upgradeErr like a rolled back transaction
+type guestAuthRepository struct {
+	*fakeAuthRepository
+	userRepo     *guestUserRepository
+	identityRepo *fakeIdentityRepository
+	upgradeErr   error
+}
+
+func (r *guestAuthRepository) UpgradeGuest(upgrade *domain.GuestUpgrade) error {
+	if r.upgradeErr != nil {
+		return r.upgradeErr
+	}
+	r.userRepo.users[upgrade.User.ID] = upgrade.User
+	r.identityRepo.identities = append(r.identityRepo.identities, upgrade.Identity)
+	delete(r.sessions, upgrade.GuestSessionID)
+	return nil
+}
+
+// noInvitations is an invitation repository without pending invitations
+type noInvitations struct {
+	domain.InvitationRepository
+}
+
+func (noInvitations) FindPendingByEmail(email string) (*domain.Invitation, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+type testGuests struct {
+	*authUsecase
+	authRepo     *guestAuthRepository
+	userRepo     *guestUserRepository
+	identityRepo *fakeIdentityRepository
+}
+
+func newTestGuests(t *testing.T, mode string) *testGuests {
+	t.Helper()
+	userRepo := &guestUserRepository{fakeUserRepository: fakeUserRepository{users: map[uuid.UUID]*userdomain.User{}}}
+	identityRepo := &fakeIdentityRepository{}
+	authRepo := &guestAuthRepository{
+		fakeAuthRepository: &fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{}},
+		userRepo:           userRepo,
+		identityRepo:       identityRepo,
+	}
+	u := NewAuthUsecase(authRepo, userRepo, noInvitations{}, identityRepo, AuthUsecaseConfig{
+		JWTSecret:        "test-secret",
+		TokenConfig:      TokenConfig{AccessTTL: 15 * time.Minute, RefreshTTL: 24 * time.Hour},
+		ProvisioningMode: mode,
+		GuestTTL:         time.Hour,
+	}).(*authUsecase)
+	return &testGuests{authUsecase: u, authRepo: authRepo, userRepo: userRepo, identityRepo: identityRepo}
+}
+
+// loginWithGoogle signs in as a Google account, with the guest credentials if any
+func (g *testGuests) loginWithGoogle(email string, creds domain.GuestCredentials) (*domain.AuthToken, error) {
+	guest, err := g.findGuest(creds)
+	if err != nil {
+		return nil, err
+	}
+	return g.loginWithIdentity(context.Background(), &domain.ExternalIdentity{
+		Provider:      domain.ProviderGoogle,
+		Subject:       "google-" + email,
+		Email:         email,
+		EmailVerified: true,
+	}, nil, "", guest)
+}
+
+func (g *testGuests) subject(t *testing.T, token *domain.AuthToken) uuid.UUID {
+	t.Helper()
+	claims, err := parseAccessToken(g.jwtSecret, token.AccessToken)
+	require.NoError(t, err)
+	return uuid.MustParse(authn.StringClaim(claims, "sub"))
+}
+
+func TestLoginAsGuest(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("creates a guest session for the device", func(t *testing.T) {
+		g := newTestGuests(t, domain.ProvisioningOpen)
+		token, err := g.LoginAsGuest(ctx, "device-1", domain.DPoPProofRequest{})
+		require.NoError(t, err)
+
+		claims, err := parseAccessToken(g.jwtSecret, token.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, true, claims["guest"])
+		assert.Nil(t, claims["acr"])
+
+		user := g.userRepo.users[g.subject(t, token)]
+		require.NotNil(t, user)
+		assert.True(t, user.Guest)
+		require.NotNil(t, user.GuestExpiresAt)
+
+		session, err := g.authRepo.GetSessionByRefreshToken(token.RefreshToken)
+		require.NoError(t, err)
+		assert.Equal(t, "device-1", session.DeviceID)
+		// The session ends with the guest, before the refresh TTL
+		assert.Equal(t, *user.GuestExpiresAt, session.ExpiresAt)
+	})
+
+	t.Run("deletes expired guests", func(t *testing.T) {
+		g := newTestGuests(t, domain.ProvisioningOpen)
+		expired := time.Now().Add(-time.Minute)
+		old := &userdomain.User{ID: uuid.New(), Active: true, Guest: true, GuestExpiresAt: &expired}
+		g.userRepo.users[old.ID] = old
+
+		_, err := g.LoginAsGuest(ctx, "device-1", domain.DPoPProofRequest{})
+		require.NoError(t, err)
+		assert.NotContains(t, g.userRepo.users, old.ID)
+		assert.Len(t, g.userRepo.users, 1)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		g := newTestGuests(t, domain.ProvisioningOpen)
+		_, err := g.LoginAsGuest(ctx, "", domain.DPoPProofRequest{})
+		assert.ErrorIs(t, err, ErrInvalidDeviceID)
+
+		g.guestTTL = 0
+		_, err = g.LoginAsGuest(ctx, "device-1", domain.DPoPProofRequest{})
+		assert.ErrorIs(t, err, ErrGuestsDisabled)
+
+		g = newTestGuests(t, domain.ProvisioningInviteOnly)
+		_, err = g.LoginAsGuest(ctx, "device-1", domain.DPoPProofRequest{})
+		assert.ErrorIs(t, err, ErrInvitationRequired)
+		assert.Empty(t, g.userRepo.users)
+	})
+}
+
+func TestGuestSignsInWithGoogle(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("new account upgrades the guest", func(t *testing.T) {
+		g := newTestGuests(t, domain.ProvisioningOpen)
+		guestToken, err := g.LoginAsGuest(ctx, "device-1", domain.DPoPProofRequest{})
+		require.NoError(t, err)
+		guestID := g.subject(t, guestToken)
+
+		token, err := g.loginWithGoogle("alice@example.com", domain.GuestCredentials{
+			RefreshToken: guestToken.RefreshToken,
+			DeviceID:     "device-1",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, guestID, g.subject(t, token), "the guest keeps its ID")
+		assert.Empty(t, g.userRepo.merges)
+
+		user := g.userRepo.users[guestID]
+		assert.False(t, user.Guest)
+		assert.Nil(t, user.GuestExpiresAt)
+		assert.Equal(t, "alice@example.com", user.Email)
+		assert.Len(t, g.userRepo.users, 1)
+
+		claims, err := parseAccessToken(g.jwtSecret, token.AccessToken)
+		require.NoError(t, err)
+		assert.Nil(t, claims["guest"])
+
+		_, err = g.authRepo.GetSessionByRefreshToken(guestToken.RefreshToken)
+		assert.Error(t, err, "the guest session ends")
+		require.Len(t, g.identityRepo.identities, 1)
+		assert.Equal(t, guestID, g.identityRepo.identities[0].UserID)
+	})
+
+	t.Run("failed upgrade keeps the guest session", func(t *testing.T) {
+		g := newTestGuests(t, domain.ProvisioningOpen)
+		guestToken, err := g.LoginAsGuest(ctx, "device-1", domain.DPoPProofRequest{})
+		require.NoError(t, err)
+		g.authRepo.upgradeErr = errors.New("connection reset")
+
+		_, err = g.loginWithGoogle("alice@example.com", domain.GuestCredentials{
+			RefreshToken: guestToken.RefreshToken,
+			DeviceID:     "device-1",
+		})
+		assert.Error(t, err)
+		assert.Empty(t, g.identityRepo.identities)
+		_, err = g.authRepo.GetSessionByRefreshToken(guestToken.RefreshToken)
+		assert.NoError(t, err, "the guest can try again")
+	})
+
+	t.Run("existing account merges the guest", func(t *testing.T) {
+		g := newTestGuests(t, domain.ProvisioningOpen)
+		alice := &userdomain.User{ID: uuid.New(), Email: "alice@example.com", Role: userdomain.RoleUser, Active: true}
+		g.userRepo.users[alice.ID] = alice
+		guestToken, err := g.LoginAsGuest(ctx, "device-1", domain.DPoPProofRequest{})
+		require.NoError(t, err)
+		guestID := g.subject(t, guestToken)
+
+		token, err := g.loginWithGoogle("alice@example.com", domain.GuestCredentials{
+			RefreshToken: guestToken.RefreshToken,
+			DeviceID:     "device-1",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, alice.ID, g.subject(t, token))
+		assert.Equal(t, [][2]uuid.UUID{{guestID, alice.ID}}, g.userRepo.merges)
+		assert.NotContains(t, g.userRepo.users, guestID)
+	})
+
+	t.Run("suspended account doesn't merge the guest", func(t *testing.T) {
+		g := newTestGuests(t, domain.ProvisioningOpen)
+		until := time.Now().Add(time.Hour)
+		alice := &userdomain.User{
+			ID: uuid.New(), Email: "alice@example.com", Role: userdomain.RoleUser, Active: true,
+			Status: userdomain.StatusSuspended, SuspendedUntil: &until,
+		}
+		g.userRepo.users[alice.ID] = alice
+		guestToken, err := g.LoginAsGuest(ctx, "device-1", domain.DPoPProofRequest{})
+		require.NoError(t, err)
+
+		_, err = g.loginWithGoogle("alice@example.com", domain.GuestCredentials{
+			RefreshToken: guestToken.RefreshToken,
+			DeviceID:     "device-1",
+		})
+		assert.ErrorIs(t, err, ErrAccountSuspended)
+		assert.Empty(t, g.userRepo.merges)
+	})
+
+	t.Run("invalid guest credentials", func(t *testing.T) {
+		g := newTestGuests(t, domain.ProvisioningOpen)
+		guestToken, err := g.LoginAsGuest(ctx, "device-1", domain.DPoPProofRequest{})
+		require.NoError(t, err)
+		bob, err := g.loginWithGoogle("bob@example.com", domain.GuestCredentials{})
+		require.NoError(t, err)
+
+		for name, creds := range map[string]domain.GuestCredentials{
+			"another device":        {RefreshToken: guestToken.RefreshToken, DeviceID: "device-2"},
+			"unknown refresh token": {RefreshToken: "unknown", DeviceID: "device-1"},
+			"session of a user":     {RefreshToken: bob.RefreshToken, DeviceID: ""},
+		} {
+			_, err := g.loginWithGoogle("alice@example.com", creds)
+			assert.ErrorIs(t, err, ErrInvalidGuestToken, name)
+		}
+
+		expired := time.Now().Add(-time.Minute)
+		g.userRepo.users[g.subject(t, guestToken)].GuestExpiresAt = &expired
+		_, err = g.loginWithGoogle("alice@example.com", domain.GuestCredentials{
+			RefreshToken: guestToken.RefreshToken,
+			DeviceID:     "device-1",
+		})
+		assert.ErrorIs(t, err, ErrInvalidGuestToken, "expired guest")
+	})
+}
@@ -0,0 +1,85 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/gorm"
+)
+
+// Custom errors
+var (
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrInvitationNotFound = errors.New("invitation not found")
+)
+
+type invitationUsecase struct {
+	invitationRepo domain.InvitationRepository
+	ttl            time.Duration
+}
+
+// NewInvitationUsecase creates a new instance of InvitationUsecase.
+// Invitations expire after ttl.
+func NewInvitationUsecase(invitationRepo domain.InvitationRepository, ttl time.Duration) domain.InvitationUsecase {
+	return &invitationUsecase{
+		invitationRepo: invitationRepo,
+		ttl:            ttl,
+	}
+}
+
+func (u *invitationUsecase) CreateInvitation(ctx context.Context, invitedBy uuid.UUID, email, role string) (*domain.Invitation, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEmail, err)
+	}
+
+	if role == "" {
+		role = userdomain.RoleUser
+	}
+	if role != userdomain.RoleUser && role != userdomain.RoleAdmin {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRole, role)
+	}
+
+	now := time.Now()
+	invitation := &domain.Invitation{
+		ID:        uuid.New(),
+		Email:     strings.ToLower(address.Address),
+		Role:      role,
+		InvitedBy: &invitedBy,
+		ExpiresAt: now.Add(u.ttl),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := u.invitationRepo.Create(invitation); err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+	return invitation, nil
+}
+
+func (u *invitationUsecase) ListInvitations(ctx context.Context, page, limit int) ([]*domain.Invitation, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+	return u.invitationRepo.GetAll(page, limit)
+}
+
+func (u *invitationUsecase) RevokeInvitation(ctx context.Context, id uuid.UUID) error {
+	if err := u.invitationRepo.Revoke(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvitationNotFound
+		}
+		return fmt.Errorf("failed to revoke invitation: %w", err)
+	}
+	return nil
+}
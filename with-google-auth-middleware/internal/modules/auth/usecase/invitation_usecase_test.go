@@ -0,0 +1,53 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+func TestCreateInvitation(t *testing.T) {
+	repo := &fakeInvitationRepository{invitations: map[uuid.UUID]*domain.Invitation{}}
+	u := NewInvitationUsecase(repo, 24*time.Hour)
+	ctx := context.Background()
+	admin := uuid.New()
+
+	invitation, err := u.CreateInvitation(ctx, admin, "Alice <Alice@Example.com>", "")
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", invitation.Email)
+	assert.Equal(t, userdomain.RoleUser, invitation.Role)
+	assert.Equal(t, &admin, invitation.InvitedBy)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), invitation.ExpiresAt, time.Minute)
+	assert.True(t, invitation.IsPending())
+	assert.Contains(t, repo.invitations, invitation.ID)
+
+	invitation, err = u.CreateInvitation(ctx, admin, "bob@example.com", userdomain.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, userdomain.RoleAdmin, invitation.Role)
+
+	_, err = u.CreateInvitation(ctx, admin, "not an email", "")
+	assert.ErrorIs(t, err, ErrInvalidEmail)
+	_, err = u.CreateInvitation(ctx, admin, "carol@example.com", "owner")
+	assert.ErrorIs(t, err, ErrInvalidRole)
+	assert.Len(t, repo.invitations, 2)
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	repo := &fakeInvitationRepository{invitations: map[uuid.UUID]*domain.Invitation{}}
+	u := NewInvitationUsecase(repo, time.Hour)
+	ctx := context.Background()
+
+	invitation, err := u.CreateInvitation(ctx, uuid.New(), "alice@example.com", "")
+	require.NoError(t, err)
+	require.NoError(t, u.RevokeInvitation(ctx, invitation.ID))
+	assert.False(t, repo.invitations[invitation.ID].IsPending())
+
+	assert.ErrorIs(t, u.RevokeInvitation(ctx, invitation.ID), ErrInvitationNotFound)
+	assert.ErrorIs(t, u.RevokeInvitation(ctx, uuid.New()), ErrInvitationNotFound)
+}
@@ -0,0 +1,33 @@
+package usecase
+
+import (
+	"crypto"
+	"net/http"
+
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
+)
+
+// Custom errors
+var (
+	ErrUnknownSigningKey = authn.ErrUnknownSigningKey
+)
+
+// JSONWebKey is a single key of a JSON Web Key Set (RFC 7517)
+type JSONWebKey = domain.JSONWebKey
+
+// JSONWebKeySet is a JSON Web Key Set (RFC 7517)
+type JSONWebKeySet = domain.JSONWebKeySet
+
+// jwksCache fetches and caches the public keys an identity provider signs its
+// ID tokens with
+type jwksCache = authn.KeySet
+
+func newJWKSCache(url string, httpClient *http.Client) *jwksCache {
+	return authn.NewKeySet(url, httpClient)
+}
+
+// jwkPublicKey converts an RSA or EC JSON Web Key into a Go public key
+func jwkPublicKey(k JSONWebKey) (crypto.PublicKey, error) {
+	return authn.PublicKey(k)
+}
@@ -0,0 +1,234 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
+	"gorm.io/gorm"
+)
+
+// Custom errors
+var (
+	ErrInvalidClient = errors.New("invalid client credentials")
+)
+
+type oauthUsecase struct {
+	authRepo  domain.AuthRepository
+	jwtSecret []byte
+	events    *SecurityEventRecorder
+	accounts  domain.AccountStatusLookup
+}
+
+// NewOAuthUsecase creates the usecase behind the token introspection (RFC 7662)
+// and revocation (RFC 7009) endpoints. Revoked sessions are recorded as
+// security events of their users. accounts looks up the account status of
+// token holders like AuthMiddleware does, nil reports the tokens of every
+// account as active.
+func NewOAuthUsecase(authRepo domain.AuthRepository, jwtSecret string, events *SecurityEventRecorder, accounts domain.AccountStatusLookup) domain.OAuthUsecase {
+	return &oauthUsecase{
+		authRepo:  authRepo,
+		jwtSecret: []byte(jwtSecret),
+		events:    events,
+		accounts:  accounts,
+	}
+}
+
+func (u *oauthUsecase) AuthenticateClient(ctx context.Context, clientID, clientSecret string) (*domain.OAuthClient, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, ErrInvalidClient
+	}
+
+	client, err := u.authRepo.GetClientByClientID(clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, fmt.Errorf("failed to find client: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashClientSecret(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+func (u *oauthUsecase) Introspect(ctx context.Context, token, tokenTypeHint string) (*domain.TokenIntrospection, error) {
+	// The hint only decides which lookup runs first; RFC 7662 requires falling
+	// back to the other token types when the hinted one does not match.
+	lookups := []func(string) (*domain.TokenIntrospection, error){u.introspectAccessToken, u.introspectRefreshToken}
+	if tokenTypeHint == domain.TokenTypeHintRefreshToken {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		result, err := lookup(token)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			// Tokens of suspended, banned, pending and deactivated accounts
+			// are rejected by AuthMiddleware, so gateways must reject them too
+			active, err := u.accountActive(ctx, result.Sub)
+			if err != nil {
+				return nil, err
+			}
+			if !active {
+				break
+			}
+			return result, nil
+		}
+	}
+	return &domain.TokenIntrospection{Active: false}, nil
+}
+
+// accountActive reports whether the user of a token may still use it
+func (u *oauthUsecase) accountActive(ctx context.Context, sub string) (bool, error) {
+	if u.accounts == nil {
+		return true, nil
+	}
+	userID, err := uuid.Parse(sub)
+	if err != nil {
+		return false, nil
+	}
+	status, err := u.accounts.AccountStatus(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check account status: %w", err)
+	}
+	return status == userdomain.StatusActive, nil
+}
+
+func (u *oauthUsecase) Revoke(ctx context.Context, client *domain.OAuthClient, token, tokenTypeHint string) error {
+	if tokenTypeHint != domain.TokenTypeHintAccessToken {
+		session, err := u.authRepo.GetSessionByRefreshToken(token)
+		if err == nil {
+			if !canRevoke(client, session.Client) {
+				return nil
+			}
+			return u.revokeSession(ctx, client, session.UserID, session.ID)
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to find session: %w", err)
+		}
+	}
+
+	// Access tokens are revoked through the session they were issued for, which
+	// also revokes the refresh token and every other access token of that session.
+	claims, err := parseAccessToken(u.jwtSecret, token)
+	if err != nil {
+		// RFC 7009: invalid or unknown tokens are not an error for the caller
+		return nil
+	}
+	sessionID, err := uuid.Parse(authn.StringClaim(claims, "sid"))
+	if err != nil || !canRevoke(client, authn.StringClaim(claims, "client_id")) {
+		return nil
+	}
+	userID, err := uuid.Parse(authn.StringClaim(claims, "sub"))
+	if err != nil {
+		return nil
+	}
+	return u.revokeSession(ctx, client, userID, sessionID)
+}
+
+// canRevoke reports whether the client may revoke a token issued to tokenClient.
+// Like unknown tokens, tokens of other clients are silently ignored.
+func canRevoke(client *domain.OAuthClient, tokenClient string) bool {
+	return client.Internal || client.ClientID == tokenClient
+}
+
+// introspectAccessToken returns nil when the token is not an active access token
+func (u *oauthUsecase) introspectAccessToken(token string) (*domain.TokenIntrospection, error) {
+	claims, err := parseAccessToken(u.jwtSecret, token)
+	if err != nil {
+		return nil, nil
+	}
+
+	// Every access token we issue belongs to a session
+	sessionID, err := uuid.Parse(authn.StringClaim(claims, "sid"))
+	if err != nil {
+		return nil, nil
+	}
+	if _, err := u.authRepo.GetSessionByID(sessionID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find session: %w", err)
+	}
+
+	introspection := &domain.TokenIntrospection{
+		Active:    true,
+		Sub:       authn.StringClaim(claims, "sub"),
+		Exp:       int64Claim(claims, "exp"),
+		Iat:       int64Claim(claims, "iat"),
+		Scope:     authn.StringClaim(claims, "scope"),
+		ClientID:  authn.StringClaim(claims, "client_id"),
+		TokenType: domain.TokenTypeHintAccessToken,
+	}
+	// Services must then require a DPoP proof of this key with the token
+	if cnf, ok := claims["cnf"].(map[string]interface{}); ok {
+		if jkt, _ := cnf["jkt"].(string); jkt != "" {
+			introspection.Cnf = &domain.TokenConfirmation{JKT: jkt}
+		}
+	}
+	return introspection, nil
+}
+
+// introspectRefreshToken returns nil when the token is not an active refresh token
+func (u *oauthUsecase) introspectRefreshToken(token string) (*domain.TokenIntrospection, error) {
+	session, err := u.authRepo.GetSessionByRefreshToken(token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find session: %w", err)
+	}
+
+	introspection := &domain.TokenIntrospection{
+		Active:    true,
+		Sub:       session.UserID.String(),
+		Exp:       session.ExpiresAt.Unix(),
+		Iat:       session.CreatedAt.Unix(),
+		TokenType: domain.TokenTypeHintRefreshToken,
+	}
+	if session.DPoPKeyThumbprint != "" {
+		introspection.Cnf = &domain.TokenConfirmation{JKT: session.DPoPKeyThumbprint}
+	}
+	return introspection, nil
+}
+
+// revokeSession deletes the session and records its revocation by the client
+func (u *oauthUsecase) revokeSession(ctx context.Context, client *domain.OAuthClient, userID, sessionID uuid.UUID) error {
+	if err := u.authRepo.DeleteSession(sessionID); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	// The client's server called the endpoint, not the user's device
+	return u.events.Record(domain.WithRequestInfo(ctx, domain.RequestInfo{}), &domain.SecurityEvent{
+		UserID:    userID,
+		SessionID: &sessionID,
+		Type:      domain.SecurityEventSessionRevoked,
+		Outcome:   domain.SecurityOutcomeSuccess,
+		Reason:    "token_revoked",
+		Client:    client.ClientID,
+	})
+}
+
+// HashClientSecret returns the hex encoded SHA-256 digest stored in oauth_clients.secret_hash.
+// Client secrets are long random strings, so a fast hash is sufficient.
+func HashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// int64Claim returns a numeric claim or zero if it is missing
+func int64Claim(claims jwt.MapClaims, key string) int64 {
+	value, _ := claims[key].(float64)
+	return int64(value)
+}
@@ -0,0 +1,227 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+const testOAuthSecret = "test-secret"
+
+// accountStatuses maps users to their account status, users not in it are active
+type accountStatuses map[uuid.UUID]string
+
+func (s accountStatuses) AccountStatus(ctx context.Context, userID uuid.UUID) (string, error) {
+	if status, ok := s[userID]; ok {
+		return status, nil
+	}
+	return userdomain.StatusActive, nil
+}
+
+// newTestOAuthUsecase returns the usecase with a web app session, an internal
+// gateway client and a third-party client
+func newTestOAuthUsecase(t *testing.T) (*oauthUsecase, *fakeAuthRepository, *fakeSecurityEventRepository, *domain.Session) {
+	t.Helper()
+	repo := &fakeAuthRepository{
+		sessions: map[uuid.UUID]*domain.Session{},
+		clients: map[string]*domain.OAuthClient{
+			"gateway": {ClientID: "gateway", SecretHash: HashClientSecret("gateway-secret"), Internal: true},
+			"partner": {ClientID: "partner", SecretHash: HashClientSecret("partner-secret")},
+		},
+	}
+	session := &domain.Session{
+		ID:           uuid.New(),
+		UserID:       uuid.New(),
+		Client:       "web",
+		RefreshToken: "refresh-token",
+		ExpiresAt:    time.Now().Add(time.Hour),
+		CreatedAt:    time.Now(),
+	}
+	repo.sessions[session.ID] = session
+	events := &fakeSecurityEventRepository{}
+	u := NewOAuthUsecase(repo, testOAuthSecret, NewSecurityEventRecorder(events), accountStatuses{}).(*oauthUsecase)
+	return u, repo, events, session
+}
+
+func signTestAccessToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testOAuthSecret))
+	require.NoError(t, err)
+	return token
+}
+
+// sessionAccessToken returns an access token of the session
+func sessionAccessToken(t *testing.T, session *domain.Session) string {
+	return signTestAccessToken(t, jwt.MapClaims{
+		"sub":       session.UserID.String(),
+		"sid":       session.ID.String(),
+		"client_id": session.Client,
+		"scope":     "users:read",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+		"iat":       time.Now().Unix(),
+	})
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	u, _, _, _ := newTestOAuthUsecase(t)
+	ctx := context.Background()
+
+	client, err := u.AuthenticateClient(ctx, "gateway", "gateway-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "gateway", client.ClientID)
+
+	invalid := map[string][2]string{
+		"wrong secret":   {"gateway", "partner-secret"},
+		"unknown client": {"unknown", "gateway-secret"},
+		"no secret":      {"gateway", ""},
+		"no client":      {"", "gateway-secret"},
+	}
+	for name, credentials := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := u.AuthenticateClient(ctx, credentials[0], credentials[1])
+			assert.ErrorIs(t, err, ErrInvalidClient)
+		})
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	u, repo, _, session := newTestOAuthUsecase(t)
+	ctx := context.Background()
+	accessToken := sessionAccessToken(t, session)
+
+	t.Run("access token", func(t *testing.T) {
+		result, err := u.Introspect(ctx, accessToken, domain.TokenTypeHintAccessToken)
+		require.NoError(t, err)
+		assert.True(t, result.Active)
+		assert.Equal(t, domain.TokenTypeHintAccessToken, result.TokenType)
+		assert.Equal(t, session.UserID.String(), result.Sub)
+		assert.Equal(t, "web", result.ClientID)
+		assert.Equal(t, "users:read", result.Scope)
+	})
+
+	t.Run("refresh token", func(t *testing.T) {
+		result, err := u.Introspect(ctx, session.RefreshToken, domain.TokenTypeHintRefreshToken)
+		require.NoError(t, err)
+		assert.True(t, result.Active)
+		assert.Equal(t, domain.TokenTypeHintRefreshToken, result.TokenType)
+		assert.Equal(t, session.ExpiresAt.Unix(), result.Exp)
+	})
+
+	// RFC 7662: a wrong hint only changes the order of the lookups
+	t.Run("wrong hint", func(t *testing.T) {
+		result, err := u.Introspect(ctx, accessToken, domain.TokenTypeHintRefreshToken)
+		require.NoError(t, err)
+		assert.True(t, result.Active)
+		assert.Equal(t, domain.TokenTypeHintAccessToken, result.TokenType)
+
+		result, err = u.Introspect(ctx, session.RefreshToken, domain.TokenTypeHintAccessToken)
+		require.NoError(t, err)
+		assert.True(t, result.Active)
+		assert.Equal(t, domain.TokenTypeHintRefreshToken, result.TokenType)
+	})
+
+	t.Run("no hint", func(t *testing.T) {
+		result, err := u.Introspect(ctx, session.RefreshToken, "")
+		require.NoError(t, err)
+		assert.True(t, result.Active)
+	})
+
+	inactive := map[string]string{
+		"unknown token": "not-a-token",
+		"no session": signTestAccessToken(t, jwt.MapClaims{
+			"sub": session.UserID.String(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}),
+		"unknown session": signTestAccessToken(t, jwt.MapClaims{
+			"sub": session.UserID.String(),
+			"sid": uuid.NewString(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}),
+		"expired": signTestAccessToken(t, jwt.MapClaims{
+			"sub": session.UserID.String(),
+			"sid": session.ID.String(),
+			"exp": time.Now().Add(-time.Minute).Unix(),
+		}),
+	}
+	for name, token := range inactive {
+		t.Run(name, func(t *testing.T) {
+			result, err := u.Introspect(ctx, token, "")
+			require.NoError(t, err)
+			assert.False(t, result.Active)
+		})
+	}
+
+	t.Run("account status", func(t *testing.T) {
+		statuses := u.accounts.(accountStatuses)
+		defer delete(statuses, session.UserID)
+		for _, status := range []string{userdomain.StatusSuspended, userdomain.StatusBanned, userdomain.StatusPending, domain.AccountDeactivated} {
+			statuses[session.UserID] = status
+			result, err := u.Introspect(ctx, accessToken, domain.TokenTypeHintAccessToken)
+			require.NoError(t, err)
+			assert.False(t, result.Active, status)
+			result, err = u.Introspect(ctx, session.RefreshToken, domain.TokenTypeHintRefreshToken)
+			require.NoError(t, err)
+			assert.False(t, result.Active, status)
+		}
+	})
+
+	t.Run("revoked session", func(t *testing.T) {
+		delete(repo.sessions, session.ID)
+		result, err := u.Introspect(ctx, accessToken, domain.TokenTypeHintAccessToken)
+		require.NoError(t, err)
+		assert.False(t, result.Active)
+		result, err = u.Introspect(ctx, session.RefreshToken, domain.TokenTypeHintRefreshToken)
+		require.NoError(t, err)
+		assert.False(t, result.Active)
+	})
+}
+
+func TestRevoke(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("refresh token", func(t *testing.T) {
+		u, repo, events, session := newTestOAuthUsecase(t)
+		require.NoError(t, u.Revoke(ctx, repo.clients["gateway"], session.RefreshToken, domain.TokenTypeHintRefreshToken))
+		assert.NotContains(t, repo.sessions, session.ID)
+		require.Len(t, events.events, 1)
+		assert.Equal(t, domain.SecurityEventSessionRevoked, events.events[0].Type)
+		assert.Equal(t, "gateway", events.events[0].Client)
+		assert.Equal(t, session.UserID, events.events[0].UserID)
+	})
+
+	t.Run("access token with the wrong hint", func(t *testing.T) {
+		u, repo, _, session := newTestOAuthUsecase(t)
+		require.NoError(t, u.Revoke(ctx, repo.clients["gateway"], sessionAccessToken(t, session), domain.TokenTypeHintRefreshToken))
+		assert.NotContains(t, repo.sessions, session.ID)
+	})
+
+	t.Run("token of another client", func(t *testing.T) {
+		u, repo, events, session := newTestOAuthUsecase(t)
+		partner := repo.clients["partner"]
+		require.NoError(t, u.Revoke(ctx, partner, session.RefreshToken, domain.TokenTypeHintRefreshToken))
+		require.NoError(t, u.Revoke(ctx, partner, sessionAccessToken(t, session), domain.TokenTypeHintAccessToken))
+		assert.Contains(t, repo.sessions, session.ID)
+		assert.Empty(t, events.events)
+	})
+
+	t.Run("own token of a third-party client", func(t *testing.T) {
+		u, repo, _, session := newTestOAuthUsecase(t)
+		session.Client = "partner"
+		require.NoError(t, u.Revoke(ctx, repo.clients["partner"], session.RefreshToken, ""))
+		assert.NotContains(t, repo.sessions, session.ID)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		u, repo, events, session := newTestOAuthUsecase(t)
+		require.NoError(t, u.Revoke(ctx, repo.clients["gateway"], "not-a-token", ""))
+		assert.Contains(t, repo.sessions, session.ID)
+		assert.Empty(t, events.events)
+	})
+}
@@ -0,0 +1,463 @@
+package usecase
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// Custom errors
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrOIDCAuthFailed  = errors.New("failed to authenticate with identity provider")
+)
+
+// oidcDiscoveryPath is where an issuer publishes its provider metadata
+const oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+// oidcDiscoveryTTL is how long discovered provider metadata is used before it is fetched again
+const oidcDiscoveryTTL = 24 * time.Hour
+
+// oidcLoginTTL is how long a login started with an authorization URL may take
+const oidcLoginTTL = 10 * time.Minute
+
+// Claim mapping keys besides userdomain.ProfileFields
+const (
+	ClaimMappingSubject       = "subject"
+	ClaimMappingEmail         = "email"
+	ClaimMappingEmailVerified = "email_verified"
+)
+
+// defaultOIDCClaimMappings maps our user fields to standard OIDC claims
+var defaultOIDCClaimMappings = map[string]string{
+	ClaimMappingSubject:               "sub",
+	ClaimMappingEmail:                 "email",
+	ClaimMappingEmailVerified:         "email_verified",
+	userdomain.ProfileFieldName:       "name",
+	userdomain.ProfileFieldGivenName:  "given_name",
+	userdomain.ProfileFieldFamilyName: "family_name",
+	userdomain.ProfileFieldAvatarURL:  "picture",
+	userdomain.ProfileFieldLocale:     "locale",
+}
+
+// defaultOIDCScopes are requested when a provider doesn't configure its own
+var defaultOIDCScopes = []string{"openid", "email", "profile"}
+
+// OIDCProviderConfig configures an OpenID Connect identity provider such as a
+// Keycloak realm, an Okta org or an Azure AD tenant
+type OIDCProviderConfig struct {
+	// Name identifies the provider in URLs and linked identities, e.g. "okta"
+	Name string
+	// IssuerURL is the issuer identifier, its metadata is discovered at IssuerURL + /.well-known/openid-configuration
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// Scopes requested in the authorization URL, defaults to openid, email and profile
+	Scopes []string
+	// ClaimMappings overrides the claim a user field is read from, keyed by
+	// subject, email, email_verified or a userdomain.ProfileFields entry.
+	// Nested claims are addressed with dots, e.g. "attributes.mail".
+	ClaimMappings map[string]string
+}
+
+// oidcProviderMetadata is the subset of the discovery document we use
+type oidcProviderMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// oidcTokenResponse is the response of a provider's token endpoint
+type oidcTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+}
+
+// oidcProvider discovers a provider's endpoints and verifies its ID tokens
+type oidcProvider struct {
+	cfg        OIDCProviderConfig
+	httpClient *http.Client
+
+	mu           sync.Mutex
+	metadata     *oidcProviderMetadata
+	discoveredAt time.Time
+	keys         *jwksCache
+	discovering  chan struct{} // Closed when the running discovery is done
+	discoverErr  error         // Error of the last discovery
+}
+
+func newOIDCProvider(cfg OIDCProviderConfig, httpClient *http.Client) *oidcProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.IssuerURL = strings.TrimSuffix(cfg.IssuerURL, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = defaultOIDCScopes
+	}
+	mappings := make(map[string]string, len(defaultOIDCClaimMappings))
+	for field, claim := range defaultOIDCClaimMappings {
+		mappings[field] = claim
+	}
+	for field, claim := range cfg.ClaimMappings {
+		mappings[field] = claim
+	}
+	cfg.ClaimMappings = mappings
+	return &oidcProvider{cfg: cfg, httpClient: httpClient}
+}
+
+// discover returns the provider metadata, fetching it on first use and once a
+// day after that. The metadata is fetched without holding the lock, requests
+// arriving meanwhile wait for that fetch or use the expired metadata.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcProviderMetadata, *jwksCache, error) {
+	p.mu.Lock()
+	metadata, keys := p.metadata, p.keys
+	if metadata != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		p.mu.Unlock()
+		return metadata, keys, nil
+	}
+
+	done := p.discovering
+	if done == nil {
+		done = make(chan struct{})
+		p.discovering = done
+		p.mu.Unlock()
+
+		fetched, err := p.fetchMetadata(ctx)
+
+		p.mu.Lock()
+		if err == nil {
+			if p.keys == nil || p.metadata == nil || p.metadata.JWKSURI != fetched.JWKSURI {
+				p.keys = newJWKSCache(fetched.JWKSURI, p.httpClient)
+			}
+			p.metadata = fetched
+			p.discoveredAt = time.Now()
+		}
+		p.discoverErr = err
+		p.discovering = nil
+		close(done)
+		p.mu.Unlock()
+	} else {
+		p.mu.Unlock()
+		// Another request is discovering, the expired metadata is good until it's done
+		if metadata != nil {
+			return metadata, keys, nil
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discoverErr != nil {
+		return nil, nil, p.discoverErr
+	}
+	return p.metadata, p.keys, nil
+}
+
+// fetchMetadata downloads and checks the provider's discovery document
+func (p *oidcProvider) fetchMetadata(ctx context.Context) (*oidcProviderMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.IssuerURL+oidcDiscoveryPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery request: %w", err)
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch provider metadata: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch provider metadata: unexpected status %d", resp.StatusCode)
+	}
+
+	var metadata oidcProviderMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode provider metadata: %w", err)
+	}
+	// The issuer in the metadata must be the one we were configured with (OIDC Discovery 4.3)
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.cfg.IssuerURL {
+		return nil, fmt.Errorf("provider metadata issuer %q does not match %q", metadata.Issuer, p.cfg.IssuerURL)
+	}
+	if metadata.JWKSURI == "" {
+		return nil, errors.New("provider metadata has no jwks_uri")
+	}
+	return &metadata, nil
+}
+
+// authorizationURL builds the URL that starts the authorization code flow at the provider
+func (p *oidcProvider) authorizationURL(ctx context.Context, redirectURI, state, nonce, codeChallenge string) (string, error) {
+	metadata, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	if metadata.AuthorizationEndpoint == "" {
+		return "", errors.New("provider metadata has no authorization_endpoint")
+	}
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {redirectURI},
+		"scope":         {strings.Join(p.cfg.Scopes, " ")},
+		"nonce":         {nonce},
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	if codeChallenge != "" {
+		query.Set("code_challenge", codeChallenge)
+		query.Set("code_challenge_method", "S256")
+	}
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// exchangeCode redeems an authorization code and returns the ID token of the response
+func (p *oidcProvider) exchangeCode(ctx context.Context, code, redirectURI, codeVerifier string) (string, error) {
+	metadata, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	if metadata.TokenEndpoint == "" {
+		return "", errors.New("provider metadata has no token_endpoint")
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	}
+	if codeVerifier != "" {
+		form.Set("code_verifier", codeVerifier)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// client_secret_basic, the default client authentication method of OIDC
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokens oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+// verifyIDToken checks the signature, issuer, expiry, audience and nonce of an ID token and returns its claims
+func (p *oidcProvider) verifyIDToken(ctx context.Context, idToken, nonce string) (jwt.MapClaims, error) {
+	metadata, keys, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	algs := metadata.SigningAlgs
+	if len(algs) == 0 {
+		algs = []string{jwt.SigningMethodRS256.Alg()}
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, keys.Keyfunc(ctx),
+		jwt.WithValidMethods(algs),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	// With several audiences the token must have been issued to us (OIDC Core 3.1.3.7)
+	if audiences, _ := claims.GetAudience(); len(audiences) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.cfg.ClientID {
+			return nil, fmt.Errorf("invalid ID token authorized party %q", azp)
+		}
+	}
+	// The nonce binds the token to the login that requested it, so that a token
+	// issued to another session can't be replayed (OIDC Core 3.1.3.7)
+	if nonce == "" {
+		return nil, errors.New("nonce is required")
+	}
+	if claimed, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(claimed), []byte(nonce)) != 1 {
+		return nil, errors.New("invalid ID token nonce")
+	}
+	return claims, nil
+}
+
+// identity maps verified ID token claims to an external identity using the claim mappings
+func (p *oidcProvider) identity(claims jwt.MapClaims) (*domain.ExternalIdentity, error) {
+	subject := p.claim(claims, ClaimMappingSubject)
+	if subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	profile := make(map[string]string, len(userdomain.ProfileFields))
+	for _, field := range userdomain.ProfileFields {
+		profile[field] = p.claim(claims, field)
+	}
+	identity := &domain.ExternalIdentity{
+		Provider:      p.cfg.Name,
+		Subject:       subject,
+		Email:         p.claim(claims, ClaimMappingEmail),
+		EmailVerified: p.claim(claims, ClaimMappingEmailVerified) == "true",
+		Profile:       profile,
+	}
+	// How the user authenticated, if the provider says so (OIDC Core 2)
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		t := time.Unix(int64(authTime), 0)
+		identity.AuthTime = &t
+	}
+	if amr, ok := claims["amr"].([]interface{}); ok {
+		for _, method := range amr {
+			if method, ok := method.(string); ok {
+				identity.AMR = append(identity.AMR, method)
+			}
+		}
+	}
+	return identity, nil
+}
+
+// claim returns the claim mapped to field as a string, following dotted paths into nested objects
+func (p *oidcProvider) claim(claims jwt.MapClaims, field string) string {
+	name := p.cfg.ClaimMappings[field]
+	if name == "" {
+		return ""
+	}
+
+	var value interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[part]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case float64:
+		return fmt.Sprintf("%v", v)
+	}
+	return ""
+}
+
+func (u *authUsecase) oidcProvider(name string) (*oidcProvider, error) {
+	provider, ok := u.oidcProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+func (u *authUsecase) OIDCAuthorizationURL(ctx context.Context, providerName, redirectURI, state, codeChallenge string) (string, error) {
+	provider, err := u.oidcProvider(providerName)
+	if err != nil {
+		return "", err
+	}
+
+	// The nonce is generated here and stored with the state, so the client
+	// can't choose the nonce an ID token is accepted with
+	nonce, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	authURL, err := provider.authorizationURL(ctx, redirectURI, state, nonce, codeChallenge)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrOIDCAuthFailed, err)
+	}
+
+	now := time.Now()
+	if err := u.oidcLogins.DeleteExpired(now); err != nil {
+		return "", fmt.Errorf("failed to delete expired OIDC logins: %w", err)
+	}
+	err = u.oidcLogins.Create(&domain.OIDCLogin{
+		Provider:  providerName,
+		StateHash: hashToken(state),
+		Nonce:     nonce,
+		ExpiresAt: now.Add(oidcLoginTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store OIDC login: %w", err)
+	}
+	return authURL, nil
+}
+
+func (u *authUsecase) LoginWithOIDC(ctx context.Context, providerName string, req domain.OIDCLoginRequest) (*domain.AuthToken, error) {
+	provider, err := u.oidcProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	// The state finds the login started with OIDCAuthorizationURL, which can
+	// only be used once. Its nonce binds the ID token to this login.
+	login, err := u.oidcLogins.Take(providerName, hashToken(req.State), time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find OIDC login: %w", err)
+	}
+	if login == nil {
+		return nil, fmt.Errorf("%w: unknown or expired state", ErrOIDCAuthFailed)
+	}
+
+	// Web apps send the authorization code, native apps that ran the flow
+	// themselves send the ID token
+	idToken := req.IDToken
+	if req.Code != "" {
+		idToken, err = provider.exchangeCode(ctx, req.Code, req.RedirectURI, req.CodeVerifier)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrOIDCAuthFailed, err)
+		}
+	}
+
+	claims, err := provider.verifyIDToken(ctx, idToken, login.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCAuthFailed, err)
+	}
+	identity, err := provider.identity(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCAuthFailed, err)
+	}
+
+	return u.loginWithIdentity(ctx, identity, nil, "", nil)
+}
@@ -0,0 +1,382 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// testOIDCNonce is the nonce of the test logins, signed into ID tokens by default
+const testOIDCNonce = "n-0S6_WzA2Mj"
+
+// fakeIdP is a minimal OpenID Connect provider serving discovery, JWKS and a token endpoint
+type fakeIdP struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+	// idTokenClaims are signed into the ID token returned for a valid code
+	idTokenClaims jwt.MapClaims
+}
+
+// fakeOIDCLoginRepository keeps pending OIDC logins in memory, keyed by provider and state hash
+type fakeOIDCLoginRepository struct {
+	logins map[[2]string]domain.OIDCLogin
+}
+
+func (r *fakeOIDCLoginRepository) Create(login *domain.OIDCLogin) error {
+	r.logins[[2]string{login.Provider, login.StateHash}] = *login
+	return nil
+}
+
+func (r *fakeOIDCLoginRepository) Take(provider, stateHash string, now time.Time) (*domain.OIDCLogin, error) {
+	key := [2]string{provider, stateHash}
+	login, ok := r.logins[key]
+	delete(r.logins, key)
+	if !ok || !now.Before(login.ExpiresAt) {
+		return nil, nil
+	}
+	return &login, nil
+}
+
+func (r *fakeOIDCLoginRepository) DeleteExpired(before time.Time) error {
+	for key, login := range r.logins {
+		if login.ExpiresAt.Before(before) {
+			delete(r.logins, key)
+		}
+	}
+	return nil
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &fakeIdP{t: t, key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProviderMetadata{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+			SigningAlgs:           []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{{
+			Kty: "RSA",
+			Kid: "idp-key",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, ok := r.BasicAuth()
+		if !ok || clientID != "backend" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.PostFormValue("code") != "valid-code" || r.PostFormValue("redirect_uri") != "https://app.example.com/callback" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(oidcTokenResponse{
+			AccessToken: "idp-access-token",
+			TokenType:   "Bearer",
+			IDToken:     idp.sign(idp.idTokenClaims),
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *fakeIdP) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "idp-key"
+	signed, err := token.SignedString(idp.key)
+	require.NoError(idp.t, err)
+	return signed
+}
+
+func (idp *fakeIdP) claims(overrides jwt.MapClaims) jwt.MapClaims {
+	c := jwt.MapClaims{
+		"iss":            idp.URL,
+		"sub":            "user-1",
+		"aud":            "backend",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice Example",
+		"nonce":          testOIDCNonce,
+	}
+	for k, v := range overrides {
+		c[k] = v
+	}
+	return c
+}
+
+func TestOIDCProvider(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := newOIDCProvider(OIDCProviderConfig{
+		Name:         "okta",
+		IssuerURL:    idp.URL + "/",
+		ClientID:     "backend",
+		ClientSecret: "s3cret",
+	}, nil)
+
+	t.Run("authorization URL", func(t *testing.T) {
+		authURL, err := p.authorizationURL(context.Background(), "https://app.example.com/callback", "xyz", testOIDCNonce, "challenge")
+		require.NoError(t, err)
+		parsed, err := url.Parse(authURL)
+		require.NoError(t, err)
+		assert.Equal(t, "/authorize", parsed.Path)
+		assert.Equal(t, "backend", parsed.Query().Get("client_id"))
+		assert.Equal(t, "openid email profile", parsed.Query().Get("scope"))
+		assert.Equal(t, "xyz", parsed.Query().Get("state"))
+		assert.Equal(t, testOIDCNonce, parsed.Query().Get("nonce"))
+		assert.Equal(t, "S256", parsed.Query().Get("code_challenge_method"))
+	})
+
+	t.Run("ID token", func(t *testing.T) {
+		claims, err := p.verifyIDToken(context.Background(), idp.sign(idp.claims(nil)), testOIDCNonce)
+		require.NoError(t, err)
+		identity, err := p.identity(claims)
+		require.NoError(t, err)
+		assert.Equal(t, "okta", identity.Provider)
+		assert.Equal(t, "user-1", identity.Subject)
+		assert.Equal(t, "alice@example.com", identity.Email)
+		assert.True(t, identity.EmailVerified)
+		assert.Equal(t, "Alice Example", identity.Profile[userdomain.ProfileFieldName])
+	})
+
+	t.Run("authentication methods", func(t *testing.T) {
+		authTime := time.Now().Add(-time.Hour).Unix()
+		claims, err := p.verifyIDToken(context.Background(), idp.sign(idp.claims(jwt.MapClaims{"auth_time": authTime, "amr": []string{"pwd", "otp"}})), testOIDCNonce)
+		require.NoError(t, err)
+		identity, err := p.identity(claims)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pwd", "otp"}, identity.AMR)
+		require.NotNil(t, identity.AuthTime)
+		assert.Equal(t, authTime, identity.AuthTime.Unix())
+	})
+
+	t.Run("ID token for another client", func(t *testing.T) {
+		_, err := p.verifyIDToken(context.Background(), idp.sign(idp.claims(jwt.MapClaims{"aud": "someone-else"})), testOIDCNonce)
+		assert.Error(t, err)
+	})
+
+	t.Run("ID token with several audiences needs azp", func(t *testing.T) {
+		_, err := p.verifyIDToken(context.Background(), idp.sign(idp.claims(jwt.MapClaims{"aud": []string{"backend", "other"}})), testOIDCNonce)
+		assert.Error(t, err)
+		_, err = p.verifyIDToken(context.Background(), idp.sign(idp.claims(jwt.MapClaims{"aud": []string{"backend", "other"}, "azp": "backend"})), testOIDCNonce)
+		assert.NoError(t, err)
+	})
+
+	t.Run("ID token from another issuer", func(t *testing.T) {
+		_, err := p.verifyIDToken(context.Background(), idp.sign(idp.claims(jwt.MapClaims{"iss": "https://evil.example.com"})), testOIDCNonce)
+		assert.Error(t, err)
+	})
+
+	t.Run("ID token nonce", func(t *testing.T) {
+		// A token issued to another login, or without a nonce, is rejected
+		_, err := p.verifyIDToken(context.Background(), idp.sign(idp.claims(nil)), "another-nonce")
+		assert.ErrorContains(t, err, "nonce")
+		_, err = p.verifyIDToken(context.Background(), idp.sign(idp.claims(nil)), "")
+		assert.ErrorContains(t, err, "nonce")
+		claims := idp.claims(nil)
+		delete(claims, "nonce")
+		_, err = p.verifyIDToken(context.Background(), idp.sign(claims), testOIDCNonce)
+		assert.ErrorContains(t, err, "nonce")
+	})
+
+	t.Run("exchange code", func(t *testing.T) {
+		idp.idTokenClaims = idp.claims(nil)
+		idToken, err := p.exchangeCode(context.Background(), "valid-code", "https://app.example.com/callback", "")
+		require.NoError(t, err)
+		_, err = p.verifyIDToken(context.Background(), idToken, testOIDCNonce)
+		assert.NoError(t, err)
+	})
+
+	t.Run("exchange invalid code", func(t *testing.T) {
+		_, err := p.exchangeCode(context.Background(), "invalid-code", "https://app.example.com/callback", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestOIDCProviderClaimMappings(t *testing.T) {
+	idp := newFakeIdP(t)
+	// Azure AD style tokens carry the email in upn and Keycloak style nested attributes
+	p := newOIDCProvider(OIDCProviderConfig{
+		Name:      "azure",
+		IssuerURL: idp.URL,
+		ClientID:  "backend",
+		ClaimMappings: map[string]string{
+			ClaimMappingSubject:              "oid",
+			ClaimMappingEmail:                "upn",
+			ClaimMappingEmailVerified:        "",
+			userdomain.ProfileFieldAvatarURL: "attributes.photo",
+		},
+	}, nil)
+
+	claims, err := p.verifyIDToken(context.Background(), idp.sign(idp.claims(jwt.MapClaims{
+		"oid":        "object-id",
+		"upn":        "bob@contoso.com",
+		"attributes": map[string]interface{}{"photo": "https://contoso.com/bob.png"},
+	})), testOIDCNonce)
+	require.NoError(t, err)
+	identity, err := p.identity(claims)
+	require.NoError(t, err)
+
+	assert.Equal(t, "object-id", identity.Subject)
+	assert.Equal(t, "bob@contoso.com", identity.Email)
+	assert.False(t, identity.EmailVerified)
+	assert.Equal(t, "https://contoso.com/bob.png", identity.Profile[userdomain.ProfileFieldAvatarURL])
+	// Unmapped fields keep the standard claims
+	assert.Equal(t, "Alice Example", identity.Profile[userdomain.ProfileFieldName])
+}
+
+func TestOIDCProviderDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newFakeIdP(t)
+	// An issuer whose metadata claims to be another issuer must not be trusted
+	impostor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProviderMetadata{
+			Issuer:  idp.URL,
+			JWKSURI: idp.URL + "/jwks",
+		})
+	}))
+	t.Cleanup(impostor.Close)
+
+	p := newOIDCProvider(OIDCProviderConfig{
+		Name:      "okta",
+		IssuerURL: impostor.URL,
+		ClientID:  "backend",
+	}, nil)
+
+	_, err := p.verifyIDToken(context.Background(), idp.sign(idp.claims(nil)), testOIDCNonce)
+	assert.ErrorContains(t, err, "does not match")
+}
+
+func TestOIDCProviderDiscoveryWithoutLock(t *testing.T) {
+	idp := newFakeIdP(t)
+	requests := make(chan struct{}, 10)
+	release := make(chan struct{})
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		<-release
+		json.NewEncoder(w).Encode(oidcProviderMetadata{
+			Issuer:  "http://" + r.Host,
+			JWKSURI: idp.URL + "/jwks",
+		})
+	}))
+	t.Cleanup(issuer.Close)
+
+	p := newOIDCProvider(OIDCProviderConfig{
+		Name:      "okta",
+		IssuerURL: issuer.URL,
+		ClientID:  "backend",
+	}, nil)
+
+	discovered := make(chan error, 1)
+	go func() {
+		_, _, err := p.discover(context.Background())
+		discovered <- err
+	}()
+	<-requests
+
+	// A slow issuer must not block requests that give up waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := p.discover(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	require.NoError(t, <-discovered)
+	metadata, _, err := p.discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, idp.URL+"/jwks", metadata.JWKSURI)
+	// Concurrent requests share one fetch
+	assert.Len(t, requests, 0)
+}
+
+func TestLoginWithOIDC(t *testing.T) {
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	u := NewAuthUsecase(&fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{}},
+		&guestUserRepository{fakeUserRepository: fakeUserRepository{users: map[uuid.UUID]*userdomain.User{}}},
+		noInvitations{}, &fakeIdentityRepository{}, AuthUsecaseConfig{
+			JWTSecret:   "test-secret",
+			TokenConfig: TokenConfig{AccessTTL: 15 * time.Minute, RefreshTTL: 24 * time.Hour},
+			OIDCProviders: []OIDCProviderConfig{{
+				Name:         "okta",
+				IssuerURL:    idp.URL,
+				ClientID:     "backend",
+				ClientSecret: "s3cret",
+			}},
+			OIDCLogins: &fakeOIDCLoginRepository{logins: map[[2]string]domain.OIDCLogin{}},
+		})
+
+	// start returns the nonce the server put into the authorization URL
+	start := func(t *testing.T, state string) string {
+		t.Helper()
+		authURL, err := u.OIDCAuthorizationURL(ctx, "okta", "https://app.example.com/callback", state, "")
+		require.NoError(t, err)
+		parsed, err := url.Parse(authURL)
+		require.NoError(t, err)
+		nonce := parsed.Query().Get("nonce")
+		require.NotEmpty(t, nonce)
+		return nonce
+	}
+	login := func(state string) (*domain.AuthToken, error) {
+		return u.LoginWithOIDC(ctx, "okta", domain.OIDCLoginRequest{
+			Code:        "valid-code",
+			RedirectURI: "https://app.example.com/callback",
+			State:       state,
+		})
+	}
+
+	t.Run("nonce issued for the state", func(t *testing.T) {
+		nonce := start(t, "state-1")
+		idp.idTokenClaims = idp.claims(jwt.MapClaims{"nonce": nonce})
+
+		token, err := login("state-1")
+		require.NoError(t, err)
+		assert.NotEmpty(t, token.AccessToken)
+
+		// The login is used up
+		_, err = login("state-1")
+		assert.ErrorIs(t, err, ErrOIDCAuthFailed)
+	})
+
+	t.Run("nonce of another login", func(t *testing.T) {
+		nonce := start(t, "state-2")
+		start(t, "state-3")
+		idp.idTokenClaims = idp.claims(jwt.MapClaims{"nonce": nonce})
+
+		_, err := login("state-3")
+		assert.ErrorIs(t, err, ErrOIDCAuthFailed)
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		idp.idTokenClaims = idp.claims(nil)
+		_, err := login("state-4")
+		assert.ErrorIs(t, err, ErrOIDCAuthFailed)
+	})
+}
@@ -0,0 +1,39 @@
+package usecase
+
+import (
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// ProfileSyncPolicy decides, per profile field, whether a value the user edited
+// themselves wins over the value sent by the identity provider on login.
+//
+// By default the user's edit wins. Fields listed in ProviderWins are always
+// overwritten with the provider value. Empty provider values never overwrite
+// anything.
+type ProfileSyncPolicy struct {
+	// ProviderWins lists userdomain.ProfileFields that always follow the provider
+	ProviderWins []string
+}
+
+// Apply copies provider claims into the user according to the policy
+func (p ProfileSyncPolicy) Apply(user *userdomain.User, claims map[string]string) {
+	for _, field := range userdomain.ProfileFields {
+		value := claims[field]
+		if value == "" {
+			continue
+		}
+		if user.IsEdited(field) && !p.providerWins(field) {
+			continue
+		}
+		user.SetProfile(field, value)
+	}
+}
+
+func (p ProfileSyncPolicy) providerWins(field string) bool {
+	for _, f := range p.ProviderWins {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
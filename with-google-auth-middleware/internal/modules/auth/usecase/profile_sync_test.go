@@ -0,0 +1,68 @@
+package usecase
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+func TestMarkEdited(t *testing.T) {
+	user := &userdomain.User{}
+	assert.False(t, user.IsEdited(userdomain.ProfileFieldName))
+
+	user.MarkEdited(userdomain.ProfileFieldName)
+	user.MarkEdited(userdomain.ProfileFieldLocale)
+	user.MarkEdited(userdomain.ProfileFieldName)
+	assert.Equal(t, "name,locale", user.EditedFields)
+	assert.True(t, user.IsEdited(userdomain.ProfileFieldName))
+	assert.True(t, user.IsEdited(userdomain.ProfileFieldLocale))
+	assert.False(t, user.IsEdited(userdomain.ProfileFieldGivenName))
+}
+
+func TestProfileSyncPolicyApply(t *testing.T) {
+	claims := map[string]string{
+		userdomain.ProfileFieldName:      "Alice Provider",
+		userdomain.ProfileFieldAvatarURL: "https://provider.example.com/alice.png",
+		userdomain.ProfileFieldLocale:    "",
+	}
+	newUser := func() *userdomain.User {
+		user := &userdomain.User{
+			Name:      "Alice Edited",
+			AvatarURL: "https://example.com/alice.png",
+			Locale:    "id-ID",
+			GivenName: "Alice",
+		}
+		user.MarkEdited(userdomain.ProfileFieldName)
+		user.MarkEdited(userdomain.ProfileFieldAvatarURL)
+		return user
+	}
+
+	t.Run("user wins by default", func(t *testing.T) {
+		user := newUser()
+		ProfileSyncPolicy{}.Apply(user, claims)
+		assert.Equal(t, "Alice Edited", user.Name)
+		assert.Equal(t, "https://example.com/alice.png", user.AvatarURL)
+	})
+
+	t.Run("provider wins", func(t *testing.T) {
+		user := newUser()
+		ProfileSyncPolicy{ProviderWins: []string{userdomain.ProfileFieldAvatarURL}}.Apply(user, claims)
+		assert.Equal(t, "Alice Edited", user.Name)
+		assert.Equal(t, "https://provider.example.com/alice.png", user.AvatarURL)
+	})
+
+	t.Run("fields the user didn't edit follow the provider", func(t *testing.T) {
+		user := &userdomain.User{Name: "Alice"}
+		ProfileSyncPolicy{}.Apply(user, claims)
+		assert.Equal(t, "Alice Provider", user.Name)
+		assert.Equal(t, "https://provider.example.com/alice.png", user.AvatarURL)
+	})
+
+	t.Run("empty provider values are ignored", func(t *testing.T) {
+		user := newUser()
+		ProfileSyncPolicy{ProviderWins: userdomain.ProfileFields}.Apply(user, claims)
+		assert.Equal(t, "id-ID", user.Locale)
+		assert.Equal(t, "Alice", user.GivenName)
+	})
+}
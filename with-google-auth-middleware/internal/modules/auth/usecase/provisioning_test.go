@@ -0,0 +1,217 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
+	"gorm.io/gorm"
+)
+
+// fakeInvitationRepository keeps invitations in memory and stores accepted
+// users in the user repository
+type fakeInvitationRepository struct {
+	invitations map[uuid.UUID]*domain.Invitation
+	users       *guestUserRepository
+	// stale is found instead of the stored invitations, as by a concurrent login
+	stale *domain.Invitation
+}
+
+func (r *fakeInvitationRepository) Create(invitation *domain.Invitation) error {
+	r.invitations[invitation.ID] = invitation
+	return nil
+}
+
+func (r *fakeInvitationRepository) FindPendingByEmail(email string) (*domain.Invitation, error) {
+	if r.stale != nil {
+		return r.stale, nil
+	}
+	for _, invitation := range r.invitations {
+		if strings.EqualFold(invitation.Email, email) && invitation.IsPending() {
+			copied := *invitation
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeInvitationRepository) GetAll(page, limit int) ([]*domain.Invitation, error) {
+	var invitations []*domain.Invitation
+	for _, invitation := range r.invitations {
+		invitations = append(invitations, invitation)
+	}
+	return invitations, nil
+}
+
+func (r *fakeInvitationRepository) Accept(id uuid.UUID, user *userdomain.User, create bool) error {
+	invitation, ok := r.invitations[id]
+	if !ok || invitation.AcceptedAt != nil || invitation.RevokedAt != nil {
+		return gorm.ErrRecordNotFound
+	}
+	now := time.Now()
+	invitation.AcceptedAt = &now
+	invitation.AcceptedBy = &user.ID
+	if create {
+		return r.users.Create(user)
+	}
+	return r.users.Update(user)
+}
+
+func (r *fakeInvitationRepository) Revoke(id uuid.UUID) error {
+	invitation, ok := r.invitations[id]
+	if !ok || invitation.AcceptedAt != nil || invitation.RevokedAt != nil {
+		return gorm.ErrRecordNotFound
+	}
+	now := time.Now()
+	invitation.RevokedAt = &now
+	return nil
+}
+
+type testProvisioning struct {
+	*authUsecase
+	userRepo       *guestUserRepository
+	invitationRepo *fakeInvitationRepository
+}
+
+func newTestProvisioning(t *testing.T, mode string) *testProvisioning {
+	t.Helper()
+	userRepo := &guestUserRepository{fakeUserRepository: fakeUserRepository{users: map[uuid.UUID]*userdomain.User{}}}
+	invitationRepo := &fakeInvitationRepository{invitations: map[uuid.UUID]*domain.Invitation{}, users: userRepo}
+	u := NewAuthUsecase(&fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{}}, userRepo, invitationRepo, &fakeIdentityRepository{}, AuthUsecaseConfig{
+		JWTSecret:        "test-secret",
+		TokenConfig:      TokenConfig{AccessTTL: 15 * time.Minute, RefreshTTL: 24 * time.Hour},
+		ProvisioningMode: mode,
+	}).(*authUsecase)
+	return &testProvisioning{authUsecase: u, userRepo: userRepo, invitationRepo: invitationRepo}
+}
+
+func (p *testProvisioning) invite(email, role string) *domain.Invitation {
+	invitation := &domain.Invitation{
+		ID:        uuid.New(),
+		Email:     email,
+		Role:      role,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	p.invitationRepo.invitations[invitation.ID] = invitation
+	return invitation
+}
+
+func (p *testProvisioning) login(email string) (*userdomain.User, error) {
+	token, err := p.loginWithIdentity(context.Background(), &domain.ExternalIdentity{
+		Provider:      domain.ProviderGoogle,
+		Subject:       "google-" + email,
+		Email:         email,
+		EmailVerified: true,
+	}, nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := parseAccessToken(p.jwtSecret, token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return p.userRepo.users[uuid.MustParse(authn.StringClaim(claims, "sub"))], nil
+}
+
+func TestProvisioningModes(t *testing.T) {
+	t.Run("open", func(t *testing.T) {
+		p := newTestProvisioning(t, domain.ProvisioningOpen)
+		user, err := p.login("alice@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, userdomain.RoleUser, user.Role)
+		assert.Equal(t, userdomain.StatusActive, user.Status)
+	})
+
+	t.Run("open with an invitation", func(t *testing.T) {
+		p := newTestProvisioning(t, domain.ProvisioningOpen)
+		invitation := p.invite("alice@example.com", userdomain.RoleAdmin)
+		user, err := p.login("alice@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, userdomain.RoleAdmin, user.Role)
+		require.NotNil(t, invitation.AcceptedBy)
+		assert.Equal(t, user.ID, *invitation.AcceptedBy)
+	})
+
+	t.Run("invite only", func(t *testing.T) {
+		p := newTestProvisioning(t, domain.ProvisioningInviteOnly)
+		_, err := p.login("alice@example.com")
+		assert.ErrorIs(t, err, ErrInvitationRequired)
+		assert.Empty(t, p.userRepo.users)
+
+		invitation := p.invite("Bob@Example.com", userdomain.RoleUser)
+		user, err := p.login("bob@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, userdomain.RoleUser, user.Role)
+		assert.NotNil(t, invitation.AcceptedAt)
+	})
+
+	t.Run("invite only with a revoked invitation", func(t *testing.T) {
+		p := newTestProvisioning(t, domain.ProvisioningInviteOnly)
+		invitation := p.invite("alice@example.com", userdomain.RoleUser)
+		require.NoError(t, p.invitationRepo.Revoke(invitation.ID))
+		_, err := p.login("alice@example.com")
+		assert.ErrorIs(t, err, ErrInvitationRequired)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		p := newTestProvisioning(t, domain.ProvisioningClosed)
+		p.invite("alice@example.com", userdomain.RoleUser)
+		_, err := p.login("alice@example.com")
+		assert.ErrorIs(t, err, ErrRegistrationClosed)
+		assert.Empty(t, p.userRepo.users)
+	})
+
+	t.Run("closed lets existing users in", func(t *testing.T) {
+		p := newTestProvisioning(t, domain.ProvisioningClosed)
+		existing := &userdomain.User{ID: uuid.New(), Email: "alice@example.com", Active: true, Status: userdomain.StatusActive}
+		require.NoError(t, p.userRepo.Create(existing))
+		user, err := p.login("alice@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, existing.ID, user.ID)
+	})
+
+	// Another login found the invitation too and accepted it first
+	t.Run("invitation consumed concurrently", func(t *testing.T) {
+		p := newTestProvisioning(t, domain.ProvisioningInviteOnly)
+		invitation := p.invite("alice@example.com", userdomain.RoleAdmin)
+		pending, err := p.invitationRepo.FindPendingByEmail("alice@example.com")
+		require.NoError(t, err)
+		require.NoError(t, p.invitationRepo.Accept(invitation.ID, &userdomain.User{ID: uuid.New()}, true))
+		p.invitationRepo.stale = pending
+
+		_, err = p.provisionUser("alice@example.com", nil)
+		assert.ErrorIs(t, err, ErrInvitationRequired)
+		assert.Len(t, p.userRepo.users, 1)
+	})
+}
+
+func TestResolveUserEmailInUse(t *testing.T) {
+	p := newTestProvisioning(t, domain.ProvisioningOpen)
+	existing := &userdomain.User{ID: uuid.New(), Email: "alice@example.com", Active: true, Status: userdomain.StatusActive}
+	require.NoError(t, p.userRepo.Create(existing))
+
+	// An unverified email neither links to the user nor registers it again
+	_, err := p.resolveUser(&domain.ExternalIdentity{
+		Provider: "okta",
+		Subject:  "okta-alice",
+		Email:    "alice@example.com",
+	}, nil)
+	assert.ErrorIs(t, err, ErrEmailInUse)
+	assert.Len(t, p.userRepo.users, 1)
+
+	user, err := p.resolveUser(&domain.ExternalIdentity{
+		Provider:      "okta",
+		Subject:       "okta-alice",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, user.ID)
+}
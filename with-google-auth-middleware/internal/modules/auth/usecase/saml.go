@@ -0,0 +1,662 @@
+package usecase
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// Custom errors
+var (
+	ErrSAMLAuthFailed    = errors.New("failed to authenticate with SAML identity provider")
+	ErrSAMLNotConfigured = errors.New("SAML service provider is not configured")
+)
+
+// SAML namespaces, bindings and formats
+const (
+	samlAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlProtocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlMetadataNamespace  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	samlStatusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlConfirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlNameIDEmail        = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	samlNameIDUnspecified  = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+const (
+	// samlClockSkew is tolerated between our clock and the identity provider's
+	samlClockSkew = 2 * time.Minute
+	// samlRequestTTL is how long a login started with an AuthnRequest may take
+	samlRequestTTL = 10 * time.Minute
+	// samlMaxAssertionTTL caps how long assertion IDs are remembered for replay detection
+	samlMaxAssertionTTL = 24 * time.Hour
+)
+
+// Attribute mapping keys besides userdomain.ProfileFields
+const (
+	AttributeMappingSubject = "subject"
+	AttributeMappingEmail   = "email"
+)
+
+// defaultSAMLAttributes lists the attribute names commonly used for each user
+// field: plain names, LDAP OIDs and the claim URIs of AD FS and Azure AD
+var defaultSAMLAttributes = map[string][]string{
+	AttributeMappingEmail: {
+		"email", "mail", "urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	},
+	userdomain.ProfileFieldName: {
+		"name", "displayName", "urn:oid:2.16.840.1.113730.3.1.241",
+		"http://schemas.microsoft.com/identity/claims/displayname",
+	},
+	userdomain.ProfileFieldGivenName: {
+		"given_name", "givenName", "urn:oid:2.5.4.42",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname",
+	},
+	userdomain.ProfileFieldFamilyName: {
+		"family_name", "sn", "surname", "urn:oid:2.5.4.4",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname",
+	},
+	userdomain.ProfileFieldLocale: {"locale", "preferredLanguage", "urn:oid:2.16.840.1.113730.3.1.39"},
+}
+
+// samlAuthnContextAMR maps the authentication context classes identity
+// providers commonly assert to RFC 8176 amr values. Unknown classes are
+// recorded as a federated sign-in.
+var samlAuthnContextAMR = map[string][]string{
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:Password":                   {"pwd"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport": {"pwd"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:X509":                       {"swk"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:Smartcard":                  {"sc"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:SmartcardPKI":               {"sc", "pin"},
+	"https://refeds.org/profile/mfa":                                    {"mfa"},
+	"http://schemas.microsoft.com/claims/multipleauthn":                 {"mfa"},
+}
+
+// SAMLConfig configures the SAML service provider
+type SAMLConfig struct {
+	// EntityID identifies our service provider to the identity providers
+	EntityID string
+	// BaseURL is the public URL of the SAML routes, e.g. https://api.example.com/v1/auth/saml.
+	// The ACS URL of an identity provider is BaseURL/{name}/acs.
+	BaseURL string
+	// Certificate and PrivateKey are PEM encoded and sign AuthnRequests, optional
+	Certificate string
+	PrivateKey  string
+	// IdentityProviders lists the identity providers users may sign in with
+	IdentityProviders []SAMLIdentityProviderConfig
+	// Messages remembers the IDs of the AuthnRequests we sent and the assertions
+	// we consumed
+	Messages domain.SAMLMessageRepository
+}
+
+// SAMLIdentityProviderConfig configures one SAML identity provider
+type SAMLIdentityProviderConfig struct {
+	// Name identifies the provider in URLs and linked identities, e.g. "acme"
+	Name string
+	// MetadataXML is the identity provider's metadata document
+	MetadataXML string
+	// AttributeMappings overrides the attribute a user field is read from, keyed
+	// by subject, email or a userdomain.ProfileFields entry. The subject defaults
+	// to the NameID.
+	AttributeMappings map[string]string
+	// TrustEmail treats emails asserted by the provider as verified
+	TrustEmail bool
+}
+
+// samlIdentityProvider is a configured identity provider with its parsed metadata
+type samlIdentityProvider struct {
+	cfg         SAMLIdentityProviderConfig
+	entityID    string
+	ssoURL      string
+	ssoBinding  string
+	certs       []*x509.Certificate
+	metadataErr error
+}
+
+// samlMetadata is the part of an identity provider's metadata we use
+type samlMetadata struct {
+	EntityID string `xml:"entityID,attr"`
+	IDPSSO   *struct {
+		KeyDescriptors []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+		SingleSignOnServices []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleSignOnService"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+	Entities []samlMetadata `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+}
+
+// samlResponse is the unsigned envelope of a SAML response
+type samlResponse struct {
+	ID           string `xml:"ID,attr"`
+	InResponseTo string `xml:"InResponseTo,attr"`
+	Destination  string `xml:"Destination,attr"`
+	Issuer       string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Status       struct {
+		StatusCode struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusCode"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
+}
+
+// samlAssertion is a SAML assertion, only ever decoded from signed bytes
+type samlAssertion struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	ID      string   `xml:"ID,attr"`
+	Issuer  string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Subject struct {
+		NameID struct {
+			Format string `xml:"Format,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+		SubjectConfirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				InResponseTo string    `xml:"InResponseTo,attr"`
+				Recipient    string    `xml:"Recipient,attr"`
+				NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+			} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmationData"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmation"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject"`
+	Conditions *struct {
+		NotBefore            time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter         time.Time `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Audience"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AudienceRestriction"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Conditions"`
+	AttributeStatements []struct {
+		Attributes []struct {
+			Name         string   `xml:"Name,attr"`
+			FriendlyName string   `xml:"FriendlyName,attr"`
+			Values       []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeValue"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Attribute"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeStatement"`
+	AuthnStatements []struct {
+		AuthnInstant time.Time `xml:"AuthnInstant,attr"`
+		ClassRef     string    `xml:"urn:oasis:names:tc:SAML:2.0:assertion AuthnContext>AuthnContextClassRef"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AuthnStatement"`
+}
+
+// samlServiceProvider implements SP-initiated SAML 2.0 Web Browser SSO
+type samlServiceProvider struct {
+	cfg      SAMLConfig
+	key      *rsa.PrivateKey
+	cert     *x509.Certificate
+	idps     map[string]*samlIdentityProvider
+	messages domain.SAMLMessageRepository
+	now      func() time.Time
+}
+
+func newSAMLServiceProvider(cfg SAMLConfig) *samlServiceProvider {
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	sp := &samlServiceProvider{
+		cfg:      cfg,
+		idps:     make(map[string]*samlIdentityProvider, len(cfg.IdentityProviders)),
+		messages: cfg.Messages,
+		now:      time.Now,
+	}
+	if cfg.PrivateKey != "" && cfg.Certificate != "" {
+		// An unusable key only disables signing of AuthnRequests
+		sp.key, sp.cert, _ = parseSAMLKeyPair(cfg.PrivateKey, cfg.Certificate)
+	}
+	for _, idpCfg := range cfg.IdentityProviders {
+		idp := &samlIdentityProvider{cfg: idpCfg}
+		// Invalid metadata makes this provider fail on use instead of taking down the others
+		idp.metadataErr = idp.parseMetadata([]byte(idpCfg.MetadataXML))
+		sp.idps[idpCfg.Name] = idp
+	}
+	return sp
+}
+
+func parseSAMLKeyPair(keyPEM, certPEM string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	certBlock, _ := pem.Decode([]byte(certPEM))
+	if certBlock == nil {
+		return nil, nil, errors.New("invalid PEM")
+	}
+
+	key, err := parseRSAPrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+	return key, cert, nil
+}
+
+// parseMetadata reads the entity ID, signing certificates and SSO endpoint of the identity provider
+func (idp *samlIdentityProvider) parseMetadata(data []byte) error {
+	if _, err := parseXML(data); err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	var metadata samlMetadata
+	if err := xml.Unmarshal(data, &metadata); err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	// An EntitiesDescriptor is accepted when it contains a single identity provider
+	if metadata.IDPSSO == nil {
+		var found []samlMetadata
+		for _, entity := range metadata.Entities {
+			if entity.IDPSSO != nil {
+				found = append(found, entity)
+			}
+		}
+		if len(found) != 1 {
+			return errors.New("metadata must describe exactly one identity provider")
+		}
+		metadata = found[0]
+	}
+
+	idp.entityID = metadata.EntityID
+	for _, descriptor := range metadata.IDPSSO.KeyDescriptors {
+		if descriptor.Use != "" && descriptor.Use != "signing" {
+			continue
+		}
+		for _, encoded := range descriptor.Certificates {
+			der, err := decodeXMLBase64(encoded)
+			if err != nil {
+				return fmt.Errorf("invalid metadata certificate: %w", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return fmt.Errorf("invalid metadata certificate: %w", err)
+			}
+			idp.certs = append(idp.certs, cert)
+		}
+	}
+	// Prefer the redirect binding, it doesn't need an auto-submitting form
+	for _, binding := range []string{domain.SAMLBindingHTTPRedirect, domain.SAMLBindingHTTPPost} {
+		for _, sso := range metadata.IDPSSO.SingleSignOnServices {
+			if sso.Binding == binding && idp.ssoURL == "" {
+				idp.ssoURL, idp.ssoBinding = sso.Location, sso.Binding
+			}
+		}
+	}
+
+	if idp.entityID == "" || idp.ssoURL == "" || len(idp.certs) == 0 {
+		return errors.New("metadata needs an entity ID, a signing certificate and a redirect or POST SingleSignOnService")
+	}
+	return nil
+}
+
+func (sp *samlServiceProvider) identityProvider(name string) (*samlIdentityProvider, error) {
+	if sp.cfg.EntityID == "" || sp.cfg.BaseURL == "" {
+		return nil, ErrSAMLNotConfigured
+	}
+	idp, ok := sp.idps[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	if idp.metadataErr != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSAMLAuthFailed, idp.metadataErr)
+	}
+	return idp, nil
+}
+
+func (sp *samlServiceProvider) acsURL(idp *samlIdentityProvider) string {
+	return sp.cfg.BaseURL + "/" + url.PathEscape(idp.cfg.Name) + "/acs"
+}
+
+// metadata generates our service provider metadata for the identity provider
+func (sp *samlServiceProvider) metadata(idp *samlIdentityProvider) []byte {
+	var keyDescriptor string
+	if sp.cert != nil {
+		keyDescriptor = `<md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="` + xmldsigNamespace + `"><ds:X509Data><ds:X509Certificate>` +
+			base64.StdEncoding.EncodeToString(sp.cert.Raw) +
+			`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>`
+	}
+	return []byte(xml.Header +
+		`<md:EntityDescriptor xmlns:md="` + samlMetadataNamespace + `" entityID="` + escapeXML(sp.cfg.EntityID) + `">` +
+		`<md:SPSSODescriptor AuthnRequestsSigned="` + fmt.Sprint(sp.key != nil) + `" WantAssertionsSigned="true" protocolSupportEnumeration="` + samlProtocolNamespace + `">` +
+		keyDescriptor +
+		`<md:NameIDFormat>` + samlNameIDEmail + `</md:NameIDFormat>` +
+		`<md:AssertionConsumerService Binding="` + domain.SAMLBindingHTTPPost + `" Location="` + escapeXML(sp.acsURL(idp)) + `" index="0" isDefault="true"></md:AssertionConsumerService>` +
+		`</md:SPSSODescriptor>` +
+		`</md:EntityDescriptor>`)
+}
+
+// authnRequest creates an AuthnRequest for the identity provider in the binding of its SSO endpoint
+func (sp *samlServiceProvider) authnRequest(idp *samlIdentityProvider, relayState string) (*domain.SAMLAuthnRequest, error) {
+	random := make([]byte, 20)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate request ID: %w", err)
+	}
+	// IDs must not start with a digit (xs:ID)
+	id := "id-" + hex.EncodeToString(random)
+	now := sp.now().UTC()
+
+	request := etree.NewElement("samlp:AuthnRequest")
+	request.CreateAttr("xmlns:samlp", samlProtocolNamespace)
+	request.CreateAttr("xmlns:saml", samlAssertionNamespace)
+	request.CreateAttr("ID", id)
+	request.CreateAttr("Version", "2.0")
+	request.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	request.CreateAttr("Destination", idp.ssoURL)
+	request.CreateAttr("AssertionConsumerServiceURL", sp.acsURL(idp))
+	request.CreateAttr("ProtocolBinding", domain.SAMLBindingHTTPPost)
+	request.CreateElement("saml:Issuer").SetText(sp.cfg.EntityID)
+	policy := request.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("Format", samlNameIDUnspecified)
+	policy.CreateAttr("AllowCreate", "true")
+
+	// The POST binding carries the signature inside the request
+	if idp.ssoBinding == domain.SAMLBindingHTTPPost && sp.key != nil {
+		signed, err := signXML(request, sp.key, sp.cert)
+		if err != nil {
+			return nil, err
+		}
+		request = signed
+	}
+	data, err := writeXML(request)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &domain.SAMLAuthnRequest{
+		Binding:    idp.ssoBinding,
+		URL:        idp.ssoURL,
+		RelayState: relayState,
+	}
+	if idp.ssoBinding == domain.SAMLBindingHTTPPost {
+		result.SAMLRequest = base64.StdEncoding.EncodeToString(data)
+	} else {
+		var deflated bytes.Buffer
+		writer, _ := flate.NewWriter(&deflated, flate.BestCompression)
+		writer.Write(data)
+		writer.Close()
+
+		// The redirect binding signs the query string instead (SAML Bindings 3.4.4.1)
+		query := "SAMLRequest=" + url.QueryEscape(base64.StdEncoding.EncodeToString(deflated.Bytes()))
+		if relayState != "" {
+			query += "&RelayState=" + url.QueryEscape(relayState)
+		}
+		if sp.key != nil {
+			query += "&SigAlg=" + url.QueryEscape(algRSASHA256)
+			hashed := crypto.SHA256.New()
+			hashed.Write([]byte(query))
+			signature, err := rsa.SignPKCS1v15(rand.Reader, sp.key, crypto.SHA256, hashed.Sum(nil))
+			if err != nil {
+				return nil, fmt.Errorf("failed to sign AuthnRequest: %w", err)
+			}
+			query += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature))
+		}
+		separator := "?"
+		if strings.Contains(idp.ssoURL, "?") {
+			separator = "&"
+		}
+		result.URL = idp.ssoURL + separator + query
+	}
+
+	if err := sp.messages.DeleteExpired(now); err != nil {
+		return nil, fmt.Errorf("failed to delete expired SAML messages: %w", err)
+	}
+	err = sp.messages.CreateRequest(&domain.SAMLMessage{
+		Provider:  idp.cfg.Name,
+		ID:        id,
+		ExpiresAt: now.Add(samlRequestTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store AuthnRequest: %w", err)
+	}
+	return result, nil
+}
+
+// consumeResponse validates a base64 encoded SAML response posted to the ACS
+// and returns the asserted identity
+func (sp *samlServiceProvider) consumeResponse(idp *samlIdentityProvider, encoded string) (*domain.ExternalIdentity, error) {
+	data, err := decodeXMLBase64(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMLResponse encoding: %w", err)
+	}
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	if root.Tag != "Response" || root.NamespaceURI() != samlProtocolNamespace {
+		return nil, errors.New("document is not a SAML response")
+	}
+	if len(childElements(root, samlAssertionNamespace, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+	assertions := childElements(root, samlAssertionNamespace, "Assertion")
+	if len(assertions) != 1 {
+		return nil, errors.New("response must contain exactly one assertion")
+	}
+
+	// Either the whole response or the assertion must be signed. Everything
+	// below is read from the verified bytes only.
+	var response samlResponse
+	var assertionBytes []byte
+	if signedResponse, err := verifyXMLSignature(root, idp.certs, sp.now()); err == nil {
+		if err := xml.Unmarshal(signedResponse, &response); err != nil {
+			return nil, fmt.Errorf("invalid response: %w", err)
+		}
+		signedRoot, err := parseXML(signedResponse)
+		if err != nil {
+			return nil, err
+		}
+		signedAssertions := childElements(signedRoot, samlAssertionNamespace, "Assertion")
+		if len(signedAssertions) != 1 {
+			return nil, errors.New("signed response must contain exactly one assertion")
+		}
+		if assertionBytes, err = writeXML(signedAssertions[0]); err != nil {
+			return nil, err
+		}
+	} else {
+		if assertionBytes, err = verifyXMLSignature(assertions[0], idp.certs, sp.now()); err != nil {
+			return nil, fmt.Errorf("invalid signature: %w", err)
+		}
+		// The envelope isn't signed, it's only used for the status
+		if err := xml.Unmarshal(data, &response); err != nil {
+			return nil, fmt.Errorf("invalid response: %w", err)
+		}
+	}
+
+	if response.Status.StatusCode.Value != samlStatusSuccess {
+		return nil, fmt.Errorf("identity provider returned status %q", response.Status.StatusCode.Value)
+	}
+	acsURL := sp.acsURL(idp)
+	if response.Destination != "" && response.Destination != acsURL {
+		return nil, fmt.Errorf("response destination %q is not our ACS", response.Destination)
+	}
+	if response.Issuer != "" && response.Issuer != idp.entityID {
+		return nil, fmt.Errorf("response issuer %q is not the identity provider", response.Issuer)
+	}
+
+	var assertion samlAssertion
+	if err := xml.Unmarshal(assertionBytes, &assertion); err != nil {
+		return nil, fmt.Errorf("invalid assertion: %w", err)
+	}
+	expiresAt, err := sp.validateAssertion(idp, &assertion, acsURL)
+	if err != nil {
+		return nil, err
+	}
+	unused, err := sp.messages.UseAssertion(&domain.SAMLMessage{
+		Provider:  idp.cfg.Name,
+		ID:        assertion.ID,
+		ExpiresAt: expiresAt,
+		CreatedAt: sp.now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store assertion: %w", err)
+	}
+	if !unused {
+		return nil, fmt.Errorf("assertion %q was already used", assertion.ID)
+	}
+
+	return idp.identity(&assertion)
+}
+
+// validateAssertion checks issuer, conditions and subject confirmation and
+// returns until when the assertion could be replayed
+func (sp *samlServiceProvider) validateAssertion(idp *samlIdentityProvider, assertion *samlAssertion, acsURL string) (time.Time, error) {
+	now := sp.now()
+	if assertion.Issuer != idp.entityID {
+		return time.Time{}, fmt.Errorf("assertion issuer %q is not the identity provider", assertion.Issuer)
+	}
+
+	expiresAt := now.Add(samlMaxAssertionTTL)
+	if conditions := assertion.Conditions; conditions != nil {
+		if !conditions.NotBefore.IsZero() && now.Add(samlClockSkew).Before(conditions.NotBefore) {
+			return time.Time{}, errors.New("assertion is not yet valid")
+		}
+		if !conditions.NotOnOrAfter.IsZero() {
+			if !now.Add(-samlClockSkew).Before(conditions.NotOnOrAfter) {
+				return time.Time{}, errors.New("assertion has expired")
+			}
+			expiresAt = conditions.NotOnOrAfter.Add(samlClockSkew)
+		}
+		// Every AudienceRestriction must include us (SAML Core 2.5.1.4)
+		for _, restriction := range conditions.AudienceRestrictions {
+			if !containsString(restriction.Audiences, sp.cfg.EntityID) {
+				return time.Time{}, fmt.Errorf("assertion audience %v does not include us", restriction.Audiences)
+			}
+		}
+		if len(conditions.AudienceRestrictions) == 0 {
+			return time.Time{}, errors.New("assertion has no audience restriction")
+		}
+	} else {
+		return time.Time{}, errors.New("assertion has no conditions")
+	}
+
+	// A bearer confirmation for our ACS that answers an AuthnRequest we sent
+	for _, confirmation := range assertion.Subject.SubjectConfirmations {
+		data := confirmation.Data
+		if confirmation.Method != samlConfirmationBearer || data.Recipient != acsURL {
+			continue
+		}
+		if data.NotOnOrAfter.IsZero() || !now.Add(-samlClockSkew).Before(data.NotOnOrAfter) {
+			continue
+		}
+		if data.InResponseTo == "" {
+			return time.Time{}, errors.New("assertion does not answer a pending AuthnRequest")
+		}
+		pending, err := sp.messages.TakeRequest(idp.cfg.Name, data.InResponseTo, now)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to find AuthnRequest: %w", err)
+		}
+		if !pending {
+			return time.Time{}, errors.New("assertion does not answer a pending AuthnRequest")
+		}
+		return expiresAt, nil
+	}
+	return time.Time{}, errors.New("assertion has no valid bearer subject confirmation")
+}
+
+// identity maps the assertion's NameID and attributes to an external identity
+func (idp *samlIdentityProvider) identity(assertion *samlAssertion) (*domain.ExternalIdentity, error) {
+	attributes := make(map[string]string)
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if len(attribute.Values) == 0 {
+				continue
+			}
+			value := strings.TrimSpace(attribute.Values[0])
+			attributes[attribute.Name] = value
+			if attribute.FriendlyName != "" {
+				attributes[attribute.FriendlyName] = value
+			}
+		}
+	}
+
+	lookup := func(field string) string {
+		if name, ok := idp.cfg.AttributeMappings[field]; ok {
+			return attributes[name]
+		}
+		for _, name := range defaultSAMLAttributes[field] {
+			if value := attributes[name]; value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	nameID := strings.TrimSpace(assertion.Subject.NameID.Value)
+	subject := nameID
+	if _, ok := idp.cfg.AttributeMappings[AttributeMappingSubject]; ok {
+		subject = lookup(AttributeMappingSubject)
+	}
+	if subject == "" {
+		return nil, errors.New("assertion has no subject")
+	}
+
+	email := lookup(AttributeMappingEmail)
+	if email == "" && assertion.Subject.NameID.Format == samlNameIDEmail {
+		email = nameID
+	}
+
+	profile := make(map[string]string, len(userdomain.ProfileFields))
+	for _, field := range userdomain.ProfileFields {
+		profile[field] = lookup(field)
+	}
+	identity := &domain.ExternalIdentity{
+		Provider:      idp.cfg.Name,
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: idp.cfg.TrustEmail && email != "",
+		Profile:       profile,
+	}
+	// How the user authenticated, from the first authentication statement
+	if len(assertion.AuthnStatements) > 0 {
+		statement := assertion.AuthnStatements[0]
+		if !statement.AuthnInstant.IsZero() {
+			identity.AuthTime = &statement.AuthnInstant
+		}
+		identity.AMR = samlAuthnContextAMR[strings.TrimSpace(statement.ClassRef)]
+	}
+	return identity, nil
+}
+
+func (u *authUsecase) SAMLMetadata(ctx context.Context, providerName string) ([]byte, error) {
+	idp, err := u.saml.identityProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	return u.saml.metadata(idp), nil
+}
+
+func (u *authUsecase) StartSAMLLogin(ctx context.Context, providerName, relayState string) (*domain.SAMLAuthnRequest, error) {
+	idp, err := u.saml.identityProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	return u.saml.authnRequest(idp, relayState)
+}
+
+func (u *authUsecase) LoginWithSAML(ctx context.Context, providerName, samlResponse string) (*domain.AuthToken, error) {
+	idp, err := u.saml.identityProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := u.saml.consumeResponse(idp, samlResponse)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSAMLAuthFailed, err)
+	}
+	return u.loginWithIdentity(ctx, identity, nil, "", nil)
+}
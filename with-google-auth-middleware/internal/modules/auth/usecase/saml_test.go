@@ -0,0 +1,332 @@
+package usecase
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+const (
+	testSAMLEntityID = "https://api.example.com/saml"
+	testSAMLBaseURL  = "https://api.example.com/v1/auth/saml"
+	testSAMLACSURL   = testSAMLBaseURL + "/acme/acs"
+	testIdPEntityID  = "https://idp.acme.com"
+)
+
+// fakeSAMLMessageRepository keeps SAML message IDs in memory, keyed by provider and ID
+type fakeSAMLMessageRepository struct {
+	requests   map[[2]string]time.Time
+	assertions map[[2]string]time.Time
+}
+
+func newFakeSAMLMessageRepository() *fakeSAMLMessageRepository {
+	return &fakeSAMLMessageRepository{requests: map[[2]string]time.Time{}, assertions: map[[2]string]time.Time{}}
+}
+
+func (r *fakeSAMLMessageRepository) CreateRequest(request *domain.SAMLMessage) error {
+	r.requests[[2]string{request.Provider, request.ID}] = request.ExpiresAt
+	return nil
+}
+
+func (r *fakeSAMLMessageRepository) TakeRequest(provider, id string, now time.Time) (bool, error) {
+	key := [2]string{provider, id}
+	expiresAt, ok := r.requests[key]
+	delete(r.requests, key)
+	return ok && now.Before(expiresAt), nil
+}
+
+func (r *fakeSAMLMessageRepository) UseAssertion(assertion *domain.SAMLMessage) (bool, error) {
+	key := [2]string{assertion.Provider, assertion.ID}
+	if _, ok := r.assertions[key]; ok {
+		return false, nil
+	}
+	r.assertions[key] = assertion.ExpiresAt
+	return true, nil
+}
+
+func (r *fakeSAMLMessageRepository) DeleteExpired(before time.Time) error {
+	for _, messages := range []map[[2]string]time.Time{r.requests, r.assertions} {
+		for key, expiresAt := range messages {
+			if expiresAt.Before(before) {
+				delete(messages, key)
+			}
+		}
+	}
+	return nil
+}
+
+// testSAMLIdP signs responses like a SAML identity provider would
+type testSAMLIdP struct {
+	t    *testing.T
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestSAMLIdP(t *testing.T) *testSAMLIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.acme.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testSAMLIdP{t: t, key: key, cert: cert}
+}
+
+func (idp *testSAMLIdP) metadata(binding string) string {
+	return `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="` + testIdPEntityID + `">
+  <md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">
+        <ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(idp.cert.Raw) + `</ds:X509Certificate></ds:X509Data>
+      </ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:SingleSignOnService Binding="` + binding + `" Location="https://idp.acme.com/sso"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`
+}
+
+type testAssertion struct {
+	id, inResponseTo, audience, recipient string
+	notOnOrAfter                          time.Time
+	email                                 string
+}
+
+func (idp *testSAMLIdP) response(a testAssertion) string {
+	return `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="resp-1" Version="2.0" IssueInstant="` + time.Now().UTC().Format(time.RFC3339) + `" Destination="` + testSAMLACSURL + `" InResponseTo="` + a.inResponseTo + `">
+  <saml:Issuer xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">` + testIdPEntityID + `</saml:Issuer>
+  <samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+  <saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="` + a.id + `" Version="2.0" IssueInstant="` + time.Now().UTC().Format(time.RFC3339) + `">
+    <saml:Issuer>` + testIdPEntityID + `</saml:Issuer>
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">` + a.email + `</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData InResponseTo="` + a.inResponseTo + `" Recipient="` + a.recipient + `" NotOnOrAfter="` + a.notOnOrAfter.UTC().Format(time.RFC3339) + `"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="` + time.Now().Add(-time.Minute).UTC().Format(time.RFC3339) + `" NotOnOrAfter="` + a.notOnOrAfter.UTC().Format(time.RFC3339) + `">
+      <saml:AudienceRestriction><saml:Audience>` + a.audience + `</saml:Audience></saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AuthnStatement AuthnInstant="` + time.Now().Add(-time.Minute).UTC().Format(time.RFC3339) + `">
+      <saml:AuthnContext><saml:AuthnContextClassRef>https://refeds.org/profile/mfa</saml:AuthnContextClassRef></saml:AuthnContext>
+    </saml:AuthnStatement>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="urn:oid:2.5.4.42" FriendlyName="givenName"><saml:AttributeValue>Alice</saml:AttributeValue></saml:Attribute>
+      <saml:Attribute Name="department"><saml:AttributeValue>Engineering &amp; Ops</saml:AttributeValue></saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`
+}
+
+// sign signs the assertion (or the whole response) and returns the base64 encoded document
+func (idp *testSAMLIdP) sign(document string, signResponse bool) string {
+	root, err := parseXML([]byte(document))
+	require.NoError(idp.t, err)
+	if signResponse {
+		root, err = signXML(root, idp.key, idp.cert)
+		require.NoError(idp.t, err)
+	} else {
+		assertion := childElements(root, samlAssertionNamespace, "Assertion")[0]
+		signed, err := signXML(assertion, idp.key, idp.cert)
+		require.NoError(idp.t, err)
+		root.InsertChildAt(assertion.Index(), signed)
+		root.RemoveChild(assertion)
+	}
+	data, err := writeXML(root)
+	require.NoError(idp.t, err)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func newTestSAMLServiceProvider(t *testing.T, idp *testSAMLIdP, binding string) (*samlServiceProvider, *samlIdentityProvider) {
+	sp := newSAMLServiceProvider(SAMLConfig{
+		EntityID: testSAMLEntityID,
+		BaseURL:  testSAMLBaseURL,
+		IdentityProviders: []SAMLIdentityProviderConfig{{
+			Name:        "acme",
+			MetadataXML: idp.metadata(binding),
+			TrustEmail:  true,
+		}},
+		Messages: newFakeSAMLMessageRepository(),
+	})
+	provider, err := sp.identityProvider("acme")
+	require.NoError(t, err)
+	return sp, provider
+}
+
+func TestSAMLMetadataAndAuthnRequest(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	sp, provider := newTestSAMLServiceProvider(t, idp, "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect")
+
+	metadata := string(sp.metadata(provider))
+	assert.Contains(t, metadata, `entityID="`+testSAMLEntityID+`"`)
+	assert.Contains(t, metadata, `Location="`+testSAMLACSURL+`"`)
+
+	request, err := sp.authnRequest(provider, "state-1")
+	require.NoError(t, err)
+	redirect, err := url.Parse(request.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "idp.acme.com", redirect.Host)
+	assert.Equal(t, "state-1", redirect.Query().Get("RelayState"))
+
+	deflated, err := base64.StdEncoding.DecodeString(redirect.Query().Get("SAMLRequest"))
+	require.NoError(t, err)
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	require.NoError(t, err)
+	authnRequest, err := parseXML(inflated)
+	require.NoError(t, err)
+	assert.Equal(t, testSAMLACSURL, authnRequest.SelectAttrValue("AssertionConsumerServiceURL", ""))
+	// The request is pending for this provider only
+	pending, err := sp.messages.TakeRequest("other", authnRequest.SelectAttrValue("ID", ""), time.Now())
+	require.NoError(t, err)
+	assert.False(t, pending)
+	pending, err = sp.messages.TakeRequest("acme", authnRequest.SelectAttrValue("ID", ""), time.Now())
+	require.NoError(t, err)
+	assert.True(t, pending)
+}
+
+func TestSAMLConsumeResponse(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	sp, provider := newTestSAMLServiceProvider(t, idp, "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST")
+
+	// pending starts a login and returns a valid assertion answering it
+	pending := func(id string) testAssertion {
+		request, err := sp.authnRequest(provider, "")
+		require.NoError(t, err)
+		decoded, err := base64.StdEncoding.DecodeString(request.SAMLRequest)
+		require.NoError(t, err)
+		root, err := parseXML(decoded)
+		require.NoError(t, err)
+		return testAssertion{
+			id:           id,
+			inResponseTo: root.SelectAttrValue("ID", ""),
+			audience:     testSAMLEntityID,
+			recipient:    testSAMLACSURL,
+			notOnOrAfter: time.Now().Add(5 * time.Minute),
+			email:        "alice@acme.com",
+		}
+	}
+
+	t.Run("signed assertion", func(t *testing.T) {
+		encoded := idp.sign(idp.response(pending("assertion-1")), false)
+		identity, err := sp.consumeResponse(provider, encoded)
+		require.NoError(t, err)
+		assert.Equal(t, "acme", identity.Provider)
+		assert.Equal(t, "alice@acme.com", identity.Subject)
+		assert.Equal(t, "alice@acme.com", identity.Email)
+		assert.True(t, identity.EmailVerified)
+		assert.Equal(t, "Alice", identity.Profile[userdomain.ProfileFieldGivenName])
+		assert.Equal(t, []string{"mfa"}, identity.AMR)
+		require.NotNil(t, identity.AuthTime)
+		assert.WithinDuration(t, time.Now().Add(-time.Minute), *identity.AuthTime, 2*time.Second)
+
+		// The same assertion can only be used once
+		_, err = sp.consumeResponse(provider, encoded)
+		assert.Error(t, err)
+	})
+
+	t.Run("signed response", func(t *testing.T) {
+		_, err := sp.consumeResponse(provider, idp.sign(idp.response(pending("assertion-2")), true))
+		assert.NoError(t, err)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		encoded := base64.StdEncoding.EncodeToString([]byte(idp.response(pending("assertion-3"))))
+		_, err := sp.consumeResponse(provider, encoded)
+		assert.Error(t, err)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		encoded := idp.sign(idp.response(pending("assertion-4")), false)
+		decoded, _ := base64.StdEncoding.DecodeString(encoded)
+		tampered := strings.Replace(string(decoded), "alice@acme.com", "mallory@acme.com", 1)
+		_, err := sp.consumeResponse(provider, base64.StdEncoding.EncodeToString([]byte(tampered)))
+		assert.Error(t, err)
+	})
+
+	t.Run("signed by another key", func(t *testing.T) {
+		other := newTestSAMLIdP(t)
+		_, err := sp.consumeResponse(provider, other.sign(idp.response(pending("assertion-5")), false))
+		assert.Error(t, err)
+	})
+
+	t.Run("SHA-1 signature", func(t *testing.T) {
+		root, err := parseXML([]byte(idp.response(pending("assertion-11"))))
+		require.NoError(t, err)
+		ctx, err := dsig.NewSigningContext(idp.key, [][]byte{idp.cert.Raw})
+		require.NoError(t, err)
+		ctx.Hash = crypto.SHA1
+		require.NoError(t, ctx.SetSignatureMethod(dsig.RSASHA1SignatureMethod))
+		assertion := childElements(root, samlAssertionNamespace, "Assertion")[0]
+		signed, err := ctx.SignEnveloped(assertion)
+		require.NoError(t, err)
+		root.InsertChildAt(assertion.Index(), signed)
+		root.RemoveChild(assertion)
+		data, err := writeXML(root)
+		require.NoError(t, err)
+		_, err = sp.consumeResponse(provider, base64.StdEncoding.EncodeToString(data))
+		assert.ErrorContains(t, err, "unsupported signature method")
+	})
+
+	t.Run("wrapped assertion", func(t *testing.T) {
+		// A forged assertion next to the signed one must not be picked up
+		encoded := idp.sign(idp.response(pending("assertion-6")), false)
+		decoded, _ := base64.StdEncoding.DecodeString(encoded)
+		forged := strings.Replace(idp.response(pending("assertion-6")), "alice@acme.com", "mallory@acme.com", -1)
+		start := strings.Index(forged, "<saml:Assertion")
+		end := strings.Index(forged, "</saml:Assertion>") + len("</saml:Assertion>")
+		wrapped := strings.Replace(string(decoded), "</samlp:Response>", forged[start:end]+"</samlp:Response>", 1)
+		_, err := sp.consumeResponse(provider, base64.StdEncoding.EncodeToString([]byte(wrapped)))
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		a := pending("assertion-7")
+		a.audience = "https://other.example.com"
+		_, err := sp.consumeResponse(provider, idp.sign(idp.response(a), false))
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong recipient", func(t *testing.T) {
+		a := pending("assertion-8")
+		a.recipient = "https://other.example.com/acs"
+		_, err := sp.consumeResponse(provider, idp.sign(idp.response(a), false))
+		assert.Error(t, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		a := pending("assertion-9")
+		a.notOnOrAfter = time.Now().Add(-10 * time.Minute)
+		_, err := sp.consumeResponse(provider, idp.sign(idp.response(a), false))
+		assert.Error(t, err)
+	})
+
+	t.Run("unsolicited", func(t *testing.T) {
+		a := pending("assertion-10")
+		a.inResponseTo = "id-unknown"
+		_, err := sp.consumeResponse(provider, idp.sign(idp.response(a), false))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,170 @@
+package usecase
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+// XML namespaces and algorithms used by SAML and XML Signature
+const (
+	xmldsigNamespace     = dsig.Namespace
+	algRSASHA256         = dsig.RSASHA256SignatureMethod
+	xmlMaxDocumentLength = 1 << 20
+)
+
+// allowedSignatureMethods and allowedDigestMethods are the algorithms accepted
+// on signatures of identity providers. goxmldsig also verifies SHA-1, which
+// is deliberately not accepted.
+var (
+	allowedSignatureMethods = map[string]bool{
+		dsig.RSASHA256SignatureMethod: true,
+		dsig.RSASHA512SignatureMethod: true,
+	}
+	allowedDigestMethods = map[string]bool{
+		"http://www.w3.org/2001/04/xmlenc#sha256": true,
+		"http://www.w3.org/2001/04/xmlenc#sha512": true,
+	}
+)
+
+// parseXML parses a document and returns its root element. Documents with a
+// DTD are rejected.
+func parseXML(data []byte) (*etree.Element, error) {
+	if len(data) > xmlMaxDocumentLength {
+		return nil, errors.New("XML document too large")
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XML: %w", err)
+		}
+		if _, ok := token.(xml.Directive); ok {
+			return nil, errors.New("invalid XML: DTDs are not allowed")
+		}
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, fmt.Errorf("invalid XML: %w", err)
+	}
+	if doc.Root() == nil {
+		return nil, errors.New("invalid XML: no root element")
+	}
+	return doc.Root(), nil
+}
+
+// childElements returns the child elements named local in namespace ns
+func childElements(element *etree.Element, ns, local string) []*etree.Element {
+	var result []*etree.Element
+	for _, child := range element.ChildElements() {
+		if child.Tag == local && child.NamespaceURI() == ns {
+			result = append(result, child)
+		}
+	}
+	return result
+}
+
+// detachXML returns a copy of the element that declares the namespaces it
+// inherits from its ancestors
+func detachXML(element *etree.Element) (*etree.Element, error) {
+	ctx, err := etreeutils.NSBuildParentContext(element)
+	if err != nil {
+		return nil, err
+	}
+	return etreeutils.NSDetatch(ctx, element)
+}
+
+// writeXML serializes the element as a document of its own
+func writeXML(element *etree.Element) ([]byte, error) {
+	detached, err := detachXML(element)
+	if err != nil {
+		return nil, err
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(detached)
+	return doc.WriteToBytes()
+}
+
+// verifyXMLSignature checks the enveloped signature of the element against the
+// trusted certificates at now and returns the bytes that were signed. Callers
+// must read data only from the returned bytes, never from the original tree.
+func verifyXMLSignature(element *etree.Element, certs []*x509.Certificate, now time.Time) ([]byte, error) {
+	signatures := childElements(element, xmldsigNamespace, "Signature")
+	if len(signatures) != 1 {
+		return nil, errors.New("element is not signed")
+	}
+	if method := signatures[0].FindElement("./SignedInfo/SignatureMethod"); method == nil || !allowedSignatureMethods[method.SelectAttrValue("Algorithm", "")] {
+		return nil, errors.New("unsupported signature method")
+	}
+	for _, method := range signatures[0].FindElements("./SignedInfo/Reference/DigestMethod") {
+		if !allowedDigestMethods[method.SelectAttrValue("Algorithm", "")] {
+			return nil, errors.New("unsupported digest method")
+		}
+	}
+
+	detached, err := detachXML(element)
+	if err != nil {
+		return nil, err
+	}
+	validation := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certs})
+	validation.Clock = dsig.NewFakeClockAt(now)
+	signed, err := validation.Validate(detached)
+	if err != nil {
+		return nil, err
+	}
+	return writeXML(signed)
+}
+
+// signXML returns a copy of the element with an enveloped RSA-SHA256
+// signature, inserted after the element's first child element (the SAML
+// Issuer) as the schema requires
+func signXML(element *etree.Element, key *rsa.PrivateKey, cert *x509.Certificate) (*etree.Element, error) {
+	ctx, err := dsig.NewSigningContext(key, [][]byte{cert.Raw})
+	if err != nil {
+		return nil, err
+	}
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	if err := ctx.SetSignatureMethod(dsig.RSASHA256SignatureMethod); err != nil {
+		return nil, err
+	}
+	signed, err := ctx.SignEnveloped(element)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	signature := signed.RemoveChildAt(len(signed.Child) - 1)
+	position := len(signed.Child)
+	if children := signed.ChildElements(); len(children) > 0 {
+		position = children[0].Index() + 1
+	}
+	signed.InsertChildAt(position, signature)
+	return signed, nil
+}
+
+// escapeXML escapes text for use in character data and attribute values
+func escapeXML(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// decodeXMLBase64 decodes base64 content that may be wrapped over several lines
+func decodeXMLBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,233 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+)
+
+// Custom errors
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Page sizes of the security event feed
+const (
+	defaultSecurityEventLimit = 20
+	maxSecurityEventLimit     = 100
+)
+
+// maxUserAgentLength is the size of the security_events.user_agent column
+const maxUserAgentLength = 512
+
+// failureReasons are the reasons recorded for failed sign-ins and refreshes,
+// the error codes of their responses. Other errors aren't the user's attempt
+// failing, they aren't recorded.
+var failureReasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrSignInNotAllowed, "sign_in_not_allowed"},
+	{ErrAccountDeactivated, "account_deactivated"},
+	{ErrAccountSuspended, "account_suspended"},
+	{ErrAccountBanned, "account_banned"},
+	{ErrAccountPending, "account_pending"},
+	{ErrInvalidDPoPProof, "invalid_dpop_proof"},
+	{ErrDPoPProofRequired, "invalid_dpop_proof"},
+	{ErrTokenExpired, "session_expired"},
+	{ErrInvalidToken, "invalid_token"},
+}
+
+// failureReason returns the recorded reason of a failed attempt, empty for
+// errors that aren't recorded
+func failureReason(err error) string {
+	for _, failure := range failureReasons {
+		if errors.Is(err, failure.err) {
+			return failure.reason
+		}
+	}
+	return ""
+}
+
+// SecurityEventRecorder stores the security events of users and notifies the
+// subscribers of the modules. A nil recorder records nothing.
+type SecurityEventRecorder struct {
+	repo        domain.SecurityEventRepository
+	subscribers []domain.SecurityEventSubscriber
+	now         func() time.Time
+}
+
+// NewSecurityEventRecorder creates the recorder shared by the usecases that
+// sign users in and end their sessions
+func NewSecurityEventRecorder(repo domain.SecurityEventRepository, modules ...[]domain.SecurityEventSubscriber) *SecurityEventRecorder {
+	recorder := &SecurityEventRecorder{repo: repo, now: time.Now}
+	for _, subscribers := range modules {
+		recorder.subscribers = append(recorder.subscribers, subscribers...)
+	}
+	return recorder
+}
+
+// Record completes the event with the client of the request and stores it.
+// Successful sign-ins from a device the user hadn't signed in from before are
+// flagged as new devices, a user's first sign-in isn't.
+func (r *SecurityEventRecorder) Record(ctx context.Context, event *domain.SecurityEvent) error {
+	if r == nil {
+		return nil
+	}
+
+	info := domain.RequestInfoFromContext(ctx)
+	event.ID = uuid.New()
+	// Postgres keeps microseconds, cursors must match the stored time
+	event.CreatedAt = r.now().Truncate(time.Microsecond)
+	event.IPAddress = info.IPAddress
+	event.UserAgent = truncate(info.UserAgent, maxUserAgentLength)
+	event.Device = deviceHash(event.DeviceID, info.UserAgent)
+
+	if event.Type == domain.SecurityEventLogin && event.Outcome == domain.SecurityOutcomeSuccess {
+		newDevice, err := r.isNewDevice(event.UserID, event.Device)
+		if err != nil {
+			return err
+		}
+		event.NewDevice = newDevice
+	}
+
+	if err := r.repo.Create(event); err != nil {
+		return fmt.Errorf("failed to record security event: %w", err)
+	}
+	for _, subscriber := range r.subscribers {
+		subscriber.Notify(ctx, event)
+	}
+	return nil
+}
+
+// recordFailure records a failed attempt of the user, if err is a recorded
+// failure, and returns err. The attempt already failed, an error recording it
+// isn't reported instead.
+func (r *SecurityEventRecorder) recordFailure(ctx context.Context, event *domain.SecurityEvent, err error) error {
+	event.Outcome = domain.SecurityOutcomeFailure
+	event.Reason = failureReason(err)
+	if event.Reason != "" && event.UserID != uuid.Nil {
+		_ = r.Record(ctx, event)
+	}
+	return err
+}
+
+func (r *SecurityEventRecorder) isNewDevice(userID uuid.UUID, device string) (bool, error) {
+	if device == "" {
+		return false, nil
+	}
+	known, err := r.repo.HasLogin(userID, device)
+	if err != nil {
+		return false, fmt.Errorf("failed to find device: %w", err)
+	}
+	if known {
+		return false, nil
+	}
+	signedIn, err := r.repo.HasLogin(userID, "")
+	if err != nil {
+		return false, fmt.Errorf("failed to find logins: %w", err)
+	}
+	return signedIn, nil
+}
+
+// deviceHash identifies the device of an event by its device ID, or by its
+// user agent for apps and browsers that don't send one
+func deviceHash(deviceID, userAgent string) string {
+	key := "device:" + deviceID
+	if deviceID == "" {
+		if userAgent == "" {
+			return ""
+		}
+		key = "user-agent:" + userAgent
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+type securityEventUsecase struct {
+	repo domain.SecurityEventRepository
+}
+
+// NewSecurityEventUsecase creates the usecase behind the security event feeds
+// of users and admins
+func NewSecurityEventUsecase(repo domain.SecurityEventRepository) domain.SecurityEventUsecase {
+	return &securityEventUsecase{repo: repo}
+}
+
+func (u *securityEventUsecase) ListEvents(ctx context.Context, userID uuid.UUID, cursor string, limit int) (*domain.SecurityEventPage, error) {
+	if limit < 1 {
+		limit = defaultSecurityEventLimit
+	}
+	if limit > maxSecurityEventLimit {
+		limit = maxSecurityEventLimit
+	}
+
+	var after *domain.SecurityEventCursor
+	if cursor != "" {
+		decoded, err := decodeSecurityEventCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = decoded
+	}
+
+	// One more event than requested tells whether there's a next page
+	events, err := u.repo.ListByUser(userID, after, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list security events: %w", err)
+	}
+
+	page := &domain.SecurityEventPage{Events: events}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		last := page.Events[limit-1]
+		page.NextCursor = encodeSecurityEventCursor(domain.SecurityEventCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	if page.Events == nil {
+		page.Events = []*domain.SecurityEvent{}
+	}
+	return page, nil
+}
+
+// encodeSecurityEventCursor returns the opaque cursor clients pass back, the
+// event's time in microseconds and ID
+func encodeSecurityEventCursor(cursor domain.SecurityEventCursor) string {
+	value := strconv.FormatInt(cursor.CreatedAt.UnixMicro(), 10) + "." + cursor.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func decodeSecurityEventCursor(cursor string) (*domain.SecurityEventCursor, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	micros, id, ok := strings.Cut(string(value), ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	createdAt, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	eventID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &domain.SecurityEventCursor{CreatedAt: time.UnixMicro(createdAt), ID: eventID}, nil
+}
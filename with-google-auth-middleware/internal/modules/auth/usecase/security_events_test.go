@@ -0,0 +1,189 @@
+package usecase
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// fakeSecurityEventRepository keeps events in memory
+type fakeSecurityEventRepository struct {
+	events []*domain.SecurityEvent
+}
+
+func (r *fakeSecurityEventRepository) Create(event *domain.SecurityEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *fakeSecurityEventRepository) ListByUser(userID uuid.UUID, after *domain.SecurityEventCursor, limit int) ([]*domain.SecurityEvent, error) {
+	var events []*domain.SecurityEvent
+	for _, event := range r.events {
+		if event.UserID != userID {
+			continue
+		}
+		if after != nil && !before(event, after) {
+			continue
+		}
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return !before(events[i], &domain.SecurityEventCursor{CreatedAt: events[j].CreatedAt, ID: events[j].ID})
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// before reports whether the event is older than the cursor, like the row comparison of the repository
+func before(event *domain.SecurityEvent, cursor *domain.SecurityEventCursor) bool {
+	if !event.CreatedAt.Equal(cursor.CreatedAt) {
+		return event.CreatedAt.Before(cursor.CreatedAt)
+	}
+	return event.ID.String() < cursor.ID.String()
+}
+
+func (r *fakeSecurityEventRepository) HasLogin(userID uuid.UUID, device string) (bool, error) {
+	for _, event := range r.events {
+		if event.UserID == userID && event.Type == domain.SecurityEventLogin && event.Outcome == domain.SecurityOutcomeSuccess &&
+			(device == "" || event.Device == device) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestSecurityEventRecording(t *testing.T) {
+	eventRepo := &fakeSecurityEventRepository{}
+	var notified []*domain.SecurityEvent
+	recorder := NewSecurityEventRecorder(eventRepo, []domain.SecurityEventSubscriber{{
+		Name: "test",
+		Notify: func(ctx context.Context, event *domain.SecurityEvent) {
+			notified = append(notified, event)
+		},
+	}})
+
+	authRepo := &fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{}}
+	userRepo := &guestUserRepository{fakeUserRepository: fakeUserRepository{users: map[uuid.UUID]*userdomain.User{}}}
+	u := NewAuthUsecase(authRepo, userRepo, noInvitations{}, &fakeIdentityRepository{}, AuthUsecaseConfig{
+		JWTSecret:      "test-secret",
+		TokenConfig:    TokenConfig{AccessTTL: 15 * time.Minute, RefreshTTL: 24 * time.Hour},
+		SecurityEvents: recorder,
+	}).(*authUsecase)
+
+	from := func(userAgent string) context.Context {
+		return domain.WithRequestInfo(context.Background(), domain.RequestInfo{IPAddress: "203.0.113.7", UserAgent: userAgent})
+	}
+	login := func(userAgent string) *domain.AuthToken {
+		t.Helper()
+		token, err := u.loginWithIdentity(from(userAgent), &domain.ExternalIdentity{
+			Provider:      domain.ProviderGoogle,
+			Subject:       "google-alice",
+			Email:         "alice@example.com",
+			EmailVerified: true,
+		}, nil, "", nil)
+		require.NoError(t, err)
+		return token
+	}
+	last := func() *domain.SecurityEvent {
+		require.NotEmpty(t, eventRepo.events)
+		return eventRepo.events[len(eventRepo.events)-1]
+	}
+
+	token := login("Phone")
+	event := last()
+	assert.Equal(t, domain.SecurityEventLogin, event.Type)
+	assert.Equal(t, domain.SecurityOutcomeSuccess, event.Outcome)
+	assert.Equal(t, domain.ProviderGoogle, event.Provider)
+	assert.Equal(t, "203.0.113.7", event.IPAddress)
+	assert.Equal(t, "Phone", event.UserAgent)
+	assert.NotNil(t, event.SessionID)
+	assert.False(t, event.NewDevice, "the first sign-in isn't a new device")
+	userID := event.UserID
+
+	login("Phone")
+	assert.False(t, last().NewDevice)
+	login("Laptop")
+	assert.True(t, last().NewDevice)
+	assert.Len(t, notified, 3)
+	assert.True(t, notified[2].NewDevice)
+
+	_, err := u.RefreshToken(from("Phone"), token.RefreshToken, domain.DPoPProofRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, domain.SecurityEventRefresh, last().Type)
+	assert.Equal(t, domain.SecurityOutcomeSuccess, last().Outcome)
+
+	t.Run("failed refresh", func(t *testing.T) {
+		until := time.Now().Add(time.Hour)
+		userRepo.users[userID].Status = userdomain.StatusSuspended
+		userRepo.users[userID].SuspendedUntil = &until
+		defer func() { userRepo.users[userID].Status = userdomain.StatusActive }()
+
+		_, err := u.RefreshToken(from("Phone"), token.RefreshToken, domain.DPoPProofRequest{})
+		assert.ErrorIs(t, err, ErrAccountSuspended)
+		assert.Equal(t, domain.SecurityEventRefresh, last().Type)
+		assert.Equal(t, domain.SecurityOutcomeFailure, last().Outcome)
+		assert.Equal(t, "account_suspended", last().Reason)
+	})
+
+	t.Run("unknown refresh token isn't recorded", func(t *testing.T) {
+		count := len(eventRepo.events)
+		_, err := u.RefreshToken(from("Phone"), "unknown", domain.DPoPProofRequest{})
+		assert.Error(t, err)
+		assert.Len(t, eventRepo.events, count)
+	})
+
+	require.NoError(t, u.Logout(from("Phone"), userID))
+	assert.Equal(t, domain.SecurityEventLogout, last().Type)
+	assert.Equal(t, userID, last().UserID)
+}
+
+func TestListSecurityEvents(t *testing.T) {
+	eventRepo := &fakeSecurityEventRepository{}
+	userID := uuid.New()
+	start := time.Now().Truncate(time.Microsecond)
+	for i := 0; i < 5; i++ {
+		eventRepo.events = append(eventRepo.events, &domain.SecurityEvent{
+			ID:        uuid.New(),
+			UserID:    userID,
+			Type:      domain.SecurityEventRefresh,
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+		})
+	}
+	eventRepo.events = append(eventRepo.events, &domain.SecurityEvent{ID: uuid.New(), UserID: uuid.New(), CreatedAt: start})
+	u := NewSecurityEventUsecase(eventRepo)
+	ctx := context.Background()
+
+	var seen []*domain.SecurityEvent
+	cursor := ""
+	for pages := 1; ; pages++ {
+		page, err := u.ListEvents(ctx, userID, cursor, 2)
+		require.NoError(t, err)
+		seen = append(seen, page.Events...)
+		if page.NextCursor == "" {
+			assert.Equal(t, 3, pages)
+			break
+		}
+		cursor = page.NextCursor
+	}
+	require.Len(t, seen, 5)
+	for i, event := range seen {
+		assert.Equal(t, eventRepo.events[4-i], event, "newest first")
+	}
+
+	page, err := u.ListEvents(ctx, uuid.New(), "", 0)
+	require.NoError(t, err)
+	assert.NotNil(t, page.Events)
+	assert.Empty(t, page.NextCursor)
+
+	_, err = u.ListEvents(ctx, userID, "not a cursor", 0)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
@@ -0,0 +1,80 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"gorm.io/gorm"
+)
+
+// defaultSessionTTL is how long a looked up session is used when no TTL is configured
+const defaultSessionTTL = 30 * time.Second
+
+// sessionCache looks up the sessions of access tokens for AuthMiddleware and
+// keeps the result for a short time, like accountStatusCache. Revoking a
+// session takes effect at the latest after the TTL. A revoked session never
+// comes back, so that result is kept for the TTL as well.
+type sessionCache struct {
+	authRepo domain.AuthRepository
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	entries   map[uuid.UUID]sessionEntry
+	nextPrune time.Time
+}
+
+type sessionEntry struct {
+	active    bool
+	expiresAt time.Time
+}
+
+// NewSessionCache returns the session lookup of AuthMiddleware. Results are
+// cached for ttl, 30 seconds when zero.
+func NewSessionCache(authRepo domain.AuthRepository, ttl time.Duration) domain.SessionLookup {
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	return &sessionCache{
+		authRepo: authRepo,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  map[uuid.UUID]sessionEntry{},
+	}
+}
+
+func (c *sessionCache) SessionActive(ctx context.Context, sessionID uuid.UUID) (bool, error) {
+	now := c.now()
+	c.mu.Lock()
+	entry, ok := c.entries[sessionID]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.active, nil
+	}
+
+	active := true
+	if _, err := c.authRepo.GetSessionByID(sessionID); err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, fmt.Errorf("failed to find session: %w", err)
+		}
+		active = false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.After(c.nextPrune) {
+		for id, cached := range c.entries {
+			if !now.Before(cached.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+		c.nextPrune = now.Add(time.Minute)
+	}
+	c.entries[sessionID] = sessionEntry{active: active, expiresAt: now.Add(c.ttl)}
+	return active, nil
+}
@@ -0,0 +1,45 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+)
+
+func TestSessionCache(t *testing.T) {
+	session := &domain.Session{ID: uuid.New(), UserID: uuid.New()}
+	repo := &fakeAuthRepository{sessions: map[uuid.UUID]*domain.Session{session.ID: session}}
+	now := time.Now()
+	cache := NewSessionCache(repo, time.Minute).(*sessionCache)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	active, err := cache.SessionActive(ctx, session.ID)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	t.Run("cached", func(t *testing.T) {
+		require.NoError(t, repo.DeleteSession(session.ID))
+		active, err := cache.SessionActive(ctx, session.ID)
+		require.NoError(t, err)
+		assert.True(t, active)
+	})
+
+	t.Run("looked up again after the TTL", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		active, err := cache.SessionActive(ctx, session.ID)
+		require.NoError(t, err)
+		assert.False(t, active)
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		active, err := cache.SessionActive(ctx, uuid.New())
+		require.NoError(t, err)
+		assert.False(t, active)
+	})
+}
@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+)
+
+// SignInPolicy restricts which accounts may sign in.
+//
+// Deny patterns always win. When AllowedHostedDomains or AllowedEmailPatterns
+// are set, an account must match at least one of them; when both are empty,
+// every account that passes the other checks is allowed.
+type SignInPolicy struct {
+	// AllowedHostedDomains lists Google Workspace domains matched against the hd claim
+	AllowedHostedDomains []string
+	// AllowedEmailPatterns lists glob patterns such as "*@example.com"
+	AllowedEmailPatterns []string
+	// DeniedEmailPatterns lists glob patterns that are never allowed to sign in
+	DeniedEmailPatterns []string
+	// RequireVerifiedEmail rejects accounts whose email_verified claim is false
+	RequireVerifiedEmail bool
+}
+
+// Check returns an error wrapping ErrSignInNotAllowed when the account is rejected
+func (p SignInPolicy) Check(identity *domain.ExternalIdentity) error {
+	email := strings.ToLower(identity.Email)
+
+	if p.RequireVerifiedEmail && !identity.EmailVerified {
+		return fmt.Errorf("%w: email %s is not verified", ErrSignInNotAllowed, email)
+	}
+
+	if matchAny(p.DeniedEmailPatterns, email) {
+		return fmt.Errorf("%w: email %s is denied", ErrSignInNotAllowed, email)
+	}
+
+	if len(p.AllowedHostedDomains) == 0 && len(p.AllowedEmailPatterns) == 0 {
+		return nil
+	}
+
+	hostedDomain := strings.ToLower(identity.HostedDomain)
+	for _, allowed := range p.AllowedHostedDomains {
+		if hostedDomain != "" && hostedDomain == strings.ToLower(allowed) {
+			return nil
+		}
+	}
+	if matchAny(p.AllowedEmailPatterns, email) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: email %s is not in an allowed domain", ErrSignInNotAllowed, email)
+}
+
+// matchAny reports whether the value matches one of the case-insensitive glob patterns
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(strings.ToLower(pattern), value); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
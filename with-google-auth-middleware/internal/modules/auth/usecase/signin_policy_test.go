@@ -0,0 +1,45 @@
+package usecase
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+)
+
+func TestSignInPolicyCheck(t *testing.T) {
+	policy := SignInPolicy{
+		AllowedHostedDomains: []string{"example.com"},
+		AllowedEmailPatterns: []string{"*@partner.io"},
+		DeniedEmailPatterns:  []string{"intern-*@example.com"},
+		RequireVerifiedEmail: true,
+	}
+
+	tests := []struct {
+		name     string
+		policy   SignInPolicy
+		identity domain.ExternalIdentity
+		allowed  bool
+	}{
+		{"workspace account", policy, domain.ExternalIdentity{Email: "alice@example.com", HostedDomain: "example.com", EmailVerified: true}, true},
+		{"hosted domain is case insensitive", policy, domain.ExternalIdentity{Email: "alice@example.com", HostedDomain: "Example.COM", EmailVerified: true}, true},
+		{"allowed email pattern", policy, domain.ExternalIdentity{Email: "Bob@Partner.io", EmailVerified: true}, true},
+		{"consumer account", policy, domain.ExternalIdentity{Email: "carol@gmail.com", EmailVerified: true}, false},
+		{"email domain without hd claim", policy, domain.ExternalIdentity{Email: "dave@example.com", EmailVerified: true}, false},
+		{"denied pattern wins", policy, domain.ExternalIdentity{Email: "intern-eve@example.com", HostedDomain: "example.com", EmailVerified: true}, false},
+		{"unverified email", policy, domain.ExternalIdentity{Email: "alice@example.com", HostedDomain: "example.com"}, false},
+		{"empty policy allows everyone", SignInPolicy{}, domain.ExternalIdentity{Email: "carol@gmail.com"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(&tt.identity)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrSignInNotAllowed))
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
+	"gorm.io/gorm"
+)
+
+// Custom errors
+var (
+	ErrUnauthorizedClient = errors.New("client is not allowed to use this grant type")
+	ErrInvalidTarget      = errors.New("invalid audience")
+)
+
+// defaultExchangedTokenTTL is the lifetime of exchanged tokens when the policy doesn't set one
+const defaultExchangedTokenTTL = 5 * time.Minute
+
+// exchangedTokenType is the JWT typ of exchanged tokens (RFC 9068), so that
+// services don't mistake an ID token signed with the same key for an access token
+const exchangedTokenType = "at+jwt"
+
+// TokenExchangePolicy lets an OAuth client exchange the tokens it receives for
+// tokens of downstream services (RFC 8693)
+type TokenExchangePolicy struct {
+	// ClientID is the confidential OAuth client allowed to exchange tokens
+	ClientID string
+	// Audiences are the services the client may request tokens for
+	Audiences []string
+	// Scopes limits the scopes of exchanged tokens, when empty every scope of
+	// the subject token may be kept
+	Scopes []string
+	// TokenTTL is the lifetime of exchanged tokens, 5 minutes when zero
+	TokenTTL time.Duration
+}
+
+// exchangeToken issues a token for a downstream service on behalf of the user
+// of a token the client received. The new token is restricted to one audience,
+// carries at most the scopes of the subject token and names the client in its
+// act claim, nested below the actors of earlier exchanges.
+func (s *authorizationServer) exchangeToken(client *domain.OAuthClient, req domain.TokenRequest) (*domain.TokenResponse, error) {
+	policy, ok := s.exchangePolicies[client.ClientID]
+	if !ok || client.Public {
+		return nil, ErrUnauthorizedClient
+	}
+	if req.SubjectToken == "" || req.SubjectTokenType != domain.TokenTypeAccessToken {
+		return nil, fmt.Errorf("%w: subject_token must be an access token", ErrInvalidRequest)
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != domain.TokenTypeAccessToken {
+		return nil, fmt.Errorf("%w: only access tokens can be requested", ErrInvalidRequest)
+	}
+	if req.Audience == "" || !containsString(policy.Audiences, req.Audience) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTarget, req.Audience)
+	}
+
+	subject, err := s.parseSubjectToken(client, req.SubjectToken)
+	if err != nil {
+		return nil, err
+	}
+	scopes, err := exchangeScopes(policy, strings.Fields(authn.StringClaim(subject, "scope")), strings.Fields(req.Scope))
+	if err != nil {
+		return nil, err
+	}
+
+	// Like any access token, the subject token dies with its session and user
+	sessionID, err := uuid.Parse(authn.StringClaim(subject, "sid"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: subject token has no session", ErrInvalidGrant)
+	}
+	if _, err := s.authRepo.GetSessionByID(sessionID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: session not found", ErrInvalidGrant)
+		}
+		return nil, fmt.Errorf("failed to find session: %w", err)
+	}
+	userID, err := uuid.Parse(authn.StringClaim(subject, "sub"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidGrant, err)
+	}
+	if _, err := s.activeUser(userID); err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	ttl := policy.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultExchangedTokenTTL
+	}
+	expiresAt := now.Add(ttl)
+	// An exchanged token never outlives the token it was exchanged for
+	if exp, err := subject.GetExpirationTime(); err == nil && exp != nil && exp.Before(expiresAt) {
+		expiresAt = exp.Time
+	}
+
+	act := map[string]interface{}{"sub": client.ClientID}
+	if prior, ok := subject["act"].(map[string]interface{}); ok {
+		act["act"] = prior
+	}
+	claims := jwt.MapClaims{
+		"iss":       s.issuer,
+		"sub":       userID.String(),
+		"aud":       req.Audience,
+		"exp":       expiresAt.Unix(),
+		"iat":       now.Unix(),
+		"jti":       uuid.New().String(),
+		"sid":       sessionID.String(),
+		"client_id": client.ClientID,
+		"scope":     strings.Join(scopes, " "),
+		"act":       act,
+	}
+	for _, name := range []string{"role", "grant_id"} {
+		if value := authn.StringClaim(subject, name); value != "" {
+			claims[name] = value
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	token.Header["typ"] = exchangedTokenType
+	accessToken, err := token.SignedString(s.signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign exchanged token: %w", err)
+	}
+	return &domain.TokenResponse{
+		AccessToken:     accessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(expiresAt.Sub(now).Seconds()),
+		Scope:           strings.Join(scopes, " "),
+		IssuedTokenType: domain.TokenTypeAccessToken,
+	}, nil
+}
+
+// parseSubjectToken verifies the token to exchange. Access tokens of the API
+// are accepted from every client allowed to exchange tokens. Tokens of an
+// earlier exchange are only accepted from the service they were issued for,
+// which continues the delegation chain.
+func (s *authorizationServer) parseSubjectToken(client *domain.OAuthClient, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			return s.jwtSecret, nil
+		case *jwt.SigningMethodRSA:
+			if token.Header["typ"] != exchangedTokenType || s.keyErr != nil {
+				return nil, jwt.ErrTokenUnverifiable
+			}
+			return &s.signingKey.PublicKey, nil
+		}
+		return nil, jwt.ErrSignatureInvalid
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid subject token: %v", ErrInvalidGrant, err)
+	}
+
+	if _, ok := parsed.Method.(*jwt.SigningMethodRSA); ok {
+		audience, _ := claims.GetAudience()
+		if authn.StringClaim(claims, "iss") != s.issuer || !containsString(audience, client.ClientID) {
+			return nil, fmt.Errorf("%w: subject token was issued for another audience", ErrInvalidGrant)
+		}
+	}
+	return claims, nil
+}
+
+// exchangeScopes returns the scopes of an exchanged token. Requested scopes
+// must be held by the subject token and allowed by the policy, without a
+// request every scope both allow is kept.
+func exchangeScopes(policy TokenExchangePolicy, subjectScopes, requested []string) ([]string, error) {
+	allowed := func(scope string) bool {
+		return hasScope(subjectScopes, scope) && (len(policy.Scopes) == 0 || hasScope(policy.Scopes, scope))
+	}
+
+	if len(requested) == 0 {
+		scopes := []string{}
+		for _, scope := range subjectScopes {
+			if allowed(scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+		return scopes, nil
+	}
+
+	scopes := []string{}
+	for _, scope := range requested {
+		if !allowed(scope) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+		if !hasScope(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
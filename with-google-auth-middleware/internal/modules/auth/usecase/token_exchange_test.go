@@ -0,0 +1,203 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/pkg/authn"
+)
+
+// addInternalClient registers a confidential internal client with the secret "secret"
+func (s *testAuthorizationServer) addInternalClient(clientID string) {
+	s.authRepo.clients[clientID] = &domain.OAuthClient{
+		ID:         uuid.New(),
+		ClientID:   clientID,
+		SecretHash: HashClientSecret("secret"),
+		Name:       clientID,
+		Internal:   true,
+	}
+}
+
+// userAccessToken signs an access token of our own apps for a new session of the user
+func (s *testAuthorizationServer) userAccessToken(t *testing.T, scope string, ttl time.Duration) (string, *domain.Session) {
+	t.Helper()
+	session := &domain.Session{ID: uuid.New(), UserID: s.user.ID, RefreshToken: uuid.NewString(), ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, s.authRepo.CreateSession(session))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   s.user.ID.String(),
+		"exp":   time.Now().Add(ttl).Unix(),
+		"iat":   time.Now().Unix(),
+		"sid":   session.ID.String(),
+		"role":  s.user.Role,
+		"scope": scope,
+	}).SignedString(s.jwtSecret)
+	require.NoError(t, err)
+	return token, session
+}
+
+func (s *testAuthorizationServer) exchange(clientID, subjectToken, audience, scope string) (*domain.TokenResponse, error) {
+	return s.Token(context.Background(), domain.TokenRequest{
+		GrantType:        domain.GrantTypeTokenExchange,
+		ClientID:         clientID,
+		ClientSecret:     "secret",
+		SubjectToken:     subjectToken,
+		SubjectTokenType: domain.TokenTypeAccessToken,
+		Audience:         audience,
+		Scope:            scope,
+	})
+}
+
+// verifyExchangedToken checks the signature of an exchanged token with the published keys
+func (s *testAuthorizationServer) verifyExchangedToken(t *testing.T, token, audience string) jwt.MapClaims {
+	t.Helper()
+	keys, err := s.JSONWebKeySet()
+	require.NoError(t, err)
+	publicKey, err := jwkPublicKey(keys.Keys[0])
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	}, jwt.WithIssuer("https://api.example.com"), jwt.WithAudience(audience))
+	require.NoError(t, err)
+	assert.Equal(t, "at+jwt", parsed.Header["typ"])
+	return claims
+}
+
+func newTestTokenExchange(t *testing.T) *testAuthorizationServer {
+	s := newTestAuthorizationServer(t)
+	s.addInternalClient("api-gateway")
+	s.addInternalClient("billing")
+	s.addInternalClient("reports")
+	s.exchangePolicies = map[string]TokenExchangePolicy{
+		"api-gateway": {ClientID: "api-gateway", Audiences: []string{"billing"}, Scopes: []string{"users:read", "billing:read"}},
+		"billing":     {ClientID: "billing", Audiences: []string{"ledger"}, TokenTTL: time.Minute},
+	}
+	return s
+}
+
+func TestTokenExchange(t *testing.T) {
+	s := newTestTokenExchange(t)
+	subjectToken, session := s.userAccessToken(t, "users:read users:write billing:read", 15*time.Minute)
+
+	exchanged, err := s.exchange("api-gateway", subjectToken, "billing", "")
+	require.NoError(t, err)
+	assert.Equal(t, domain.TokenTypeAccessToken, exchanged.IssuedTokenType)
+	assert.Equal(t, "users:read billing:read", exchanged.Scope)
+	assert.Equal(t, int64(defaultExchangedTokenTTL.Seconds()), exchanged.ExpiresIn)
+
+	claims := s.verifyExchangedToken(t, exchanged.AccessToken, "billing")
+	assert.Equal(t, s.user.ID.String(), claims["sub"])
+	assert.Equal(t, session.ID.String(), claims["sid"])
+	assert.Equal(t, "user", claims["role"])
+	assert.Equal(t, map[string]interface{}{"sub": "api-gateway"}, claims["act"])
+
+	t.Run("verified by the service with its key set", func(t *testing.T) {
+		keys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			set, err := s.JSONWebKeySet()
+			require.NoError(t, err)
+			json.NewEncoder(w).Encode(set)
+		}))
+		defer keys.Close()
+		verifier, err := authn.New(authn.Config{JWKSURL: keys.URL, Issuer: "https://api.example.com", Audience: "billing"})
+		require.NoError(t, err)
+
+		principal, err := verifier.Verify(context.Background(), exchanged.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, s.user.ID, principal.UserID)
+		assert.Equal(t, session.ID, principal.SessionID)
+		assert.Equal(t, "api-gateway", principal.Actor)
+		assert.True(t, principal.HasScope("billing:read"))
+
+		// Tokens of the API's own apps are signed with its secret, not its keys
+		_, err = verifier.Verify(context.Background(), subjectToken)
+		assert.ErrorIs(t, err, authn.ErrInvalidToken)
+	})
+
+	t.Run("not accepted as an access token of the API", func(t *testing.T) {
+		_, err := parseAccessToken(s.jwtSecret, exchanged.AccessToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("requested scopes", func(t *testing.T) {
+		token, err := s.exchange("api-gateway", subjectToken, "billing", "billing:read")
+		require.NoError(t, err)
+		assert.Equal(t, "billing:read", token.Scope)
+
+		_, err = s.exchange("api-gateway", subjectToken, "billing", "users:write")
+		assert.ErrorIs(t, err, ErrInvalidScope)
+	})
+
+	t.Run("delegation chain", func(t *testing.T) {
+		chained, err := s.exchange("billing", exchanged.AccessToken, "ledger", "")
+		require.NoError(t, err)
+		assert.Equal(t, int64(time.Minute.Seconds()), chained.ExpiresIn)
+
+		claims := s.verifyExchangedToken(t, chained.AccessToken, "ledger")
+		assert.Equal(t, map[string]interface{}{
+			"sub": "billing",
+			"act": map[string]interface{}{"sub": "api-gateway"},
+		}, claims["act"])
+	})
+
+	t.Run("exchanged token presented by another service", func(t *testing.T) {
+		s.exchangePolicies["reports"] = TokenExchangePolicy{ClientID: "reports", Audiences: []string{"ledger"}}
+		defer delete(s.exchangePolicies, "reports")
+		_, err := s.exchange("reports", exchanged.AccessToken, "ledger", "")
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+
+	t.Run("audience not allowed", func(t *testing.T) {
+		_, err := s.exchange("api-gateway", subjectToken, "ledger", "")
+		assert.ErrorIs(t, err, ErrInvalidTarget)
+	})
+
+	t.Run("client without policy", func(t *testing.T) {
+		_, err := s.exchange("reports", subjectToken, "billing", "")
+		assert.ErrorIs(t, err, ErrUnauthorizedClient)
+	})
+
+	t.Run("wrong client secret", func(t *testing.T) {
+		_, err := s.Token(context.Background(), domain.TokenRequest{
+			GrantType:    domain.GrantTypeTokenExchange,
+			ClientID:     "api-gateway",
+			ClientSecret: "wrong",
+		})
+		assert.ErrorIs(t, err, ErrInvalidClient)
+	})
+
+	t.Run("missing subject token type", func(t *testing.T) {
+		_, err := s.Token(context.Background(), domain.TokenRequest{
+			GrantType:    domain.GrantTypeTokenExchange,
+			ClientID:     "api-gateway",
+			ClientSecret: "secret",
+			SubjectToken: subjectToken,
+			Audience:     "billing",
+		})
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+
+	t.Run("revoked session", func(t *testing.T) {
+		require.NoError(t, s.authRepo.DeleteSession(session.ID))
+		_, err := s.exchange("api-gateway", subjectToken, "billing", "")
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+}
+
+func TestTokenExchangeNeverOutlivesSubjectToken(t *testing.T) {
+	s := newTestTokenExchange(t)
+	subjectToken, _ := s.userAccessToken(t, "users:read", time.Minute)
+
+	exchanged, err := s.exchange("api-gateway", subjectToken, "billing", "")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, exchanged.ExpiresIn, int64(time.Minute.Seconds()))
+}
@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Document is the current version of a legal document users consent to,
+// e.g. the terms of service or the privacy policy
+type Document struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	URL     string `json:"url,omitempty"`
+	// Required documents must be accepted before using the API. Optional
+	// ones, such as marketing emails, can be accepted or declined at any time.
+	Required bool `json:"required"`
+}
+
+// Acceptance records a user's decision on a version of a document. Records
+// are never updated, a new decision adds a record.
+type Acceptance struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Document  string    `json:"document"`
+	Version   string    `json:"version"`
+	Accepted  bool      `json:"accepted"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName overrides the GORM default of "acceptances"
+func (Acceptance) TableName() string {
+	return "consent_acceptances"
+}
+
+// Consent is the state of a document for a user
+type Consent struct {
+	Document
+	// AcceptedVersion is the version of the user's latest decision if they
+	// accepted it, empty if they declined or haven't decided
+	AcceptedVersion string     `json:"accepted_version,omitempty"`
+	Accepted        bool       `json:"accepted"` // The user accepted the current version
+	DecidedAt       *time.Time `json:"decided_at,omitempty"`
+	// Pending is set on required documents whose current version the user
+	// hasn't accepted, requests are blocked until they do
+	Pending bool `json:"pending"`
+}
+
+// Decision is a user accepting or declining a version of a document
+type Decision struct {
+	Document string `json:"document" binding:"required"`
+	Version  string `json:"version" binding:"required"`
+	Accepted bool   `json:"accepted"`
+}
+
+// AcceptanceRepository defines the interface for consent data access
+type AcceptanceRepository interface {
+	// Create stores the acceptances in one transaction
+	Create(acceptances []*Acceptance) error
+	// FindLatest returns the user's latest decision for each document
+	FindLatest(userID uuid.UUID) ([]*Acceptance, error)
+}
+
+// ConsentUsecase defines the interface for consent business logic
+type ConsentUsecase interface {
+	// ListConsents returns the user's consent to every document
+	ListConsents(ctx context.Context, userID uuid.UUID) ([]*Consent, error)
+	// RecordDecisions records the user's decisions on current document versions
+	RecordDecisions(ctx context.Context, userID uuid.UUID, decisions []Decision) ([]*Consent, error)
+	// PendingConsents returns the names of the required documents whose
+	// current version the user hasn't accepted
+	PendingConsents(ctx context.Context, userID uuid.UUID) ([]string, error)
+}
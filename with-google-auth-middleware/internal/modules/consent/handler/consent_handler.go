@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/consent/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/consent/usecase"
+)
+
+type ConsentHandler struct {
+	consentUsecase domain.ConsentUsecase
+}
+
+func NewConsentHandler(consentUsecase domain.ConsentUsecase) *ConsentHandler {
+	return &ConsentHandler{
+		consentUsecase: consentUsecase,
+	}
+}
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// ConsentRequest represents the body of a consent request
+type ConsentRequest struct {
+	Consents []domain.Decision `json:"consents" binding:"required,dive"`
+}
+
+// RegisterRoutes registers the consent routes of signed-in users. The router
+// group must require a first-party access token but not ConsentRequired, so
+// that users with pending consents can accept them.
+func (h *ConsentHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/me/consents", h.ListConsents)
+	router.POST("/me/consents", h.RecordConsents)
+}
+
+// ListConsents handles listing the current user's consents
+// @Summary List my consents
+// @Description List the current version of every legal document and the current user's decision on it. Pending documents must be accepted before using the API.
+// @Tags consents
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} domain.Consent
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/consents [get]
+func (h *ConsentHandler) ListConsents(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	consents, err := h.consentUsecase.ListConsents(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to list consents",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, consents)
+}
+
+// RecordConsents handles accepting or declining documents
+// @Summary Accept or decline documents
+// @Description Record the current user's decisions on the current versions of legal documents. Required documents can only be accepted.
+// @Tags consents
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body ConsentRequest true "Decisions"
+// @Success 200 {array} domain.Consent
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/consents [post]
+func (h *ConsentHandler) RecordConsents(c *gin.Context) {
+	var req ConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	consents, err := h.consentUsecase.RecordDecisions(c.Request.Context(), userID.(uuid.UUID), req.Consents)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrOutdatedVersion):
+			// The document changed since the user was shown it
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error(), Code: "outdated_version"})
+		case errors.Is(err, usecase.ErrUnknownDocument), errors.Is(err, usecase.ErrRequiredDocument),
+			errors.Is(err, usecase.ErrDuplicateDocument), errors.Is(err, usecase.ErrNoDecisions):
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to record consents"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, consents)
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/consent/domain"
+)
+
+type ConsentMiddleware struct {
+	consentUsecase domain.ConsentUsecase
+}
+
+func NewConsentMiddleware(consentUsecase domain.ConsentUsecase) *ConsentMiddleware {
+	return &ConsentMiddleware{
+		consentUsecase: consentUsecase,
+	}
+}
+
+// ConsentRequired is a middleware that blocks users who haven't accepted the
+// current version of every required document. It must run after AuthRequired.
+// Tokens without a user, e.g. of client credentials, aren't blocked.
+func (m *ConsentMiddleware) ConsentRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := c.Get("user_id")
+		if !ok {
+			c.Next()
+			return
+		}
+		id, ok := userID.(uuid.UUID)
+		if !ok || id == uuid.Nil {
+			c.Next()
+			return
+		}
+
+		pending, err := m.consentUsecase.PendingConsents(c.Request.Context(), id)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check consents",
+			})
+			return
+		}
+		if len(pending) > 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":     "New versions of documents must be accepted, see /v1/me/consents",
+				"code":      "consent_required",
+				"documents": pending,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
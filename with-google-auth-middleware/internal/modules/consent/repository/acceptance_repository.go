@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/consent/domain"
+	"gorm.io/gorm"
+)
+
+type acceptanceRepository struct {
+	db *gorm.DB
+}
+
+func NewAcceptanceRepository(db *gorm.DB) domain.AcceptanceRepository {
+	return &acceptanceRepository{db: db}
+}
+
+func (r *acceptanceRepository) Create(acceptances []*domain.Acceptance) error {
+	return r.db.Create(acceptances).Error
+}
+
+func (r *acceptanceRepository) FindLatest(userID uuid.UUID) ([]*domain.Acceptance, error) {
+	var acceptances []*domain.Acceptance
+	err := r.db.Raw(`SELECT DISTINCT ON (document) * FROM consent_acceptances
+		WHERE user_id = ? ORDER BY document, created_at DESC`, userID).
+		Scan(&acceptances).Error
+	if err != nil {
+		return nil, err
+	}
+	return acceptances, nil
+}
@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	userrepo "github.com/tyobaskara/jeki-backend/internal/modules/user/repository"
+	"gorm.io/gorm"
+)
+
+// MergeHooks move the consent records of a user merged into another user.
+// The records are kept as they were made, the target's latest decision on a
+// document still decides its consent.
+var MergeHooks = []userrepo.MergeHook{
+	{Name: "consent_acceptances", Merge: mergeAcceptances},
+}
+
+func mergeAcceptances(tx *gorm.DB, sourceID, targetID uuid.UUID) (int64, error) {
+	result := tx.Exec("UPDATE consent_acceptances SET user_id = ? WHERE user_id = ?", targetID, sourceID)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS consent_acceptances;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS consent_acceptances (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    document VARCHAR(64) NOT NULL,
+    version VARCHAR(64) NOT NULL,
+    accepted BOOLEAN NOT NULL,
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_consent_acceptances_user_document ON consent_acceptances(user_id, document, created_at DESC);
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/consent/domain"
+	privacyrepo "github.com/tyobaskara/jeki-backend/internal/modules/privacy/repository"
+	"gorm.io/gorm"
+)
+
+// ExportHooks export every consent decision of a user
+var ExportHooks = []privacyrepo.ExportHook{
+	{Name: "consent_acceptances", Export: exportAcceptances},
+}
+
+// ErasureHooks delete the consent records of a user
+var ErasureHooks = []privacyrepo.ErasureHook{
+	{Name: "consent_acceptances", Erase: eraseAcceptances},
+}
+
+func exportAcceptances(db *gorm.DB, userID uuid.UUID) (interface{}, error) {
+	acceptances := []*domain.Acceptance{}
+	err := db.Where("user_id = ?", userID).Order("created_at").Find(&acceptances).Error
+	return acceptances, err
+}
+
+func eraseAcceptances(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("DELETE FROM consent_acceptances WHERE user_id = ?", userID)
+	return result.RowsAffected, result.Error
+}
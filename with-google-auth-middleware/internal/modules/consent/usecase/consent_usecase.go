@@ -0,0 +1,192 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	authdomain "github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/consent/domain"
+)
+
+// Custom errors
+var (
+	ErrUnknownDocument    = errors.New("unknown document")
+	ErrOutdatedVersion    = errors.New("not the current version of the document")
+	ErrRequiredDocument   = errors.New("required documents can't be declined")
+	ErrDuplicateDocument  = errors.New("document decided twice")
+	ErrNoDecisions        = errors.New("no decisions")
+	ErrInvalidDocumentSet = errors.New("invalid consent documents")
+)
+
+// acceptedTTL is how long users who accepted every required document aren't
+// looked up again. Required documents can't be declined, the entries only
+// keep the cache from growing.
+const acceptedTTL = 5 * time.Minute
+
+// maxUserAgentLength is the size of the consent_acceptances.user_agent column
+const maxUserAgentLength = 512
+
+type consentUsecase struct {
+	repo      domain.AcceptanceRepository
+	documents []domain.Document
+	now       func() time.Time
+
+	mu        sync.Mutex
+	accepted  map[uuid.UUID]time.Time
+	nextPrune time.Time
+}
+
+// NewConsentUsecase creates a new instance of ConsentUsecase for the current
+// versions of the documents. Names must be unique and versions set.
+func NewConsentUsecase(repo domain.AcceptanceRepository, documents []domain.Document) (domain.ConsentUsecase, error) {
+	names := map[string]bool{}
+	for _, document := range documents {
+		switch {
+		case document.Name == "":
+			return nil, fmt.Errorf("%w: a document has no name", ErrInvalidDocumentSet)
+		case document.Version == "":
+			return nil, fmt.Errorf("%w: %s has no version", ErrInvalidDocumentSet, document.Name)
+		case names[document.Name]:
+			return nil, fmt.Errorf("%w: %s is configured twice", ErrInvalidDocumentSet, document.Name)
+		}
+		names[document.Name] = true
+	}
+	return &consentUsecase{
+		repo:      repo,
+		documents: documents,
+		now:       time.Now,
+		accepted:  map[uuid.UUID]time.Time{},
+	}, nil
+}
+
+func (u *consentUsecase) ListConsents(ctx context.Context, userID uuid.UUID) ([]*domain.Consent, error) {
+	latest, err := u.repo.FindLatest(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find consents: %w", err)
+	}
+	return u.consents(latest), nil
+}
+
+func (u *consentUsecase) RecordDecisions(ctx context.Context, userID uuid.UUID, decisions []domain.Decision) ([]*domain.Consent, error) {
+	if len(decisions) == 0 {
+		return nil, ErrNoDecisions
+	}
+
+	info := authdomain.RequestInfoFromContext(ctx)
+	now := u.now()
+	decided := map[string]bool{}
+	acceptances := make([]*domain.Acceptance, 0, len(decisions))
+	for _, decision := range decisions {
+		document, ok := u.document(decision.Document)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownDocument, decision.Document)
+		case decided[document.Name]:
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateDocument, document.Name)
+		case decision.Version != document.Version:
+			// The user decided on a version they were shown, not on a newer one
+			return nil, fmt.Errorf("%w: %s is at version %s", ErrOutdatedVersion, document.Name, document.Version)
+		case document.Required && !decision.Accepted:
+			return nil, fmt.Errorf("%w: %s", ErrRequiredDocument, document.Name)
+		}
+		decided[document.Name] = true
+		acceptances = append(acceptances, &domain.Acceptance{
+			ID:        uuid.New(),
+			UserID:    userID,
+			Document:  document.Name,
+			Version:   document.Version,
+			Accepted:  decision.Accepted,
+			IPAddress: info.IPAddress,
+			UserAgent: truncate(info.UserAgent, maxUserAgentLength),
+			CreatedAt: now,
+		})
+	}
+	if err := u.repo.Create(acceptances); err != nil {
+		return nil, fmt.Errorf("failed to record consents: %w", err)
+	}
+
+	return u.ListConsents(ctx, userID)
+}
+
+func (u *consentUsecase) PendingConsents(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	now := u.now()
+	u.mu.Lock()
+	expiresAt, ok := u.accepted[userID]
+	u.mu.Unlock()
+	if ok && now.Before(expiresAt) {
+		return nil, nil
+	}
+
+	consents, err := u.ListConsents(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var pending []string
+	for _, consent := range consents {
+		if consent.Pending {
+			pending = append(pending, consent.Name)
+		}
+	}
+	if len(pending) > 0 {
+		// Users accepting are looked up again on their next request
+		return pending, nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if now.After(u.nextPrune) {
+		for id, expiresAt := range u.accepted {
+			if !now.Before(expiresAt) {
+				delete(u.accepted, id)
+			}
+		}
+		u.nextPrune = now.Add(time.Minute)
+	}
+	u.accepted[userID] = now.Add(acceptedTTL)
+	return nil, nil
+}
+
+// consents returns the state of every document given the user's latest decisions
+func (u *consentUsecase) consents(latest []*domain.Acceptance) []*domain.Consent {
+	decisions := make(map[string]*domain.Acceptance, len(latest))
+	for _, acceptance := range latest {
+		decisions[acceptance.Document] = acceptance
+	}
+
+	consents := make([]*domain.Consent, 0, len(u.documents))
+	for _, document := range u.documents {
+		consent := &domain.Consent{Document: document}
+		if decision, ok := decisions[document.Name]; ok {
+			decidedAt := decision.CreatedAt
+			consent.DecidedAt = &decidedAt
+			if decision.Accepted {
+				consent.AcceptedVersion = decision.Version
+				consent.Accepted = decision.Version == document.Version
+			}
+		}
+		consent.Pending = document.Required && !consent.Accepted
+		consents = append(consents, consent)
+	}
+	return consents
+}
+
+func (u *consentUsecase) document(name string) (domain.Document, bool) {
+	for _, document := range u.documents {
+		if document.Name == name {
+			return document, true
+		}
+	}
+	return domain.Document{}, false
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
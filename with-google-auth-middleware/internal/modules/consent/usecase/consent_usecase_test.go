@@ -0,0 +1,143 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authdomain "github.com/tyobaskara/jeki-backend/internal/modules/auth/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/consent/domain"
+)
+
+// fakeAcceptanceRepository keeps acceptances in memory
+type fakeAcceptanceRepository struct {
+	acceptances []*domain.Acceptance
+	lookups     int
+}
+
+func (f *fakeAcceptanceRepository) Create(acceptances []*domain.Acceptance) error {
+	f.acceptances = append(f.acceptances, acceptances...)
+	return nil
+}
+
+func (f *fakeAcceptanceRepository) FindLatest(userID uuid.UUID) ([]*domain.Acceptance, error) {
+	f.lookups++
+	latest := map[string]*domain.Acceptance{}
+	for _, acceptance := range f.acceptances {
+		if acceptance.UserID == userID {
+			latest[acceptance.Document] = acceptance
+		}
+	}
+	var result []*domain.Acceptance
+	for _, acceptance := range latest {
+		result = append(result, acceptance)
+	}
+	return result, nil
+}
+
+func newTestConsents(t *testing.T, repo domain.AcceptanceRepository, termsVersion string) *consentUsecase {
+	t.Helper()
+	u, err := NewConsentUsecase(repo, []domain.Document{
+		{Name: "terms", Version: termsVersion, Required: true},
+		{Name: "privacy", Version: "1", Required: true},
+		{Name: "marketing", Version: "1"},
+	})
+	require.NoError(t, err)
+	return u.(*consentUsecase)
+}
+
+func TestNewConsentUsecase(t *testing.T) {
+	_, err := NewConsentUsecase(nil, []domain.Document{{Name: "terms"}})
+	assert.ErrorIs(t, err, ErrInvalidDocumentSet)
+
+	_, err = NewConsentUsecase(nil, []domain.Document{{Name: "terms", Version: "1"}, {Name: "terms", Version: "2"}})
+	assert.ErrorIs(t, err, ErrInvalidDocumentSet)
+}
+
+func TestRecordDecisions(t *testing.T) {
+	repo := &fakeAcceptanceRepository{}
+	u := newTestConsents(t, repo, "1")
+	userID := uuid.New()
+	ctx := authdomain.WithRequestInfo(context.Background(), authdomain.RequestInfo{IPAddress: "203.0.113.7", UserAgent: "Phone"})
+
+	pending, err := u.PendingConsents(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"terms", "privacy"}, pending)
+
+	tests := []struct {
+		name      string
+		decisions []domain.Decision
+		err       error
+	}{
+		{"no decisions", nil, ErrNoDecisions},
+		{"unknown document", []domain.Decision{{Document: "cookies", Version: "1", Accepted: true}}, ErrUnknownDocument},
+		{"outdated version", []domain.Decision{{Document: "terms", Version: "0", Accepted: true}}, ErrOutdatedVersion},
+		{"required declined", []domain.Decision{{Document: "terms", Version: "1"}}, ErrRequiredDocument},
+		{"decided twice", []domain.Decision{
+			{Document: "marketing", Version: "1", Accepted: true},
+			{Document: "marketing", Version: "1"},
+		}, ErrDuplicateDocument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := u.RecordDecisions(ctx, userID, tt.decisions)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Empty(t, repo.acceptances, "nothing is recorded")
+		})
+	}
+
+	consents, err := u.RecordDecisions(ctx, userID, []domain.Decision{
+		{Document: "terms", Version: "1", Accepted: true},
+		{Document: "marketing", Version: "1"},
+	})
+	require.NoError(t, err)
+	require.Len(t, consents, 3)
+	assert.True(t, consents[0].Accepted)
+	assert.False(t, consents[0].Pending)
+	assert.True(t, consents[1].Pending)
+	assert.False(t, consents[2].Accepted)
+	assert.False(t, consents[2].Pending, "optional documents are never pending")
+	assert.NotNil(t, consents[2].DecidedAt)
+	require.Len(t, repo.acceptances, 2)
+	assert.Equal(t, "203.0.113.7", repo.acceptances[0].IPAddress)
+	assert.Equal(t, "Phone", repo.acceptances[0].UserAgent)
+
+	pending, err = u.PendingConsents(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"privacy"}, pending)
+
+	_, err = u.RecordDecisions(ctx, userID, []domain.Decision{{Document: "privacy", Version: "1", Accepted: true}})
+	require.NoError(t, err)
+	pending, err = u.PendingConsents(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	t.Run("accepted users are cached", func(t *testing.T) {
+		lookups := repo.lookups
+		_, err := u.PendingConsents(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, lookups, repo.lookups)
+
+		u.now = func() time.Time { return time.Now().Add(acceptedTTL) }
+		defer func() { u.now = time.Now }()
+		_, err = u.PendingConsents(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, lookups+1, repo.lookups)
+	})
+
+	t.Run("new version", func(t *testing.T) {
+		// A new version is deployed with a restart
+		u := newTestConsents(t, repo, "2")
+		pending, err := u.PendingConsents(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"terms"}, pending)
+
+		consents, err := u.ListConsents(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, "1", consents[0].AcceptedVersion)
+		assert.False(t, consents[0].Accepted)
+	})
+}
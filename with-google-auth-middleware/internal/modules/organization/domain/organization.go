@@ -0,0 +1,139 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Organization roles, from least to most privileged. Every organization has
+// exactly one owner.
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+	RoleOwner  = "owner"
+)
+
+// Roles lists the organization roles from least to most privileged
+var Roles = []string{RoleMember, RoleAdmin, RoleOwner}
+
+// RoleAtLeast reports whether role is minimum or a more privileged role
+func RoleAtLeast(role, minimum string) bool {
+	level, required := -1, -1
+	for i, r := range Roles {
+		if r == role {
+			level = i
+		}
+		if r == minimum {
+			required = i
+		}
+	}
+	return level >= 0 && required >= 0 && level >= required
+}
+
+// Organization is a team account users belong to
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Membership gives a user a role in an organization
+type Membership struct {
+	OrganizationID uuid.UUID     `json:"organization_id"`
+	UserID         uuid.UUID     `json:"user_id"`
+	Role           string        `json:"role"`
+	Organization   *Organization `json:"organization,omitempty" gorm:"foreignKey:OrganizationID"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// TableName overrides the GORM default of "memberships"
+func (Membership) TableName() string {
+	return "organization_memberships"
+}
+
+// Invitation invites an email address to join an organization with a role.
+// The invitee accepts it after signing in with that email.
+type Invitation struct {
+	ID             uuid.UUID     `json:"id"`
+	OrganizationID uuid.UUID     `json:"organization_id"`
+	Organization   *Organization `json:"organization,omitempty" gorm:"foreignKey:OrganizationID"`
+	Email          string        `json:"email"`
+	Role           string        `json:"role"`
+	InvitedBy      *uuid.UUID    `json:"invited_by,omitempty"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+	AcceptedAt     *time.Time    `json:"accepted_at,omitempty"`
+	AcceptedBy     *uuid.UUID    `json:"accepted_by,omitempty"`
+	RevokedAt      *time.Time    `json:"revoked_at,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// TableName overrides the GORM default of "invitations", used by the auth module
+func (Invitation) TableName() string {
+	return "organization_invitations"
+}
+
+// IsPending reports whether the invitation can still be accepted
+func (i *Invitation) IsPending() bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && time.Now().Before(i.ExpiresAt)
+}
+
+// OrganizationRepository defines the interface for organization data access
+type OrganizationRepository interface {
+	// Create creates the organization together with its owner's membership
+	Create(org *Organization, owner *Membership) error
+	FindByID(id uuid.UUID) (*Organization, error)
+	Update(org *Organization) error
+	Delete(id uuid.UUID) error
+	FindMembership(orgID, userID uuid.UUID) (*Membership, error)
+	// GetUserMemberships returns the user's memberships with their organization
+	GetUserMemberships(userID uuid.UUID) ([]*Membership, error)
+	GetMembers(orgID uuid.UUID) ([]*Membership, error)
+	UpdateMembership(membership *Membership) error
+	DeleteMembership(orgID, userID uuid.UUID) error
+	// TransferOwnership makes newOwnerID the owner and the previous owner an
+	// admin. It returns gorm.ErrRecordNotFound if ownerID is no longer the owner.
+	TransferOwnership(orgID, ownerID, newOwnerID uuid.UUID) error
+}
+
+// InvitationRepository defines the interface for organization invitation data access
+type InvitationRepository interface {
+	Create(invitation *Invitation) error
+	// FindPendingByEmail returns the pending invitations of an email with their organization
+	FindPendingByEmail(email string) ([]*Invitation, error)
+	GetByOrganization(orgID uuid.UUID) ([]*Invitation, error)
+	// Accept consumes a pending invitation and creates the membership. It
+	// returns gorm.ErrRecordNotFound if the invitation is no longer pending.
+	Accept(invitation *Invitation, membership *Membership) error
+	// Revoke returns gorm.ErrRecordNotFound if the organization has no such pending invitation
+	Revoke(orgID, id uuid.UUID) error
+}
+
+// OrganizationUsecase defines the interface for organization business logic.
+// actorID is the user making the change, whose role in the organization is
+// checked against the database.
+type OrganizationUsecase interface {
+	// CreateOrganization creates an organization owned by the user
+	CreateOrganization(ctx context.Context, userID uuid.UUID, name string) (*Organization, error)
+	ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]*Membership, error)
+	GetOrganization(ctx context.Context, orgID uuid.UUID) (*Organization, error)
+	UpdateOrganization(ctx context.Context, actorID, orgID uuid.UUID, name string) (*Organization, error)
+	DeleteOrganization(ctx context.Context, actorID, orgID uuid.UUID) error
+	ListMembers(ctx context.Context, orgID uuid.UUID) ([]*Membership, error)
+	UpdateMemberRole(ctx context.Context, actorID, orgID, userID uuid.UUID, role string) (*Membership, error)
+	// RemoveMember removes a member, or lets a member other than the owner leave
+	RemoveMember(ctx context.Context, actorID, orgID, userID uuid.UUID) error
+	TransferOwnership(ctx context.Context, actorID, orgID, newOwnerID uuid.UUID) error
+	InviteMember(ctx context.Context, actorID, orgID uuid.UUID, email, role string) (*Invitation, error)
+	ListInvitations(ctx context.Context, orgID uuid.UUID) ([]*Invitation, error)
+	RevokeInvitation(ctx context.Context, actorID, orgID, id uuid.UUID) error
+	// ListUserInvitations returns the pending invitations of the user's email
+	ListUserInvitations(ctx context.Context, userID uuid.UUID) ([]*Invitation, error)
+	AcceptInvitation(ctx context.Context, userID, invitationID uuid.UUID) (*Membership, error)
+	// MemberRole returns the user's role in the organization, empty if the user isn't a member
+	MemberRole(ctx context.Context, orgID, userID uuid.UUID) (string, error)
+}
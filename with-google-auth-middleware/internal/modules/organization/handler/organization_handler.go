@@ -0,0 +1,551 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/organization/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/organization/usecase"
+)
+
+type OrganizationHandler struct {
+	orgUsecase domain.OrganizationUsecase
+}
+
+func NewOrganizationHandler(orgUsecase domain.OrganizationUsecase) *OrganizationHandler {
+	return &OrganizationHandler{
+		orgUsecase: orgUsecase,
+	}
+}
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// OrganizationRequest represents the body of an organization create or update request
+type OrganizationRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// MemberRoleRequest represents the body of a member role update
+type MemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// TransferOwnershipRequest represents the body of an ownership transfer
+type TransferOwnershipRequest struct {
+	UserID uuid.UUID `json:"user_id" binding:"required"`
+}
+
+// InvitationRequest represents the body of an organization invitation request
+type InvitationRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role"`
+}
+
+// RegisterRoutes registers the organization routes. requireOrg restricts a
+// route to access tokens switched to the organization in the :org_id path
+// parameter with one of the given roles, stepUp requires a recent sign-in.
+func (h *OrganizationHandler) RegisterRoutes(router *gin.RouterGroup, requireOrg func(roles ...string) gin.HandlerFunc, stepUp gin.HandlerFunc) {
+	router.POST("/orgs", h.CreateOrganization)
+	router.GET("/orgs", h.ListOrganizations)
+	router.GET("/me/org-invitations", h.ListUserInvitations)
+	router.POST("/me/org-invitations/:id/accept", h.AcceptInvitation)
+
+	member := requireOrg()
+	admin := requireOrg(domain.RoleAdmin, domain.RoleOwner)
+	owner := requireOrg(domain.RoleOwner)
+
+	group := router.Group("/orgs/:org_id")
+	{
+		group.GET("", member, h.GetOrganization)
+		group.PATCH("", admin, h.UpdateOrganization)
+		group.DELETE("", owner, stepUp, h.DeleteOrganization)
+		group.GET("/members", member, h.ListMembers)
+		group.PUT("/members/:user_id", admin, h.UpdateMemberRole)
+		group.DELETE("/members/:user_id", member, h.RemoveMember)
+		group.POST("/transfer-ownership", owner, stepUp, h.TransferOwnership)
+		group.POST("/invitations", admin, h.CreateInvitation)
+		group.GET("/invitations", admin, h.ListInvitations)
+		group.DELETE("/invitations/:id", admin, h.RevokeInvitation)
+	}
+}
+
+// CreateOrganization handles organization creation
+// @Summary Create an organization
+// @Description Create an organization owned by the current user
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body OrganizationRequest true "Organization"
+// @Success 201 {object} domain.Organization
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs [post]
+func (h *OrganizationHandler) CreateOrganization(c *gin.Context) {
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	org, err := h.orgUsecase.CreateOrganization(c.Request.Context(), userID.(uuid.UUID), req.Name)
+	if err != nil {
+		respondError(c, err, "Failed to create organization")
+		return
+	}
+
+	c.JSON(http.StatusCreated, org)
+}
+
+// ListOrganizations handles listing the current user's organizations
+// @Summary List my organizations
+// @Description List the organizations the current user belongs to, with their role in each
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} domain.Membership
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs [get]
+func (h *OrganizationHandler) ListOrganizations(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	memberships, err := h.orgUsecase.ListUserOrganizations(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to list organizations",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, memberships)
+}
+
+// GetOrganization handles getting the active organization
+// @Summary Get an organization
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param org_id path string true "Organization ID"
+// @Success 200 {object} domain.Organization
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs/{org_id} [get]
+func (h *OrganizationHandler) GetOrganization(c *gin.Context) {
+	orgID, ok := parseID(c, "org_id", "Invalid organization ID")
+	if !ok {
+		return
+	}
+
+	org, err := h.orgUsecase.GetOrganization(c.Request.Context(), orgID)
+	if err != nil {
+		respondError(c, err, "Failed to get organization")
+		return
+	}
+
+	c.JSON(http.StatusOK, org)
+}
+
+// UpdateOrganization handles renaming an organization
+// @Summary Update an organization
+// @Description Rename the organization. Organization admins only.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param org_id path string true "Organization ID"
+// @Param request body OrganizationRequest true "Organization"
+// @Success 200 {object} domain.Organization
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs/{org_id} [patch]
+func (h *OrganizationHandler) UpdateOrganization(c *gin.Context) {
+	orgID, ok := parseID(c, "org_id", "Invalid organization ID")
+	if !ok {
+		return
+	}
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	org, err := h.orgUsecase.UpdateOrganization(c.Request.Context(), userID.(uuid.UUID), orgID, req.Name)
+	if err != nil {
+		respondError(c, err, "Failed to update organization")
+		return
+	}
+
+	c.JSON(http.StatusOK, org)
+}
+
+// DeleteOrganization handles organization deletion
+// @Summary Delete an organization
+// @Description Delete the organization with its memberships and invitations. Owner only, requires a recent sign-in.
+// @Tags organizations
+// @Security BearerAuth
+// @Param org_id path string true "Organization ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs/{org_id} [delete]
+func (h *OrganizationHandler) DeleteOrganization(c *gin.Context) {
+	orgID, ok := parseID(c, "org_id", "Invalid organization ID")
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if err := h.orgUsecase.DeleteOrganization(c.Request.Context(), userID.(uuid.UUID), orgID); err != nil {
+		respondError(c, err, "Failed to delete organization")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListMembers handles listing an organization's members
+// @Summary List members
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param org_id path string true "Organization ID"
+// @Success 200 {array} domain.Membership
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs/{org_id}/members [get]
+func (h *OrganizationHandler) ListMembers(c *gin.Context) {
+	orgID, ok := parseID(c, "org_id", "Invalid organization ID")
+	if !ok {
+		return
+	}
+
+	members, err := h.orgUsecase.ListMembers(c.Request.Context(), orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to list members",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// UpdateMemberRole handles changing a member's role
+// @Summary Change a member's role
+// @Description Make a member an admin or a member. Organization admins only, the owner changes by transferring ownership.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param org_id path string true "Organization ID"
+// @Param user_id path string true "User ID"
+// @Param request body MemberRoleRequest true "Role"
+// @Success 200 {object} domain.Membership
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs/{org_id}/members/{user_id} [put]
+func (h *OrganizationHandler) UpdateMemberRole(c *gin.Context) {
+	orgID, ok := parseID(c, "org_id", "Invalid organization ID")
+	if !ok {
+		return
+	}
+	memberID, ok := parseID(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+	var req MemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	membership, err := h.orgUsecase.UpdateMemberRole(c.Request.Context(), userID.(uuid.UUID), orgID, memberID, req.Role)
+	if err != nil {
+		respondError(c, err, "Failed to update member")
+		return
+	}
+
+	c.JSON(http.StatusOK, membership)
+}
+
+// RemoveMember handles removing a member
+// @Summary Remove a member
+// @Description Remove a member from the organization, organization admins only. Any member but the owner may remove themselves to leave.
+// @Tags organizations
+// @Security BearerAuth
+// @Param org_id path string true "Organization ID"
+// @Param user_id path string true "User ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs/{org_id}/members/{user_id} [delete]
+func (h *OrganizationHandler) RemoveMember(c *gin.Context) {
+	orgID, ok := parseID(c, "org_id", "Invalid organization ID")
+	if !ok {
+		return
+	}
+	memberID, ok := parseID(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if err := h.orgUsecase.RemoveMember(c.Request.Context(), userID.(uuid.UUID), orgID, memberID); err != nil {
+		respondError(c, err, "Failed to remove member")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// TransferOwnership handles transferring ownership of an organization
+// @Summary Transfer ownership
+// @Description Make another member the owner, the current owner becomes an admin. Owner only, requires a recent sign-in.
+// @Tags organizations
+// @Accept json
+// @Security BearerAuth
+// @Param org_id path string true "Organization ID"
+// @Param request body TransferOwnershipRequest true "New owner"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs/{org_id}/transfer-ownership [post]
+func (h *OrganizationHandler) TransferOwnership(c *gin.Context) {
+	orgID, ok := parseID(c, "org_id", "Invalid organization ID")
+	if !ok {
+		return
+	}
+	var req TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if err := h.orgUsecase.TransferOwnership(c.Request.Context(), userID.(uuid.UUID), orgID, req.UserID); err != nil {
+		respondError(c, err, "Failed to transfer ownership")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// CreateInvitation handles inviting an email address to the organization
+// @Summary Invite a member
+// @Description Invite an email address to join the organization as a member or admin. Organization admins only.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param org_id path string true "Organization ID"
+// @Param request body InvitationRequest true "Invitation"
+// @Success 201 {object} domain.Invitation
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs/{org_id}/invitations [post]
+func (h *OrganizationHandler) CreateInvitation(c *gin.Context) {
+	orgID, ok := parseID(c, "org_id", "Invalid organization ID")
+	if !ok {
+		return
+	}
+	var req InvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	invitation, err := h.orgUsecase.InviteMember(c.Request.Context(), userID.(uuid.UUID), orgID, req.Email, req.Role)
+	if err != nil {
+		respondError(c, err, "Failed to create invitation")
+		return
+	}
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// ListInvitations handles listing the organization's invitations
+// @Summary List organization invitations
+// @Description List the organization's invitations, newest first. Organization admins only.
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param org_id path string true "Organization ID"
+// @Success 200 {array} domain.Invitation
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs/{org_id}/invitations [get]
+func (h *OrganizationHandler) ListInvitations(c *gin.Context) {
+	orgID, ok := parseID(c, "org_id", "Invalid organization ID")
+	if !ok {
+		return
+	}
+
+	invitations, err := h.orgUsecase.ListInvitations(c.Request.Context(), orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to list invitations",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// RevokeInvitation handles revoking an organization invitation
+// @Summary Revoke an organization invitation
+// @Description Revoke a pending invitation. Organization admins only.
+// @Tags organizations
+// @Security BearerAuth
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Invitation ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /orgs/{org_id}/invitations/{id} [delete]
+func (h *OrganizationHandler) RevokeInvitation(c *gin.Context) {
+	orgID, ok := parseID(c, "org_id", "Invalid organization ID")
+	if !ok {
+		return
+	}
+	id, ok := parseID(c, "id", "Invalid invitation ID")
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if err := h.orgUsecase.RevokeInvitation(c.Request.Context(), userID.(uuid.UUID), orgID, id); err != nil {
+		respondError(c, err, "Failed to revoke invitation")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListUserInvitations handles listing the current user's pending invitations
+// @Summary List my organization invitations
+// @Description List the pending organization invitations sent to the current user's email
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} domain.Invitation
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/org-invitations [get]
+func (h *OrganizationHandler) ListUserInvitations(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	invitations, err := h.orgUsecase.ListUserInvitations(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to list invitations",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// AcceptInvitation handles accepting an organization invitation
+// @Summary Accept an organization invitation
+// @Description Join the organization of an invitation sent to the current user's email
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Invitation ID"
+// @Success 201 {object} domain.Membership
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/org-invitations/{id}/accept [post]
+func (h *OrganizationHandler) AcceptInvitation(c *gin.Context) {
+	id, ok := parseID(c, "id", "Invalid invitation ID")
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	membership, err := h.orgUsecase.AcceptInvitation(c.Request.Context(), userID.(uuid.UUID), id)
+	if err != nil {
+		respondError(c, err, "Failed to accept invitation")
+		return
+	}
+
+	c.JSON(http.StatusCreated, membership)
+}
+
+// parseID parses a UUID path parameter, responding with message if it's invalid
+func parseID(c *gin.Context, param, message string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param(param))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: message,
+		})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// respondError maps the organization usecase errors to responses, anything
+// else is an internal error reported with message
+func respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, usecase.ErrInvalidName), errors.Is(err, usecase.ErrInvalidEmail), errors.Is(err, usecase.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, usecase.ErrInsufficientRole):
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error(), Code: "insufficient_org_role"})
+	case errors.Is(err, usecase.ErrNotMember):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error(), Code: "not_org_member"})
+	case errors.Is(err, usecase.ErrOrganizationNotFound), errors.Is(err, usecase.ErrInvitationNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, usecase.ErrAlreadyMember), errors.Is(err, usecase.ErrOwnerCannotLeave):
+		c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: message})
+	}
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/organization/domain"
+	"gorm.io/gorm"
+)
+
+// invitationRepository runs every call in a transaction scoped with
+// withTenant or withUserEmail, see migration 000002 for the policies
+type invitationRepository struct {
+	db *gorm.DB
+}
+
+func NewInvitationRepository(db *gorm.DB) domain.InvitationRepository {
+	return &invitationRepository{db: db}
+}
+
+func (r *invitationRepository) Create(invitation *domain.Invitation) error {
+	return withTenant(r.db, invitation.OrganizationID, func(tx *gorm.DB) error {
+		return tx.Omit("Organization").Create(invitation).Error
+	})
+}
+
+func (r *invitationRepository) FindPendingByEmail(email string) ([]*domain.Invitation, error) {
+	var invitations []*domain.Invitation
+	err := withUserEmail(r.db, strings.ToLower(email), func(tx *gorm.DB) error {
+		return tx.Preload("Organization").
+			Where("LOWER(email) = LOWER(?) AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", email, time.Now()).
+			Order("created_at DESC").
+			Find(&invitations).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (r *invitationRepository) GetByOrganization(orgID uuid.UUID) ([]*domain.Invitation, error) {
+	var invitations []*domain.Invitation
+	err := withTenant(r.db, orgID, func(tx *gorm.DB) error {
+		return tx.Where("organization_id = ?", orgID).Order("created_at DESC").Find(&invitations).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// Accept marks the invitation accepted and creates the membership in one
+// transaction, so that an invitation can only be accepted once
+func (r *invitationRepository) Accept(invitation *domain.Invitation, membership *domain.Membership) error {
+	return withTenant(r.db, invitation.OrganizationID, func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&domain.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", invitation.ID, now).
+			Updates(map[string]interface{}{
+				"accepted_at": now,
+				"accepted_by": membership.UserID,
+				"updated_at":  now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Omit("Organization").Create(membership).Error
+	})
+}
+
+func (r *invitationRepository) Revoke(orgID, id uuid.UUID) error {
+	return withTenant(r.db, orgID, func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&domain.Invitation{}).
+			Where("id = ? AND organization_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id, orgID).
+			Updates(map[string]interface{}{
+				"revoked_at": now,
+				"updated_at": now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
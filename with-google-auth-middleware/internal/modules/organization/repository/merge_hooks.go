@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/organization/domain"
+	userrepo "github.com/tyobaskara/jeki-backend/internal/modules/user/repository"
+	"gorm.io/gorm"
+)
+
+// MergeHooks move the memberships of a user merged into another user
+var MergeHooks = []userrepo.MergeHook{
+	{Name: "organization_memberships", Merge: mergeMemberships},
+}
+
+// mergeMemberships moves the source's memberships to the target. In an
+// organization both belong to, the target keeps the more privileged role.
+func mergeMemberships(tx *gorm.DB, sourceID, targetID uuid.UUID) (int64, error) {
+	var memberships []*domain.Membership
+	err := withUser(tx, sourceID, func(tx *gorm.DB) error {
+		return tx.Where("user_id = ?", sourceID).Find(&memberships).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, membership := range memberships {
+		err := withTenant(tx, membership.OrganizationID, func(tx *gorm.DB) error {
+			now := time.Now()
+			var existing domain.Membership
+			err := tx.Where("organization_id = ? AND user_id = ?", membership.OrganizationID, targetID).First(&existing).Error
+			if err == gorm.ErrRecordNotFound {
+				return tx.Model(&domain.Membership{}).
+					Where("organization_id = ? AND user_id = ?", membership.OrganizationID, sourceID).
+					Updates(map[string]interface{}{"user_id": targetID, "updated_at": now}).Error
+			}
+			if err != nil {
+				return err
+			}
+
+			// Deleted first, the unique index on the owner would reject two
+			if err := tx.Delete(&domain.Membership{}, "organization_id = ? AND user_id = ?", membership.OrganizationID, sourceID).Error; err != nil {
+				return err
+			}
+			if domain.RoleAtLeast(existing.Role, membership.Role) {
+				return nil
+			}
+			return tx.Model(&domain.Membership{}).
+				Where("organization_id = ? AND user_id = ?", membership.OrganizationID, targetID).
+				Updates(map[string]interface{}{"role": membership.Role, "updated_at": now}).Error
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(memberships)), nil
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS organization_invitations;
+DROP TABLE IF EXISTS organization_memberships;
+DROP TABLE IF EXISTS organizations;
\ No newline at end of file
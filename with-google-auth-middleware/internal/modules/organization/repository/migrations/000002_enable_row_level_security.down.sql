@@ -0,0 +1,15 @@
+DROP POLICY IF EXISTS tenant_isolation ON organizations;
+ALTER TABLE organizations NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE organizations DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON organization_invitations;
+ALTER TABLE organization_invitations NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE organization_invitations DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON organization_memberships;
+ALTER TABLE organization_memberships NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE organization_memberships DISABLE ROW LEVEL SECURITY;
+
+DROP FUNCTION IF EXISTS app_current_user_email();
+DROP FUNCTION IF EXISTS app_current_user_id();
+DROP FUNCTION IF EXISTS app_current_tenant();
\ No newline at end of file
@@ -0,0 +1,49 @@
+-- Row-level security on the organization tables. The repositories run every
+-- query in a transaction that sets one of these with SET LOCAL:
+--   app.current_tenant      an organization ID: its rows
+--   app.current_user_id     a user ID: the user's memberships
+--   app.current_user_email  an email address: its invitations
+-- Rows outside the scope are invisible and can't be written, so a query that
+-- forgets its organization_id filter returns nothing of other organizations.
+--
+-- FORCE applies the policies to the table owner as well. Superusers and roles
+-- with BYPASSRLS skip them, the application must connect as another role.
+
+-- current_setting returns '' instead of NULL on connections where a
+-- transaction set the value before
+CREATE OR REPLACE FUNCTION app_current_tenant() RETURNS UUID AS $$
+    SELECT NULLIF(current_setting('app.current_tenant', true), '')::uuid
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION app_current_user_id() RETURNS UUID AS $$
+    SELECT NULLIF(current_setting('app.current_user_id', true), '')::uuid
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION app_current_user_email() RETURNS TEXT AS $$
+    SELECT NULLIF(LOWER(current_setting('app.current_user_email', true)), '')
+$$ LANGUAGE sql STABLE;
+
+ALTER TABLE organization_memberships ENABLE ROW LEVEL SECURITY;
+ALTER TABLE organization_memberships FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON organization_memberships
+    USING (organization_id = app_current_tenant() OR user_id = app_current_user_id())
+    WITH CHECK (organization_id = app_current_tenant());
+
+ALTER TABLE organization_invitations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE organization_invitations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON organization_invitations
+    USING (organization_id = app_current_tenant() OR LOWER(email) = app_current_user_email())
+    WITH CHECK (organization_id = app_current_tenant());
+
+-- An organization is visible in its own scope, and to the users who can see
+-- one of its memberships or invitations. The subqueries are subject to the
+-- policies above.
+ALTER TABLE organizations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE organizations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON organizations
+    USING (
+        id = app_current_tenant()
+        OR EXISTS (SELECT 1 FROM organization_memberships m WHERE m.organization_id = organizations.id)
+        OR EXISTS (SELECT 1 FROM organization_invitations i WHERE i.organization_id = organizations.id)
+    )
+    WITH CHECK (id = app_current_tenant());
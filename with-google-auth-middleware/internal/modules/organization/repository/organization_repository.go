@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/organization/domain"
+	"gorm.io/gorm"
+)
+
+// organizationRepository runs every call in a transaction scoped with
+// withTenant or withUser, see migration 000002 for the policies
+type organizationRepository struct {
+	db *gorm.DB
+}
+
+func NewOrganizationRepository(db *gorm.DB) domain.OrganizationRepository {
+	return &organizationRepository{db: db}
+}
+
+func (r *organizationRepository) Create(org *domain.Organization, owner *domain.Membership) error {
+	return withTenant(r.db, org.ID, func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Omit("Organization").Create(owner).Error
+	})
+}
+
+func (r *organizationRepository) FindByID(id uuid.UUID) (*domain.Organization, error) {
+	var org domain.Organization
+	err := withTenant(r.db, id, func(tx *gorm.DB) error {
+		return tx.Where("id = ?", id).First(&org).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (r *organizationRepository) Update(org *domain.Organization) error {
+	org.UpdatedAt = time.Now()
+	return withTenant(r.db, org.ID, func(tx *gorm.DB) error {
+		return tx.Save(org).Error
+	})
+}
+
+// Delete deletes the organization, its memberships and invitations cascade
+func (r *organizationRepository) Delete(id uuid.UUID) error {
+	return withTenant(r.db, id, func(tx *gorm.DB) error {
+		return tx.Delete(&domain.Organization{}, "id = ?", id).Error
+	})
+}
+
+func (r *organizationRepository) FindMembership(orgID, userID uuid.UUID) (*domain.Membership, error) {
+	var membership domain.Membership
+	err := withTenant(r.db, orgID, func(tx *gorm.DB) error {
+		return tx.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&membership).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+func (r *organizationRepository) GetUserMemberships(userID uuid.UUID) ([]*domain.Membership, error) {
+	var memberships []*domain.Membership
+	err := withUser(r.db, userID, func(tx *gorm.DB) error {
+		return tx.Preload("Organization").Where("user_id = ?", userID).Order("created_at ASC").Find(&memberships).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func (r *organizationRepository) GetMembers(orgID uuid.UUID) ([]*domain.Membership, error) {
+	var memberships []*domain.Membership
+	err := withTenant(r.db, orgID, func(tx *gorm.DB) error {
+		return tx.Where("organization_id = ?", orgID).Order("created_at ASC").Find(&memberships).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func (r *organizationRepository) UpdateMembership(membership *domain.Membership) error {
+	membership.UpdatedAt = time.Now()
+	return withTenant(r.db, membership.OrganizationID, func(tx *gorm.DB) error {
+		return tx.Model(&domain.Membership{}).
+			Where("organization_id = ? AND user_id = ?", membership.OrganizationID, membership.UserID).
+			Updates(map[string]interface{}{
+				"role":       membership.Role,
+				"updated_at": membership.UpdatedAt,
+			}).Error
+	})
+}
+
+func (r *organizationRepository) DeleteMembership(orgID, userID uuid.UUID) error {
+	return withTenant(r.db, orgID, func(tx *gorm.DB) error {
+		return tx.Delete(&domain.Membership{}, "organization_id = ? AND user_id = ?", orgID, userID).Error
+	})
+}
+
+// TransferOwnership swaps the roles in one transaction. The owner is demoted
+// first, the unique index on the owner of an organization would reject two.
+func (r *organizationRepository) TransferOwnership(orgID, ownerID, newOwnerID uuid.UUID) error {
+	return withTenant(r.db, orgID, func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&domain.Membership{}).
+			Where("organization_id = ? AND user_id = ? AND role = ?", orgID, ownerID, domain.RoleOwner).
+			Updates(map[string]interface{}{"role": domain.RoleAdmin, "updated_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		result = tx.Model(&domain.Membership{}).
+			Where("organization_id = ? AND user_id = ?", orgID, newOwnerID).
+			Updates(map[string]interface{}{"role": domain.RoleOwner, "updated_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
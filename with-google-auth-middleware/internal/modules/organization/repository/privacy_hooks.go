@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/organization/domain"
+	privacyrepo "github.com/tyobaskara/jeki-backend/internal/modules/privacy/repository"
+	"gorm.io/gorm"
+)
+
+// ExportHooks export the user's memberships with their organizations
+var ExportHooks = []privacyrepo.ExportHook{
+	{Name: "organization_memberships", Export: exportMemberships},
+}
+
+// ErasureHooks refuse to erase the owner of an organization with other
+// members, and delete the organizations the user owns alone, their
+// memberships and the invitations to their email
+var ErasureHooks = []privacyrepo.ErasureHook{
+	{Name: "organizations", Check: checkOwnedOrganizations, Erase: eraseOwnedOrganizations},
+	{Name: "organization_memberships", Erase: eraseMemberships},
+	{Name: "organization_invitations", Erase: eraseInvitations},
+}
+
+func exportMemberships(db *gorm.DB, userID uuid.UUID) (interface{}, error) {
+	memberships := []*domain.Membership{}
+	err := withUser(db, userID, func(tx *gorm.DB) error {
+		return tx.Preload("Organization").Where("user_id = ?", userID).Order("created_at").Find(&memberships).Error
+	})
+	return memberships, err
+}
+
+// ownedOrganizations returns the organizations the user owns
+func ownedOrganizations(db *gorm.DB, userID uuid.UUID) ([]*domain.Membership, error) {
+	var owned []*domain.Membership
+	err := withUser(db, userID, func(tx *gorm.DB) error {
+		return tx.Preload("Organization").Where("user_id = ? AND role = ?", userID, domain.RoleOwner).Find(&owned).Error
+	})
+	return owned, err
+}
+
+// otherMembers counts the members of an organization besides the user
+func otherMembers(db *gorm.DB, orgID, userID uuid.UUID) (int64, error) {
+	var count int64
+	err := withTenant(db, orgID, func(tx *gorm.DB) error {
+		return tx.Model(&domain.Membership{}).Where("organization_id = ? AND user_id <> ?", orgID, userID).Count(&count).Error
+	})
+	return count, err
+}
+
+func checkOwnedOrganizations(db *gorm.DB, userID uuid.UUID) error {
+	owned, err := ownedOrganizations(db, userID)
+	if err != nil {
+		return err
+	}
+	for _, membership := range owned {
+		count, err := otherMembers(db, membership.OrganizationID, userID)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("you own the organization %q, which has other members: transfer its ownership first", membership.Organization.Name)
+		}
+	}
+	return nil
+}
+
+// eraseOwnedOrganizations deletes the organizations the user owns alone.
+// Members who joined an organization during the grace period keep it: its
+// longest-standing admin, or member if it has no admin, becomes the owner.
+func eraseOwnedOrganizations(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	owned, err := ownedOrganizations(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, membership := range owned {
+		err := withTenant(tx, membership.OrganizationID, func(tx *gorm.DB) error {
+			var successor domain.Membership
+			err := tx.Where("organization_id = ? AND user_id <> ?", membership.OrganizationID, userID).
+				Order(gorm.Expr("role = ? DESC, created_at", domain.RoleAdmin)).
+				First(&successor).Error
+			if err == gorm.ErrRecordNotFound {
+				deleted++
+				return tx.Delete(&domain.Organization{}, "id = ?", membership.OrganizationID).Error
+			}
+			if err != nil {
+				return err
+			}
+
+			// Deleted first, the unique index on the owner would reject two
+			if err := tx.Delete(&domain.Membership{}, "organization_id = ? AND user_id = ?", membership.OrganizationID, userID).Error; err != nil {
+				return err
+			}
+			return tx.Model(&domain.Membership{}).
+				Where("organization_id = ? AND user_id = ?", membership.OrganizationID, successor.UserID).
+				Updates(map[string]interface{}{"role": domain.RoleOwner, "updated_at": time.Now()}).Error
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return deleted, nil
+}
+
+func eraseMemberships(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	var erased int64
+	err := withUser(tx, userID, func(tx *gorm.DB) error {
+		result := tx.Delete(&domain.Membership{}, "user_id = ?", userID)
+		erased = result.RowsAffected
+		return result.Error
+	})
+	return erased, err
+}
+
+// eraseInvitations deletes the invitations to the user's email, accepted or
+// not, which would outlive the user with their email
+func eraseInvitations(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	var email string
+	if err := tx.Raw("SELECT LOWER(email) FROM users WHERE id = ?", userID).Scan(&email).Error; err != nil {
+		return 0, err
+	}
+	if email == "" {
+		return 0, nil
+	}
+
+	var erased int64
+	err := withUserEmail(tx, email, func(tx *gorm.DB) error {
+		result := tx.Delete(&domain.Invitation{}, "LOWER(email) = ?", email)
+		erased = result.RowsAffected
+		return result.Error
+	})
+	return erased, err
+}
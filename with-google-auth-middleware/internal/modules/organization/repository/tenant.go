@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Settings read by the row-level security policies of the organization tables
+const (
+	settingTenant    = "app.current_tenant"
+	settingUserID    = "app.current_user_id"
+	settingUserEmail = "app.current_user_email"
+)
+
+// withTenant runs fn in a transaction scoped to an organization. The policies
+// only let it read and write the organization's rows, a query that forgets
+// its organization_id filter finds nothing of other organizations.
+func withTenant(db *gorm.DB, orgID uuid.UUID, fn func(tx *gorm.DB) error) error {
+	return withSetting(db, settingTenant, orgID.String(), fn)
+}
+
+// withUser runs fn in a transaction scoped to a user's memberships across
+// organizations, and the organizations they belong to
+func withUser(db *gorm.DB, userID uuid.UUID, fn func(tx *gorm.DB) error) error {
+	return withSetting(db, settingUserID, userID.String(), fn)
+}
+
+// withUserEmail runs fn in a transaction scoped to the invitations of an email
+// address, and the organizations they invite to
+func withUserEmail(db *gorm.DB, email string, fn func(tx *gorm.DB) error) error {
+	return withSetting(db, settingUserEmail, email, fn)
+}
+
+// withSetting is SET LOCAL name = value for the duration of a transaction.
+// set_config is used because SET doesn't take bind parameters; the setting
+// ends with the transaction, so pooled connections don't keep it.
+//
+// In a caller's transaction, e.g. of a merge or erasure hook, db.Transaction
+// only creates a savepoint, and releasing it keeps the setting until the
+// outer transaction ends. The previous value is restored once fn succeeds,
+// so that scopes don't pile up; rolling back to the savepoint restores it
+// when fn fails.
+func withSetting(db *gorm.DB, name, value string, fn func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var previous string
+		if err := tx.Raw("SELECT COALESCE(current_setting(?, true), '')", name).Scan(&previous).Error; err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if err := tx.Exec("SELECT set_config(?, ?, true)", name, value).Error; err != nil {
+			return fmt.Errorf("failed to set %s: %w", name, err)
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if err := tx.Exec("SELECT set_config(?, ?, true)", name, previous).Error; err != nil {
+			return fmt.Errorf("failed to reset %s: %w", name, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/organization/domain"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testAppRole is the role the tests query as. Superusers, which test
+// databases are usually accessed with, bypass row-level security.
+const testAppRole = "organization_rls_test"
+
+// openTestDB migrates a throwaway schema of the database at TEST_DATABASE_URL
+// and returns a connection querying it as testAppRole. The user must be
+// allowed to create schemas and roles.
+func openTestDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	// One connection, so that search_path and the role stick
+	sqlDB.SetMaxOpenConns(1)
+
+	schema := "rls_test_" + uuid.NewString()[:8]
+	require.NoError(t, db.Exec("CREATE SCHEMA "+schema).Error)
+	require.NoError(t, db.Exec("SET search_path TO "+schema).Error)
+	t.Cleanup(func() {
+		db.Exec("RESET ROLE")
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		sqlDB.Close()
+	})
+
+	for _, dir := range []string{"../../user/repository/migrations", "migrations"} {
+		files, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+		require.NoError(t, err)
+		sort.Strings(files)
+		for _, file := range files {
+			migration, err := os.ReadFile(file)
+			require.NoError(t, err)
+			require.NoError(t, db.Exec(string(migration)).Error, file)
+		}
+	}
+
+	require.NoError(t, db.Exec(fmt.Sprintf(
+		"DO $$ BEGIN IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = '%s') THEN CREATE ROLE %s NOLOGIN; END IF; END $$",
+		testAppRole, testAppRole,
+	)).Error)
+	require.NoError(t, db.Exec(fmt.Sprintf("GRANT USAGE ON SCHEMA %s TO %s", schema, testAppRole)).Error)
+	require.NoError(t, db.Exec(fmt.Sprintf("GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA %s TO %s", schema, testAppRole)).Error)
+	require.NoError(t, db.Exec(fmt.Sprintf("GRANT EXECUTE ON ALL FUNCTIONS IN SCHEMA %s TO %s", schema, testAppRole)).Error)
+	return db
+}
+
+// createUser inserts a user before the tests switch to testAppRole
+func createUser(t *testing.T, db *gorm.DB, email string) uuid.UUID {
+	id := uuid.New()
+	require.NoError(t, db.Exec("INSERT INTO users (id, email, name) VALUES (?, ?, ?)", id, email, email).Error)
+	return id
+}
+
+func TestRowLevelSecurity(t *testing.T) {
+	db := openTestDB(t)
+	alice := createUser(t, db, "alice@example.com")
+	bob := createUser(t, db, "bob@example.com")
+	require.NoError(t, db.Exec("SET ROLE "+testAppRole).Error)
+
+	orgs := NewOrganizationRepository(db)
+	invitations := NewInvitationRepository(db)
+	now := time.Now()
+	newOrg := func(name string, owner uuid.UUID) *domain.Organization {
+		org := &domain.Organization{ID: uuid.New(), Name: name, CreatedAt: now, UpdatedAt: now}
+		require.NoError(t, orgs.Create(org, &domain.Membership{
+			OrganizationID: org.ID, UserID: owner, Role: domain.RoleOwner, CreatedAt: now, UpdatedAt: now,
+		}))
+		return org
+	}
+	acme := newOrg("Acme", alice)
+	globex := newOrg("Globex", bob)
+	require.NoError(t, invitations.Create(&domain.Invitation{
+		ID: uuid.New(), OrganizationID: globex.ID, Email: "Alice@Example.com", Role: domain.RoleMember,
+		ExpiresAt: now.Add(time.Hour), CreatedAt: now, UpdatedAt: now,
+	}))
+
+	t.Run("query without a filter", func(t *testing.T) {
+		var memberships []domain.Membership
+		var organizations []domain.Organization
+		require.NoError(t, withTenant(db, acme.ID, func(tx *gorm.DB) error {
+			if err := tx.Find(&memberships).Error; err != nil {
+				return err
+			}
+			return tx.Find(&organizations).Error
+		}))
+		require.Len(t, memberships, 1)
+		assert.Equal(t, acme.ID, memberships[0].OrganizationID)
+		require.Len(t, organizations, 1)
+		assert.Equal(t, acme.ID, organizations[0].ID)
+	})
+
+	t.Run("query of another organization", func(t *testing.T) {
+		var memberships []domain.Membership
+		var invites []domain.Invitation
+		require.NoError(t, withTenant(db, acme.ID, func(tx *gorm.DB) error {
+			if err := tx.Where("organization_id = ?", globex.ID).Find(&memberships).Error; err != nil {
+				return err
+			}
+			return tx.Where("organization_id = ?", globex.ID).Find(&invites).Error
+		}))
+		assert.Empty(t, memberships)
+		assert.Empty(t, invites)
+
+		_, err := orgs.FindMembership(acme.ID, bob)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("writes to another organization", func(t *testing.T) {
+		var updated int64
+		require.NoError(t, withTenant(db, acme.ID, func(tx *gorm.DB) error {
+			result := tx.Model(&domain.Membership{}).Where("organization_id = ?", globex.ID).Update("role", domain.RoleMember)
+			updated = result.RowsAffected
+			return result.Error
+		}))
+		assert.Zero(t, updated)
+
+		err := withTenant(db, acme.ID, func(tx *gorm.DB) error {
+			return tx.Omit("Organization").Create(&domain.Membership{
+				OrganizationID: globex.ID, UserID: alice, Role: domain.RoleAdmin, CreatedAt: now, UpdatedAt: now,
+			}).Error
+		})
+		assert.Error(t, err, "row violates the policy")
+	})
+
+	t.Run("query without a scope", func(t *testing.T) {
+		var memberships []domain.Membership
+		require.NoError(t, db.Find(&memberships).Error)
+		assert.Empty(t, memberships)
+	})
+
+	t.Run("user scope", func(t *testing.T) {
+		memberships, err := orgs.GetUserMemberships(alice)
+		require.NoError(t, err)
+		require.Len(t, memberships, 1)
+		require.NotNil(t, memberships[0].Organization)
+		assert.Equal(t, "Acme", memberships[0].Organization.Name)
+
+		pending, err := invitations.FindPendingByEmail("alice@example.com")
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		require.NotNil(t, pending[0].Organization)
+		assert.Equal(t, "Globex", pending[0].Organization.Name)
+
+		pending, err = invitations.FindPendingByEmail("bob@example.com")
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+}
+
+func TestMergeMemberships(t *testing.T) {
+	db := openTestDB(t)
+	guest := createUser(t, db, "")
+	alice := createUser(t, db, "alice@example.com")
+	bob := createUser(t, db, "bob@example.com")
+	require.NoError(t, db.Exec("SET ROLE "+testAppRole).Error)
+
+	orgs := NewOrganizationRepository(db)
+	now := time.Now()
+	newOrg := func(name string, members map[uuid.UUID]string) *domain.Organization {
+		org := &domain.Organization{ID: uuid.New(), Name: name, CreatedAt: now, UpdatedAt: now}
+		require.NoError(t, withTenant(db, org.ID, func(tx *gorm.DB) error {
+			if err := tx.Create(org).Error; err != nil {
+				return err
+			}
+			for userID, role := range members {
+				err := tx.Omit("Organization").Create(&domain.Membership{
+					OrganizationID: org.ID, UserID: userID, Role: role, CreatedAt: now, UpdatedAt: now,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		return org
+	}
+	own := newOrg("Guest's", map[uuid.UUID]string{guest: domain.RoleOwner})
+	shared := newOrg("Shared", map[uuid.UUID]string{guest: domain.RoleOwner, alice: domain.RoleMember})
+	other := newOrg("Bob's", map[uuid.UUID]string{bob: domain.RoleOwner, guest: domain.RoleMember, alice: domain.RoleAdmin})
+
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		moved, err := mergeMemberships(tx, guest, alice)
+		assert.Equal(t, int64(3), moved)
+		return err
+	}))
+
+	memberships, err := orgs.GetUserMemberships(guest)
+	require.NoError(t, err)
+	assert.Empty(t, memberships)
+
+	roles := map[uuid.UUID]string{}
+	memberships, err = orgs.GetUserMemberships(alice)
+	require.NoError(t, err)
+	for _, membership := range memberships {
+		roles[membership.OrganizationID] = membership.Role
+	}
+	assert.Equal(t, map[uuid.UUID]string{
+		own.ID:    domain.RoleOwner,
+		shared.ID: domain.RoleOwner,
+		other.ID:  domain.RoleAdmin,
+	}, roles)
+}
+
+func TestWithSettingNested(t *testing.T) {
+	db := openTestDB(t)
+	alice := createUser(t, db, "alice@example.com")
+	orgID := uuid.New()
+	require.NoError(t, db.Exec("SET ROLE "+testAppRole).Error)
+
+	setting := func(tx *gorm.DB, name string) string {
+		var value string
+		require.NoError(t, tx.Raw("SELECT COALESCE(current_setting(?, true), '')", name).Scan(&value).Error)
+		return value
+	}
+
+	// Like the merge and erasure hooks, the scopes run in a caller's transaction
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		require.NoError(t, withTenant(tx, orgID, func(tx *gorm.DB) error {
+			assert.Equal(t, orgID.String(), setting(tx, settingTenant))
+			return withUser(tx, alice, func(tx *gorm.DB) error {
+				assert.Equal(t, orgID.String(), setting(tx, settingTenant))
+				assert.Equal(t, alice.String(), setting(tx, settingUserID))
+				return nil
+			})
+		}))
+		assert.Empty(t, setting(tx, settingTenant))
+		assert.Empty(t, setting(tx, settingUserID))
+
+		err := withUserEmail(tx, "alice@example.com", func(tx *gorm.DB) error {
+			return fmt.Errorf("failed")
+		})
+		assert.Error(t, err)
+		assert.Empty(t, setting(tx, settingUserEmail))
+		return nil
+	}))
+}
@@ -0,0 +1,360 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/organization/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/gorm"
+)
+
+// Custom errors
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrInvalidName          = errors.New("invalid organization name")
+	ErrInvalidEmail         = errors.New("invalid email address")
+	ErrInvalidRole          = errors.New("invalid role")
+	ErrNotMember            = errors.New("not a member of the organization")
+	ErrInsufficientRole     = errors.New("insufficient organization role")
+	ErrAlreadyMember        = errors.New("already a member of the organization")
+	ErrOwnerCannotLeave     = errors.New("the owner can't leave the organization, transfer ownership first")
+	ErrInvitationNotFound   = errors.New("invitation not found")
+)
+
+// maxNameLength matches the organizations.name column
+const maxNameLength = 255
+
+type organizationUsecase struct {
+	orgRepo        domain.OrganizationRepository
+	invitationRepo domain.InvitationRepository
+	userRepo       userdomain.UserRepository
+	invitationTTL  time.Duration
+}
+
+// NewOrganizationUsecase creates a new instance of OrganizationUsecase.
+// Invitations expire after invitationTTL.
+func NewOrganizationUsecase(
+	orgRepo domain.OrganizationRepository,
+	invitationRepo domain.InvitationRepository,
+	userRepo userdomain.UserRepository,
+	invitationTTL time.Duration,
+) domain.OrganizationUsecase {
+	return &organizationUsecase{
+		orgRepo:        orgRepo,
+		invitationRepo: invitationRepo,
+		userRepo:       userRepo,
+		invitationTTL:  invitationTTL,
+	}
+}
+
+func (u *organizationUsecase) CreateOrganization(ctx context.Context, userID uuid.UUID, name string) (*domain.Organization, error) {
+	name, err := validateName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	org := &domain.Organization{
+		ID:        uuid.New(),
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	owner := &domain.Membership{
+		OrganizationID: org.ID,
+		UserID:         userID,
+		Role:           domain.RoleOwner,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := u.orgRepo.Create(org, owner); err != nil {
+		return nil, fmt.Errorf("failed to create organization: %w", err)
+	}
+	return org, nil
+}
+
+func (u *organizationUsecase) ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]*domain.Membership, error) {
+	return u.orgRepo.GetUserMemberships(userID)
+}
+
+func (u *organizationUsecase) GetOrganization(ctx context.Context, orgID uuid.UUID) (*domain.Organization, error) {
+	org, err := u.orgRepo.FindByID(orgID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, fmt.Errorf("failed to find organization: %w", err)
+	}
+	return org, nil
+}
+
+func (u *organizationUsecase) UpdateOrganization(ctx context.Context, actorID, orgID uuid.UUID, name string) (*domain.Organization, error) {
+	if _, err := u.requireRole(orgID, actorID, domain.RoleAdmin); err != nil {
+		return nil, err
+	}
+	name, err := validateName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := u.GetOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	org.Name = name
+	if err := u.orgRepo.Update(org); err != nil {
+		return nil, fmt.Errorf("failed to update organization: %w", err)
+	}
+	return org, nil
+}
+
+func (u *organizationUsecase) DeleteOrganization(ctx context.Context, actorID, orgID uuid.UUID) error {
+	if _, err := u.requireRole(orgID, actorID, domain.RoleOwner); err != nil {
+		return err
+	}
+	if err := u.orgRepo.Delete(orgID); err != nil {
+		return fmt.Errorf("failed to delete organization: %w", err)
+	}
+	return nil
+}
+
+func (u *organizationUsecase) ListMembers(ctx context.Context, orgID uuid.UUID) ([]*domain.Membership, error) {
+	return u.orgRepo.GetMembers(orgID)
+}
+
+// UpdateMemberRole changes a member's role to member or admin. Ownership only
+// changes hands through TransferOwnership.
+func (u *organizationUsecase) UpdateMemberRole(ctx context.Context, actorID, orgID, userID uuid.UUID, role string) (*domain.Membership, error) {
+	if role != domain.RoleMember && role != domain.RoleAdmin {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRole, role)
+	}
+	if _, err := u.requireRole(orgID, actorID, domain.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	membership, err := u.findMembership(orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if membership.Role == domain.RoleOwner {
+		return nil, fmt.Errorf("%w: the owner's role changes by transferring ownership", ErrInsufficientRole)
+	}
+	membership.Role = role
+	if err := u.orgRepo.UpdateMembership(membership); err != nil {
+		return nil, fmt.Errorf("failed to update membership: %w", err)
+	}
+	return membership, nil
+}
+
+func (u *organizationUsecase) RemoveMember(ctx context.Context, actorID, orgID, userID uuid.UUID) error {
+	membership, err := u.findMembership(orgID, userID)
+	if err != nil {
+		return err
+	}
+	if membership.Role == domain.RoleOwner {
+		return ErrOwnerCannotLeave
+	}
+	if actorID != userID {
+		if _, err := u.requireRole(orgID, actorID, domain.RoleAdmin); err != nil {
+			return err
+		}
+	}
+	if err := u.orgRepo.DeleteMembership(orgID, userID); err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	return nil
+}
+
+// TransferOwnership makes another member the owner, the current owner becomes an admin
+func (u *organizationUsecase) TransferOwnership(ctx context.Context, actorID, orgID, newOwnerID uuid.UUID) error {
+	if _, err := u.requireRole(orgID, actorID, domain.RoleOwner); err != nil {
+		return err
+	}
+	if actorID == newOwnerID {
+		return fmt.Errorf("%w: already the owner", ErrInvalidRole)
+	}
+	if _, err := u.findMembership(orgID, newOwnerID); err != nil {
+		return err
+	}
+	if err := u.orgRepo.TransferOwnership(orgID, actorID, newOwnerID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInsufficientRole
+		}
+		return fmt.Errorf("failed to transfer ownership: %w", err)
+	}
+	return nil
+}
+
+func (u *organizationUsecase) InviteMember(ctx context.Context, actorID, orgID uuid.UUID, email, role string) (*domain.Invitation, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEmail, err)
+	}
+	if role == "" {
+		role = domain.RoleMember
+	}
+	if role != domain.RoleMember && role != domain.RoleAdmin {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRole, role)
+	}
+	if _, err := u.requireRole(orgID, actorID, domain.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	email = strings.ToLower(address.Address)
+	user, err := u.userRepo.FindByEmail(email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if user != nil {
+		if _, err := u.findMembership(orgID, user.ID); err == nil {
+			return nil, ErrAlreadyMember
+		} else if !errors.Is(err, ErrNotMember) {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	invitation := &domain.Invitation{
+		ID:             uuid.New(),
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           role,
+		InvitedBy:      &actorID,
+		ExpiresAt:      now.Add(u.invitationTTL),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := u.invitationRepo.Create(invitation); err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+	return invitation, nil
+}
+
+func (u *organizationUsecase) ListInvitations(ctx context.Context, orgID uuid.UUID) ([]*domain.Invitation, error) {
+	return u.invitationRepo.GetByOrganization(orgID)
+}
+
+func (u *organizationUsecase) RevokeInvitation(ctx context.Context, actorID, orgID, id uuid.UUID) error {
+	if _, err := u.requireRole(orgID, actorID, domain.RoleAdmin); err != nil {
+		return err
+	}
+	if err := u.invitationRepo.Revoke(orgID, id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvitationNotFound
+		}
+		return fmt.Errorf("failed to revoke invitation: %w", err)
+	}
+	return nil
+}
+
+func (u *organizationUsecase) ListUserInvitations(ctx context.Context, userID uuid.UUID) ([]*domain.Invitation, error) {
+	user, err := u.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return u.invitationRepo.FindPendingByEmail(user.Email)
+}
+
+// AcceptInvitation adds the user to the organization with the invited role.
+// Only the user signed in with the invited email can accept it, the
+// invitation is looked up among theirs.
+func (u *organizationUsecase) AcceptInvitation(ctx context.Context, userID, invitationID uuid.UUID) (*domain.Membership, error) {
+	user, err := u.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := u.invitationRepo.FindPendingByEmail(user.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find invitation: %w", err)
+	}
+	var invitation *domain.Invitation
+	for _, candidate := range pending {
+		if candidate.ID == invitationID {
+			invitation = candidate
+			break
+		}
+	}
+	if invitation == nil {
+		return nil, ErrInvitationNotFound
+	}
+	if _, err := u.findMembership(invitation.OrganizationID, userID); err == nil {
+		return nil, ErrAlreadyMember
+	} else if !errors.Is(err, ErrNotMember) {
+		return nil, err
+	}
+
+	now := time.Now()
+	membership := &domain.Membership{
+		OrganizationID: invitation.OrganizationID,
+		UserID:         userID,
+		Role:           invitation.Role,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := u.invitationRepo.Accept(invitation, membership); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, fmt.Errorf("failed to accept invitation: %w", err)
+	}
+	return membership, nil
+}
+
+func (u *organizationUsecase) MemberRole(ctx context.Context, orgID, userID uuid.UUID) (string, error) {
+	membership, err := u.findMembership(orgID, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotMember) {
+			return "", nil
+		}
+		return "", err
+	}
+	return membership.Role, nil
+}
+
+// requireRole returns the actor's membership if they have at least the minimum role
+func (u *organizationUsecase) requireRole(orgID, actorID uuid.UUID, minimum string) (*domain.Membership, error) {
+	membership, err := u.findMembership(orgID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !domain.RoleAtLeast(membership.Role, minimum) {
+		return nil, fmt.Errorf("%w: requires %s", ErrInsufficientRole, minimum)
+	}
+	return membership, nil
+}
+
+func (u *organizationUsecase) findMembership(orgID, userID uuid.UUID) (*domain.Membership, error) {
+	membership, err := u.orgRepo.FindMembership(orgID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotMember
+		}
+		return nil, fmt.Errorf("failed to find membership: %w", err)
+	}
+	return membership, nil
+}
+
+func (u *organizationUsecase) findUser(userID uuid.UUID) (*userdomain.User, error) {
+	user, err := u.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrNotMember
+	}
+	return user, nil
+}
+
+func validateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxNameLength {
+		return "", ErrInvalidName
+	}
+	return name, nil
+}
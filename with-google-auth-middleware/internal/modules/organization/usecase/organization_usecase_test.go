@@ -0,0 +1,304 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/organization/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/gorm"
+)
+
+// fakeUserRepository keeps users in memory
+type fakeUserRepository struct {
+	userdomain.UserRepository
+	users []*userdomain.User
+}
+
+func (f *fakeUserRepository) FindByID(id uuid.UUID) (*userdomain.User, error) {
+	for _, user := range f.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUserRepository) FindByEmail(email string) (*userdomain.User, error) {
+	for _, user := range f.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+type membershipKey struct {
+	orgID, userID uuid.UUID
+}
+
+// fakeOrganizationRepository keeps organizations and memberships in memory
+type fakeOrganizationRepository struct {
+	orgs        map[uuid.UUID]*domain.Organization
+	memberships map[membershipKey]*domain.Membership
+}
+
+func (f *fakeOrganizationRepository) Create(org *domain.Organization, owner *domain.Membership) error {
+	f.orgs[org.ID] = org
+	f.memberships[membershipKey{owner.OrganizationID, owner.UserID}] = owner
+	return nil
+}
+
+func (f *fakeOrganizationRepository) FindByID(id uuid.UUID) (*domain.Organization, error) {
+	org, ok := f.orgs[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return org, nil
+}
+
+func (f *fakeOrganizationRepository) Update(org *domain.Organization) error {
+	f.orgs[org.ID] = org
+	return nil
+}
+
+func (f *fakeOrganizationRepository) Delete(id uuid.UUID) error {
+	delete(f.orgs, id)
+	for key := range f.memberships {
+		if key.orgID == id {
+			delete(f.memberships, key)
+		}
+	}
+	return nil
+}
+
+func (f *fakeOrganizationRepository) FindMembership(orgID, userID uuid.UUID) (*domain.Membership, error) {
+	membership, ok := f.memberships[membershipKey{orgID, userID}]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *membership
+	return &copied, nil
+}
+
+func (f *fakeOrganizationRepository) GetUserMemberships(userID uuid.UUID) ([]*domain.Membership, error) {
+	var memberships []*domain.Membership
+	for key, membership := range f.memberships {
+		if key.userID == userID {
+			memberships = append(memberships, membership)
+		}
+	}
+	return memberships, nil
+}
+
+func (f *fakeOrganizationRepository) GetMembers(orgID uuid.UUID) ([]*domain.Membership, error) {
+	var memberships []*domain.Membership
+	for key, membership := range f.memberships {
+		if key.orgID == orgID {
+			memberships = append(memberships, membership)
+		}
+	}
+	return memberships, nil
+}
+
+func (f *fakeOrganizationRepository) UpdateMembership(membership *domain.Membership) error {
+	copied := *membership
+	f.memberships[membershipKey{membership.OrganizationID, membership.UserID}] = &copied
+	return nil
+}
+
+func (f *fakeOrganizationRepository) DeleteMembership(orgID, userID uuid.UUID) error {
+	delete(f.memberships, membershipKey{orgID, userID})
+	return nil
+}
+
+func (f *fakeOrganizationRepository) TransferOwnership(orgID, ownerID, newOwnerID uuid.UUID) error {
+	owner, ok := f.memberships[membershipKey{orgID, ownerID}]
+	if !ok || owner.Role != domain.RoleOwner {
+		return gorm.ErrRecordNotFound
+	}
+	newOwner, ok := f.memberships[membershipKey{orgID, newOwnerID}]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	owner.Role = domain.RoleAdmin
+	newOwner.Role = domain.RoleOwner
+	return nil
+}
+
+// fakeInvitationRepository keeps invitations in memory
+type fakeInvitationRepository struct {
+	invitations map[uuid.UUID]*domain.Invitation
+	orgs        *fakeOrganizationRepository
+}
+
+func (f *fakeInvitationRepository) Create(invitation *domain.Invitation) error {
+	f.invitations[invitation.ID] = invitation
+	return nil
+}
+
+func (f *fakeInvitationRepository) FindPendingByEmail(email string) ([]*domain.Invitation, error) {
+	var invitations []*domain.Invitation
+	for _, invitation := range f.invitations {
+		if strings.EqualFold(invitation.Email, email) && invitation.IsPending() {
+			invitations = append(invitations, invitation)
+		}
+	}
+	return invitations, nil
+}
+
+func (f *fakeInvitationRepository) GetByOrganization(orgID uuid.UUID) ([]*domain.Invitation, error) {
+	var invitations []*domain.Invitation
+	for _, invitation := range f.invitations {
+		if invitation.OrganizationID == orgID {
+			invitations = append(invitations, invitation)
+		}
+	}
+	return invitations, nil
+}
+
+func (f *fakeInvitationRepository) Accept(invitation *domain.Invitation, membership *domain.Membership) error {
+	stored, ok := f.invitations[invitation.ID]
+	if !ok || !stored.IsPending() {
+		return gorm.ErrRecordNotFound
+	}
+	now := time.Now()
+	stored.AcceptedAt = &now
+	stored.AcceptedBy = &membership.UserID
+	f.orgs.memberships[membershipKey{membership.OrganizationID, membership.UserID}] = membership
+	return nil
+}
+
+func (f *fakeInvitationRepository) Revoke(orgID, id uuid.UUID) error {
+	invitation, ok := f.invitations[id]
+	if !ok || invitation.OrganizationID != orgID || invitation.AcceptedAt != nil || invitation.RevokedAt != nil {
+		return gorm.ErrRecordNotFound
+	}
+	now := time.Now()
+	invitation.RevokedAt = &now
+	return nil
+}
+
+func newTestOrganizationUsecase(users ...*userdomain.User) (domain.OrganizationUsecase, *fakeOrganizationRepository) {
+	orgRepo := &fakeOrganizationRepository{
+		orgs:        map[uuid.UUID]*domain.Organization{},
+		memberships: map[membershipKey]*domain.Membership{},
+	}
+	invitationRepo := &fakeInvitationRepository{invitations: map[uuid.UUID]*domain.Invitation{}, orgs: orgRepo}
+	return NewOrganizationUsecase(orgRepo, invitationRepo, &fakeUserRepository{users: users}, 24*time.Hour), orgRepo
+}
+
+func TestRoleAtLeast(t *testing.T) {
+	assert.True(t, domain.RoleAtLeast(domain.RoleOwner, domain.RoleAdmin))
+	assert.True(t, domain.RoleAtLeast(domain.RoleAdmin, domain.RoleAdmin))
+	assert.False(t, domain.RoleAtLeast(domain.RoleMember, domain.RoleAdmin))
+	assert.False(t, domain.RoleAtLeast("", domain.RoleMember))
+	assert.False(t, domain.RoleAtLeast(domain.RoleOwner, "superuser"))
+}
+
+func TestOrganizationMembership(t *testing.T) {
+	ctx := context.Background()
+	owner := &userdomain.User{ID: uuid.New(), Email: "owner@example.com"}
+	invitee := &userdomain.User{ID: uuid.New(), Email: "invitee@example.com"}
+	stranger := &userdomain.User{ID: uuid.New(), Email: "stranger@example.com"}
+	u, _ := newTestOrganizationUsecase(owner, invitee, stranger)
+
+	org, err := u.CreateOrganization(ctx, owner.ID, "  Acme  ")
+	require.NoError(t, err)
+	assert.Equal(t, "Acme", org.Name)
+	role, err := u.MemberRole(ctx, org.ID, owner.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.RoleOwner, role)
+
+	_, err = u.CreateOrganization(ctx, owner.ID, " ")
+	assert.ErrorIs(t, err, ErrInvalidName)
+
+	t.Run("invitation", func(t *testing.T) {
+		_, err := u.InviteMember(ctx, owner.ID, org.ID, "Invitee@Example.com", domain.RoleOwner)
+		assert.ErrorIs(t, err, ErrInvalidRole)
+		_, err = u.InviteMember(ctx, stranger.ID, org.ID, "invitee@example.com", "")
+		assert.ErrorIs(t, err, ErrNotMember)
+		_, err = u.InviteMember(ctx, owner.ID, org.ID, "owner@example.com", "")
+		assert.ErrorIs(t, err, ErrAlreadyMember)
+
+		invitation, err := u.InviteMember(ctx, owner.ID, org.ID, "Invitee@Example.com", "")
+		require.NoError(t, err)
+		assert.Equal(t, "invitee@example.com", invitation.Email)
+		assert.Equal(t, domain.RoleMember, invitation.Role)
+
+		pending, err := u.ListUserInvitations(ctx, invitee.ID)
+		require.NoError(t, err)
+		assert.Len(t, pending, 1)
+
+		// Invitations of other emails are invisible
+		_, err = u.AcceptInvitation(ctx, stranger.ID, invitation.ID)
+		assert.ErrorIs(t, err, ErrInvitationNotFound)
+
+		membership, err := u.AcceptInvitation(ctx, invitee.ID, invitation.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.RoleMember, membership.Role)
+
+		_, err = u.AcceptInvitation(ctx, invitee.ID, invitation.ID)
+		assert.ErrorIs(t, err, ErrInvitationNotFound)
+	})
+
+	t.Run("roles", func(t *testing.T) {
+		_, err := u.UpdateOrganization(ctx, invitee.ID, org.ID, "Renamed")
+		assert.ErrorIs(t, err, ErrInsufficientRole)
+		_, err = u.UpdateMemberRole(ctx, invitee.ID, org.ID, invitee.ID, domain.RoleAdmin)
+		assert.ErrorIs(t, err, ErrInsufficientRole)
+		_, err = u.UpdateMemberRole(ctx, owner.ID, org.ID, invitee.ID, domain.RoleOwner)
+		assert.ErrorIs(t, err, ErrInvalidRole)
+
+		membership, err := u.UpdateMemberRole(ctx, owner.ID, org.ID, invitee.ID, domain.RoleAdmin)
+		require.NoError(t, err)
+		assert.Equal(t, domain.RoleAdmin, membership.Role)
+
+		_, err = u.UpdateMemberRole(ctx, invitee.ID, org.ID, owner.ID, domain.RoleMember)
+		assert.ErrorIs(t, err, ErrInsufficientRole)
+		assert.ErrorIs(t, u.DeleteOrganization(ctx, invitee.ID, org.ID), ErrInsufficientRole)
+	})
+
+	t.Run("transfer ownership", func(t *testing.T) {
+		assert.ErrorIs(t, u.TransferOwnership(ctx, invitee.ID, org.ID, invitee.ID), ErrInsufficientRole)
+		assert.ErrorIs(t, u.TransferOwnership(ctx, owner.ID, org.ID, stranger.ID), ErrNotMember)
+		assert.ErrorIs(t, u.RemoveMember(ctx, owner.ID, org.ID, owner.ID), ErrOwnerCannotLeave)
+
+		require.NoError(t, u.TransferOwnership(ctx, owner.ID, org.ID, invitee.ID))
+		role, err := u.MemberRole(ctx, org.ID, invitee.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.RoleOwner, role)
+		role, err = u.MemberRole(ctx, org.ID, owner.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.RoleAdmin, role)
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		require.NoError(t, u.RemoveMember(ctx, owner.ID, org.ID, owner.ID))
+		role, err := u.MemberRole(ctx, org.ID, owner.ID)
+		require.NoError(t, err)
+		assert.Empty(t, role)
+	})
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	ctx := context.Background()
+	owner := &userdomain.User{ID: uuid.New(), Email: "owner@example.com"}
+	u, _ := newTestOrganizationUsecase(owner)
+	org, err := u.CreateOrganization(ctx, owner.ID, "Acme")
+	require.NoError(t, err)
+	other, err := u.CreateOrganization(ctx, owner.ID, "Other")
+	require.NoError(t, err)
+
+	invitation, err := u.InviteMember(ctx, owner.ID, org.ID, "invitee@example.com", domain.RoleAdmin)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, u.RevokeInvitation(ctx, owner.ID, other.ID, invitation.ID), ErrInvitationNotFound)
+	require.NoError(t, u.RevokeInvitation(ctx, owner.ID, org.ID, invitation.ID))
+	assert.ErrorIs(t, u.RevokeInvitation(ctx, owner.ID, org.ID, invitation.ID), ErrInvitationNotFound)
+}
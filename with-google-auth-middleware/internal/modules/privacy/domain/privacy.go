@@ -0,0 +1,120 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Formats of data exports
+const (
+	ExportFormatZIP  = "zip"  // One JSON file per section
+	ExportFormatJSON = "json" // A single JSON document
+)
+
+// Statuses of data exports
+const (
+	ExportStatusPending  = "pending"  // Waiting for the worker
+	ExportStatusBuilding = "building" // Being collected by a worker
+	ExportStatusReady    = "ready"    // Can be downloaded until ExpiresAt
+	ExportStatusFailed   = "failed"
+)
+
+// DataExport is a user's request for a copy of everything stored about them.
+// The worker collects the sections of the modules' export hooks into Content.
+type DataExport struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Format      string     `json:"format"`
+	Status      string     `json:"status"`
+	Content     []byte     `json:"-"`
+	Size        int        `json:"size,omitempty"` // Bytes of the finished export
+	StartedAt   *time.Time `json:"-"`              // When a worker claimed the export, stale claims are retried
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // The export is deleted after this time
+	CreatedAt   time.Time  `json:"created_at"`
+	// DownloadURL is a signed link to the ready export, valid until DownloadExpiresAt
+	DownloadURL       string     `json:"download_url,omitempty" gorm:"-"`
+	DownloadExpiresAt *time.Time `json:"download_expires_at,omitempty" gorm:"-"`
+}
+
+// ContentType returns the media type of the export's content
+func (e *DataExport) ContentType() string {
+	if e.Format == ExportFormatJSON {
+		return "application/json"
+	}
+	return "application/zip"
+}
+
+// Erasure schedules the deletion of a user's account at the end of a grace
+// period. The record outlives the user: once erased it only keeps the user's
+// ID and the rows erased per hook.
+type Erasure struct {
+	UserID      uuid.UUID        `json:"user_id" gorm:"primaryKey"`
+	RequestedAt time.Time        `json:"requested_at"`
+	EraseAt     time.Time        `json:"erase_at"` // End of the grace period
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+	Erased      map[string]int64 `json:"erased,omitempty" gorm:"serializer:json"` // Rows erased per erasure hook
+}
+
+// ExportRepository defines the interface for data export access
+type ExportRepository interface {
+	Create(export *DataExport) error
+	FindByID(id uuid.UUID) (*DataExport, error)
+	// HasActive reports whether the user has an export that isn't finished
+	HasActive(userID uuid.UUID) (bool, error)
+	// Claim marks the oldest pending export, or one a worker claimed before
+	// staleBefore without finishing it, as building and returns it. It returns
+	// nil when there's nothing to build.
+	Claim(now, staleBefore time.Time) (*DataExport, error)
+	// Collect runs the modules' export hooks and returns the JSON encoded
+	// sections of the user's data, keyed by hook name
+	Collect(userID uuid.UUID) (map[string][]byte, error)
+	// Complete stores the outcome of a built export
+	Complete(export *DataExport) error
+	// DeleteExpired deletes exports past their expiry
+	DeleteExpired(now time.Time) error
+}
+
+// ErasureRepository defines the interface for erasure data access
+type ErasureRepository interface {
+	Create(erasure *Erasure) error
+	// FindByUser returns nil if the user's erasure isn't scheduled
+	FindByUser(userID uuid.UUID) (*Erasure, error)
+	// Delete cancels an erasure that hasn't been carried out
+	Delete(userID uuid.UUID) error
+	// Check runs the checks of the modules' erasure hooks, which refuse the
+	// erasure of e.g. the owner of an organization with other members
+	Check(userID uuid.UUID) error
+	// FindDue returns the users whose grace period ended
+	FindDue(now time.Time, limit int) ([]uuid.UUID, error)
+	// Erase runs the modules' erasure hooks and deletes the user, and records
+	// the rows erased per hook, in one transaction. It returns false when the
+	// erasure was cancelled or carried out by another worker.
+	Erase(userID uuid.UUID, now time.Time) (bool, error)
+}
+
+// SessionRevoker ends every session of a user. The auth repository implements it.
+type SessionRevoker interface {
+	DeleteUserSessions(userID uuid.UUID) error
+}
+
+// PrivacyUsecase defines the interface for data export and erasure business logic
+type PrivacyUsecase interface {
+	// RequestExport requests an export of the user's data, built in the background
+	RequestExport(ctx context.Context, userID uuid.UUID, format string) (*DataExport, error)
+	// GetExport returns the user's export, with a download link once it's ready
+	GetExport(ctx context.Context, userID, exportID uuid.UUID) (*DataExport, error)
+	// DownloadExport returns the export of a signed download link
+	DownloadExport(ctx context.Context, exportID uuid.UUID, expires, signature string) (*DataExport, error)
+	// ScheduleErasure schedules the erasure of the user's account and ends their sessions
+	ScheduleErasure(ctx context.Context, userID uuid.UUID) (*Erasure, error)
+	// GetErasure returns the user's scheduled erasure
+	GetErasure(ctx context.Context, userID uuid.UUID) (*Erasure, error)
+	// CancelErasure cancels the user's erasure during the grace period
+	CancelErasure(ctx context.Context, userID uuid.UUID) error
+	// Run builds requested exports and erases accounts whose grace period
+	// ended until ctx is done, every interval and as soon as an export is requested
+	Run(ctx context.Context, interval time.Duration)
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/privacy/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/privacy/usecase"
+)
+
+type PrivacyHandler struct {
+	privacyUsecase domain.PrivacyUsecase
+}
+
+func NewPrivacyHandler(privacyUsecase domain.PrivacyUsecase) *PrivacyHandler {
+	return &PrivacyHandler{
+		privacyUsecase: privacyUsecase,
+	}
+}
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// ExportRequest represents the body of a data export request
+type ExportRequest struct {
+	Format string `json:"format"`
+}
+
+// RegisterRoutes registers the export and erasure routes of signed-in users.
+// The router group must require a first-party access token. stepUp demands
+// a recent sign-in before the account is scheduled for erasure.
+func (h *PrivacyHandler) RegisterRoutes(router *gin.RouterGroup, stepUp gin.HandlerFunc) {
+	router.POST("/me/export", h.RequestExport)
+	router.GET("/me/export/:id", h.GetExport)
+	router.DELETE("/me", stepUp, h.ScheduleErasure)
+	router.GET("/me/erasure", h.GetErasure)
+	router.DELETE("/me/erasure", h.CancelErasure)
+}
+
+// RegisterDownloadRoutes registers the download of exports. The route is
+// authenticated by the signature of the link, not by an access token.
+func (h *PrivacyHandler) RegisterDownloadRoutes(router *gin.RouterGroup) {
+	router.GET("/exports/:id/download", h.DownloadExport)
+}
+
+// RequestExport handles requesting an export of the user's data
+// @Summary Export my data
+// @Description Request a copy of everything stored about the current user, built in the background. Poll the export until it's ready and download it from its download_url.
+// @Tags privacy
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body ExportRequest false "Format, zip (default) or json"
+// @Success 202 {object} domain.DataExport
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/export [post]
+func (h *PrivacyHandler) RequestExport(c *gin.Context) {
+	var req ExportRequest
+	// The body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: err.Error(),
+			})
+			return
+		}
+	}
+
+	userID, _ := c.Get("user_id")
+	export, err := h.privacyUsecase.RequestExport(c.Request.Context(), userID.(uuid.UUID), req.Format)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrInvalidFormat):
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		case errors.Is(err, usecase.ErrExportInProgress):
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error(), Code: "export_in_progress"})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to request export"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, export)
+}
+
+// GetExport handles polling an export
+// @Summary Get my data export
+// @Description Get the status of an export. Ready exports carry a signed download_url that expires after a few minutes, get the export again for a new one.
+// @Tags privacy
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Export ID"
+// @Success 200 {object} domain.DataExport
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/export/{id} [get]
+func (h *PrivacyHandler) GetExport(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: usecase.ErrExportNotFound.Error()})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	export, err := h.privacyUsecase.GetExport(c.Request.Context(), userID.(uuid.UUID), id)
+	if err != nil {
+		if errors.Is(err, usecase.ErrExportNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get export"})
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
+
+// DownloadExport handles downloading an export with a signed link
+// @Summary Download a data export
+// @Description Download a ready export. The link is the download_url of the export and needs no access token.
+// @Tags privacy
+// @Produce application/zip,application/json
+// @Param id path string true "Export ID"
+// @Param expires query string true "Expiry of the link"
+// @Param signature query string true "Signature of the link"
+// @Success 200 {file} file
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /exports/{id}/download [get]
+func (h *PrivacyHandler) DownloadExport(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: usecase.ErrInvalidLink.Error(), Code: "invalid_link"})
+		return
+	}
+
+	export, err := h.privacyUsecase.DownloadExport(c.Request.Context(), id, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidLink) {
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error(), Code: "invalid_link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to download export"})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="export-`+export.CreatedAt.UTC().Format("2006-01-02")+"."+export.Format+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, export.ContentType(), export.Content)
+}
+
+// ScheduleErasure handles a user deleting their account
+// @Summary Delete my account
+// @Description Schedule the erasure of the current user's account after a grace period and sign out every session. Signing in again and cancelling the erasure keeps the account. Requires a recent sign-in.
+// @Tags privacy
+// @Produce json
+// @Security BearerAuth
+// @Success 202 {object} domain.Erasure
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me [delete]
+func (h *PrivacyHandler) ScheduleErasure(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	erasure, err := h.privacyUsecase.ScheduleErasure(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		if errors.Is(err, usecase.ErrErasureBlocked) {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error(), Code: "erasure_blocked"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to schedule erasure"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, erasure)
+}
+
+// GetErasure handles getting the user's scheduled erasure
+// @Summary Get my account erasure
+// @Description Get when the current user's account will be erased
+// @Tags privacy
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} domain.Erasure
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/erasure [get]
+func (h *PrivacyHandler) GetErasure(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	erasure, err := h.privacyUsecase.GetErasure(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		if errors.Is(err, usecase.ErrErasureNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get erasure"})
+		return
+	}
+
+	c.JSON(http.StatusOK, erasure)
+}
+
+// CancelErasure handles a user keeping their account during the grace period
+// @Summary Cancel my account erasure
+// @Description Cancel the erasure of the current user's account during the grace period
+// @Tags privacy
+// @Security BearerAuth
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /me/erasure [delete]
+func (h *PrivacyHandler) CancelErasure(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	if err := h.privacyUsecase.CancelErasure(c.Request.Context(), userID.(uuid.UUID)); err != nil {
+		if errors.Is(err, usecase.ErrErasureNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to cancel erasure"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
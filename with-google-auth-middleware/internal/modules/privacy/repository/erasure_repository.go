@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/privacy/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type erasureRepository struct {
+	db    *gorm.DB
+	hooks []ErasureHook
+}
+
+// NewErasureRepository creates a new instance of ErasureRepository. The
+// erasure hooks of the modules keeping rows for users are run by Check and
+// Erase, in order.
+func NewErasureRepository(db *gorm.DB, erasureHooks ...[]ErasureHook) domain.ErasureRepository {
+	repo := &erasureRepository{db: db}
+	for _, hooks := range erasureHooks {
+		repo.hooks = append(repo.hooks, hooks...)
+	}
+	return repo
+}
+
+func (r *erasureRepository) Create(erasure *domain.Erasure) error {
+	return r.db.Create(erasure).Error
+}
+
+func (r *erasureRepository) FindByUser(userID uuid.UUID) (*domain.Erasure, error) {
+	var erasure domain.Erasure
+	err := r.db.Where("user_id = ?", userID).First(&erasure).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &erasure, nil
+}
+
+func (r *erasureRepository) Delete(userID uuid.UUID) error {
+	return r.db.Where("user_id = ? AND completed_at IS NULL", userID).Delete(&domain.Erasure{}).Error
+}
+
+func (r *erasureRepository) Check(userID uuid.UUID) error {
+	for _, hook := range r.hooks {
+		if hook.Check == nil {
+			continue
+		}
+		if err := hook.Check(r.db, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *erasureRepository) FindDue(now time.Time, limit int) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	err := r.db.Model(&domain.Erasure{}).
+		Where("completed_at IS NULL AND erase_at <= ?", now).
+		Order("erase_at").
+		Limit(limit).
+		Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// Erase locks the erasure with SKIP LOCKED, so that workers of several
+// instances don't erase the same user
+func (r *erasureRepository) Erase(userID uuid.UUID, now time.Time) (bool, error) {
+	erased := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var erasure domain.Erasure
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("user_id = ? AND completed_at IS NULL AND erase_at <= ?", userID, now).
+			First(&erasure).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		counts := make(map[string]int64, len(r.hooks)+1)
+		for _, hook := range r.hooks {
+			count, err := hook.Erase(tx, userID)
+			if err != nil {
+				return fmt.Errorf("failed to erase %s: %w", hook.Name, err)
+			}
+			counts[hook.Name] = count
+		}
+		// Rows of modules without a hook go with the user's foreign keys
+		result := tx.Exec("DELETE FROM users WHERE id = ?", userID)
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete user: %w", result.Error)
+		}
+		counts["users"] = result.RowsAffected
+
+		erased = true
+		erasure.CompletedAt = &now
+		erasure.Erased = counts
+		return tx.Save(&erasure).Error
+	})
+	return erased, err
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/privacy/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type exportRepository struct {
+	db    *gorm.DB
+	hooks []ExportHook
+}
+
+// NewExportRepository creates a new instance of ExportRepository. The export
+// hooks of the modules keeping rows for users are run by Collect.
+func NewExportRepository(db *gorm.DB, exportHooks ...[]ExportHook) domain.ExportRepository {
+	repo := &exportRepository{db: db}
+	for _, hooks := range exportHooks {
+		repo.hooks = append(repo.hooks, hooks...)
+	}
+	return repo
+}
+
+func (r *exportRepository) Create(export *domain.DataExport) error {
+	return r.db.Create(export).Error
+}
+
+func (r *exportRepository) FindByID(id uuid.UUID) (*domain.DataExport, error) {
+	var export domain.DataExport
+	err := r.db.Where("id = ?", id).First(&export).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (r *exportRepository) HasActive(userID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.Model(&domain.DataExport{}).
+		Where("user_id = ? AND status IN ?", userID, []string{domain.ExportStatusPending, domain.ExportStatusBuilding}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Claim locks the export with SKIP LOCKED, so that workers of several
+// instances don't build the same export
+func (r *exportRepository) Claim(now, staleBefore time.Time) (*domain.DataExport, error) {
+	var claimed *domain.DataExport
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var export domain.DataExport
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? OR (status = ? AND started_at < ?)", domain.ExportStatusPending, domain.ExportStatusBuilding, staleBefore).
+			Order("created_at").
+			First(&export).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		export.Status = domain.ExportStatusBuilding
+		export.StartedAt = &now
+		err = tx.Model(&export).Updates(map[string]interface{}{"status": export.Status, "started_at": now}).Error
+		if err != nil {
+			return err
+		}
+		claimed = &export
+		return nil
+	})
+	return claimed, err
+}
+
+func (r *exportRepository) Collect(userID uuid.UUID) (map[string][]byte, error) {
+	sections := make(map[string][]byte, len(r.hooks))
+	for _, hook := range r.hooks {
+		rows, err := hook.Export(r.db, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", hook.Name, err)
+		}
+		encoded, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", hook.Name, err)
+		}
+		sections[hook.Name] = encoded
+	}
+	return sections, nil
+}
+
+func (r *exportRepository) Complete(export *domain.DataExport) error {
+	return r.db.Model(export).Updates(map[string]interface{}{
+		"status":       export.Status,
+		"content":      export.Content,
+		"size":         export.Size,
+		"completed_at": export.CompletedAt,
+		"expires_at":   export.ExpiresAt,
+	}).Error
+}
+
+func (r *exportRepository) DeleteExpired(now time.Time) error {
+	return r.db.Where("expires_at <= ?", now).Delete(&domain.DataExport{}).Error
+}
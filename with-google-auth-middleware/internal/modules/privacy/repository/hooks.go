@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ExportHook returns the rows a module keeps for a user. The result is
+// encoded as JSON and included in the user's data export under Name.
+// Credentials such as token hashes must be left out.
+type ExportHook struct {
+	Name   string
+	Export func(db *gorm.DB, userID uuid.UUID) (interface{}, error)
+}
+
+// ErasureHook deletes or anonymizes the rows a module keeps for a user whose
+// account is erased, and returns how many it changed. Erase runs in the
+// erasure's transaction, before the user is deleted. Check, if set, refuses
+// to schedule the erasure; its error is shown to the user.
+type ErasureHook struct {
+	Name  string
+	Check func(db *gorm.DB, userID uuid.UUID) error
+	Erase func(tx *gorm.DB, userID uuid.UUID) (int64, error)
+}
+
+// ErasureHooks delete the user's data exports
+var ErasureHooks = []ErasureHook{
+	{Name: "data_exports", Erase: eraseExports},
+}
+
+func eraseExports(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("DELETE FROM data_exports WHERE user_id = ?", userID)
+	return result.RowsAffected, result.Error
+}
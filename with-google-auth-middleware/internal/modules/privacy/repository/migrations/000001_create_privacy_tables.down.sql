@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS erasures;
+DROP TABLE IF EXISTS data_exports;
\ No newline at end of file
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    format VARCHAR(8) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    content BYTEA,
+    size INTEGER NOT NULL DEFAULT 0,
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_data_exports_user_id ON data_exports(user_id);
+CREATE INDEX idx_data_exports_status ON data_exports(status, created_at) WHERE status IN ('pending', 'building');
+CREATE INDEX idx_data_exports_expires_at ON data_exports(expires_at);
+
+-- Not a foreign key, the record of a completed erasure outlives the user
+CREATE TABLE IF NOT EXISTS erasures (
+    user_id UUID PRIMARY KEY,
+    requested_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    erase_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    erased JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX idx_erasures_erase_at ON erasures(erase_at) WHERE completed_at IS NULL;
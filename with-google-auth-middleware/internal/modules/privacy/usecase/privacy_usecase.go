@@ -0,0 +1,376 @@
+package usecase
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/privacy/domain"
+)
+
+// Custom errors
+var (
+	ErrInvalidFormat    = errors.New("invalid export format, use zip or json")
+	ErrExportInProgress = errors.New("an export is already in progress")
+	ErrExportNotFound   = errors.New("export not found")
+	ErrInvalidLink      = errors.New("invalid or expired download link")
+	ErrErasureBlocked   = errors.New("the account can't be erased")
+	ErrErasureNotFound  = errors.New("no erasure is scheduled")
+)
+
+// Defaults of PrivacyConfig
+const (
+	defaultExportTTL   = 48 * time.Hour
+	defaultLinkTTL     = 15 * time.Minute
+	defaultGracePeriod = 30 * 24 * time.Hour
+)
+
+// staleExportAfter is how long a worker may build an export before another
+// worker takes over, e.g. after the first one's instance stopped
+const staleExportAfter = 15 * time.Minute
+
+// maxErasuresPerRun bounds the erasures one run of the worker carries out
+const maxErasuresPerRun = 100
+
+// PrivacyConfig configures data exports and account erasure
+type PrivacyConfig struct {
+	// SigningKey signs the download links of exports
+	SigningKey []byte
+	// ExportTTL is how long a finished export is kept, 48 hours when zero
+	ExportTTL time.Duration
+	// LinkTTL is how long a download link is valid, 15 minutes when zero
+	LinkTTL time.Duration
+	// GracePeriod is the time between a user's request and the erasure of
+	// their account, 30 days when zero
+	GracePeriod time.Duration
+}
+
+type privacyUsecase struct {
+	exportRepo  domain.ExportRepository
+	erasureRepo domain.ErasureRepository
+	sessions    domain.SessionRevoker
+	signingKey  []byte
+	exportTTL   time.Duration
+	linkTTL     time.Duration
+	gracePeriod time.Duration
+	now         func() time.Time
+	wake        chan struct{}
+}
+
+// NewPrivacyUsecase creates a new instance of PrivacyUsecase. Exports are
+// built and accounts erased by Run, which must be started once per instance.
+func NewPrivacyUsecase(
+	exportRepo domain.ExportRepository,
+	erasureRepo domain.ErasureRepository,
+	sessions domain.SessionRevoker,
+	cfg PrivacyConfig,
+) domain.PrivacyUsecase {
+	u := &privacyUsecase{
+		exportRepo:  exportRepo,
+		erasureRepo: erasureRepo,
+		sessions:    sessions,
+		signingKey:  cfg.SigningKey,
+		exportTTL:   cfg.ExportTTL,
+		linkTTL:     cfg.LinkTTL,
+		gracePeriod: cfg.GracePeriod,
+		now:         time.Now,
+		wake:        make(chan struct{}, 1),
+	}
+	if u.exportTTL <= 0 {
+		u.exportTTL = defaultExportTTL
+	}
+	if u.linkTTL <= 0 {
+		u.linkTTL = defaultLinkTTL
+	}
+	if u.gracePeriod <= 0 {
+		u.gracePeriod = defaultGracePeriod
+	}
+	return u
+}
+
+func (u *privacyUsecase) RequestExport(ctx context.Context, userID uuid.UUID, format string) (*domain.DataExport, error) {
+	switch format {
+	case "":
+		format = domain.ExportFormatZIP
+	case domain.ExportFormatZIP, domain.ExportFormatJSON:
+	default:
+		return nil, ErrInvalidFormat
+	}
+
+	active, err := u.exportRepo.HasActive(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find exports: %w", err)
+	}
+	if active {
+		return nil, ErrExportInProgress
+	}
+
+	export := &domain.DataExport{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Format:    format,
+		Status:    domain.ExportStatusPending,
+		CreatedAt: u.now(),
+	}
+	if err := u.exportRepo.Create(export); err != nil {
+		return nil, fmt.Errorf("failed to create export: %w", err)
+	}
+
+	// The worker of this instance builds it right away
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+	return export, nil
+}
+
+func (u *privacyUsecase) GetExport(ctx context.Context, userID, exportID uuid.UUID) (*domain.DataExport, error) {
+	export, err := u.exportRepo.FindByID(exportID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find export: %w", err)
+	}
+	// Other users' exports don't exist for the user
+	if export == nil || export.UserID != userID {
+		return nil, ErrExportNotFound
+	}
+
+	now := u.now()
+	if export.Status == domain.ExportStatusReady && export.ExpiresAt != nil && now.Before(*export.ExpiresAt) {
+		expiresAt := now.Add(u.linkTTL)
+		if export.ExpiresAt.Before(expiresAt) {
+			expiresAt = *export.ExpiresAt
+		}
+		expiresAt = expiresAt.Truncate(time.Second)
+		expires := strconv.FormatInt(expiresAt.Unix(), 10)
+		query := url.Values{"expires": {expires}, "signature": {u.sign(export.ID, expires)}}
+		export.DownloadURL = "/v1/exports/" + export.ID.String() + "/download?" + query.Encode()
+		export.DownloadExpiresAt = &expiresAt
+	}
+	return export, nil
+}
+
+func (u *privacyUsecase) DownloadExport(ctx context.Context, exportID uuid.UUID, expires, signature string) (*domain.DataExport, error) {
+	if !hmac.Equal([]byte(signature), []byte(u.sign(exportID, expires))) {
+		return nil, ErrInvalidLink
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	now := u.now()
+	if !now.Before(time.Unix(expiresAt, 0)) {
+		return nil, ErrInvalidLink
+	}
+
+	export, err := u.exportRepo.FindByID(exportID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find export: %w", err)
+	}
+	if export == nil || export.Status != domain.ExportStatusReady || export.ExpiresAt == nil || !now.Before(*export.ExpiresAt) {
+		return nil, ErrInvalidLink
+	}
+	return export, nil
+}
+
+// sign returns the signature of a download link, which only the holder of the
+// link can present: downloads aren't authenticated otherwise
+func (u *privacyUsecase) sign(exportID uuid.UUID, expires string) string {
+	mac := hmac.New(sha256.New, u.signingKey)
+	mac.Write([]byte("data-export:" + exportID.String() + ":" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (u *privacyUsecase) ScheduleErasure(ctx context.Context, userID uuid.UUID) (*domain.Erasure, error) {
+	erasure, err := u.erasureRepo.FindByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find erasure: %w", err)
+	}
+
+	if erasure == nil {
+		if err := u.erasureRepo.Check(userID); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrErasureBlocked, err)
+		}
+		now := u.now()
+		erasure = &domain.Erasure{
+			UserID:      userID,
+			RequestedAt: now,
+			EraseAt:     now.Add(u.gracePeriod),
+		}
+		if err := u.erasureRepo.Create(erasure); err != nil {
+			return nil, fmt.Errorf("failed to schedule erasure: %w", err)
+		}
+	}
+
+	// Also when the user repeats the request after signing in again
+	if err := u.sessions.DeleteUserSessions(userID); err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return erasure, nil
+}
+
+func (u *privacyUsecase) GetErasure(ctx context.Context, userID uuid.UUID) (*domain.Erasure, error) {
+	erasure, err := u.erasureRepo.FindByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find erasure: %w", err)
+	}
+	if erasure == nil {
+		return nil, ErrErasureNotFound
+	}
+	return erasure, nil
+}
+
+func (u *privacyUsecase) CancelErasure(ctx context.Context, userID uuid.UUID) error {
+	if _, err := u.GetErasure(ctx, userID); err != nil {
+		return err
+	}
+	if err := u.erasureRepo.Delete(userID); err != nil {
+		return fmt.Errorf("failed to cancel erasure: %w", err)
+	}
+	return nil
+}
+
+func (u *privacyUsecase) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := u.process(); err != nil {
+			log.Printf("privacy worker: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-u.wake:
+		}
+	}
+}
+
+// process deletes expired exports, builds requested ones and erases the
+// accounts whose grace period ended
+func (u *privacyUsecase) process() error {
+	if err := u.exportRepo.DeleteExpired(u.now()); err != nil {
+		return fmt.Errorf("failed to delete expired exports: %w", err)
+	}
+
+	for {
+		now := u.now()
+		export, err := u.exportRepo.Claim(now, now.Add(-staleExportAfter))
+		if err != nil {
+			return fmt.Errorf("failed to claim export: %w", err)
+		}
+		if export == nil {
+			break
+		}
+		if err := u.build(export); err != nil {
+			return err
+		}
+	}
+
+	due, err := u.erasureRepo.FindDue(u.now(), maxErasuresPerRun)
+	if err != nil {
+		return fmt.Errorf("failed to find due erasures: %w", err)
+	}
+	for _, userID := range due {
+		// A failing erasure is retried on the next run, it doesn't hold up the others
+		if _, err := u.erasureRepo.Erase(userID, u.now()); err != nil {
+			log.Printf("privacy worker: failed to erase user %s: %v", userID, err)
+		}
+	}
+	return nil
+}
+
+// build collects the user's data into the export. An export that fails is
+// kept as failed until it expires, so that the user sees the outcome.
+func (u *privacyUsecase) build(export *domain.DataExport) error {
+	sections, err := u.exportRepo.Collect(export.UserID)
+	now := u.now()
+	if err == nil {
+		export.Content, err = encodeExport(export, sections, now)
+	}
+	if err != nil {
+		log.Printf("privacy worker: failed to build export %s: %v", export.ID, err)
+		export.Status = domain.ExportStatusFailed
+		export.Content = nil
+	} else {
+		export.Status = domain.ExportStatusReady
+	}
+	expiresAt := now.Add(u.exportTTL)
+	export.Size = len(export.Content)
+	export.CompletedAt = &now
+	export.ExpiresAt = &expiresAt
+
+	if err := u.exportRepo.Complete(export); err != nil {
+		return fmt.Errorf("failed to complete export: %w", err)
+	}
+	return nil
+}
+
+// exportManifest describes the export, it's the top level of a JSON export
+// and export.json in a ZIP export
+type exportManifest struct {
+	UserID     uuid.UUID                  `json:"user_id"`
+	ExportedAt time.Time                  `json:"exported_at"`
+	Sections   []string                   `json:"sections"`
+	Data       map[string]json.RawMessage `json:"data,omitempty"`
+}
+
+// encodeExport returns the sections as a JSON document or as a ZIP archive
+// with one <section>.json file each
+func encodeExport(export *domain.DataExport, sections map[string][]byte, now time.Time) ([]byte, error) {
+	manifest := exportManifest{
+		UserID:     export.UserID,
+		ExportedAt: now.UTC(),
+		Sections:   make([]string, 0, len(sections)),
+	}
+	for name := range sections {
+		manifest.Sections = append(manifest.Sections, name)
+	}
+	sort.Strings(manifest.Sections)
+
+	if export.Format == domain.ExportFormatJSON {
+		manifest.Data = make(map[string]json.RawMessage, len(sections))
+		for name, section := range sections {
+			manifest.Data[name] = section
+		}
+		return json.MarshalIndent(manifest, "", "  ")
+	}
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	write := func(name string, content []byte) error {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	}
+	if err := write("export.json", encoded); err != nil {
+		return nil, err
+	}
+	for _, name := range manifest.Sections {
+		if err := write(name+".json", sections[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
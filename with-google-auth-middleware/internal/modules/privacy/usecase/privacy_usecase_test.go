@@ -0,0 +1,396 @@
+package usecase
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/privacy/domain"
+)
+
+// fakeExportRepository keeps exports in memory and collects fixed sections
+type fakeExportRepository struct {
+	exports  []*domain.DataExport
+	sections map[string][]byte
+	err      error // Returned by Collect
+}
+
+func (r *fakeExportRepository) Create(export *domain.DataExport) error {
+	r.exports = append(r.exports, export)
+	return nil
+}
+
+func (r *fakeExportRepository) FindByID(id uuid.UUID) (*domain.DataExport, error) {
+	for _, export := range r.exports {
+		if export.ID == id {
+			copied := *export
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeExportRepository) HasActive(userID uuid.UUID) (bool, error) {
+	for _, export := range r.exports {
+		if export.UserID == userID && (export.Status == domain.ExportStatusPending || export.Status == domain.ExportStatusBuilding) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeExportRepository) Claim(now, staleBefore time.Time) (*domain.DataExport, error) {
+	for _, export := range r.exports {
+		if export.Status == domain.ExportStatusPending ||
+			export.Status == domain.ExportStatusBuilding && export.StartedAt.Before(staleBefore) {
+			export.Status = domain.ExportStatusBuilding
+			export.StartedAt = &now
+			copied := *export
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeExportRepository) Collect(userID uuid.UUID) (map[string][]byte, error) {
+	return r.sections, r.err
+}
+
+func (r *fakeExportRepository) Complete(export *domain.DataExport) error {
+	for i, existing := range r.exports {
+		if existing.ID == export.ID {
+			r.exports[i] = export
+		}
+	}
+	return nil
+}
+
+func (r *fakeExportRepository) DeleteExpired(now time.Time) error {
+	var kept []*domain.DataExport
+	for _, export := range r.exports {
+		if export.ExpiresAt == nil || now.Before(*export.ExpiresAt) {
+			kept = append(kept, export)
+		}
+	}
+	r.exports = kept
+	return nil
+}
+
+// fakeErasureRepository keeps erasures in memory, blocked users fail the check
+type fakeErasureRepository struct {
+	erasures map[uuid.UUID]*domain.Erasure
+	blocked  map[uuid.UUID]bool
+	erased   []uuid.UUID
+}
+
+func (r *fakeErasureRepository) Create(erasure *domain.Erasure) error {
+	r.erasures[erasure.UserID] = erasure
+	return nil
+}
+
+func (r *fakeErasureRepository) FindByUser(userID uuid.UUID) (*domain.Erasure, error) {
+	return r.erasures[userID], nil
+}
+
+func (r *fakeErasureRepository) Delete(userID uuid.UUID) error {
+	if erasure, ok := r.erasures[userID]; ok && erasure.CompletedAt == nil {
+		delete(r.erasures, userID)
+	}
+	return nil
+}
+
+func (r *fakeErasureRepository) Check(userID uuid.UUID) error {
+	if r.blocked[userID] {
+		return errors.New("owns an organization")
+	}
+	return nil
+}
+
+func (r *fakeErasureRepository) FindDue(now time.Time, limit int) ([]uuid.UUID, error) {
+	var due []uuid.UUID
+	for userID, erasure := range r.erasures {
+		if erasure.CompletedAt == nil && !now.Before(erasure.EraseAt) {
+			due = append(due, userID)
+		}
+	}
+	return due, nil
+}
+
+func (r *fakeErasureRepository) Erase(userID uuid.UUID, now time.Time) (bool, error) {
+	erasure, ok := r.erasures[userID]
+	if !ok || erasure.CompletedAt != nil {
+		return false, nil
+	}
+	erasure.CompletedAt = &now
+	erasure.Erased = map[string]int64{"users": 1}
+	r.erased = append(r.erased, userID)
+	return true, nil
+}
+
+// fakeSessionRevoker records the users whose sessions were revoked
+type fakeSessionRevoker struct {
+	revoked []uuid.UUID
+}
+
+func (r *fakeSessionRevoker) DeleteUserSessions(userID uuid.UUID) error {
+	r.revoked = append(r.revoked, userID)
+	return nil
+}
+
+type privacyFixture struct {
+	u        *privacyUsecase
+	exports  *fakeExportRepository
+	erasures *fakeErasureRepository
+	sessions *fakeSessionRevoker
+	now      time.Time
+}
+
+func newPrivacyFixture() *privacyFixture {
+	f := &privacyFixture{
+		exports: &fakeExportRepository{sections: map[string][]byte{
+			"user":     []byte(`{"email":"alice@example.com"}`),
+			"sessions": []byte(`[]`),
+		}},
+		erasures: &fakeErasureRepository{erasures: map[uuid.UUID]*domain.Erasure{}, blocked: map[uuid.UUID]bool{}},
+		sessions: &fakeSessionRevoker{},
+		now:      time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	f.u = NewPrivacyUsecase(f.exports, f.erasures, f.sessions, PrivacyConfig{
+		SigningKey: []byte("test-secret"),
+	}).(*privacyUsecase)
+	f.u.now = func() time.Time { return f.now }
+	return f
+}
+
+func TestRequestExport(t *testing.T) {
+	f := newPrivacyFixture()
+	ctx := context.Background()
+	userID := uuid.New()
+
+	_, err := f.u.RequestExport(ctx, userID, "csv")
+	assert.ErrorIs(t, err, ErrInvalidFormat)
+
+	export, err := f.u.RequestExport(ctx, userID, "")
+	require.NoError(t, err)
+	assert.Equal(t, domain.ExportFormatZIP, export.Format)
+	assert.Equal(t, domain.ExportStatusPending, export.Status)
+	assert.Len(t, f.u.wake, 1, "the worker is woken up")
+
+	_, err = f.u.RequestExport(ctx, userID, domain.ExportFormatJSON)
+	assert.ErrorIs(t, err, ErrExportInProgress)
+
+	_, err = f.u.GetExport(ctx, uuid.New(), export.ID)
+	assert.ErrorIs(t, err, ErrExportNotFound, "other users' exports aren't found")
+
+	pending, err := f.u.GetExport(ctx, userID, export.ID)
+	require.NoError(t, err)
+	assert.Empty(t, pending.DownloadURL)
+}
+
+func TestBuildExport(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("json", func(t *testing.T) {
+		f := newPrivacyFixture()
+		userID := uuid.New()
+		export, err := f.u.RequestExport(ctx, userID, domain.ExportFormatJSON)
+		require.NoError(t, err)
+		require.NoError(t, f.u.process())
+
+		built, err := f.u.GetExport(ctx, userID, export.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.ExportStatusReady, built.Status)
+		assert.Equal(t, f.now.Add(defaultExportTTL), *built.ExpiresAt)
+		assert.Equal(t, len(built.Content), built.Size)
+		assert.Equal(t, "application/json", built.ContentType())
+
+		var manifest struct {
+			UserID   uuid.UUID                  `json:"user_id"`
+			Sections []string                   `json:"sections"`
+			Data     map[string]json.RawMessage `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(built.Content, &manifest))
+		assert.Equal(t, userID, manifest.UserID)
+		assert.Equal(t, []string{"sessions", "user"}, manifest.Sections)
+		assert.JSONEq(t, `{"email":"alice@example.com"}`, string(manifest.Data["user"]))
+
+		// The user may request another export once this one is built
+		_, err = f.u.RequestExport(ctx, userID, domain.ExportFormatJSON)
+		assert.NoError(t, err)
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		f := newPrivacyFixture()
+		userID := uuid.New()
+		export, err := f.u.RequestExport(ctx, userID, "")
+		require.NoError(t, err)
+		require.NoError(t, f.u.process())
+
+		built, err := f.u.GetExport(ctx, userID, export.ID)
+		require.NoError(t, err)
+		require.Equal(t, domain.ExportStatusReady, built.Status)
+		archive, err := zip.NewReader(bytes.NewReader(built.Content), int64(len(built.Content)))
+		require.NoError(t, err)
+		files := map[string]string{}
+		for _, file := range archive.File {
+			r, err := file.Open()
+			require.NoError(t, err)
+			content, err := io.ReadAll(r)
+			require.NoError(t, err)
+			files[file.Name] = string(content)
+		}
+		assert.Len(t, files, 3)
+		assert.Contains(t, files["export.json"], userID.String())
+		assert.Equal(t, `{"email":"alice@example.com"}`, files["user.json"])
+		assert.Equal(t, `[]`, files["sessions.json"])
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		f := newPrivacyFixture()
+		f.exports.err = errors.New("database is down")
+		userID := uuid.New()
+		export, err := f.u.RequestExport(ctx, userID, "")
+		require.NoError(t, err)
+		require.NoError(t, f.u.process())
+
+		failed, err := f.u.GetExport(ctx, userID, export.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.ExportStatusFailed, failed.Status)
+		assert.Empty(t, failed.Content)
+		assert.Empty(t, failed.DownloadURL)
+	})
+
+	t.Run("stale claim is retried", func(t *testing.T) {
+		f := newPrivacyFixture()
+		startedAt := f.now.Add(-time.Hour)
+		f.exports.exports = append(f.exports.exports, &domain.DataExport{
+			ID:        uuid.New(),
+			UserID:    uuid.New(),
+			Format:    domain.ExportFormatJSON,
+			Status:    domain.ExportStatusBuilding,
+			StartedAt: &startedAt,
+		})
+		require.NoError(t, f.u.process())
+		assert.Equal(t, domain.ExportStatusReady, f.exports.exports[0].Status)
+	})
+
+	t.Run("expired exports are deleted", func(t *testing.T) {
+		f := newPrivacyFixture()
+		export, err := f.u.RequestExport(ctx, uuid.New(), "")
+		require.NoError(t, err)
+		require.NoError(t, f.u.process())
+		f.now = f.now.Add(defaultExportTTL)
+		require.NoError(t, f.u.process())
+
+		_, err = f.u.GetExport(ctx, export.UserID, export.ID)
+		assert.ErrorIs(t, err, ErrExportNotFound)
+	})
+}
+
+func TestDownloadExport(t *testing.T) {
+	f := newPrivacyFixture()
+	ctx := context.Background()
+	userID := uuid.New()
+	export, err := f.u.RequestExport(ctx, userID, domain.ExportFormatJSON)
+	require.NoError(t, err)
+	require.NoError(t, f.u.process())
+
+	ready, err := f.u.GetExport(ctx, userID, export.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, ready.DownloadURL)
+	assert.Equal(t, f.now.Add(defaultLinkTTL), *ready.DownloadExpiresAt)
+	link, err := url.Parse(ready.DownloadURL)
+	require.NoError(t, err)
+	assert.Equal(t, "/v1/exports/"+export.ID.String()+"/download", link.Path)
+	expires, signature := link.Query().Get("expires"), link.Query().Get("signature")
+
+	downloaded, err := f.u.DownloadExport(ctx, export.ID, expires, signature)
+	require.NoError(t, err)
+	assert.Equal(t, ready.Content, downloaded.Content)
+
+	t.Run("tampered", func(t *testing.T) {
+		_, err := f.u.DownloadExport(ctx, uuid.New(), expires, signature)
+		assert.ErrorIs(t, err, ErrInvalidLink, "another export")
+		_, err = f.u.DownloadExport(ctx, export.ID, expires+"0", signature)
+		assert.ErrorIs(t, err, ErrInvalidLink, "a later expiry")
+		_, err = f.u.DownloadExport(ctx, export.ID, expires, strings.ToUpper(signature))
+		assert.ErrorIs(t, err, ErrInvalidLink)
+	})
+
+	t.Run("expired link", func(t *testing.T) {
+		now := f.now
+		defer func() { f.now = now }()
+		f.now = f.now.Add(defaultLinkTTL)
+		_, err := f.u.DownloadExport(ctx, export.ID, expires, signature)
+		assert.ErrorIs(t, err, ErrInvalidLink)
+	})
+
+	t.Run("link doesn't outlive the export", func(t *testing.T) {
+		now := f.now
+		defer func() { f.now = now }()
+		f.now = ready.ExpiresAt.Add(-time.Minute)
+		link, err := f.u.GetExport(ctx, userID, export.ID)
+		require.NoError(t, err)
+		assert.Equal(t, *ready.ExpiresAt, *link.DownloadExpiresAt)
+	})
+}
+
+func TestErasure(t *testing.T) {
+	f := newPrivacyFixture()
+	ctx := context.Background()
+	userID := uuid.New()
+
+	_, err := f.u.GetErasure(ctx, userID)
+	assert.ErrorIs(t, err, ErrErasureNotFound)
+	assert.ErrorIs(t, f.u.CancelErasure(ctx, userID), ErrErasureNotFound)
+
+	erasure, err := f.u.ScheduleErasure(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, f.now.Add(defaultGracePeriod), erasure.EraseAt)
+	assert.Equal(t, []uuid.UUID{userID}, f.sessions.revoked)
+
+	// Signing in again and repeating the request keeps the first schedule
+	f.now = f.now.Add(time.Hour)
+	again, err := f.u.ScheduleErasure(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, erasure.EraseAt, again.EraseAt)
+	assert.Len(t, f.sessions.revoked, 2)
+
+	require.NoError(t, f.u.CancelErasure(ctx, userID))
+	_, err = f.u.GetErasure(ctx, userID)
+	assert.ErrorIs(t, err, ErrErasureNotFound)
+
+	t.Run("blocked", func(t *testing.T) {
+		owner := uuid.New()
+		f.erasures.blocked[owner] = true
+		revoked := len(f.sessions.revoked)
+		_, err := f.u.ScheduleErasure(ctx, owner)
+		assert.ErrorIs(t, err, ErrErasureBlocked)
+		assert.Contains(t, err.Error(), "owns an organization")
+		assert.Len(t, f.sessions.revoked, revoked, "sessions are kept")
+	})
+
+	t.Run("erased at the end of the grace period", func(t *testing.T) {
+		_, err := f.u.ScheduleErasure(ctx, userID)
+		require.NoError(t, err)
+		require.NoError(t, f.u.process())
+		assert.Empty(t, f.erasures.erased)
+
+		f.now = f.now.Add(defaultGracePeriod)
+		require.NoError(t, f.u.process())
+		assert.Equal(t, []uuid.UUID{userID}, f.erasures.erased)
+		erasure, err := f.u.GetErasure(ctx, userID)
+		require.NoError(t, err)
+		assert.NotNil(t, erasure.CompletedAt)
+	})
+}
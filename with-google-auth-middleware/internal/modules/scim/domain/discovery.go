@@ -0,0 +1,75 @@
+package domain
+
+// Supported is a feature flag of the ServiceProviderConfig
+type Supported struct {
+	Supported bool `json:"supported"`
+}
+
+// FilterSupport describes filtering in the ServiceProviderConfig
+type FilterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+// BulkSupport describes bulk operations in the ServiceProviderConfig
+type BulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+// AuthenticationScheme describes how clients authenticate
+type AuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
+
+// ServiceProviderConfig describes the SCIM features we support (RFC 7643 section 5)
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 Supported              `json:"patch"`
+	Bulk                  BulkSupport            `json:"bulk"`
+	Filter                FilterSupport          `json:"filter"`
+	ChangePassword        Supported              `json:"changePassword"`
+	Sort                  Supported              `json:"sort"`
+	ETag                  Supported              `json:"etag"`
+	AuthenticationSchemes []AuthenticationScheme `json:"authenticationSchemes"`
+	Meta                  *Meta                  `json:"meta,omitempty"`
+}
+
+// SchemaAttribute describes an attribute of a resource schema (RFC 7643 section 7)
+type SchemaAttribute struct {
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`
+	MultiValued   bool              `json:"multiValued"`
+	Description   string            `json:"description,omitempty"`
+	Required      bool              `json:"required"`
+	CaseExact     bool              `json:"caseExact"`
+	Mutability    string            `json:"mutability"`
+	Returned      string            `json:"returned"`
+	Uniqueness    string            `json:"uniqueness"`
+	SubAttributes []SchemaAttribute `json:"subAttributes,omitempty"`
+}
+
+// Schema describes the attributes of a resource type
+type Schema struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Attributes  []SchemaAttribute `json:"attributes"`
+	Meta        *Meta             `json:"meta,omitempty"`
+}
+
+// ResourceType describes an endpoint serving a resource (RFC 7643 section 6)
+type ResourceType struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Endpoint    string   `json:"endpoint"`
+	Description string   `json:"description"`
+	Schema      string   `json:"schema"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
@@ -0,0 +1,185 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SCIM schema URNs (RFC 7643, RFC 7644)
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Resource types
+const (
+	ResourceTypeUser  = "User"
+	ResourceTypeGroup = "Group"
+)
+
+// PATCH operations
+const (
+	PatchOpAdd     = "add"
+	PatchOpReplace = "replace"
+	PatchOpRemove  = "remove"
+)
+
+// Client is a provisioning client (Okta, Azure AD, ...) allowed to call the
+// SCIM API with its own bearer token
+type Client struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	TokenHash string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName overrides the GORM default of "clients"
+func (Client) TableName() string {
+	return "scim_clients"
+}
+
+// Group is a group of users managed by a provisioning client
+type Group struct {
+	ID          uuid.UUID `json:"id"`
+	DisplayName string    `json:"display_name"`
+	ExternalID  string    `json:"external_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// GroupMember links a user to a group
+type GroupMember struct {
+	GroupID   uuid.UUID `json:"group_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Meta holds the SCIM resource metadata
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+// Name is the SCIM name complex attribute
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// MultiValuedAttribute is an element of a SCIM multi-valued attribute such as emails
+type MultiValuedAttribute struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// UserResource is the SCIM representation of a user
+type UserResource struct {
+	Schemas     []string               `json:"schemas"`
+	ID          string                 `json:"id,omitempty"`
+	ExternalID  string                 `json:"externalId,omitempty"`
+	UserName    string                 `json:"userName"`
+	Name        *Name                  `json:"name,omitempty"`
+	DisplayName string                 `json:"displayName,omitempty"`
+	Locale      string                 `json:"locale,omitempty"`
+	Active      *bool                  `json:"active,omitempty"`
+	Emails      []MultiValuedAttribute `json:"emails,omitempty"`
+	Photos      []MultiValuedAttribute `json:"photos,omitempty"`
+	Meta        *Meta                  `json:"meta,omitempty"`
+}
+
+// GroupResource is the SCIM representation of a group
+type GroupResource struct {
+	Schemas     []string               `json:"schemas"`
+	ID          string                 `json:"id,omitempty"`
+	ExternalID  string                 `json:"externalId,omitempty"`
+	DisplayName string                 `json:"displayName"`
+	Members     []MultiValuedAttribute `json:"members"`
+	Meta        *Meta                  `json:"meta,omitempty"`
+}
+
+// ListResponse is a page of SCIM resources
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// PatchOperation is a single operation of a SCIM PATCH request
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// PatchRequest is the body of a SCIM PATCH request
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// ListQuery holds the filter and pagination parameters of a list request
+type ListQuery struct {
+	Filter     string
+	StartIndex int // 1-based index of the first result
+	Count      int // Page size, 0 only returns totalResults
+}
+
+// ClientRepository defines the interface for provisioning client data access
+type ClientRepository interface {
+	FindByTokenHash(tokenHash string) (*Client, error)
+}
+
+// GroupRepository defines the interface for group data access
+type GroupRepository interface {
+	Create(group *Group) error
+	FindByID(id uuid.UUID) (*Group, error)
+	FindByDisplayName(displayName string) (*Group, error)
+	FindByExternalID(externalID string) (*Group, error)
+	Update(group *Group) error
+	Delete(id uuid.UUID) error
+	List(offset, limit int) ([]*Group, int64, error)
+	Members(groupID uuid.UUID) ([]GroupMember, error)
+	ReplaceMembers(groupID uuid.UUID, userIDs []uuid.UUID) error
+}
+
+// SessionRevoker ends every session of a user. The auth repository implements it.
+type SessionRevoker interface {
+	DeleteUserSessions(userID uuid.UUID) error
+}
+
+// SCIMUsecase defines the interface for the SCIM provisioning API
+type SCIMUsecase interface {
+	AuthenticateClient(ctx context.Context, token string) (*Client, error)
+
+	ListUsers(ctx context.Context, query ListQuery) (*ListResponse, error)
+	GetUser(ctx context.Context, id string) (*UserResource, error)
+	CreateUser(ctx context.Context, resource *UserResource) (*UserResource, error)
+	ReplaceUser(ctx context.Context, id string, resource *UserResource) (*UserResource, error)
+	PatchUser(ctx context.Context, id string, patch *PatchRequest) (*UserResource, error)
+	DeleteUser(ctx context.Context, id string) error
+
+	ListGroups(ctx context.Context, query ListQuery) (*ListResponse, error)
+	GetGroup(ctx context.Context, id string) (*GroupResource, error)
+	CreateGroup(ctx context.Context, resource *GroupResource) (*GroupResource, error)
+	ReplaceGroup(ctx context.Context, id string, resource *GroupResource) (*GroupResource, error)
+	PatchGroup(ctx context.Context, id string, patch *PatchRequest) (*GroupResource, error)
+	DeleteGroup(ctx context.Context, id string) error
+}
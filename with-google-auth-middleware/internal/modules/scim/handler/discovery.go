@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/usecase"
+)
+
+// Attribute characteristics of RFC 7643 section 2.2
+const (
+	mutabilityReadWrite = "readWrite"
+	mutabilityImmutable = "immutable"
+	returnedDefault     = "default"
+	uniquenessNone      = "none"
+	uniquenessServer    = "server"
+)
+
+// serviceProviderConfig advertises what the SCIM API supports
+var serviceProviderConfig = domain.ServiceProviderConfig{
+	Schemas:        []string{domain.SchemaServiceProviderConfig},
+	Patch:          domain.Supported{Supported: true},
+	Bulk:           domain.BulkSupport{Supported: false},
+	Filter:         domain.FilterSupport{Supported: true, MaxResults: usecase.MaxPageSize},
+	ChangePassword: domain.Supported{Supported: false},
+	Sort:           domain.Supported{Supported: false},
+	ETag:           domain.Supported{Supported: false},
+	AuthenticationSchemes: []domain.AuthenticationScheme{{
+		Type:        "oauthbearertoken",
+		Name:        "Bearer Token",
+		Description: "Each provisioning client authenticates with its own bearer token",
+		Primary:     true,
+	}},
+	Meta: &domain.Meta{ResourceType: "ServiceProviderConfig"},
+}
+
+// attribute describes a read-write, non-unique string attribute
+func attribute(name, description string) domain.SchemaAttribute {
+	return domain.SchemaAttribute{
+		Name:        name,
+		Type:        "string",
+		Description: description,
+		Mutability:  mutabilityReadWrite,
+		Returned:    returnedDefault,
+		Uniqueness:  uniquenessNone,
+	}
+}
+
+// multiValued describes a multi-valued complex attribute with value, type and primary
+func multiValued(name, description, valueType string) domain.SchemaAttribute {
+	value := attribute("value", "The value of the "+name)
+	value.Type = valueType
+	primary := attribute("primary", "Whether this is the primary value")
+	primary.Type = "boolean"
+
+	a := attribute(name, description)
+	a.Type = "complex"
+	a.MultiValued = true
+	a.SubAttributes = []domain.SchemaAttribute{value, attribute("type", "The kind of value"), primary}
+	return a
+}
+
+// schemas describes the User and Group resources
+var schemas = func() []domain.Schema {
+	userName := attribute("userName", "Unique identifier of the user, their email address")
+	userName.Required = true
+	userName.Uniqueness = uniquenessServer
+
+	name := attribute("name", "The components of the user's name")
+	name.Type = "complex"
+	name.SubAttributes = []domain.SchemaAttribute{
+		attribute("formatted", "The full name"),
+		attribute("givenName", "The given name"),
+		attribute("familyName", "The family name"),
+	}
+
+	active := attribute("active", "Inactive users can't sign in and lose their sessions")
+	active.Type = "boolean"
+
+	displayName := attribute("displayName", "Name of the group")
+	displayName.Required = true
+	displayName.Uniqueness = uniquenessServer
+
+	memberValue := attribute("value", "ID of the member user")
+	memberValue.Mutability = mutabilityImmutable
+	memberRef := attribute("$ref", "URI of the member user")
+	memberRef.Type = "reference"
+	memberRef.Mutability = mutabilityImmutable
+	memberType := attribute("type", "Type of the member, always User")
+	memberType.Mutability = mutabilityImmutable
+	members := attribute("members", "Users in the group")
+	members.Type = "complex"
+	members.MultiValued = true
+	members.SubAttributes = []domain.SchemaAttribute{memberValue, memberRef, memberType}
+
+	return []domain.Schema{
+		{
+			Schemas:     []string{domain.SchemaSchema},
+			ID:          domain.SchemaUser,
+			Name:        "User",
+			Description: "User account",
+			Attributes: []domain.SchemaAttribute{
+				userName,
+				name,
+				attribute("displayName", "Name of the user, suitable for display"),
+				attribute("locale", "Preferred language, e.g. en-US"),
+				active,
+				multiValued("emails", "Email addresses, the primary one equals userName", "string"),
+				multiValued("photos", "URLs of profile pictures", "reference"),
+			},
+			Meta: &domain.Meta{ResourceType: "Schema"},
+		},
+		{
+			Schemas:     []string{domain.SchemaSchema},
+			ID:          domain.SchemaGroup,
+			Name:        "Group",
+			Description: "Group of users",
+			Attributes:  []domain.SchemaAttribute{displayName, members},
+			Meta:        &domain.Meta{ResourceType: "Schema"},
+		},
+	}
+}()
+
+// resourceTypes lists the resource endpoints
+var resourceTypes = []domain.ResourceType{
+	{
+		Schemas:     []string{domain.SchemaResourceType},
+		ID:          domain.ResourceTypeUser,
+		Name:        domain.ResourceTypeUser,
+		Endpoint:    "/Users",
+		Description: "User account",
+		Schema:      domain.SchemaUser,
+		Meta:        &domain.Meta{ResourceType: "ResourceType"},
+	},
+	{
+		Schemas:     []string{domain.SchemaResourceType},
+		ID:          domain.ResourceTypeGroup,
+		Name:        domain.ResourceTypeGroup,
+		Endpoint:    "/Groups",
+		Description: "Group of users",
+		Schema:      domain.SchemaGroup,
+		Meta:        &domain.Meta{ResourceType: "ResourceType"},
+	},
+}
+
+// GetServiceProviderConfig describes the supported SCIM features
+// @Summary SCIM service provider configuration
+// @Description Features of the SCIM API: PATCH and filtering are supported, bulk, sorting and ETags are not
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} domain.ServiceProviderConfig
+// @Failure 401 {object} ErrorResponse
+// @Router /scim/v2/ServiceProviderConfig [get]
+func (h *SCIMHandler) GetServiceProviderConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, serviceProviderConfig)
+}
+
+// ListSchemas lists the resource schemas
+// @Summary List SCIM schemas
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} domain.ListResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /scim/v2/Schemas [get]
+func (h *SCIMHandler) ListSchemas(c *gin.Context) {
+	response := &domain.ListResponse{
+		Schemas:      []string{domain.SchemaListResponse},
+		TotalResults: int64(len(schemas)),
+		StartIndex:   1,
+		ItemsPerPage: len(schemas),
+	}
+	for _, schema := range schemas {
+		response.Resources = append(response.Resources, schema)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetSchema returns a resource schema by its URN
+// @Summary Get a SCIM schema
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Schema URN"
+// @Success 200 {object} domain.Schema
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /scim/v2/Schemas/{id} [get]
+func (h *SCIMHandler) GetSchema(c *gin.Context) {
+	for _, schema := range schemas {
+		if schema.ID == c.Param("id") {
+			c.JSON(http.StatusOK, schema)
+			return
+		}
+	}
+	respondError(c, usecase.ErrResourceNotFound)
+}
+
+// ListResourceTypes lists the resource endpoints
+// @Summary List SCIM resource types
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} domain.ListResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /scim/v2/ResourceTypes [get]
+func (h *SCIMHandler) ListResourceTypes(c *gin.Context) {
+	response := &domain.ListResponse{
+		Schemas:      []string{domain.SchemaListResponse},
+		TotalResults: int64(len(resourceTypes)),
+		StartIndex:   1,
+		ItemsPerPage: len(resourceTypes),
+	}
+	for _, resourceType := range resourceTypes {
+		response.Resources = append(response.Resources, resourceType)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetResourceType returns a resource type by name
+// @Summary Get a SCIM resource type
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Resource type, User or Group"
+// @Success 200 {object} domain.ResourceType
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /scim/v2/ResourceTypes/{id} [get]
+func (h *SCIMHandler) GetResourceType(c *gin.Context) {
+	for _, resourceType := range resourceTypes {
+		if resourceType.ID == c.Param("id") {
+			c.JSON(http.StatusOK, resourceType)
+			return
+		}
+	}
+	respondError(c, usecase.ErrResourceNotFound)
+}
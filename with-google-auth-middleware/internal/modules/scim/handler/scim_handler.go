@@ -0,0 +1,450 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/usecase"
+)
+
+// ContentType is the media type of SCIM requests and responses
+const ContentType = "application/scim+json"
+
+type SCIMHandler struct {
+	scimUsecase domain.SCIMUsecase
+}
+
+func NewSCIMHandler(scimUsecase domain.SCIMUsecase) *SCIMHandler {
+	return &SCIMHandler{
+		scimUsecase: scimUsecase,
+	}
+}
+
+// ErrorResponse represents a SCIM error (RFC 7644 section 3.12)
+type ErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewErrorResponse creates a SCIM error with the given HTTP status
+func NewErrorResponse(status int, scimType, detail string) ErrorResponse {
+	return ErrorResponse{
+		Schemas:  []string{domain.SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// ListUsers handles listing and filtering users
+// @Summary List SCIM users
+// @Description List users, optionally filtered, e.g. filter=userName eq "alice@example.com"
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Param filter query string false "SCIM filter comparing id, userName or externalId with eq, other comparisons joined with and"
+// @Param startIndex query int false "1-based index of the first result"
+// @Param count query int false "Page size, at most 200"
+// @Success 200 {object} domain.ListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Users [get]
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	response, err := h.scimUsecase.ListUsers(c.Request.Context(), listQuery(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetUser handles getting a user
+// @Summary Get a SCIM user
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} domain.UserResource
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Users/{id} [get]
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	user, err := h.scimUsecase.GetUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// CreateUser handles user provisioning
+// @Summary Create a SCIM user
+// @Description Provision a user. userName is the user's email address.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body domain.UserResource true "User"
+// @Success 201 {object} domain.UserResource
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Users [post]
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var req domain.UserResource
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidSyntax(c, err)
+		return
+	}
+
+	user, err := h.scimUsecase.CreateUser(c.Request.Context(), &req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if user.Meta != nil && user.Meta.Location != "" {
+		c.Header("Location", user.Meta.Location)
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+// ReplaceUser handles replacing a user
+// @Summary Replace a SCIM user
+// @Description Replace the attributes of a user. Setting active to false ends all of the user's sessions.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param request body domain.UserResource true "User"
+// @Success 200 {object} domain.UserResource
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Users/{id} [put]
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	var req domain.UserResource
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidSyntax(c, err)
+		return
+	}
+
+	user, err := h.scimUsecase.ReplaceUser(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// PatchUser handles partial user updates
+// @Summary Patch a SCIM user
+// @Description Apply add, replace and remove operations, e.g. {"op": "replace", "path": "active", "value": false} to deactivate the user
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param request body domain.PatchRequest true "PATCH operations"
+// @Success 200 {object} domain.UserResource
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Users/{id} [patch]
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	var req domain.PatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidSyntax(c, err)
+		return
+	}
+
+	user, err := h.scimUsecase.PatchUser(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser handles user deprovisioning
+// @Summary Delete a SCIM user
+// @Description Delete the user and end all of their sessions
+// @Tags scim
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Users/{id} [delete]
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	if err := h.scimUsecase.DeleteUser(c.Request.Context(), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListGroups handles listing and filtering groups
+// @Summary List SCIM groups
+// @Description List groups, optionally filtered, e.g. filter=displayName eq "Engineering"
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Param filter query string false "SCIM filter comparing id, displayName or externalId with eq, other comparisons joined with and"
+// @Param startIndex query int false "1-based index of the first result"
+// @Param count query int false "Page size, at most 200"
+// @Success 200 {object} domain.ListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Groups [get]
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	response, err := h.scimUsecase.ListGroups(c.Request.Context(), listQuery(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetGroup handles getting a group
+// @Summary Get a SCIM group
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Group ID"
+// @Success 200 {object} domain.GroupResource
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Groups/{id} [get]
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	group, err := h.scimUsecase.GetGroup(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+// CreateGroup handles group provisioning
+// @Summary Create a SCIM group
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body domain.GroupResource true "Group"
+// @Success 201 {object} domain.GroupResource
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Groups [post]
+func (h *SCIMHandler) CreateGroup(c *gin.Context) {
+	var req domain.GroupResource
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidSyntax(c, err)
+		return
+	}
+
+	group, err := h.scimUsecase.CreateGroup(c.Request.Context(), &req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if group.Meta != nil && group.Meta.Location != "" {
+		c.Header("Location", group.Meta.Location)
+	}
+	c.JSON(http.StatusCreated, group)
+}
+
+// ReplaceGroup handles replacing a group
+// @Summary Replace a SCIM group
+// @Description Replace the name and the full member list of a group
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Group ID"
+// @Param request body domain.GroupResource true "Group"
+// @Success 200 {object} domain.GroupResource
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Groups/{id} [put]
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	var req domain.GroupResource
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidSyntax(c, err)
+		return
+	}
+
+	group, err := h.scimUsecase.ReplaceGroup(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+// PatchGroup handles partial group updates
+// @Summary Patch a SCIM group
+// @Description Rename a group or add and remove members, e.g. {"op": "remove", "path": "members[value eq \"{user_id}\"]"}
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Group ID"
+// @Param request body domain.PatchRequest true "PATCH operations"
+// @Success 200 {object} domain.GroupResource
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Groups/{id} [patch]
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	var req domain.PatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidSyntax(c, err)
+		return
+	}
+
+	group, err := h.scimUsecase.PatchGroup(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+// DeleteGroup handles group deletion
+// @Summary Delete a SCIM group
+// @Description Delete the group. Its members are not affected.
+// @Tags scim
+// @Security BearerAuth
+// @Param id path string true "Group ID"
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /scim/v2/Groups/{id} [delete]
+func (h *SCIMHandler) DeleteGroup(c *gin.Context) {
+	if err := h.scimUsecase.DeleteGroup(c.Request.Context(), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// listQuery reads the filter and pagination parameters of a list request
+func listQuery(c *gin.Context) domain.ListQuery {
+	query := domain.ListQuery{
+		Filter:     c.Query("filter"),
+		StartIndex: 1,
+		Count:      usecase.DefaultPageSize,
+	}
+	if startIndex, err := strconv.Atoi(c.Query("startIndex")); err == nil {
+		query.StartIndex = startIndex
+	}
+	if count, err := strconv.Atoi(c.Query("count")); err == nil {
+		query.Count = count
+	}
+	return query
+}
+
+func respondInvalidSyntax(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, NewErrorResponse(http.StatusBadRequest, "invalidSyntax", err.Error()))
+}
+
+// respondError maps usecase errors to SCIM errors
+func respondError(c *gin.Context, err error) {
+	status, scimType := http.StatusInternalServerError, ""
+	detail := err.Error()
+	switch {
+	case errors.Is(err, usecase.ErrResourceNotFound):
+		status = http.StatusNotFound
+		detail = "Resource not found"
+	case errors.Is(err, usecase.ErrUniqueness):
+		status, scimType = http.StatusConflict, "uniqueness"
+	case errors.Is(err, usecase.ErrInvalidFilter):
+		status, scimType = http.StatusBadRequest, "invalidFilter"
+	case errors.Is(err, usecase.ErrInvalidPath):
+		status, scimType = http.StatusBadRequest, "invalidPath"
+	case errors.Is(err, usecase.ErrNoTarget):
+		status, scimType = http.StatusBadRequest, "noTarget"
+	case errors.Is(err, usecase.ErrInvalidValue):
+		status, scimType = http.StatusBadRequest, "invalidValue"
+	case errors.Is(err, usecase.ErrInvalidSyntax):
+		status, scimType = http.StatusBadRequest, "invalidSyntax"
+	default:
+		detail = "Failed to process SCIM request"
+	}
+	c.JSON(status, NewErrorResponse(status, scimType, detail))
+}
+
+// setContentType makes every response use the SCIM media type
+func setContentType(c *gin.Context) {
+	c.Header("Content-Type", ContentType)
+	c.Next()
+}
+
+// RegisterRoutes registers the SCIM routes. The router group must be
+// authenticated with the SCIM middleware.
+func (h *SCIMHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.Use(setContentType)
+	{
+		router.GET("/ServiceProviderConfig", h.GetServiceProviderConfig)
+		router.GET("/Schemas", h.ListSchemas)
+		router.GET("/Schemas/:id", h.GetSchema)
+		router.GET("/ResourceTypes", h.ListResourceTypes)
+		router.GET("/ResourceTypes/:id", h.GetResourceType)
+	}
+
+	users := router.Group("/Users")
+	{
+		users.GET("", h.ListUsers)
+		users.POST("", h.CreateUser)
+		users.GET("/:id", h.GetUser)
+		users.PUT("/:id", h.ReplaceUser)
+		users.PATCH("/:id", h.PatchUser)
+		users.DELETE("/:id", h.DeleteUser)
+	}
+
+	groups := router.Group("/Groups")
+	{
+		groups.GET("", h.ListGroups)
+		groups.POST("", h.CreateGroup)
+		groups.GET("/:id", h.GetGroup)
+		groups.PUT("/:id", h.ReplaceGroup)
+		groups.PATCH("/:id", h.PatchGroup)
+		groups.DELETE("/:id", h.DeleteGroup)
+	}
+}
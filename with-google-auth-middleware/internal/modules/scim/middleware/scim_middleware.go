@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/handler"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/usecase"
+)
+
+type SCIMMiddleware struct {
+	scimUsecase domain.SCIMUsecase
+}
+
+func NewSCIMMiddleware(scimUsecase domain.SCIMUsecase) *SCIMMiddleware {
+	return &SCIMMiddleware{
+		scimUsecase: scimUsecase,
+	}
+}
+
+// ClientRequired is a middleware that only lets provisioning clients with a
+// valid bearer token through. The client is stored as "scim_client".
+func (m *SCIMMiddleware) ClientRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			m.unauthorized(c, "Bearer token is required")
+			return
+		}
+
+		client, err := m.scimUsecase.AuthenticateClient(c.Request.Context(), token)
+		if err != nil {
+			if errors.Is(err, usecase.ErrInvalidToken) {
+				m.unauthorized(c, "Invalid bearer token")
+				return
+			}
+			c.Header("Content-Type", handler.ContentType)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, handler.NewErrorResponse(http.StatusInternalServerError, "", "Failed to authenticate client"))
+			return
+		}
+
+		c.Set("scim_client", client)
+		c.Next()
+	}
+}
+
+func (m *SCIMMiddleware) unauthorized(c *gin.Context, detail string) {
+	c.Header("Content-Type", handler.ContentType)
+	c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, handler.NewErrorResponse(http.StatusUnauthorized, "", detail))
+}
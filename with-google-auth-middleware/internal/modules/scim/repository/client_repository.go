@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+	"gorm.io/gorm"
+)
+
+type clientRepository struct {
+	db *gorm.DB
+}
+
+func NewClientRepository(db *gorm.DB) domain.ClientRepository {
+	return &clientRepository{db: db}
+}
+
+func (r *clientRepository) FindByTokenHash(tokenHash string) (*domain.Client, error) {
+	var client domain.Client
+	err := r.db.Where("token_hash = ?", tokenHash).First(&client).Error
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type groupRepository struct {
+	db *gorm.DB
+}
+
+func NewGroupRepository(db *gorm.DB) domain.GroupRepository {
+	return &groupRepository{db: db}
+}
+
+func (r *groupRepository) Create(group *domain.Group) error {
+	return r.db.Create(group).Error
+}
+
+func (r *groupRepository) FindByID(id uuid.UUID) (*domain.Group, error) {
+	var group domain.Group
+	err := r.db.Where("id = ?", id).First(&group).Error
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) FindByDisplayName(displayName string) (*domain.Group, error) {
+	var group domain.Group
+	err := r.db.Where("LOWER(display_name) = LOWER(?)", displayName).First(&group).Error
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) FindByExternalID(externalID string) (*domain.Group, error) {
+	var group domain.Group
+	err := r.db.Where("external_id = ?", externalID).First(&group).Error
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) Update(group *domain.Group) error {
+	group.UpdatedAt = time.Now()
+	return r.db.Save(group).Error
+}
+
+func (r *groupRepository) Delete(id uuid.UUID) error {
+	return r.db.Delete(&domain.Group{}, "id = ?", id).Error
+}
+
+func (r *groupRepository) List(offset, limit int) ([]*domain.Group, int64, error) {
+	var total int64
+	if err := r.db.Model(&domain.Group{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var groups []*domain.Group
+	err := r.db.Order("created_at ASC, id ASC").Offset(offset).Limit(limit).Find(&groups).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
+func (r *groupRepository) Members(groupID uuid.UUID) ([]domain.GroupMember, error) {
+	var members []domain.GroupMember
+	err := r.db.Where("group_id = ?", groupID).Order("created_at ASC").Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// ReplaceMembers sets the members of the group in one transaction. Members
+// that stay in the group keep their original join time.
+func (r *groupRepository) ReplaceMembers(groupID uuid.UUID, userIDs []uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("group_id = ?", groupID)
+		if len(userIDs) > 0 {
+			query = query.Where("user_id NOT IN ?", userIDs)
+		}
+		if err := query.Delete(&domain.GroupMember{}).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, userID := range userIDs {
+			member := domain.GroupMember{GroupID: groupID, UserID: userID, CreatedAt: now}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&domain.Group{}).Where("id = ?", groupID).Update("updated_at", now).Error
+	})
+}
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	userrepo "github.com/tyobaskara/jeki-backend/internal/modules/user/repository"
+	"gorm.io/gorm"
+)
+
+// MergeHooks move the group memberships of a user merged into another user
+var MergeHooks = []userrepo.MergeHook{
+	{Name: "group_members", Merge: mergeGroupMembers},
+}
+
+// mergeGroupMembers moves the source's group memberships, memberships of
+// groups the target is already in are dropped
+func mergeGroupMembers(tx *gorm.DB, sourceID, targetID uuid.UUID) (int64, error) {
+	err := tx.Exec(`DELETE FROM group_members s WHERE s.user_id = ? AND EXISTS (
+		SELECT 1 FROM group_members t WHERE t.user_id = ? AND t.group_id = s.group_id
+	)`, sourceID, targetID).Error
+	if err != nil {
+		return 0, err
+	}
+	result := tx.Exec("UPDATE group_members SET user_id = ? WHERE user_id = ?", targetID, sourceID)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS scim_clients;
\ No newline at end of file
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS groups (
+    id UUID PRIMARY KEY,
+    display_name VARCHAR(255) NOT NULL,
+    external_id VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_groups_display_name ON groups(LOWER(display_name));
+CREATE UNIQUE INDEX idx_groups_external_id ON groups(external_id) WHERE external_id <> '';
+
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX idx_group_members_user_id ON group_members(user_id);
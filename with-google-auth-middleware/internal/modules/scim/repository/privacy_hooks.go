@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	privacyrepo "github.com/tyobaskara/jeki-backend/internal/modules/privacy/repository"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+	"gorm.io/gorm"
+)
+
+// ExportHooks export the groups a user was provisioned into
+var ExportHooks = []privacyrepo.ExportHook{
+	{Name: "groups", Export: exportGroups},
+}
+
+// ErasureHooks remove a user from their groups, the groups belong to the
+// provisioning client and are kept
+var ErasureHooks = []privacyrepo.ErasureHook{
+	{Name: "group_members", Erase: eraseGroupMembers},
+}
+
+func exportGroups(db *gorm.DB, userID uuid.UUID) (interface{}, error) {
+	groups := []*domain.Group{}
+	err := db.Where("id IN (?)", db.Model(&domain.GroupMember{}).Select("group_id").Where("user_id = ?", userID)).
+		Order("created_at, id").
+		Find(&groups).Error
+	return groups, err
+}
+
+func eraseGroupMembers(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("DELETE FROM group_members WHERE user_id = ?", userID)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,191 @@
+package usecase
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+)
+
+// Filter operators we can evaluate
+const (
+	filterOpEqual      = "eq"
+	filterOpNotEqual   = "ne"
+	filterOpContains   = "co"
+	filterOpStartsWith = "sw"
+	filterOpEndsWith   = "ew"
+	filterOpPresent    = "pr"
+)
+
+// filterClause is a single comparison of a SCIM filter, e.g. userName eq "alice@example.com"
+type filterClause struct {
+	// attribute is the lower-cased attribute path without the core schema URN
+	attribute string
+	operator  string
+	value     string
+}
+
+// parseFilter parses the subset of the SCIM filter grammar (RFC 7644 section
+// 3.4.2.2) provisioning clients use: comparisons joined by "and". Grouping,
+// "or", "not" and ordering comparisons are rejected.
+func parseFilter(filter string) ([]filterClause, error) {
+	tokens, err := tokenizeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var clauses []filterClause
+	for i := 0; i < len(tokens); {
+		if len(clauses) > 0 {
+			if !strings.EqualFold(tokens[i].text, "and") || tokens[i].quoted {
+				return nil, fmt.Errorf("%w: unsupported expression %q", ErrInvalidFilter, tokens[i].text)
+			}
+			i++
+		}
+		if i+1 >= len(tokens) {
+			return nil, fmt.Errorf("%w: incomplete expression", ErrInvalidFilter)
+		}
+
+		clause := filterClause{
+			attribute: normalizeAttribute(tokens[i].text),
+			operator:  strings.ToLower(tokens[i+1].text),
+		}
+		if tokens[i].quoted || tokens[i+1].quoted {
+			return nil, fmt.Errorf("%w: expected attribute and operator", ErrInvalidFilter)
+		}
+		i += 2
+
+		switch clause.operator {
+		case filterOpPresent:
+		case filterOpEqual, filterOpNotEqual, filterOpContains, filterOpStartsWith, filterOpEndsWith:
+			if i >= len(tokens) {
+				return nil, fmt.Errorf("%w: missing value for %s", ErrInvalidFilter, clause.operator)
+			}
+			clause.value = tokens[i].text
+			i++
+		default:
+			return nil, fmt.Errorf("%w: unsupported operator %q", ErrInvalidFilter, clause.operator)
+		}
+		clauses = append(clauses, clause)
+	}
+	return clauses, nil
+}
+
+// filterToken is a word or a decoded string literal of a filter
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeFilter(filter string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			return nil, fmt.Errorf("%w: grouping is not supported", ErrInvalidFilter)
+		case c == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+			}
+			var value string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &value); err != nil {
+				return nil, fmt.Errorf("%w: invalid string: %v", ErrInvalidFilter, err)
+			}
+			tokens = append(tokens, filterToken{text: value, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t()[]\"", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, filterToken{text: filter[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// normalizeAttribute lower-cases an attribute path and strips the core schema
+// URN, so that "urn:...:User:userName" and "USERNAME" both become "username"
+func normalizeAttribute(path string) string {
+	return strings.ToLower(stripSchema(path))
+}
+
+// stripSchema removes the core schema URN from an attribute path
+func stripSchema(path string) string {
+	for _, schema := range []string{domain.SchemaUser, domain.SchemaGroup} {
+		prefix := schema + ":"
+		if len(path) > len(prefix) && strings.EqualFold(path[:len(prefix)], prefix) {
+			return path[len(prefix):]
+		}
+	}
+	return path
+}
+
+// lookupClause returns the first clause that compares one of the attributes
+// with eq, the clause a resource can be looked up by
+func lookupClause(filter []filterClause, attributes ...string) (filterClause, bool) {
+	for _, clause := range filter {
+		if clause.operator != filterOpEqual {
+			continue
+		}
+		for _, attribute := range attributes {
+			if clause.attribute == attribute {
+				return clause, true
+			}
+		}
+	}
+	return filterClause{}, false
+}
+
+// matches reports whether any of the attribute values satisfies the clause.
+// Comparisons are case-insensitive unless caseExact is set.
+func (c filterClause) matches(values []string, caseExact bool) bool {
+	if c.operator == filterOpPresent {
+		for _, value := range values {
+			if value != "" {
+				return true
+			}
+		}
+		return false
+	}
+	if c.operator == filterOpNotEqual {
+		return !filterClause{attribute: c.attribute, operator: filterOpEqual, value: c.value}.matches(values, caseExact)
+	}
+
+	want := c.value
+	for _, value := range values {
+		if !caseExact {
+			value, want = strings.ToLower(value), strings.ToLower(c.value)
+		}
+		switch c.operator {
+		case filterOpEqual:
+			if value == want {
+				return true
+			}
+		case filterOpContains:
+			if strings.Contains(value, want) {
+				return true
+			}
+		case filterOpStartsWith:
+			if strings.HasPrefix(value, want) {
+				return true
+			}
+		case filterOpEndsWith:
+			if strings.HasSuffix(value, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
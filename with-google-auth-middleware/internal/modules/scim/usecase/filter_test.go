@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	t.Run("userName eq", func(t *testing.T) {
+		clauses, err := parseFilter(`userName eq "alice@example.com"`)
+		require.NoError(t, err)
+		assert.Equal(t, []filterClause{{attribute: "username", operator: "eq", value: "alice@example.com"}}, clauses)
+	})
+
+	t.Run("schema URN, escapes and and", func(t *testing.T) {
+		clauses, err := parseFilter(`urn:ietf:params:scim:schemas:core:2.0:User:externalId EQ "a\"b" and active eq true and title pr`)
+		require.NoError(t, err)
+		assert.Equal(t, []filterClause{
+			{attribute: "externalid", operator: "eq", value: `a"b`},
+			{attribute: "active", operator: "eq", value: "true"},
+			{attribute: "title", operator: "pr"},
+		}, clauses)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		clauses, err := parseFilter("  ")
+		require.NoError(t, err)
+		assert.Empty(t, clauses)
+	})
+
+	for _, filter := range []string{
+		`userName eq "a" or userName eq "b"`,
+		`(userName eq "a")`,
+		`emails[type eq "work"]`,
+		`meta.lastModified gt "2011-05-13T04:42:34Z"`,
+		`userName eq`,
+		`userName eq "unterminated`,
+		`"userName" eq "a"`,
+	} {
+		t.Run("rejects "+filter, func(t *testing.T) {
+			_, err := parseFilter(filter)
+			assert.ErrorIs(t, err, ErrInvalidFilter)
+		})
+	}
+}
+
+func TestFilterClauseMatches(t *testing.T) {
+	tests := []struct {
+		clause    filterClause
+		values    []string
+		caseExact bool
+		want      bool
+	}{
+		{filterClause{operator: "eq", value: "Alice@Example.com"}, []string{"alice@example.com"}, false, true},
+		{filterClause{operator: "eq", value: "ABC"}, []string{"abc"}, true, false},
+		{filterClause{operator: "ne", value: "abc"}, []string{"abd"}, true, true},
+		{filterClause{operator: "co", value: "example"}, []string{"alice@example.com"}, false, true},
+		{filterClause{operator: "sw", value: "ali"}, []string{"alice"}, false, true},
+		{filterClause{operator: "ew", value: ".org"}, []string{"alice@example.com"}, false, false},
+		{filterClause{operator: "eq", value: "b"}, []string{"a", "b"}, false, true},
+		{filterClause{operator: "pr"}, []string{""}, false, false},
+		{filterClause{operator: "pr"}, []string{"x"}, false, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.clause.matches(tt.values, tt.caseExact), "%+v %v", tt.clause, tt.values)
+	}
+}
+
+func TestLookupClause(t *testing.T) {
+	filter, err := parseFilter(`active eq true and externalId ne "x" and userName eq "alice@example.com" and externalId eq "42"`)
+	require.NoError(t, err)
+
+	clause, ok := lookupClause(filter, "id", "username", "externalid")
+	require.True(t, ok)
+	assert.Equal(t, "username", clause.attribute)
+	assert.Equal(t, "alice@example.com", clause.value)
+
+	_, ok = lookupClause(filter, "id", "displayname")
+	assert.False(t, ok)
+}
@@ -0,0 +1,285 @@
+package usecase
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+)
+
+// patchPath is a parsed PATCH path: attribute[filter].subAttribute
+type patchPath struct {
+	attribute    string
+	filter       []filterClause
+	subAttribute string
+}
+
+// parsePatchPath parses the attribute paths of RFC 7644 section 3.5.2,
+// e.g. "active", "name.givenName", `emails[type eq "work"].value` or
+// `members[value eq "2819c223"]`
+func parsePatchPath(path string) (*patchPath, error) {
+	path = stripSchema(strings.TrimSpace(path))
+	if path == "" {
+		return nil, fmt.Errorf("%w: empty path", ErrInvalidPath)
+	}
+	// Extension attributes are kept whole, we don't store them
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		return &patchPath{attribute: path}, nil
+	}
+
+	parsed := &patchPath{}
+	if open := strings.IndexByte(path, '['); open >= 0 {
+		end := strings.LastIndexByte(path, ']')
+		if end < open {
+			return nil, fmt.Errorf("%w: unterminated filter in %q", ErrInvalidPath, path)
+		}
+		filter, err := parseFilter(path[open+1 : end])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPath, err)
+		}
+		if len(filter) == 0 {
+			return nil, fmt.Errorf("%w: empty filter in %q", ErrInvalidPath, path)
+		}
+		parsed.filter = filter
+		rest := path[end+1:]
+		if rest != "" && !strings.HasPrefix(rest, ".") {
+			return nil, fmt.Errorf("%w: unexpected %q after filter", ErrInvalidPath, rest)
+		}
+		parsed.subAttribute = strings.TrimPrefix(rest, ".")
+		path = path[:open]
+	} else if dot := strings.IndexByte(path, '.'); dot >= 0 {
+		parsed.subAttribute = path[dot+1:]
+		path = path[:dot]
+	}
+
+	if path == "" || strings.ContainsAny(path+parsed.subAttribute, ".[] ") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	parsed.attribute = path
+	return parsed, nil
+}
+
+// applyPatch applies the operations of a PATCH request to the JSON object of a resource
+func applyPatch(resource map[string]interface{}, operations []domain.PatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != domain.PatchOpAdd && op != domain.PatchOpReplace && op != domain.PatchOpRemove {
+			return fmt.Errorf("%w: unsupported operation %q", ErrInvalidSyntax, operation.Op)
+		}
+
+		var value interface{}
+		if len(operation.Value) > 0 {
+			if err := json.Unmarshal(operation.Value, &value); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+			}
+		}
+
+		if operation.Path != "" {
+			path, err := parsePatchPath(operation.Path)
+			if err != nil {
+				return err
+			}
+			if err := applyPatchOperation(resource, op, path, value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Without a path the value holds the attributes to add or replace.
+		// Some clients (Azure AD) use paths such as "name.givenName" as keys.
+		if op == domain.PatchOpRemove {
+			return fmt.Errorf("%w: remove requires a path", ErrNoTarget)
+		}
+		attributes, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: value must be an object when path is omitted", ErrInvalidValue)
+		}
+		for key, attributeValue := range attributes {
+			path, err := parsePatchPath(key)
+			if err != nil {
+				return err
+			}
+			if err := applyPatchOperation(resource, op, path, attributeValue); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func applyPatchOperation(resource map[string]interface{}, op string, path *patchPath, value interface{}) error {
+	key := findKey(resource, path.attribute)
+
+	if path.filter != nil {
+		elements, _ := resource[key].([]interface{})
+		return patchElements(resource, key, elements, op, path, value)
+	}
+
+	if path.subAttribute != "" {
+		complex, _ := resource[key].(map[string]interface{})
+		if op == domain.PatchOpRemove {
+			if complex != nil {
+				delete(complex, findKey(complex, path.subAttribute))
+			}
+			return nil
+		}
+		if complex == nil {
+			complex = map[string]interface{}{}
+			resource[key] = complex
+		}
+		complex[findKey(complex, path.subAttribute)] = value
+		return nil
+	}
+
+	existing, isMultiValued := resource[key].([]interface{})
+	switch op {
+	case domain.PatchOpAdd, domain.PatchOpReplace:
+		if isMultiValued && op == domain.PatchOpAdd {
+			resource[key] = appendValues(existing, value)
+			return nil
+		}
+		if complex, ok := resource[key].(map[string]interface{}); ok {
+			// Sub-attributes missing from the value of a complex attribute are left unchanged
+			if values, ok := value.(map[string]interface{}); ok {
+				for subKey, subValue := range values {
+					complex[findKey(complex, subKey)] = subValue
+				}
+				return nil
+			}
+		}
+		resource[key] = value
+	case domain.PatchOpRemove:
+		// Azure AD removes members by listing them in the value
+		if values, ok := value.([]interface{}); ok && isMultiValued {
+			resource[key] = removeValues(existing, values)
+			return nil
+		}
+		delete(resource, key)
+	}
+	return nil
+}
+
+// patchElements applies an operation to the elements of a multi-valued
+// attribute matching the path filter
+func patchElements(resource map[string]interface{}, key string, elements []interface{}, op string, path *patchPath, value interface{}) error {
+	var kept []interface{}
+	matched := false
+	for _, element := range elements {
+		object, ok := element.(map[string]interface{})
+		if !ok || !elementMatches(object, path.filter) {
+			kept = append(kept, element)
+			continue
+		}
+		matched = true
+
+		switch {
+		case op == domain.PatchOpRemove && path.subAttribute == "":
+			continue
+		case op == domain.PatchOpRemove:
+			delete(object, findKey(object, path.subAttribute))
+		case path.subAttribute != "":
+			object[findKey(object, path.subAttribute)] = value
+		default:
+			replacement, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%w: value must be an object", ErrInvalidValue)
+			}
+			object = replacement
+		}
+		kept = append(kept, object)
+	}
+
+	if !matched && op != domain.PatchOpRemove {
+		// Adding or replacing a value that doesn't exist yet creates it, with
+		// the attributes the filter asked for
+		element := map[string]interface{}{}
+		for _, clause := range path.filter {
+			if clause.operator != filterOpEqual {
+				return fmt.Errorf("%w: no value matches the filter", ErrNoTarget)
+			}
+			element[clause.attribute] = clause.value
+		}
+		if path.subAttribute != "" {
+			element[path.subAttribute] = value
+		} else if values, ok := value.(map[string]interface{}); ok {
+			for subKey, subValue := range values {
+				element[subKey] = subValue
+			}
+		}
+		kept = append(kept, element)
+	}
+
+	resource[key] = kept
+	return nil
+}
+
+// elementMatches evaluates a value filter against an element of a multi-valued attribute
+func elementMatches(element map[string]interface{}, filter []filterClause) bool {
+	for _, clause := range filter {
+		value, ok := element[findKey(element, clause.attribute)]
+		var values []string
+		if ok && value != nil {
+			values = []string{fmt.Sprint(value)}
+		}
+		if !clause.matches(values, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendValues adds values to a multi-valued attribute, skipping elements
+// whose value is already present
+func appendValues(existing []interface{}, value interface{}) []interface{} {
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	for _, v := range values {
+		if id := elementValue(v); id == "" || indexOfValue(existing, id) < 0 {
+			existing = append(existing, v)
+		}
+	}
+	return existing
+}
+
+// removeValues removes the elements with the given values from a multi-valued attribute
+func removeValues(existing []interface{}, values []interface{}) []interface{} {
+	for _, v := range values {
+		if i := indexOfValue(existing, elementValue(v)); i >= 0 {
+			existing = append(existing[:i], existing[i+1:]...)
+		}
+	}
+	return existing
+}
+
+func indexOfValue(elements []interface{}, value string) int {
+	for i, element := range elements {
+		if value != "" && strings.EqualFold(elementValue(element), value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// elementValue returns the "value" sub-attribute of an element
+func elementValue(element interface{}) string {
+	object, ok := element.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	value, _ := object[findKey(object, "value")].(string)
+	return value
+}
+
+// findKey returns the key of a JSON object matching the attribute name
+// case-insensitively, or the name itself if there is none
+func findKey(object map[string]interface{}, name string) string {
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
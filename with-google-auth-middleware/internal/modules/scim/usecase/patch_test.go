@@ -0,0 +1,139 @@
+package usecase
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+)
+
+// patch applies a JSON PATCH request body to a JSON resource and returns the result
+func patch(t *testing.T, resource, operations string) (map[string]interface{}, error) {
+	t.Helper()
+	var object map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(resource), &object))
+	var request domain.PatchRequest
+	require.NoError(t, json.Unmarshal([]byte(operations), &request))
+	return object, applyPatch(object, request.Operations)
+}
+
+const patchUser = `{
+	"userName": "alice@example.com",
+	"active": true,
+	"name": {"givenName": "Alice", "familyName": "Example"},
+	"emails": [{"value": "alice@example.com", "type": "work", "primary": true}]
+}`
+
+func TestApplyPatchUser(t *testing.T) {
+	t.Run("replace with path", func(t *testing.T) {
+		object, err := patch(t, patchUser, `{"Operations": [{"op": "replace", "path": "active", "value": false}]}`)
+		require.NoError(t, err)
+		assert.Equal(t, false, object["active"])
+	})
+
+	t.Run("replace without path, Okta style", func(t *testing.T) {
+		object, err := patch(t, patchUser, `{"Operations": [{"op": "replace", "value": {"active": false, "name": {"givenName": "Alicia"}}}]}`)
+		require.NoError(t, err)
+		assert.Equal(t, false, object["active"])
+		assert.Equal(t, map[string]interface{}{"givenName": "Alicia", "familyName": "Example"}, object["name"])
+	})
+
+	t.Run("replace without path, Azure AD style", func(t *testing.T) {
+		object, err := patch(t, patchUser, `{"Operations": [{"op": "Replace", "value": {"name.givenName": "Alicia", "active": "False"}}]}`)
+		require.NoError(t, err)
+		assert.Equal(t, "False", object["active"])
+		assert.Equal(t, "Alicia", object["name"].(map[string]interface{})["givenName"])
+		assert.Equal(t, "Example", object["name"].(map[string]interface{})["familyName"])
+	})
+
+	t.Run("sub-attribute with schema URN", func(t *testing.T) {
+		object, err := patch(t, patchUser, `{"Operations": [{"op": "add", "path": "urn:ietf:params:scim:schemas:core:2.0:User:name.familyName", "value": "Smith"}]}`)
+		require.NoError(t, err)
+		assert.Equal(t, "Smith", object["name"].(map[string]interface{})["familyName"])
+	})
+
+	t.Run("value filter", func(t *testing.T) {
+		object, err := patch(t, patchUser, `{"Operations": [{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "alice@corp.example.com"}]}`)
+		require.NoError(t, err)
+		emails := object["emails"].([]interface{})
+		require.Len(t, emails, 1)
+		assert.Equal(t, "alice@corp.example.com", emails[0].(map[string]interface{})["value"])
+	})
+
+	t.Run("value filter creates missing value", func(t *testing.T) {
+		object, err := patch(t, patchUser, `{"Operations": [{"op": "add", "path": "photos[type eq \"photo\"].value", "value": "https://example.com/a.png"}]}`)
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{map[string]interface{}{"type": "photo", "value": "https://example.com/a.png"}}, object["photos"])
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		object, err := patch(t, patchUser, `{"Operations": [{"op": "remove", "path": "name.familyName"}, {"op": "remove", "path": "emails"}]}`)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"givenName": "Alice"}, object["name"])
+		assert.NotContains(t, object, "emails")
+	})
+
+	t.Run("remove without path", func(t *testing.T) {
+		_, err := patch(t, patchUser, `{"Operations": [{"op": "remove"}]}`)
+		assert.ErrorIs(t, err, ErrNoTarget)
+	})
+
+	t.Run("unknown operation", func(t *testing.T) {
+		_, err := patch(t, patchUser, `{"Operations": [{"op": "move", "path": "active"}]}`)
+		assert.ErrorIs(t, err, ErrInvalidSyntax)
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		_, err := patch(t, patchUser, `{"Operations": [{"op": "replace", "path": "emails[type eq \"work\"", "value": "x"}]}`)
+		assert.ErrorIs(t, err, ErrInvalidPath)
+	})
+}
+
+const patchGroup = `{
+	"displayName": "Engineering",
+	"members": [{"value": "u1"}, {"value": "u2"}]
+}`
+
+func memberValues(object map[string]interface{}) []string {
+	var values []string
+	members, _ := object["members"].([]interface{})
+	for _, member := range members {
+		values = append(values, elementValue(member))
+	}
+	return values
+}
+
+func TestApplyPatchGroupMembers(t *testing.T) {
+	t.Run("add skips existing members", func(t *testing.T) {
+		object, err := patch(t, patchGroup, `{"Operations": [{"op": "add", "path": "members", "value": [{"value": "u2"}, {"value": "u3"}]}]}`)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"u1", "u2", "u3"}, memberValues(object))
+	})
+
+	t.Run("remove with value filter", func(t *testing.T) {
+		object, err := patch(t, patchGroup, `{"Operations": [{"op": "remove", "path": "members[value eq \"u1\"]"}]}`)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"u2"}, memberValues(object))
+	})
+
+	t.Run("remove listed members, Azure AD style", func(t *testing.T) {
+		object, err := patch(t, patchGroup, `{"Operations": [{"op": "Remove", "path": "members", "value": [{"value": "u2"}]}]}`)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"u1"}, memberValues(object))
+	})
+
+	t.Run("replace members", func(t *testing.T) {
+		object, err := patch(t, patchGroup, `{"Operations": [{"op": "replace", "path": "members", "value": [{"value": "u9"}]}]}`)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"u9"}, memberValues(object))
+	})
+
+	t.Run("remove all members", func(t *testing.T) {
+		object, err := patch(t, patchGroup, `{"Operations": [{"op": "remove", "path": "members"}]}`)
+		require.NoError(t, err)
+		assert.Empty(t, memberValues(object))
+		assert.Equal(t, "Engineering", object["displayName"])
+	})
+}
@@ -0,0 +1,725 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/gorm"
+)
+
+// Custom errors, mapped to the SCIM error types of RFC 7644 section 3.12
+var (
+	ErrInvalidToken     = errors.New("invalid provisioning token")
+	ErrResourceNotFound = errors.New("resource not found")
+	ErrUniqueness       = errors.New("resource already exists")
+	ErrInvalidFilter    = errors.New("invalid filter")
+	ErrInvalidPath      = errors.New("invalid path")
+	ErrInvalidValue     = errors.New("invalid value")
+	ErrInvalidSyntax    = errors.New("invalid syntax")
+	ErrNoTarget         = errors.New("no target")
+)
+
+// Pagination defaults, also advertised in the ServiceProviderConfig
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 200
+)
+
+type scimUsecase struct {
+	clientRepo     domain.ClientRepository
+	groupRepo      domain.GroupRepository
+	userUsecase    userdomain.UserUsecase
+	sessionRevoker domain.SessionRevoker
+	baseURL        string
+}
+
+// NewSCIMUsecase creates the usecase behind the SCIM 2.0 provisioning API.
+// baseURL is the public URL of the SCIM routes, used for resource locations.
+func NewSCIMUsecase(
+	clientRepo domain.ClientRepository,
+	groupRepo domain.GroupRepository,
+	userUsecase userdomain.UserUsecase,
+	sessionRevoker domain.SessionRevoker,
+	baseURL string,
+) domain.SCIMUsecase {
+	return &scimUsecase{
+		clientRepo:     clientRepo,
+		groupRepo:      groupRepo,
+		userUsecase:    userUsecase,
+		sessionRevoker: sessionRevoker,
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// HashToken returns the hex encoded SHA-256 hash stored for a provisioning token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (u *scimUsecase) AuthenticateClient(ctx context.Context, token string) (*domain.Client, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	client, err := u.clientRepo.FindByTokenHash(HashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("failed to find client: %w", err)
+	}
+	return client, nil
+}
+
+func (u *scimUsecase) ListUsers(ctx context.Context, query domain.ListQuery) (*domain.ListResponse, error) {
+	filter, err := parseFilter(query.Filter)
+	if err != nil {
+		return nil, err
+	}
+	startIndex, count := pagination(query)
+
+	var users []*userdomain.User
+	var total int64
+	if len(filter) == 0 {
+		users, total, err = u.userUsecase.ListUsers(startIndex-1, count)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+	} else {
+		users, err = u.findUsers(filter)
+		if err != nil {
+			return nil, err
+		}
+		total = int64(len(users))
+		users = page(users, startIndex, count)
+	}
+
+	response := newListResponse(total, startIndex)
+	for _, user := range users {
+		response.Resources = append(response.Resources, u.userResource(user))
+	}
+	response.ItemsPerPage = len(response.Resources)
+	return response, nil
+}
+
+// findUsers resolves a filter. One of its clauses must look up users by a
+// unique attribute, the others are evaluated against the result.
+func (u *scimUsecase) findUsers(filter []filterClause) ([]*userdomain.User, error) {
+	lookup, ok := lookupClause(filter, "id", "username", "emails", "emails.value", "externalid")
+	if !ok {
+		return nil, fmt.Errorf("%w: filter must compare id, userName or externalId with eq", ErrInvalidFilter)
+	}
+	var user *userdomain.User
+	var err error
+	switch lookup.attribute {
+	case "id":
+		user, err = u.findUser(lookup.value)
+		if errors.Is(err, ErrResourceNotFound) {
+			user, err = nil, nil
+		}
+	case "externalid":
+		user, err = u.userUsecase.GetUserByExternalID(lookup.value)
+	default:
+		user, err = u.userUsecase.GetUserByEmail(lookup.value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if user == nil {
+		return nil, nil
+	}
+
+	resource := u.userResource(user)
+	for _, clause := range filter {
+		values, caseExact := userAttribute(resource, clause.attribute)
+		if !clause.matches(values, caseExact) {
+			return nil, nil
+		}
+	}
+	return []*userdomain.User{user}, nil
+}
+
+// userAttribute returns the values of a user attribute for filtering and
+// whether they are compared case-sensitively
+func userAttribute(resource *domain.UserResource, attribute string) ([]string, bool) {
+	switch attribute {
+	case "id":
+		return []string{resource.ID}, true
+	case "externalid":
+		return []string{resource.ExternalID}, true
+	case "username":
+		return []string{resource.UserName}, false
+	case "displayname":
+		return []string{resource.DisplayName}, false
+	case "name.givenname":
+		return []string{resource.Name.GivenName}, false
+	case "name.familyname":
+		return []string{resource.Name.FamilyName}, false
+	case "name.formatted":
+		return []string{resource.Name.Formatted}, false
+	case "locale":
+		return []string{resource.Locale}, false
+	case "active":
+		return []string{strconv.FormatBool(*resource.Active)}, false
+	case "emails", "emails.value":
+		var values []string
+		for _, email := range resource.Emails {
+			values = append(values, email.Value)
+		}
+		return values, false
+	}
+	return nil, false
+}
+
+func (u *scimUsecase) GetUser(ctx context.Context, id string) (*domain.UserResource, error) {
+	user, err := u.findUser(id)
+	if err != nil {
+		return nil, err
+	}
+	return u.userResource(user), nil
+}
+
+func (u *scimUsecase) CreateUser(ctx context.Context, resource *domain.UserResource) (*domain.UserResource, error) {
+	user := &userdomain.User{Active: true}
+	if err := applyUserResource(user, resource); err != nil {
+		return nil, err
+	}
+	if err := u.checkUserUniqueness(user); err != nil {
+		return nil, err
+	}
+
+	if err := u.userUsecase.ProvisionUser(user); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return u.userResource(user), nil
+}
+
+func (u *scimUsecase) ReplaceUser(ctx context.Context, id string, resource *domain.UserResource) (*domain.UserResource, error) {
+	user, err := u.findUser(id)
+	if err != nil {
+		return nil, err
+	}
+	return u.saveUser(user, resource)
+}
+
+func (u *scimUsecase) PatchUser(ctx context.Context, id string, patch *domain.PatchRequest) (*domain.UserResource, error) {
+	user, err := u.findUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := toObject(u.userResource(user))
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPatch(object, patch.Operations); err != nil {
+		return nil, err
+	}
+	// Azure AD sends booleans as strings, e.g. "False"
+	if key := findKey(object, "active"); object[key] != nil {
+		if value, ok := object[key].(string); ok {
+			active, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: active must be a boolean", ErrInvalidValue)
+			}
+			object[key] = active
+		}
+	}
+
+	var resource domain.UserResource
+	if err := fromObject(object, &resource); err != nil {
+		return nil, err
+	}
+	return u.saveUser(user, &resource)
+}
+
+// saveUser applies a resource to an existing user. Deactivating a user ends
+// all of its sessions.
+func (u *scimUsecase) saveUser(user *userdomain.User, resource *domain.UserResource) (*domain.UserResource, error) {
+	wasActive := user.Active
+	updated := *user
+	if err := applyUserResource(&updated, resource); err != nil {
+		return nil, err
+	}
+	if err := u.checkUserUniqueness(&updated); err != nil {
+		return nil, err
+	}
+
+	if err := u.userUsecase.SyncUser(&updated); err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	if wasActive && !updated.Active {
+		if err := u.sessionRevoker.DeleteUserSessions(updated.ID); err != nil {
+			return nil, fmt.Errorf("failed to delete user sessions: %w", err)
+		}
+	}
+	return u.userResource(&updated), nil
+}
+
+func (u *scimUsecase) DeleteUser(ctx context.Context, id string) error {
+	user, err := u.findUser(id)
+	if err != nil {
+		return err
+	}
+
+	if err := u.sessionRevoker.DeleteUserSessions(user.ID); err != nil {
+		return fmt.Errorf("failed to delete user sessions: %w", err)
+	}
+	if err := u.userUsecase.DeleteUser(user.ID); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
+
+func (u *scimUsecase) findUser(id string) (*userdomain.User, error) {
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrResourceNotFound
+	}
+	user, err := u.userUsecase.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrResourceNotFound
+	}
+	return user, nil
+}
+
+// checkUserUniqueness rejects a userName or externalId used by another user
+func (u *scimUsecase) checkUserUniqueness(user *userdomain.User) error {
+	existing, err := u.userUsecase.GetUserByEmail(user.Email)
+	if err != nil {
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	if existing != nil && existing.ID != user.ID {
+		return fmt.Errorf("%w: userName %q is taken", ErrUniqueness, user.Email)
+	}
+
+	if user.ExternalID == "" {
+		return nil
+	}
+	existing, err = u.userUsecase.GetUserByExternalID(user.ExternalID)
+	if err != nil {
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	if existing != nil && existing.ID != user.ID {
+		return fmt.Errorf("%w: externalId %q is taken", ErrUniqueness, user.ExternalID)
+	}
+	return nil
+}
+
+// userResource converts a user to its SCIM representation
+func (u *scimUsecase) userResource(user *userdomain.User) *domain.UserResource {
+	active := user.Active
+	resource := &domain.UserResource{
+		Schemas:    []string{domain.SchemaUser},
+		ID:         user.ID.String(),
+		ExternalID: user.ExternalID,
+		UserName:   user.Email,
+		Name: &domain.Name{
+			Formatted:  user.Name,
+			GivenName:  user.GivenName,
+			FamilyName: user.FamilyName,
+		},
+		DisplayName: user.Name,
+		Locale:      user.Locale,
+		Active:      &active,
+		Emails:      []domain.MultiValuedAttribute{{Value: user.Email, Type: "work", Primary: true}},
+		Meta:        u.meta(domain.ResourceTypeUser, "/Users/", user.ID, user.CreatedAt, user.UpdatedAt),
+	}
+	if user.AvatarURL != "" {
+		resource.Photos = []domain.MultiValuedAttribute{{Value: user.AvatarURL, Type: "photo", Primary: true}}
+	}
+	return resource
+}
+
+// applyUserResource copies the writable attributes of a SCIM user to a user.
+// Photos and locale are left alone when the client doesn't send them, since
+// they may come from the user's identity provider instead.
+func applyUserResource(user *userdomain.User, resource *domain.UserResource) error {
+	userName := strings.TrimSpace(resource.UserName)
+	if userName == "" {
+		return fmt.Errorf("%w: userName is required", ErrInvalidValue)
+	}
+
+	user.Email = userName
+	user.ExternalID = resource.ExternalID
+	if resource.Name != nil {
+		user.GivenName = resource.Name.GivenName
+		user.FamilyName = resource.Name.FamilyName
+		user.Name = resource.Name.Formatted
+	}
+	if resource.DisplayName != "" {
+		user.Name = resource.DisplayName
+	}
+	if user.Name == "" {
+		user.Name = strings.TrimSpace(user.GivenName + " " + user.FamilyName)
+	}
+	if resource.Locale != "" {
+		user.Locale = resource.Locale
+	}
+	if resource.Active != nil {
+		user.Active = *resource.Active
+	}
+	for _, photo := range resource.Photos {
+		if photo.Primary || user.AvatarURL == "" {
+			user.AvatarURL = photo.Value
+		}
+	}
+	return nil
+}
+
+func (u *scimUsecase) ListGroups(ctx context.Context, query domain.ListQuery) (*domain.ListResponse, error) {
+	filter, err := parseFilter(query.Filter)
+	if err != nil {
+		return nil, err
+	}
+	startIndex, count := pagination(query)
+
+	var groups []*domain.Group
+	var total int64
+	if len(filter) == 0 {
+		groups, total, err = u.groupRepo.List(startIndex-1, count)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list groups: %w", err)
+		}
+	} else {
+		groups, err = u.findGroups(filter)
+		if err != nil {
+			return nil, err
+		}
+		total = int64(len(groups))
+		groups = page(groups, startIndex, count)
+	}
+
+	response := newListResponse(total, startIndex)
+	for _, group := range groups {
+		resource, err := u.groupResource(group)
+		if err != nil {
+			return nil, err
+		}
+		response.Resources = append(response.Resources, resource)
+	}
+	response.ItemsPerPage = len(response.Resources)
+	return response, nil
+}
+
+// findGroups resolves a filter the same way as findUsers
+func (u *scimUsecase) findGroups(filter []filterClause) ([]*domain.Group, error) {
+	lookup, ok := lookupClause(filter, "id", "displayname", "externalid")
+	if !ok {
+		return nil, fmt.Errorf("%w: filter must compare id, displayName or externalId with eq", ErrInvalidFilter)
+	}
+	var group *domain.Group
+	var err error
+	switch lookup.attribute {
+	case "id":
+		group, err = u.findGroup(lookup.value)
+		if errors.Is(err, ErrResourceNotFound) {
+			group, err = nil, nil
+		}
+	case "displayname":
+		group, err = u.groupRepo.FindByDisplayName(lookup.value)
+	default:
+		group, err = u.groupRepo.FindByExternalID(lookup.value)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && group == nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find group: %w", err)
+	}
+
+	for _, clause := range filter {
+		var values []string
+		caseExact := false
+		switch clause.attribute {
+		case "id":
+			values, caseExact = []string{group.ID.String()}, true
+		case "externalid":
+			values, caseExact = []string{group.ExternalID}, true
+		case "displayname":
+			values = []string{group.DisplayName}
+		}
+		if !clause.matches(values, caseExact) {
+			return nil, nil
+		}
+	}
+	return []*domain.Group{group}, nil
+}
+
+func (u *scimUsecase) GetGroup(ctx context.Context, id string) (*domain.GroupResource, error) {
+	group, err := u.findGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	return u.groupResource(group)
+}
+
+func (u *scimUsecase) CreateGroup(ctx context.Context, resource *domain.GroupResource) (*domain.GroupResource, error) {
+	now := time.Now()
+	group := &domain.Group{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	memberIDs, err := u.applyGroupResource(group, resource)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.checkGroupUniqueness(group); err != nil {
+		return nil, err
+	}
+
+	if err := u.groupRepo.Create(group); err != nil {
+		return nil, fmt.Errorf("failed to create group: %w", err)
+	}
+	if err := u.groupRepo.ReplaceMembers(group.ID, memberIDs); err != nil {
+		return nil, fmt.Errorf("failed to set group members: %w", err)
+	}
+	return u.groupResource(group)
+}
+
+func (u *scimUsecase) ReplaceGroup(ctx context.Context, id string, resource *domain.GroupResource) (*domain.GroupResource, error) {
+	group, err := u.findGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	return u.saveGroup(group, resource)
+}
+
+func (u *scimUsecase) PatchGroup(ctx context.Context, id string, patch *domain.PatchRequest) (*domain.GroupResource, error) {
+	group, err := u.findGroup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := u.groupResource(group)
+	if err != nil {
+		return nil, err
+	}
+	object, err := toObject(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPatch(object, patch.Operations); err != nil {
+		return nil, err
+	}
+
+	var resource domain.GroupResource
+	if err := fromObject(object, &resource); err != nil {
+		return nil, err
+	}
+	return u.saveGroup(group, &resource)
+}
+
+func (u *scimUsecase) saveGroup(group *domain.Group, resource *domain.GroupResource) (*domain.GroupResource, error) {
+	memberIDs, err := u.applyGroupResource(group, resource)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.checkGroupUniqueness(group); err != nil {
+		return nil, err
+	}
+
+	if err := u.groupRepo.Update(group); err != nil {
+		return nil, fmt.Errorf("failed to update group: %w", err)
+	}
+	if err := u.groupRepo.ReplaceMembers(group.ID, memberIDs); err != nil {
+		return nil, fmt.Errorf("failed to set group members: %w", err)
+	}
+	return u.groupResource(group)
+}
+
+func (u *scimUsecase) DeleteGroup(ctx context.Context, id string) error {
+	group, err := u.findGroup(id)
+	if err != nil {
+		return err
+	}
+	if err := u.groupRepo.Delete(group.ID); err != nil {
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+	return nil
+}
+
+func (u *scimUsecase) findGroup(id string) (*domain.Group, error) {
+	groupID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrResourceNotFound
+	}
+	group, err := u.groupRepo.FindByID(groupID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrResourceNotFound
+		}
+		return nil, fmt.Errorf("failed to find group: %w", err)
+	}
+	return group, nil
+}
+
+// checkGroupUniqueness rejects a displayName or externalId used by another group
+func (u *scimUsecase) checkGroupUniqueness(group *domain.Group) error {
+	existing, err := u.groupRepo.FindByDisplayName(group.DisplayName)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to find group: %w", err)
+	}
+	if existing != nil && existing.ID != group.ID {
+		return fmt.Errorf("%w: displayName %q is taken", ErrUniqueness, group.DisplayName)
+	}
+
+	if group.ExternalID == "" {
+		return nil
+	}
+	existing, err = u.groupRepo.FindByExternalID(group.ExternalID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to find group: %w", err)
+	}
+	if existing != nil && existing.ID != group.ID {
+		return fmt.Errorf("%w: externalId %q is taken", ErrUniqueness, group.ExternalID)
+	}
+	return nil
+}
+
+// groupResource converts a group and its members to the SCIM representation
+func (u *scimUsecase) groupResource(group *domain.Group) (*domain.GroupResource, error) {
+	members, err := u.groupRepo.Members(group.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list group members: %w", err)
+	}
+
+	resource := &domain.GroupResource{
+		Schemas:     []string{domain.SchemaGroup},
+		ID:          group.ID.String(),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     []domain.MultiValuedAttribute{},
+		Meta:        u.meta(domain.ResourceTypeGroup, "/Groups/", group.ID, group.CreatedAt, group.UpdatedAt),
+	}
+	for _, member := range members {
+		attribute := domain.MultiValuedAttribute{Value: member.UserID.String(), Type: domain.ResourceTypeUser}
+		if u.baseURL != "" {
+			attribute.Ref = u.baseURL + "/Users/" + member.UserID.String()
+		}
+		resource.Members = append(resource.Members, attribute)
+	}
+	return resource, nil
+}
+
+// applyGroupResource copies the attributes of a SCIM group to a group and
+// returns its members, which must be existing users
+func (u *scimUsecase) applyGroupResource(group *domain.Group, resource *domain.GroupResource) ([]uuid.UUID, error) {
+	displayName := strings.TrimSpace(resource.DisplayName)
+	if displayName == "" {
+		return nil, fmt.Errorf("%w: displayName is required", ErrInvalidValue)
+	}
+	group.DisplayName = displayName
+	group.ExternalID = resource.ExternalID
+
+	memberIDs := make([]uuid.UUID, 0, len(resource.Members))
+	seen := make(map[uuid.UUID]bool)
+	for _, member := range resource.Members {
+		userID, err := uuid.Parse(member.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: member %q is not a user", ErrInvalidValue, member.Value)
+		}
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+
+		user, err := u.userUsecase.GetUserByID(userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find user: %w", err)
+		}
+		if user == nil {
+			return nil, fmt.Errorf("%w: member %q is not a user", ErrInvalidValue, member.Value)
+		}
+		memberIDs = append(memberIDs, userID)
+	}
+	return memberIDs, nil
+}
+
+func (u *scimUsecase) meta(resourceType, path string, id uuid.UUID, created, lastModified time.Time) *domain.Meta {
+	meta := &domain.Meta{
+		ResourceType: resourceType,
+		Created:      &created,
+		LastModified: &lastModified,
+	}
+	if u.baseURL != "" {
+		meta.Location = u.baseURL + path + id.String()
+	}
+	return meta
+}
+
+// pagination returns the 1-based start index and page size of a list request
+func pagination(query domain.ListQuery) (int, int) {
+	startIndex, count := query.StartIndex, query.Count
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > MaxPageSize {
+		count = MaxPageSize
+	}
+	return startIndex, count
+}
+
+// page returns the slice of a filtered result for the requested page
+func page[T any](items []T, startIndex, count int) []T {
+	if startIndex > len(items) {
+		return nil
+	}
+	items = items[startIndex-1:]
+	if count < len(items) {
+		items = items[:count]
+	}
+	return items
+}
+
+func newListResponse(total int64, startIndex int) *domain.ListResponse {
+	return &domain.ListResponse{
+		Schemas:      []string{domain.SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		Resources:    []interface{}{},
+	}
+}
+
+// toObject converts a resource to a generic JSON object for patching
+func toObject(resource interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode resource: %w", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("failed to decode resource: %w", err)
+	}
+	return object, nil
+}
+
+// fromObject converts a patched JSON object back to a resource
+func fromObject(object map[string]interface{}, resource interface{}) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	if err := json.Unmarshal(data, resource); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	return nil
+}
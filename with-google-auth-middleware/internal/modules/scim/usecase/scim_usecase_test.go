@@ -0,0 +1,358 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/scim/domain"
+	userdomain "github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/gorm"
+)
+
+// fakeUserUsecase keeps users in memory
+type fakeUserUsecase struct {
+	userdomain.UserUsecase
+	users []*userdomain.User
+}
+
+func (f *fakeUserUsecase) find(match func(*userdomain.User) bool) (*userdomain.User, error) {
+	for _, user := range f.users {
+		if match(user) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUserUsecase) GetUserByID(id uuid.UUID) (*userdomain.User, error) {
+	return f.find(func(u *userdomain.User) bool { return u.ID == id })
+}
+
+func (f *fakeUserUsecase) GetUserByEmail(email string) (*userdomain.User, error) {
+	return f.find(func(u *userdomain.User) bool { return u.Email == email })
+}
+
+func (f *fakeUserUsecase) GetUserByExternalID(externalID string) (*userdomain.User, error) {
+	return f.find(func(u *userdomain.User) bool { return u.ExternalID == externalID })
+}
+
+func (f *fakeUserUsecase) ListUsers(offset, limit int) ([]*userdomain.User, int64, error) {
+	return page(f.users, offset+1, limit), int64(len(f.users)), nil
+}
+
+func (f *fakeUserUsecase) ProvisionUser(user *userdomain.User) error {
+	user.ID = uuid.New()
+	user.Role = userdomain.RoleUser
+	copied := *user
+	f.users = append(f.users, &copied)
+	return nil
+}
+
+func (f *fakeUserUsecase) SyncUser(user *userdomain.User) error {
+	for i, existing := range f.users {
+		if existing.ID == user.ID {
+			copied := *user
+			f.users[i] = &copied
+		}
+	}
+	return nil
+}
+
+func (f *fakeUserUsecase) DeleteUser(id uuid.UUID) error {
+	for i, user := range f.users {
+		if user.ID == id {
+			f.users = append(f.users[:i], f.users[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// fakeGroupRepository keeps groups and memberships in memory
+type fakeGroupRepository struct {
+	groups  map[uuid.UUID]*domain.Group
+	members map[uuid.UUID][]uuid.UUID
+}
+
+func newFakeGroupRepository() *fakeGroupRepository {
+	return &fakeGroupRepository{groups: map[uuid.UUID]*domain.Group{}, members: map[uuid.UUID][]uuid.UUID{}}
+}
+
+func (f *fakeGroupRepository) Create(group *domain.Group) error {
+	copied := *group
+	f.groups[group.ID] = &copied
+	return nil
+}
+
+func (f *fakeGroupRepository) FindByID(id uuid.UUID) (*domain.Group, error) {
+	if group, ok := f.groups[id]; ok {
+		copied := *group
+		return &copied, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (f *fakeGroupRepository) FindByDisplayName(displayName string) (*domain.Group, error) {
+	for _, group := range f.groups {
+		if strings.EqualFold(group.DisplayName, displayName) {
+			return f.FindByID(group.ID)
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (f *fakeGroupRepository) FindByExternalID(externalID string) (*domain.Group, error) {
+	for _, group := range f.groups {
+		if group.ExternalID == externalID {
+			return f.FindByID(group.ID)
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (f *fakeGroupRepository) Update(group *domain.Group) error {
+	return f.Create(group)
+}
+
+func (f *fakeGroupRepository) Delete(id uuid.UUID) error {
+	delete(f.groups, id)
+	delete(f.members, id)
+	return nil
+}
+
+func (f *fakeGroupRepository) List(offset, limit int) ([]*domain.Group, int64, error) {
+	var groups []*domain.Group
+	for _, group := range f.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].DisplayName < groups[j].DisplayName })
+	return page(groups, offset+1, limit), int64(len(groups)), nil
+}
+
+func (f *fakeGroupRepository) Members(groupID uuid.UUID) ([]domain.GroupMember, error) {
+	var members []domain.GroupMember
+	for _, userID := range f.members[groupID] {
+		members = append(members, domain.GroupMember{GroupID: groupID, UserID: userID})
+	}
+	return members, nil
+}
+
+func (f *fakeGroupRepository) ReplaceMembers(groupID uuid.UUID, userIDs []uuid.UUID) error {
+	f.members[groupID] = userIDs
+	return nil
+}
+
+// fakeSessionRevoker records whose sessions were deleted
+type fakeSessionRevoker struct {
+	revoked []uuid.UUID
+}
+
+func (f *fakeSessionRevoker) DeleteUserSessions(userID uuid.UUID) error {
+	f.revoked = append(f.revoked, userID)
+	return nil
+}
+
+type fakeClientRepository struct {
+	client *domain.Client
+}
+
+func (f *fakeClientRepository) FindByTokenHash(tokenHash string) (*domain.Client, error) {
+	if f.client != nil && f.client.TokenHash == tokenHash {
+		return f.client, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+type scimFixture struct {
+	usecase  domain.SCIMUsecase
+	users    *fakeUserUsecase
+	sessions *fakeSessionRevoker
+}
+
+func newSCIMFixture() *scimFixture {
+	users := &fakeUserUsecase{}
+	sessions := &fakeSessionRevoker{}
+	clients := &fakeClientRepository{client: &domain.Client{ID: uuid.New(), Name: "okta", TokenHash: HashToken("okta-token")}}
+	return &scimFixture{
+		usecase:  NewSCIMUsecase(clients, newFakeGroupRepository(), users, sessions, "https://api.example.com/scim/v2/"),
+		users:    users,
+		sessions: sessions,
+	}
+}
+
+func (f *scimFixture) createUser(t *testing.T, userName, externalID string) *domain.UserResource {
+	t.Helper()
+	user, err := f.usecase.CreateUser(context.Background(), &domain.UserResource{
+		UserName:   userName,
+		ExternalID: externalID,
+		Name:       &domain.Name{GivenName: "Alice", FamilyName: "Example"},
+	})
+	require.NoError(t, err)
+	return user
+}
+
+func patchRequest(t *testing.T, body string) *domain.PatchRequest {
+	t.Helper()
+	var request domain.PatchRequest
+	require.NoError(t, json.Unmarshal([]byte(body), &request))
+	return &request
+}
+
+func TestSCIMAuthenticateClient(t *testing.T) {
+	f := newSCIMFixture()
+
+	client, err := f.usecase.AuthenticateClient(context.Background(), "okta-token")
+	require.NoError(t, err)
+	assert.Equal(t, "okta", client.Name)
+
+	_, err = f.usecase.AuthenticateClient(context.Background(), "other-token")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = f.usecase.AuthenticateClient(context.Background(), "")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestSCIMUsers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("create", func(t *testing.T) {
+		f := newSCIMFixture()
+		user := f.createUser(t, "alice@example.com", "00u1")
+
+		assert.Equal(t, "alice@example.com", user.UserName)
+		assert.Equal(t, "Alice Example", user.DisplayName)
+		assert.True(t, *user.Active)
+		assert.Equal(t, "https://api.example.com/scim/v2/Users/"+user.ID, user.Meta.Location)
+		assert.Equal(t, userdomain.RoleUser, f.users.users[0].Role)
+
+		_, err := f.usecase.CreateUser(ctx, &domain.UserResource{UserName: "alice@example.com"})
+		assert.ErrorIs(t, err, ErrUniqueness)
+		_, err = f.usecase.CreateUser(ctx, &domain.UserResource{UserName: "bob@example.com", ExternalID: "00u1"})
+		assert.ErrorIs(t, err, ErrUniqueness)
+		_, err = f.usecase.CreateUser(ctx, &domain.UserResource{})
+		assert.ErrorIs(t, err, ErrInvalidValue)
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		f := newSCIMFixture()
+		alice := f.createUser(t, "alice@example.com", "00u1")
+		f.createUser(t, "bob@example.com", "00u2")
+
+		list, err := f.usecase.ListUsers(ctx, domain.ListQuery{Filter: `userName eq "alice@example.com"`, StartIndex: 1, Count: 10})
+		require.NoError(t, err)
+		require.EqualValues(t, 1, list.TotalResults)
+		assert.Equal(t, alice.ID, list.Resources[0].(*domain.UserResource).ID)
+
+		list, err = f.usecase.ListUsers(ctx, domain.ListQuery{Filter: `externalId eq "00u1" and active eq false`, StartIndex: 1, Count: 10})
+		require.NoError(t, err)
+		assert.EqualValues(t, 0, list.TotalResults)
+		assert.Empty(t, list.Resources)
+
+		_, err = f.usecase.ListUsers(ctx, domain.ListQuery{Filter: `name.givenName eq "Alice"`, StartIndex: 1, Count: 10})
+		assert.ErrorIs(t, err, ErrInvalidFilter)
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		f := newSCIMFixture()
+		for _, name := range []string{"a", "b", "c"} {
+			f.createUser(t, name+"@example.com", "")
+		}
+
+		list, err := f.usecase.ListUsers(ctx, domain.ListQuery{StartIndex: 2, Count: 1})
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, list.TotalResults)
+		assert.Equal(t, 2, list.StartIndex)
+		assert.Equal(t, 1, list.ItemsPerPage)
+		assert.Equal(t, "b@example.com", list.Resources[0].(*domain.UserResource).UserName)
+	})
+
+	t.Run("deactivate ends sessions", func(t *testing.T) {
+		f := newSCIMFixture()
+		user := f.createUser(t, "alice@example.com", "")
+
+		patched, err := f.usecase.PatchUser(ctx, user.ID, patchRequest(t, `{"Operations": [{"op": "Replace", "path": "active", "value": "False"}]}`))
+		require.NoError(t, err)
+		assert.False(t, *patched.Active)
+		assert.False(t, f.users.users[0].Active)
+		assert.Equal(t, []uuid.UUID{f.users.users[0].ID}, f.sessions.revoked)
+
+		// Reactivating doesn't touch sessions
+		_, err = f.usecase.PatchUser(ctx, user.ID, patchRequest(t, `{"Operations": [{"op": "replace", "value": {"active": true}}]}`))
+		require.NoError(t, err)
+		assert.True(t, f.users.users[0].Active)
+		assert.Len(t, f.sessions.revoked, 1)
+	})
+
+	t.Run("patch name", func(t *testing.T) {
+		f := newSCIMFixture()
+		user := f.createUser(t, "alice@example.com", "")
+
+		patched, err := f.usecase.PatchUser(ctx, user.ID, patchRequest(t, `{"Operations": [{"op": "replace", "path": "name.familyName", "value": "Smith"}, {"op": "replace", "path": "displayName", "value": "Alice Smith"}]}`))
+		require.NoError(t, err)
+		assert.Equal(t, "Smith", patched.Name.FamilyName)
+		assert.Equal(t, "Alice Smith", f.users.users[0].Name)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		f := newSCIMFixture()
+		user := f.createUser(t, "alice@example.com", "")
+
+		require.NoError(t, f.usecase.DeleteUser(ctx, user.ID))
+		assert.Len(t, f.sessions.revoked, 1)
+		_, err := f.usecase.GetUser(ctx, user.ID)
+		assert.ErrorIs(t, err, ErrResourceNotFound)
+		_, err = f.usecase.GetUser(ctx, "not-a-uuid")
+		assert.ErrorIs(t, err, ErrResourceNotFound)
+	})
+}
+
+func TestSCIMGroups(t *testing.T) {
+	ctx := context.Background()
+	f := newSCIMFixture()
+	alice := f.createUser(t, "alice@example.com", "")
+	bob := f.createUser(t, "bob@example.com", "")
+
+	group, err := f.usecase.CreateGroup(ctx, &domain.GroupResource{
+		DisplayName: "Engineering",
+		Members:     []domain.MultiValuedAttribute{{Value: alice.ID}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, group.Members[0].Value)
+	assert.Equal(t, "https://api.example.com/scim/v2/Users/"+alice.ID, group.Members[0].Ref)
+
+	_, err = f.usecase.CreateGroup(ctx, &domain.GroupResource{DisplayName: "engineering"})
+	assert.ErrorIs(t, err, ErrUniqueness)
+
+	group, err = f.usecase.PatchGroup(ctx, group.ID, patchRequest(t, `{"Operations": [
+		{"op": "add", "path": "members", "value": [{"value": "`+bob.ID+`"}]},
+		{"op": "remove", "path": "members[value eq \"`+alice.ID+`\"]"}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, group.Members, 1)
+	assert.Equal(t, bob.ID, group.Members[0].Value)
+
+	_, err = f.usecase.PatchGroup(ctx, group.ID, patchRequest(t, `{"Operations": [{"op": "add", "path": "members", "value": [{"value": "`+uuid.NewString()+`"}]}]}`))
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	list, err := f.usecase.ListGroups(ctx, domain.ListQuery{Filter: `displayName eq "ENGINEERING"`, StartIndex: 1, Count: 10})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, list.TotalResults)
+
+	require.NoError(t, f.usecase.DeleteGroup(ctx, group.ID))
+	_, err = f.usecase.GetGroup(ctx, group.ID)
+	assert.ErrorIs(t, err, ErrResourceNotFound)
+}
+
+func TestPage(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	assert.Equal(t, []int{2, 3}, page(items, 2, 2))
+	assert.Equal(t, []int{5}, page(items, 5, 10))
+	assert.Empty(t, page(items, 6, 10))
+	assert.Empty(t, page(items, 1, 0))
+}
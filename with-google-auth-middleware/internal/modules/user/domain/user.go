@@ -1,19 +1,187 @@
 package domain
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Account statuses. Unlike Active, which provisioning clients manage, the
+// status is set by admins moderating the account.
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended" // Can't sign in until SuspendedUntil
+	StatusBanned    = "banned"    // Can't sign in until an admin reactivates the account
+	StatusPending   = "pending"   // Awaiting an admin's approval before the first sign-in
+)
+
+// Statuses lists the account statuses
+var Statuses = []string{StatusActive, StatusSuspended, StatusBanned, StatusPending}
+
+// Scopes required by the user routes
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+// Scopes lists the scopes of the user module with the description shown on the consent page
+var Scopes = map[string]string{
+	ScopeUsersRead:  "View user profiles",
+	ScopeUsersWrite: "Create, update and delete users",
+}
+
+// Profile fields that can be synced from an identity provider
+const (
+	ProfileFieldName       = "name"
+	ProfileFieldGivenName  = "given_name"
+	ProfileFieldFamilyName = "family_name"
+	ProfileFieldAvatarURL  = "avatar_url"
+	ProfileFieldLocale     = "locale"
+)
+
+// ProfileFields lists every field that can be synced from an identity provider
+var ProfileFields = []string{
+	ProfileFieldName,
+	ProfileFieldGivenName,
+	ProfileFieldFamilyName,
+	ProfileFieldAvatarURL,
+	ProfileFieldLocale,
+}
+
 // User represents a user in the system
 // This is the core domain entity that contains user information
 type User struct {
-	ID        uuid.UUID `json:"id"`         // Unique identifier for the user
-	Email     string    `json:"email"`      // User's email address (used for login)
-	Name      string    `json:"name"`       // User's full name
-	CreatedAt time.Time `json:"created_at"` // Timestamp when the user was created
-	UpdatedAt time.Time `json:"updated_at"` // Timestamp when the user was last updated
+	ID              uuid.UUID  `json:"id"`                          // Unique identifier for the user
+	Email           string     `json:"email"`                       // User's email address (used for login)
+	Name            string     `json:"name"`                        // User's full name
+	GivenName       string     `json:"given_name"`                  // User's first name
+	FamilyName      string     `json:"family_name"`                 // User's last name
+	AvatarURL       string     `json:"avatar_url"`                  // URL of the user's profile picture
+	Locale          string     `json:"locale"`                      // User's preferred locale, e.g. "en" or "id-ID"
+	Role            string     `json:"role"`                        // User's role, RoleUser or RoleAdmin
+	Active          bool       `json:"active"`                      // Inactive users can't sign in, set by provisioning clients
+	ExternalID      string     `json:"external_id,omitempty"`       // ID of the user in the provisioning client's directory
+	Status          string     `json:"status"`                      // Account status set by admins, one of Statuses
+	StatusReason    string     `json:"status_reason,omitempty"`     // Why an admin changed the status, shown to other admins
+	SuspendedUntil  *time.Time `json:"suspended_until,omitempty"`   // When a suspension ends without an admin lifting it
+	StatusChangedBy *uuid.UUID `json:"status_changed_by,omitempty"` // Admin who last changed the status
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"` // Timestamp of the last status change
+	Guest           bool       `json:"guest"`                       // Anonymous user of a device that hasn't signed in yet
+	GuestExpiresAt  *time.Time `json:"guest_expires_at,omitempty"`  // When a guest that wasn't upgraded is deleted
+	MergedInto      *uuid.UUID `json:"merged_into,omitempty"`       // User this duplicate was merged into, set on tombstones
+	MergedAt        *time.Time `json:"merged_at,omitempty"`         // Timestamp of the merge
+	EditedFields    string     `json:"-"`                           // Comma separated profile fields the user changed themselves
+	LastLoginAt     *time.Time `json:"last_login_at,omitempty"`     // Timestamp of the user's last login
+	CreatedAt       time.Time  `json:"created_at"`                  // Timestamp when the user was created
+	UpdatedAt       time.Time  `json:"updated_at"`                  // Timestamp when the user was last updated
+}
+
+// EffectiveStatus returns the account status at the given time. A suspension
+// that has run out counts as active.
+func (u *User) EffectiveStatus(now time.Time) string {
+	if u.Status == "" {
+		return StatusActive
+	}
+	if u.Status == StatusSuspended && u.SuspendedUntil != nil && !now.Before(*u.SuspendedUntil) {
+		return StatusActive
+	}
+	return u.Status
+}
+
+// GuestExpired reports whether the user is a guest past its expiry
+func (u *User) GuestExpired(now time.Time) bool {
+	return u.Guest && u.GuestExpiresAt != nil && !now.Before(*u.GuestExpiresAt)
+}
+
+// CanSignIn reports whether the user may sign in and use the API: neither
+// deactivated by a provisioning client nor suspended, banned or pending
+func (u *User) CanSignIn() bool {
+	return u.Active && u.EffectiveStatus(time.Now()) == StatusActive
+}
+
+// Profile returns the value of a profile field
+func (u *User) Profile(field string) string {
+	switch field {
+	case ProfileFieldName:
+		return u.Name
+	case ProfileFieldGivenName:
+		return u.GivenName
+	case ProfileFieldFamilyName:
+		return u.FamilyName
+	case ProfileFieldAvatarURL:
+		return u.AvatarURL
+	case ProfileFieldLocale:
+		return u.Locale
+	}
+	return ""
+}
+
+// SetProfile sets the value of a profile field
+func (u *User) SetProfile(field, value string) {
+	switch field {
+	case ProfileFieldName:
+		u.Name = value
+	case ProfileFieldGivenName:
+		u.GivenName = value
+	case ProfileFieldFamilyName:
+		u.FamilyName = value
+	case ProfileFieldAvatarURL:
+		u.AvatarURL = value
+	case ProfileFieldLocale:
+		u.Locale = value
+	}
+}
+
+// IsEdited reports whether the user changed the profile field themselves
+func (u *User) IsEdited(field string) bool {
+	for _, edited := range strings.Split(u.EditedFields, ",") {
+		if edited == field {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkEdited records that the user changed the profile field themselves
+func (u *User) MarkEdited(field string) {
+	if u.IsEdited(field) {
+		return
+	}
+	if u.EditedFields == "" {
+		u.EditedFields = field
+		return
+	}
+	u.EditedFields += "," + field
+}
+
+// StatusChange is an admin's change of a user's account status
+type StatusChange struct {
+	Status string
+	Reason string
+	// SuspendedUntil is required for StatusSuspended and ignored otherwise
+	SuspendedUntil *time.Time
+	// ChangedBy is the admin making the change
+	ChangedBy uuid.UUID
+}
+
+// UserMerge records the merge of a duplicate user into another user. The
+// source's rows move to the target and the source is kept as a tombstone.
+type UserMerge struct {
+	ID          uuid.UUID        `json:"id"`
+	SourceID    uuid.UUID        `json:"source_id"`
+	TargetID    uuid.UUID        `json:"target_id"`
+	SourceEmail string           `json:"source_email"`                 // Email of the source, cleared on the tombstone
+	MergedBy    *uuid.UUID       `json:"merged_by,omitempty"`          // Admin who merged, nil for guests merged at sign-in
+	Moved       map[string]int64 `json:"moved" gorm:"serializer:json"` // Rows moved per merge hook
+	DryRun      bool             `json:"dry_run" gorm:"-"`             // Counts the rows without moving them or recording the merge
+	CreatedAt   time.Time        `json:"created_at"`
 }
 
 // UserRepository defines the interface for user data access
@@ -21,9 +189,17 @@ type UserRepository interface {
 	Create(user *User) error
 	FindByID(id uuid.UUID) (*User, error)
 	FindByEmail(email string) (*User, error)
+	FindByExternalID(externalID string) (*User, error)
 	Update(user *User) error
 	Delete(id uuid.UUID) error
 	GetAll(page, limit int) ([]*User, error)
+	List(offset, limit int) ([]*User, int64, error)
+	// Merge moves the rows modules registered merge hooks for from the source
+	// user to the target user, tombstones the source and records the merge, in
+	// one transaction. It fills in the rows moved per hook, a dry run rolls back.
+	Merge(merge *UserMerge) error
+	// DeleteExpiredGuests deletes guests that weren't upgraded before their expiry
+	DeleteExpiredGuests(now time.Time) error
 }
 
 // UserUsecase defines the interface for user business logic
@@ -31,7 +207,23 @@ type UserUsecase interface {
 	CreateUser(user *User) error
 	GetUserByID(id uuid.UUID) (*User, error)
 	GetUserByEmail(email string) (*User, error)
-	UpdateUser(user *User) error
+	GetUserByExternalID(externalID string) (*User, error)
+	// UpdateUser replaces the user. Profile fields not in sent, the JSON keys
+	// of the request, keep their stored value.
+	UpdateUser(user *User, sent []string) error
 	DeleteUser(id uuid.UUID) error
 	GetAllUsers(page, limit int) ([]*User, error)
-} 
\ No newline at end of file
+	// ListUsers returns a page of users, oldest first, and the total number of users
+	ListUsers(offset, limit int) ([]*User, int64, error)
+	// ProvisionUser creates a user managed by a provisioning client, keeping
+	// its Active and ExternalID fields
+	ProvisionUser(user *User) error
+	// SyncUser updates a user from a provisioning client. Unlike UpdateUser it
+	// may change Active and ExternalID and doesn't mark profile fields as edited.
+	SyncUser(user *User) error
+	// ChangeStatus sets the account status of a user, admins can't change their own
+	ChangeStatus(id uuid.UUID, change StatusChange) (*User, error)
+	// MergeUsers merges the duplicate source user into the target user. A dry
+	// run reports what would move without changing anything.
+	MergeUsers(sourceID, targetID, mergedBy uuid.UUID, dryRun bool) (*UserMerge, error)
+}
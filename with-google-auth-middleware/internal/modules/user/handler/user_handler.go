@@ -1,12 +1,17 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
 	"github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"github.com/tyobaskara/jeki-backend/internal/modules/user/usecase"
 )
 
 type UserHandler struct {
@@ -20,19 +25,113 @@ func NewUserHandler(userUsecase domain.UserUsecase) *UserHandler {
 	}
 }
 
-// RegisterRoutes registers the user routes
-func (h *UserHandler) RegisterRoutes(router *gin.RouterGroup) {
+// RegisterRoutes registers the user routes. requireScope returns the middleware
+// that rejects access tokens without the given scopes, stepUp demands a recent
+// sign-in before a user is deleted.
+func (h *UserHandler) RegisterRoutes(router *gin.RouterGroup, requireScope func(scopes ...string) gin.HandlerFunc, stepUp gin.HandlerFunc) {
 	group := router.Group("/users")
+	read := requireScope(domain.ScopeUsersRead)
+	write := requireScope(domain.ScopeUsersWrite)
 
 	{
-		group.POST("", h.CreateUser)
-		group.GET("", h.GetAllUsers)
-		group.GET("/:id", h.GetUserByID)
-		group.PUT("/:id", h.UpdateUser)
-		group.DELETE("/:id", h.DeleteUser)
+		group.POST("", write, h.CreateUser)
+		group.GET("", read, h.GetAllUsers)
+		group.GET("/:id", read, h.GetUserByID)
+		group.PUT("/:id", write, h.UpdateUser)
+		group.DELETE("/:id", write, stepUp, h.DeleteUser)
 	}
 }
 
+// RegisterAdminRoutes registers the account moderation routes. The router
+// group must be restricted to admins. stepUp demands a recent sign-in before
+// an account's status is changed or accounts are merged.
+func (h *UserHandler) RegisterAdminRoutes(router *gin.RouterGroup, stepUp gin.HandlerFunc) {
+	router.PUT("/users/:id/status", stepUp, h.ChangeStatus)
+	router.POST("/users/:id/merge", stepUp, h.MergeUser)
+}
+
+// ChangeStatusRequest represents the body of an account status change
+type ChangeStatusRequest struct {
+	Status         string     `json:"status" binding:"required"`
+	Reason         string     `json:"reason"`
+	SuspendedUntil *time.Time `json:"suspended_until"`
+}
+
+// ChangeStatus handles an admin suspending, banning, approving or reactivating an account
+func (h *UserHandler) ChangeStatus(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req ChangeStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actorID, _ := c.Get("user_id")
+	user, err := h.userUsecase.ChangeStatus(id, domain.StatusChange{
+		Status:         req.Status,
+		Reason:         req.Reason,
+		SuspendedUntil: req.SuspendedUntil,
+		ChangedBy:      actorID.(uuid.UUID),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case errors.Is(err, usecase.ErrInvalidStatus), errors.Is(err, usecase.ErrInvalidSuspension), errors.Is(err, usecase.ErrCannotChangeOwnStatus):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// MergeUserRequest represents the body of an account merge
+type MergeUserRequest struct {
+	TargetID uuid.UUID `json:"target_id" binding:"required"`
+	DryRun   bool      `json:"dry_run"`
+}
+
+// MergeUser handles an admin merging a duplicate account into another user
+func (h *UserHandler) MergeUser(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req MergeUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actorID, _ := c.Get("user_id")
+	merge, err := h.userUsecase.MergeUsers(id, req.TargetID, actorID.(uuid.UUID), req.DryRun)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case errors.Is(err, usecase.ErrCannotMergeSameUser), errors.Is(err, usecase.ErrCannotMergeOwnAccount):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, usecase.ErrUserMerged):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, merge)
+}
+
 // CreateUser handles user creation
 func (h *UserHandler) CreateUser(c *gin.Context) {
 	var user domain.User
@@ -80,13 +179,23 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 	}
 
 	var user domain.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	if err := c.ShouldBindBodyWith(&user, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Profile fields left out of the body are kept, not cleared
+	var body map[string]json.RawMessage
+	if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sent := make([]string, 0, len(body))
+	for key := range body {
+		sent = append(sent, key)
+	}
 
 	user.ID = id
-	if err := h.userUsecase.UpdateUser(&user); err != nil {
+	if err := h.userUsecase.UpdateUser(&user, sent); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -0,0 +1,9 @@
+ALTER TABLE users DROP COLUMN IF EXISTS last_login_at;
+ALTER TABLE users DROP COLUMN IF EXISTS edited_fields;
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS family_name;
+ALTER TABLE users DROP COLUMN IF EXISTS given_name;
+
+ALTER TABLE users ALTER COLUMN avatar_url DROP NOT NULL;
+ALTER TABLE users ALTER COLUMN avatar_url DROP DEFAULT;
+ALTER TABLE users RENAME COLUMN avatar_url TO picture_url;
\ No newline at end of file
@@ -0,0 +1,10 @@
+ALTER TABLE users RENAME COLUMN picture_url TO avatar_url;
+UPDATE users SET avatar_url = '' WHERE avatar_url IS NULL;
+ALTER TABLE users ALTER COLUMN avatar_url SET DEFAULT '';
+ALTER TABLE users ALTER COLUMN avatar_url SET NOT NULL;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS given_name VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS family_name VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(35) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS edited_fields TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,8 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_suspended_until_check;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_status_check;
+
+ALTER TABLE users DROP COLUMN IF EXISTS status_changed_at;
+ALTER TABLE users DROP COLUMN IF EXISTS status_changed_by;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_until;
+ALTER TABLE users DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS status;
\ No newline at end of file
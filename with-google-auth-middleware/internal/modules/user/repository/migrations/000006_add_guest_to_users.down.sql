@@ -0,0 +1,8 @@
+DELETE FROM users WHERE guest;
+
+DROP INDEX IF EXISTS idx_users_guest_expires_at;
+DROP INDEX IF EXISTS idx_users_email_unique;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+ALTER TABLE users DROP COLUMN IF EXISTS guest_expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS guest;
\ No newline at end of file
@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS guest BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS guest_expires_at TIMESTAMP WITH TIME ZONE;
+
+-- Guests have no email, only the emails of other users are unique
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_unique ON users(email) WHERE email <> '';
+
+CREATE INDEX IF NOT EXISTS idx_users_guest_expires_at ON users(guest_expires_at) WHERE guest;
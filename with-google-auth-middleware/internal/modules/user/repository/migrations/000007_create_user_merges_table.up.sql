@@ -0,0 +1,17 @@
+-- Tombstones of users merged into another user
+ALTER TABLE users ADD COLUMN IF NOT EXISTS merged_into UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS merged_at TIMESTAMP WITH TIME ZONE;
+
+-- Audit log of merges. Not foreign keys, the records outlive the users.
+CREATE TABLE IF NOT EXISTS user_merges (
+    id UUID PRIMARY KEY,
+    source_id UUID NOT NULL,
+    target_id UUID NOT NULL,
+    source_email VARCHAR(255) NOT NULL DEFAULT '',
+    merged_by UUID,
+    moved JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_merges_source_id ON user_merges(source_id);
+CREATE INDEX idx_user_merges_target_id ON user_merges(target_id);
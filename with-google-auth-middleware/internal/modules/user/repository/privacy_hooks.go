@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	privacyrepo "github.com/tyobaskara/jeki-backend/internal/modules/privacy/repository"
+	"github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/gorm"
+)
+
+// ExportHooks export the user's profile and the duplicates merged into them
+var ExportHooks = []privacyrepo.ExportHook{
+	{Name: "user", Export: exportUser},
+	{Name: "user_merges", Export: exportMerges},
+}
+
+// ErasureHooks delete the tombstones of the user's merged duplicates and
+// clear their emails from the merge records, which are kept for admins
+var ErasureHooks = []privacyrepo.ErasureHook{
+	{Name: "merged_users", Erase: eraseTombstones},
+	{Name: "user_merges", Erase: eraseMergeEmails},
+}
+
+func exportUser(db *gorm.DB, userID uuid.UUID) (interface{}, error) {
+	var user domain.User
+	if err := db.Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func exportMerges(db *gorm.DB, userID uuid.UUID) (interface{}, error) {
+	merges := []*domain.UserMerge{}
+	err := db.Where("target_id = ?", userID).Order("created_at").Find(&merges).Error
+	return merges, err
+}
+
+func eraseTombstones(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("DELETE FROM users WHERE merged_into = ?", userID)
+	return result.RowsAffected, result.Error
+}
+
+func eraseMergeEmails(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := tx.Exec("UPDATE user_merges SET source_email = '' WHERE (source_id = ? OR target_id = ?) AND source_email <> ''", userID, userID)
+	return result.RowsAffected, result.Error
+}
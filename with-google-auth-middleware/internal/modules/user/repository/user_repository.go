@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,18 +10,38 @@ import (
 	"gorm.io/gorm"
 )
 
+// MergeHook moves the rows a module keeps for a user to another user when
+// two users are merged, and returns how many it moved. It runs in the merge's
+// transaction, which a dry run rolls back.
+type MergeHook struct {
+	Name  string
+	Merge func(tx *gorm.DB, sourceID, targetID uuid.UUID) (int64, error)
+}
+
+// errDryRun rolls back the transaction of a dry run
+var errDryRun = errors.New("dry run")
+
 type userRepository struct {
-	db *gorm.DB
+	db         *gorm.DB
+	mergeHooks []MergeHook
 }
 
-// NewUserRepository creates a new instance of UserRepository
-func NewUserRepository(db *gorm.DB) domain.UserRepository {
-	return &userRepository{
+// NewUserRepository creates a new instance of UserRepository. The merge hooks
+// of the modules keeping rows for users are run by Merge, in order.
+func NewUserRepository(db *gorm.DB, mergeHooks ...[]MergeHook) domain.UserRepository {
+	repo := &userRepository{
 		db: db,
 	}
+	for _, hooks := range mergeHooks {
+		repo.mergeHooks = append(repo.mergeHooks, hooks...)
+	}
+	return repo
 }
 
 func (r *userRepository) Create(user *domain.User) error {
+	if user.Status == "" {
+		user.Status = domain.StatusActive
+	}
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 	return r.db.Create(user).Error
@@ -49,6 +71,18 @@ func (r *userRepository) FindByEmail(email string) (*domain.User, error) {
 	return &user, nil
 }
 
+func (r *userRepository) FindByExternalID(externalID string) (*domain.User, error) {
+	var user domain.User
+	err := r.db.Where("external_id = ?", externalID).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (r *userRepository) Update(user *domain.User) error {
 	user.UpdatedAt = time.Now()
 	return r.db.Save(user).Error
@@ -66,4 +100,57 @@ func (r *userRepository) GetAll(page, limit int) ([]*domain.User, error) {
 		return nil, err
 	}
 	return users, nil
-} 
\ No newline at end of file
+}
+
+func (r *userRepository) List(offset, limit int) ([]*domain.User, int64, error) {
+	var total int64
+	if err := r.db.Model(&domain.User{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []*domain.User
+	err := r.db.Order("created_at ASC, id ASC").Offset(offset).Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// Merge tombstones the source: it can't sign in, and its email and external
+// ID are free for the target
+func (r *userRepository) Merge(merge *domain.UserMerge) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		merge.Moved = make(map[string]int64, len(r.mergeHooks))
+		for _, hook := range r.mergeHooks {
+			moved, err := hook.Merge(tx, merge.SourceID, merge.TargetID)
+			if err != nil {
+				return fmt.Errorf("failed to merge %s: %w", hook.Name, err)
+			}
+			merge.Moved[hook.Name] = moved
+		}
+		if merge.DryRun {
+			return errDryRun
+		}
+
+		err := tx.Model(&domain.User{}).Where("id = ?", merge.SourceID).Updates(map[string]interface{}{
+			"email":       "",
+			"external_id": "",
+			"active":      false,
+			"merged_into": merge.TargetID,
+			"merged_at":   merge.CreatedAt,
+			"updated_at":  merge.CreatedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(merge).Error
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	return err
+}
+
+func (r *userRepository) DeleteExpiredGuests(now time.Time) error {
+	return r.db.Where("guest AND guest_expires_at <= ?", now).Delete(&domain.User{}).Error
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB migrates a throwaway schema of the database at TEST_DATABASE_URL.
+// It has a notes table for the test merge hook.
+func openTestDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	// One connection, so that search_path sticks
+	sqlDB.SetMaxOpenConns(1)
+
+	schema := "user_test_" + uuid.NewString()[:8]
+	require.NoError(t, db.Exec("CREATE SCHEMA "+schema).Error)
+	require.NoError(t, db.Exec("SET search_path TO "+schema).Error)
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		sqlDB.Close()
+	})
+
+	files, err := filepath.Glob("migrations/*.up.sql")
+	require.NoError(t, err)
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.NoError(t, db.Exec(string(migration)).Error, file)
+	}
+	require.NoError(t, db.Exec("CREATE TABLE notes (id SERIAL PRIMARY KEY, user_id UUID NOT NULL REFERENCES users(id))").Error)
+	return db
+}
+
+// notesMergeHook moves the notes of the source to the target
+var notesMergeHook = MergeHook{
+	Name: "notes",
+	Merge: func(tx *gorm.DB, sourceID, targetID uuid.UUID) (int64, error) {
+		result := tx.Exec("UPDATE notes SET user_id = ? WHERE user_id = ?", targetID, sourceID)
+		return result.RowsAffected, result.Error
+	},
+}
+
+func createTestUser(t *testing.T, repo domain.UserRepository, email string, notes int) *domain.User {
+	user := &domain.User{ID: uuid.New(), Email: email, Name: email, ExternalID: "ext-" + email, Active: true}
+	require.NoError(t, repo.Create(user))
+	db := repo.(*userRepository).db
+	for i := 0; i < notes; i++ {
+		require.NoError(t, db.Exec("INSERT INTO notes (user_id) VALUES (?)", user.ID).Error)
+	}
+	return user
+}
+
+func countNotes(t *testing.T, db *gorm.DB, userID uuid.UUID) int64 {
+	var count int64
+	require.NoError(t, db.Table("notes").Where("user_id = ?", userID).Count(&count).Error)
+	return count
+}
+
+func TestMerge(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewUserRepository(db, []MergeHook{notesMergeHook})
+	source := createTestUser(t, repo, "alice@old.example.com", 2)
+	target := createTestUser(t, repo, "alice@example.com", 1)
+	admin := uuid.New()
+	newMerge := func(dryRun bool) *domain.UserMerge {
+		return &domain.UserMerge{
+			ID:          uuid.New(),
+			SourceID:    source.ID,
+			TargetID:    target.ID,
+			SourceEmail: source.Email,
+			MergedBy:    &admin,
+			DryRun:      dryRun,
+			CreatedAt:   time.Now().Truncate(time.Microsecond),
+		}
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		merge := newMerge(true)
+		require.NoError(t, repo.Merge(merge))
+		assert.Equal(t, map[string]int64{"notes": 2}, merge.Moved)
+
+		// Nothing was moved or recorded
+		assert.Equal(t, int64(2), countNotes(t, db, source.ID))
+		assert.Equal(t, int64(1), countNotes(t, db, target.ID))
+		stored, err := repo.FindByID(source.ID)
+		require.NoError(t, err)
+		assert.Nil(t, stored.MergedInto)
+		assert.Equal(t, source.Email, stored.Email)
+		var merges int64
+		require.NoError(t, db.Model(&domain.UserMerge{}).Count(&merges).Error)
+		assert.Zero(t, merges)
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		merge := newMerge(false)
+		require.NoError(t, repo.Merge(merge))
+		assert.Equal(t, map[string]int64{"notes": 2}, merge.Moved)
+		assert.Zero(t, countNotes(t, db, source.ID))
+		assert.Equal(t, int64(3), countNotes(t, db, target.ID))
+
+		// The source is a tombstone with its email and external ID freed
+		tombstone, err := repo.FindByID(source.ID)
+		require.NoError(t, err)
+		require.NotNil(t, tombstone.MergedInto)
+		assert.Equal(t, target.ID, *tombstone.MergedInto)
+		require.NotNil(t, tombstone.MergedAt)
+		assert.True(t, merge.CreatedAt.Equal(*tombstone.MergedAt))
+		assert.Empty(t, tombstone.Email)
+		assert.Empty(t, tombstone.ExternalID)
+		assert.False(t, tombstone.Active)
+
+		var recorded domain.UserMerge
+		require.NoError(t, db.Where("id = ?", merge.ID).First(&recorded).Error)
+		assert.Equal(t, source.ID, recorded.SourceID)
+		assert.Equal(t, target.ID, recorded.TargetID)
+		assert.Equal(t, "alice@old.example.com", recorded.SourceEmail)
+		assert.Equal(t, &admin, recorded.MergedBy)
+		assert.Equal(t, map[string]int64{"notes": 2}, recorded.Moved)
+	})
+
+	t.Run("failing hook", func(t *testing.T) {
+		other := createTestUser(t, repo, "bob@example.com", 1)
+		failing := NewUserRepository(db, []MergeHook{notesMergeHook, {
+			Name: "broken",
+			Merge: func(tx *gorm.DB, sourceID, targetID uuid.UUID) (int64, error) {
+				return 0, tx.Exec("UPDATE missing_table SET user_id = ?", targetID).Error
+			},
+		}})
+		merge := newMerge(false)
+		merge.SourceID = other.ID
+		assert.Error(t, failing.Merge(merge))
+		assert.Equal(t, int64(1), countNotes(t, db, other.ID))
+	})
+}
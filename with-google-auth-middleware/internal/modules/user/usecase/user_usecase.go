@@ -1,12 +1,25 @@
 package usecase
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
 )
 
+// Custom errors
+var (
+	ErrUserNotFound          = errors.New("user not found")
+	ErrInvalidStatus         = errors.New("invalid account status")
+	ErrInvalidSuspension     = errors.New("a suspension needs an end in the future")
+	ErrCannotChangeOwnStatus = errors.New("admins can't change their own account status")
+	ErrCannotMergeSameUser   = errors.New("a user can't be merged into itself")
+	ErrCannotMergeOwnAccount = errors.New("admins can't merge their own account into another user")
+	ErrUserMerged            = errors.New("user was already merged into another user")
+)
+
 type userUsecase struct {
 	userRepo domain.UserRepository
 }
@@ -21,7 +34,21 @@ func NewUserUsecase(userRepo domain.UserRepository) domain.UserUsecase {
 func (u *userUsecase) CreateUser(user *domain.User) error {
 	// Generate new UUID
 	user.ID = uuid.New()
-	
+
+	// Roles are only granted by admins or invitations, never through this endpoint
+	user.Role = domain.RoleUser
+
+	// Only provisioning clients manage activation and directory IDs
+	user.Active = true
+	user.ExternalID = ""
+
+	// Only admins change the account status, through ChangeStatus
+	clearStatus(user)
+
+	// Guests are only created by the auth module, tombstones by MergeUsers
+	clearGuest(user)
+	clearMerge(user)
+
 	// Set timestamps
 	now := time.Now()
 	user.CreatedAt = now
@@ -38,7 +65,37 @@ func (u *userUsecase) GetUserByEmail(email string) (*domain.User, error) {
 	return u.userRepo.FindByEmail(email)
 }
 
-func (u *userUsecase) UpdateUser(user *domain.User) error {
+func (u *userUsecase) GetUserByExternalID(externalID string) (*domain.User, error) {
+	return u.userRepo.FindByExternalID(externalID)
+}
+
+func (u *userUsecase) UpdateUser(user *domain.User, sent []string) error {
+	existing, err := u.userRepo.FindByID(user.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		// Keep fields that can't be changed through a profile update
+		user.Role = existing.Role
+		user.Active = existing.Active
+		user.ExternalID = existing.ExternalID
+		keepStatus(user, existing)
+		keepGuest(user, existing)
+		keepMerge(user, existing)
+		user.LastLoginAt = existing.LastLoginAt
+		user.CreatedAt = existing.CreatedAt
+
+		// Remember which profile fields the user changed so that
+		// provider values don't overwrite them on the next login
+		user.EditedFields = existing.EditedFields
+		for _, field := range domain.ProfileFields {
+			if !contains(sent, field) {
+				user.SetProfile(field, existing.Profile(field))
+			} else if user.Profile(field) != existing.Profile(field) {
+				user.MarkEdited(field)
+			}
+		}
+	}
 	return u.userRepo.Update(user)
 }
 
@@ -54,4 +111,186 @@ func (u *userUsecase) GetAllUsers(page, limit int) ([]*domain.User, error) {
 		limit = 10
 	}
 	return u.userRepo.GetAll(page, limit)
-}
\ No newline at end of file
+}
+
+func (u *userUsecase) ListUsers(offset, limit int) ([]*domain.User, int64, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	return u.userRepo.List(offset, limit)
+}
+
+func (u *userUsecase) ProvisionUser(user *domain.User) error {
+	user.ID = uuid.New()
+
+	// Provisioned users get their roles and statuses from admins like everyone else
+	user.Role = domain.RoleUser
+	clearStatus(user)
+	clearGuest(user)
+	clearMerge(user)
+
+	now := time.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	return u.userRepo.Create(user)
+}
+
+func (u *userUsecase) SyncUser(user *domain.User) error {
+	existing, err := u.userRepo.FindByID(user.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		user.Role = existing.Role
+		keepStatus(user, existing)
+		keepGuest(user, existing)
+		keepMerge(user, existing)
+		user.EditedFields = existing.EditedFields
+		user.LastLoginAt = existing.LastLoginAt
+		user.CreatedAt = existing.CreatedAt
+	}
+	return u.userRepo.Update(user)
+}
+
+func (u *userUsecase) ChangeStatus(id uuid.UUID, change domain.StatusChange) (*domain.User, error) {
+	if !validStatus(change.Status) {
+		return nil, ErrInvalidStatus
+	}
+	now := time.Now()
+	if change.Status == domain.StatusSuspended && (change.SuspendedUntil == nil || !change.SuspendedUntil.After(now)) {
+		return nil, ErrInvalidSuspension
+	}
+	if id == change.ChangedBy {
+		return nil, ErrCannotChangeOwnStatus
+	}
+
+	user, err := u.userRepo.FindByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user.Status = change.Status
+	user.StatusReason = change.Reason
+	user.SuspendedUntil = nil
+	if change.Status == domain.StatusSuspended {
+		user.SuspendedUntil = change.SuspendedUntil
+	}
+	user.StatusChangedBy = &change.ChangedBy
+	user.StatusChangedAt = &now
+	if err := u.userRepo.Update(user); err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	return user, nil
+}
+
+func (u *userUsecase) MergeUsers(sourceID, targetID, mergedBy uuid.UUID, dryRun bool) (*domain.UserMerge, error) {
+	if sourceID == targetID {
+		return nil, ErrCannotMergeSameUser
+	}
+	if sourceID == mergedBy {
+		return nil, ErrCannotMergeOwnAccount
+	}
+
+	source, err := u.findMergeable(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := u.findMergeable(targetID); err != nil {
+		return nil, err
+	}
+
+	merge := &domain.UserMerge{
+		ID:          uuid.New(),
+		SourceID:    sourceID,
+		TargetID:    targetID,
+		SourceEmail: source.Email,
+		MergedBy:    &mergedBy,
+		DryRun:      dryRun,
+		CreatedAt:   time.Now(),
+	}
+	if err := u.userRepo.Merge(merge); err != nil {
+		return nil, fmt.Errorf("failed to merge users: %w", err)
+	}
+	return merge, nil
+}
+
+// findMergeable returns a user that exists and isn't a tombstone of a merge
+func (u *userUsecase) findMergeable(id uuid.UUID) (*domain.User, error) {
+	user, err := u.userRepo.FindByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.MergedInto != nil {
+		return nil, ErrUserMerged
+	}
+	return user, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validStatus(status string) bool {
+	for _, s := range domain.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// clearStatus makes a new user active
+func clearStatus(user *domain.User) {
+	user.Status = domain.StatusActive
+	user.StatusReason = ""
+	user.SuspendedUntil = nil
+	user.StatusChangedBy = nil
+	user.StatusChangedAt = nil
+}
+
+// keepStatus copies the account status of the stored user, which only
+// ChangeStatus may change
+func keepStatus(user, existing *domain.User) {
+	user.Status = existing.Status
+	user.StatusReason = existing.StatusReason
+	user.SuspendedUntil = existing.SuspendedUntil
+	user.StatusChangedBy = existing.StatusChangedBy
+	user.StatusChangedAt = existing.StatusChangedAt
+}
+
+// clearGuest makes a new user a regular account
+func clearGuest(user *domain.User) {
+	user.Guest = false
+	user.GuestExpiresAt = nil
+}
+
+// keepGuest copies the guest fields of the stored user, only signing in
+// upgrades a guest
+func keepGuest(user, existing *domain.User) {
+	user.Guest = existing.Guest
+	user.GuestExpiresAt = existing.GuestExpiresAt
+}
+
+// clearMerge makes a new user one that wasn't merged
+func clearMerge(user *domain.User) {
+	user.MergedInto = nil
+	user.MergedAt = nil
+}
+
+// keepMerge copies the tombstone of the stored user, which only MergeUsers sets
+func keepMerge(user, existing *domain.User) {
+	user.MergedInto = existing.MergedInto
+	user.MergedAt = existing.MergedAt
+}
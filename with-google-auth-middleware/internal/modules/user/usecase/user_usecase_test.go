@@ -0,0 +1,174 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyobaskara/jeki-backend/internal/modules/user/domain"
+)
+
+// fakeUserRepository keeps users in memory and records merges
+type fakeUserRepository struct {
+	domain.UserRepository
+	users  map[uuid.UUID]*domain.User
+	merges []*domain.UserMerge
+}
+
+func newFakeUserRepository(users ...*domain.User) *fakeUserRepository {
+	repo := &fakeUserRepository{users: map[uuid.UUID]*domain.User{}}
+	for _, user := range users {
+		repo.users[user.ID] = user
+	}
+	return repo
+}
+
+func (r *fakeUserRepository) Create(user *domain.User) error {
+	r.users[user.ID] = user
+	return nil
+}
+
+func (r *fakeUserRepository) FindByID(id uuid.UUID) (*domain.User, error) {
+	if user, ok := r.users[id]; ok {
+		copied := *user
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeUserRepository) Update(user *domain.User) error {
+	r.users[user.ID] = user
+	return nil
+}
+
+func (r *fakeUserRepository) Merge(merge *domain.UserMerge) error {
+	r.merges = append(r.merges, merge)
+	return nil
+}
+
+func TestCreateUserProtectedFields(t *testing.T) {
+	repo := newFakeUserRepository()
+	u := NewUserUsecase(repo)
+	target := uuid.New()
+	expires := time.Now().Add(time.Hour)
+	user := &domain.User{
+		Email:          "alice@example.com",
+		Role:           domain.RoleAdmin,
+		Guest:          true,
+		GuestExpiresAt: &expires,
+		MergedInto:     &target,
+		MergedAt:       &expires,
+	}
+
+	require.NoError(t, u.CreateUser(user))
+	assert.Equal(t, domain.RoleUser, user.Role)
+	assert.False(t, user.Guest)
+	assert.Nil(t, user.GuestExpiresAt)
+	assert.Nil(t, user.MergedInto)
+	assert.Nil(t, user.MergedAt)
+}
+
+func TestUpdateUserProtectedFields(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	guest := &domain.User{ID: uuid.New(), Guest: true, GuestExpiresAt: &expires, Active: true}
+	target := uuid.New()
+	mergedAt := time.Now()
+	tombstone := &domain.User{ID: uuid.New(), MergedInto: &target, MergedAt: &mergedAt}
+	repo := newFakeUserRepository(guest, tombstone)
+	u := NewUserUsecase(repo)
+
+	t.Run("guest", func(t *testing.T) {
+		require.NoError(t, u.UpdateUser(&domain.User{ID: guest.ID, Name: "Alice"}, []string{"name", "guest"}))
+		assert.True(t, repo.users[guest.ID].Guest)
+		assert.Equal(t, &expires, repo.users[guest.ID].GuestExpiresAt)
+	})
+
+	t.Run("tombstone", func(t *testing.T) {
+		require.NoError(t, u.UpdateUser(&domain.User{ID: tombstone.ID, Name: "Bob"}, []string{"name", "merged_into"}))
+		assert.Equal(t, &target, repo.users[tombstone.ID].MergedInto)
+		assert.Equal(t, &mergedAt, repo.users[tombstone.ID].MergedAt)
+	})
+}
+
+func TestUpdateUserProfile(t *testing.T) {
+	stored := &domain.User{
+		ID:           uuid.New(),
+		Email:        "alice@example.com",
+		Name:         "Alice",
+		GivenName:    "Alice",
+		AvatarURL:    "https://example.com/alice.png",
+		Locale:       "en",
+		EditedFields: domain.ProfileFieldLocale,
+	}
+	repo := newFakeUserRepository(stored)
+	u := NewUserUsecase(repo)
+
+	update := &domain.User{ID: stored.ID, Email: stored.Email, Name: "Alice Smith", GivenName: "Alice"}
+	require.NoError(t, u.UpdateUser(update, []string{"email", "name", "given_name"}))
+	updated := repo.users[stored.ID]
+
+	// Only the sent field that changed is pinned
+	assert.Equal(t, "Alice Smith", updated.Name)
+	assert.True(t, updated.IsEdited(domain.ProfileFieldName))
+	assert.False(t, updated.IsEdited(domain.ProfileFieldGivenName))
+	assert.True(t, updated.IsEdited(domain.ProfileFieldLocale))
+
+	// Fields left out keep their value and aren't pinned
+	assert.Equal(t, "https://example.com/alice.png", updated.AvatarURL)
+	assert.False(t, updated.IsEdited(domain.ProfileFieldAvatarURL))
+	assert.Equal(t, "en", updated.Locale)
+
+	t.Run("clearing a sent field", func(t *testing.T) {
+		update := &domain.User{ID: stored.ID, Email: stored.Email}
+		require.NoError(t, u.UpdateUser(update, []string{"avatar_url"}))
+		updated := repo.users[stored.ID]
+		assert.Empty(t, updated.AvatarURL)
+		assert.True(t, updated.IsEdited(domain.ProfileFieldAvatarURL))
+		assert.Equal(t, "Alice Smith", updated.Name)
+	})
+}
+
+func TestMergeUsers(t *testing.T) {
+	admin := uuid.New()
+	source := &domain.User{ID: uuid.New(), Email: "alice@old.example.com"}
+	target := &domain.User{ID: uuid.New(), Email: "alice@example.com"}
+	mergedInto := uuid.New()
+	tombstone := &domain.User{ID: uuid.New(), MergedInto: &mergedInto}
+
+	tests := []struct {
+		name           string
+		source, target uuid.UUID
+		mergedBy       uuid.UUID
+		want           error
+	}{
+		{"same user", source.ID, source.ID, admin, ErrCannotMergeSameUser},
+		{"own account", source.ID, target.ID, source.ID, ErrCannotMergeOwnAccount},
+		{"merged source", tombstone.ID, target.ID, admin, ErrUserMerged},
+		{"merged target", source.ID, tombstone.ID, admin, ErrUserMerged},
+		{"unknown source", uuid.New(), target.ID, admin, ErrUserNotFound},
+		{"unknown target", source.ID, uuid.New(), admin, ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepository(source, target, tombstone)
+			_, err := NewUserUsecase(repo).MergeUsers(tt.source, tt.target, tt.mergedBy, false)
+			assert.ErrorIs(t, err, tt.want)
+			assert.Empty(t, repo.merges)
+		})
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		repo := newFakeUserRepository(source, target, tombstone)
+		merge, err := NewUserUsecase(repo).MergeUsers(source.ID, target.ID, admin, true)
+		require.NoError(t, err)
+		require.Len(t, repo.merges, 1)
+		assert.Same(t, merge, repo.merges[0])
+		assert.Equal(t, source.ID, merge.SourceID)
+		assert.Equal(t, target.ID, merge.TargetID)
+		assert.Equal(t, "alice@old.example.com", merge.SourceEmail)
+		assert.Equal(t, &admin, merge.MergedBy)
+		assert.True(t, merge.DryRun)
+	})
+}
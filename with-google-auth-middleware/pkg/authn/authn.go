@@ -0,0 +1,216 @@
+// Package authn authenticates requests of services behind the API. It
+// verifies the access tokens the API issues for a service through token
+// exchange against the API's public JSON Web Key Set, so services need no
+// shared secret, and exposes the caller as a typed Principal. Middleware is
+// provided for gin and net/http.
+//
+// The access tokens of the API's own apps and of third-party apps are signed
+// with a secret only the API holds (HS256) and can't be verified here. A
+// service called with the user's token must have it exchanged for a token
+// with the service's audience, e.g. by the API gateway.
+package authn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Custom errors
+var (
+	ErrMissingToken  = errors.New("authorization header is required")
+	ErrInvalidHeader = errors.New("invalid authorization header format")
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrInvalidConfig = errors.New("invalid authn config")
+)
+
+// accessTokenType is the JWT typ of access tokens for services (RFC 9068). ID
+// tokens are signed with the same keys and must not pass for access tokens.
+const accessTokenType = "at+jwt"
+
+// signingMethods are the algorithms the API signs access tokens with
+var signingMethods = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}
+
+// Config configures a Verifier
+type Config struct {
+	// JWKSURL is the API's key set, e.g. https://api.example.com/.well-known/jwks.json
+	JWKSURL string
+	// Issuer is the API's issuer, its OAUTH_ISSUER
+	Issuer string
+	// Audience is the service's own audience, as listed in the API's token
+	// exchange policies. Tokens issued for other services are rejected.
+	Audience string
+	// HTTPClient fetches the key set, a client with a 10 second timeout when nil
+	HTTPClient *http.Client
+	// Leeway is the clock skew tolerated on exp, nbf and iat
+	Leeway time.Duration
+}
+
+// Principal is the caller of a request, read from the claims of its access token
+type Principal struct {
+	UserID    uuid.UUID
+	Role      string
+	SessionID uuid.UUID  // uuid.Nil for tokens without a session
+	GrantID   *uuid.UUID // Set on tokens of third-party apps
+	ClientID  string     // App or OAuth client the user signed in with
+	// Actor is the client that exchanged the token for this service, e.g.
+	// the API gateway. Actors of earlier exchanges are in Claims["act"].
+	Actor     string
+	Scopes    []string
+	ExpiresAt time.Time
+	AuthTime  time.Time // Zero if the token doesn't say when the user signed in
+	ACR       string
+	OrgID     *uuid.UUID // Set on tokens switched to an organization
+	OrgRole   string
+	Guest     bool
+	// Claims are all claims of the token, including the namespaced claims of
+	// the API's claim providers
+	Claims map[string]interface{}
+}
+
+// HasScope reports whether the token was granted the scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CustomClaims returns the claims a claim provider added under its namespace,
+// nil if the token has none
+func (p *Principal) CustomClaims(namespace string) map[string]interface{} {
+	claims, _ := p.Claims[namespace].(map[string]interface{})
+	return claims
+}
+
+// Verifier verifies access tokens against a remote key set
+type Verifier struct {
+	keys   *KeySet
+	parser *jwt.Parser
+}
+
+// New returns a Verifier of the tokens the issuer signs for the audience.
+// The key set is fetched on first use and cached.
+func New(cfg Config) (*Verifier, error) {
+	switch {
+	case cfg.JWKSURL == "":
+		return nil, fmt.Errorf("%w: JWKSURL is required", ErrInvalidConfig)
+	case cfg.Issuer == "":
+		return nil, fmt.Errorf("%w: Issuer is required", ErrInvalidConfig)
+	case cfg.Audience == "":
+		return nil, fmt.Errorf("%w: Audience is required", ErrInvalidConfig)
+	}
+	return &Verifier{
+		keys: NewKeySet(cfg.JWKSURL, cfg.HTTPClient),
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(signingMethods),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+			jwt.WithLeeway(cfg.Leeway),
+		),
+	}, nil
+}
+
+// Verify verifies an access token and returns its principal
+func (v *Verifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := v.parser.ParseWithClaims(token, claims, v.keys.Keyfunc(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if parsed.Header["typ"] != accessTokenType {
+		return nil, fmt.Errorf("%w: not an access token", ErrInvalidToken)
+	}
+	// The service can't check the proof of a token bound to a DPoP key
+	if _, bound := claims["cnf"]; bound {
+		return nil, fmt.Errorf("%w: token is bound to a DPoP key", ErrInvalidToken)
+	}
+	userID, err := uuid.Parse(StringClaim(claims, "sub"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid user ID", ErrInvalidToken)
+	}
+	return NewPrincipal(userID, claims), nil
+}
+
+// Authenticate verifies the access token in the request's Authorization header
+func (v *Verifier) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, ErrMissingToken
+	}
+	parts := strings.Split(header, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, ErrInvalidHeader
+	}
+	return v.Verify(r.Context(), parts[1])
+}
+
+// NewPrincipal reads the caller from the claims of a verified access token.
+// The API's AuthMiddleware reads its own tokens with it too.
+func NewPrincipal(userID uuid.UUID, claims jwt.MapClaims) *Principal {
+	principal := &Principal{
+		UserID:   userID,
+		Role:     StringClaim(claims, "role"),
+		ClientID: StringClaim(claims, "client_id"),
+		Scopes:   strings.Fields(StringClaim(claims, "scope")),
+		ACR:      StringClaim(claims, "acr"),
+		OrgRole:  StringClaim(claims, "org_role"),
+		Claims:   claims,
+	}
+	principal.Guest, _ = claims["guest"].(bool)
+	if sessionID, err := uuid.Parse(StringClaim(claims, "sid")); err == nil {
+		principal.SessionID = sessionID
+	}
+	// Tokens of third-party apps carry the grant they act under
+	if grantID, err := uuid.Parse(StringClaim(claims, "grant_id")); err == nil {
+		principal.GrantID = &grantID
+	}
+	if act, ok := claims["act"].(map[string]interface{}); ok {
+		principal.Actor, _ = act["sub"].(string)
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		principal.ExpiresAt = exp.Time
+	}
+	// How the user signed in, for step-up checks
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		principal.AuthTime = time.Unix(int64(authTime), 0)
+	}
+	// The organization the token was switched to, the role is meaningless without it
+	if orgID, err := uuid.Parse(StringClaim(claims, "org_id")); err == nil {
+		principal.OrgID = &orgID
+	} else {
+		principal.OrgRole = ""
+	}
+	return principal
+}
+
+// principalContextKey is the context key of the principal
+type principalContextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal the middleware authenticated,
+// false outside of authenticated requests
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok
+}
+
+// StringClaim returns a string claim or an empty string if it is missing
+func StringClaim(claims jwt.MapClaims, key string) string {
+	value, _ := claims[key].(string)
+	return value
+}
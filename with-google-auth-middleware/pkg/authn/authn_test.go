@@ -0,0 +1,359 @@
+package authn
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIssuer   = "https://api.example.com"
+	testAudience = "billing"
+)
+
+// testIssuerServer publishes the key set of an RSA key and signs tokens with it
+type testIssuerServer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	keyID   string
+	fetches atomic.Int32
+	// failing makes the key set endpoint answer 503
+	failing atomic.Bool
+}
+
+func newTestIssuerServer(t *testing.T) *testIssuerServer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	s := &testIssuerServer{key: key, keyID: "key-1"}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		if s.failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{{
+			Kty: "RSA",
+			Kid: s.keyID,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// claims returns the claims of a token exchanged for the test audience
+func (s *testIssuerServer) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":       testIssuer,
+		"sub":       uuid.NewString(),
+		"aud":       testAudience,
+		"exp":       time.Now().Add(5 * time.Minute).Unix(),
+		"iat":       time.Now().Unix(),
+		"sid":       uuid.NewString(),
+		"client_id": "web",
+		"scope":     "users:read billing:read",
+		"role":      "user",
+		"act":       map[string]interface{}{"sub": "api-gateway"},
+	}
+}
+
+func (s *testIssuerServer) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	token.Header["typ"] = accessTokenType
+	signed, err := token.SignedString(s.key)
+	require.NoError(t, err)
+	return signed
+}
+
+func (s *testIssuerServer) verifier(t *testing.T) *Verifier {
+	t.Helper()
+	v, err := New(Config{JWKSURL: s.URL, Issuer: testIssuer, Audience: testAudience})
+	require.NoError(t, err)
+	return v
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{Issuer: testIssuer, Audience: testAudience})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = New(Config{JWKSURL: "https://api.example.com/.well-known/jwks.json", Audience: testAudience})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = New(Config{JWKSURL: "https://api.example.com/.well-known/jwks.json", Issuer: testIssuer})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestVerify(t *testing.T) {
+	s := newTestIssuerServer(t)
+	v := s.verifier(t)
+	ctx := context.Background()
+
+	claims := s.claims()
+	principal, err := v.Verify(ctx, s.sign(t, claims))
+	require.NoError(t, err)
+	assert.Equal(t, claims["sub"], principal.UserID.String())
+	assert.Equal(t, claims["sid"], principal.SessionID.String())
+	assert.Equal(t, "user", principal.Role)
+	assert.Equal(t, "web", principal.ClientID)
+	assert.Equal(t, "api-gateway", principal.Actor)
+	assert.Nil(t, principal.GrantID)
+	assert.True(t, principal.HasScope("billing:read"))
+	assert.False(t, principal.HasScope("users:write"))
+	assert.Equal(t, claims["exp"], principal.ExpiresAt.Unix())
+
+	t.Run("keys are cached", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := v.Verify(ctx, s.sign(t, s.claims()))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int32(1), s.fetches.Load())
+	})
+
+	invalid := map[string]func(claims jwt.MapClaims){
+		"another audience": func(claims jwt.MapClaims) { claims["aud"] = "ledger" },
+		"another issuer":   func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" },
+		"expired":          func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"no expiry":        func(claims jwt.MapClaims) { delete(claims, "exp") },
+		"no user":          func(claims jwt.MapClaims) { delete(claims, "sub") },
+		"bound to a DPoP key": func(claims jwt.MapClaims) {
+			claims["cnf"] = map[string]interface{}{"jkt": "thumbprint"}
+		},
+	}
+	for name, modify := range invalid {
+		t.Run(name, func(t *testing.T) {
+			claims := s.claims()
+			modify(claims)
+			_, err := v.Verify(ctx, s.sign(t, claims))
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+
+	t.Run("ID token", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, s.claims())
+		token.Header["kid"] = s.keyID
+		idToken, err := token.SignedString(s.key)
+		require.NoError(t, err)
+		_, err = v.Verify(ctx, idToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("HMAC token", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, s.claims())
+		token.Header["kid"] = s.keyID
+		token.Header["typ"] = accessTokenType
+		signed, err := token.SignedString([]byte("test-secret"))
+		require.NoError(t, err)
+		_, err = v.Verify(ctx, signed)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("another key", func(t *testing.T) {
+		other := newTestIssuerServer(t)
+		_, err := v.Verify(ctx, other.sign(t, s.claims()))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
+
+func TestNewPrincipal(t *testing.T) {
+	userID, orgID := uuid.New(), uuid.New()
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	t.Run("organization token", func(t *testing.T) {
+		principal := NewPrincipal(userID, jwt.MapClaims{
+			"scope":     "openid profile",
+			"acr":       "multi_factor",
+			"auth_time": float64(authTime.Unix()),
+			"org_id":    orgID.String(),
+			"org_role":  "owner",
+			"billing":   map[string]interface{}{"tier": "pro"},
+		})
+		assert.Equal(t, userID, principal.UserID)
+		assert.Equal(t, []string{"openid", "profile"}, principal.Scopes)
+		assert.Equal(t, "multi_factor", principal.ACR)
+		assert.True(t, authTime.Equal(principal.AuthTime))
+		require.NotNil(t, principal.OrgID)
+		assert.Equal(t, orgID, *principal.OrgID)
+		assert.Equal(t, "owner", principal.OrgRole)
+		assert.Equal(t, map[string]interface{}{"tier": "pro"}, principal.CustomClaims("billing"))
+		assert.False(t, principal.Guest)
+	})
+
+	t.Run("org role without organization", func(t *testing.T) {
+		principal := NewPrincipal(userID, jwt.MapClaims{"org_role": "owner", "guest": true})
+		assert.Nil(t, principal.OrgID)
+		assert.Empty(t, principal.OrgRole)
+		assert.True(t, principal.AuthTime.IsZero())
+		assert.True(t, principal.Guest)
+	})
+}
+
+func TestKeyRotation(t *testing.T) {
+	s := newTestIssuerServer(t)
+	v := s.verifier(t)
+	ctx := context.Background()
+	_, err := v.Verify(ctx, s.sign(t, s.claims()))
+	require.NoError(t, err)
+
+	// The issuer rotates its key, the set is refreshed for the unknown kid
+	// once the minimum refresh interval passed
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	s.key, s.keyID = key, "key-2"
+	_, err = v.Verify(ctx, s.sign(t, s.claims()))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, int32(1), s.fetches.Load())
+
+	v.keys.attemptedAt = time.Now().Add(-keySetMinRefreshInterval)
+	_, err = v.Verify(ctx, s.sign(t, s.claims()))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), s.fetches.Load())
+}
+
+func TestKeySetFailedRefresh(t *testing.T) {
+	s := newTestIssuerServer(t)
+	v := s.verifier(t)
+	ctx := context.Background()
+	token := s.sign(t, s.claims())
+	_, err := v.Verify(ctx, token)
+	require.NoError(t, err)
+
+	// The keys expired and the issuer is down, the expired keys are still used
+	s.failing.Store(true)
+	expired := time.Now().Add(-keySetCacheTTL)
+	v.keys.fetchedAt, v.keys.attemptedAt = expired, expired
+	_, err = v.Verify(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), s.fetches.Load())
+
+	// and the issuer is retried at most once a minute
+	_, err = v.Verify(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), s.fetches.Load())
+
+	// Once it is back the keys are refreshed
+	s.failing.Store(false)
+	v.keys.attemptedAt = expired
+	_, err = v.Verify(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), s.fetches.Load())
+	assert.WithinDuration(t, time.Now(), v.keys.fetchedAt, time.Second)
+}
+
+func TestKeySetUnreachable(t *testing.T) {
+	s := newTestIssuerServer(t)
+	s.failing.Store(true)
+	_, err := NewKeySet(s.URL, nil).Key(context.Background(), s.keyID)
+	assert.ErrorContains(t, err, "unexpected status 503")
+}
+
+func TestMiddleware(t *testing.T) {
+	s := newTestIssuerServer(t)
+	v := s.verifier(t)
+
+	mux := http.NewServeMux()
+	mux.Handle("/invoices", v.Middleware(RequireScope("billing:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		require.True(t, ok)
+		w.Write([]byte(principal.UserID.String()))
+	}))))
+	mux.Handle("/refunds", v.Middleware(RequireScope("billing:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))))
+
+	request := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	claims := s.claims()
+	w := request("/invoices", "Bearer "+s.sign(t, claims))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, claims["sub"], w.Body.String())
+
+	w = request("/invoices", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	assert.JSONEq(t, `{"error":"Authorization header is required"}`, w.Body.String())
+
+	w = request("/invoices", "DPoP "+s.sign(t, claims))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_request"`, w.Header().Get("WWW-Authenticate"))
+
+	w = request("/invoices", "Bearer not-a-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+
+	w = request("/refunds", "Bearer "+s.sign(t, claims))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, `Bearer error="insufficient_scope", scope="billing:write"`, w.Header().Get("WWW-Authenticate"))
+	assert.JSONEq(t, `{"error":"Insufficient scope","scope":"billing:write"}`, w.Body.String())
+}
+
+func TestGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestIssuerServer(t)
+	v := s.verifier(t)
+
+	router := gin.New()
+	router.Use(v.GinMiddleware())
+	router.GET("/invoices", GinRequireScope("billing:read"), func(c *gin.Context) {
+		principal, ok := GinPrincipal(c)
+		require.True(t, ok)
+		fromContext, ok := PrincipalFromContext(c.Request.Context())
+		require.True(t, ok)
+		assert.Same(t, principal, fromContext)
+		assert.Equal(t, principal.UserID, c.MustGet("user_id"))
+		assert.Equal(t, principal.SessionID, c.MustGet("session_id"))
+		c.Status(http.StatusOK)
+	})
+	router.GET("/refunds", GinRequireScope("billing:write"), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	request := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	token := s.sign(t, s.claims())
+	assert.Equal(t, http.StatusOK, request("/invoices", "Bearer "+token).Code)
+	assert.Equal(t, http.StatusForbidden, request("/refunds", "Bearer "+token).Code)
+
+	w := request("/invoices", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"error":"Authorization header is required"}`, w.Body.String())
+
+	claims := s.claims()
+	claims["aud"] = "ledger"
+	w = request("/invoices", "Bearer "+s.sign(t, claims))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+}
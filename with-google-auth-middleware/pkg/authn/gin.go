@@ -0,0 +1,60 @@
+package authn
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PrincipalKey is the gin context key of the *Principal set by GinMiddleware
+const PrincipalKey = "principal"
+
+// GinMiddleware is Middleware for gin. Besides the principal it sets the
+// user_id, role, session_id and scopes keys, like the API's AuthMiddleware,
+// so that handlers can be shared with the API.
+func (v *Verifier) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := v.Authenticate(c.Request)
+		if err != nil {
+			status, challenge, response := authenticationError(err)
+			c.Header("WWW-Authenticate", challenge)
+			c.AbortWithStatusJSON(status, response)
+			return
+		}
+
+		c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), principal))
+		c.Set(PrincipalKey, principal)
+		c.Set("user_id", principal.UserID)
+		if principal.Role != "" {
+			c.Set("role", principal.Role)
+		}
+		if principal.SessionID != uuid.Nil {
+			c.Set("session_id", principal.SessionID)
+		}
+		c.Set("scopes", principal.Scopes)
+		c.Next()
+	}
+}
+
+// GinRequireScope is RequireScope for gin. It must run after GinMiddleware.
+func GinRequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, _ := GinPrincipal(c)
+		if status, challenge, response, ok := checkScopes(principal, scopes); !ok {
+			c.Header("WWW-Authenticate", challenge)
+			c.AbortWithStatusJSON(status, response)
+			return
+		}
+		c.Next()
+	}
+}
+
+// GinPrincipal returns the caller GinMiddleware authenticated, false on
+// routes without it
+func GinPrincipal(c *gin.Context) (*Principal, bool) {
+	value, ok := c.Get(PrincipalKey)
+	if !ok {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
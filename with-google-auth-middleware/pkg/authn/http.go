@@ -0,0 +1,76 @@
+package authn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// errorResponse is the body of the middleware's error responses, like the API's
+type errorResponse struct {
+	Error string `json:"error"`
+	Scope string `json:"scope,omitempty"`
+}
+
+// Middleware authenticates requests with an access token of the API and
+// stores the principal in their context, see PrincipalFromContext. Other
+// requests get a 401.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := v.Authenticate(r)
+		if err != nil {
+			status, challenge, response := authenticationError(err)
+			writeError(w, status, challenge, response)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// RequireScope returns middleware that only lets access tokens with every
+// given scope through. It must run after Middleware.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ := PrincipalFromContext(r.Context())
+			if status, challenge, response, ok := checkScopes(principal, scopes); !ok {
+				writeError(w, status, challenge, response)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticationError returns the 401 response to a failed authentication,
+// with its WWW-Authenticate challenge (RFC 6750)
+func authenticationError(err error) (int, string, errorResponse) {
+	switch {
+	case errors.Is(err, ErrMissingToken):
+		return http.StatusUnauthorized, "Bearer", errorResponse{Error: "Authorization header is required"}
+	case errors.Is(err, ErrInvalidHeader):
+		return http.StatusUnauthorized, `Bearer error="invalid_request"`, errorResponse{Error: "Invalid authorization header format"}
+	}
+	return http.StatusUnauthorized, `Bearer error="invalid_token"`, errorResponse{Error: "Invalid token"}
+}
+
+// checkScopes returns the 403 response if the principal lacks one of the scopes
+func checkScopes(principal *Principal, scopes []string) (int, string, errorResponse, bool) {
+	for _, scope := range scopes {
+		if principal == nil || !principal.HasScope(scope) {
+			required := strings.Join(scopes, " ")
+			challenge := fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, required)
+			return http.StatusForbidden, challenge, errorResponse{Error: "Insufficient scope", Scope: required}, false
+		}
+	}
+	return 0, "", errorResponse{}, true
+}
+
+func writeError(w http.ResponseWriter, status int, challenge string, response errorResponse) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
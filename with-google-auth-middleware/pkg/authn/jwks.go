@@ -0,0 +1,232 @@
+package authn
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Custom errors
+var (
+	ErrUnknownSigningKey = errors.New("unknown signing key")
+)
+
+const (
+	// keySetCacheTTL is how long fetched keys are used before they are refreshed
+	keySetCacheTTL = time.Hour
+	// keySetMinRefreshInterval limits refreshes triggered by unknown key IDs
+	keySetMinRefreshInterval = time.Minute
+)
+
+// JSONWebKey is a single key of a JSON Web Key Set (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a JSON Web Key Set (RFC 7517)
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// KeySet fetches and caches the public keys of a remote JSON Web Key Set,
+// such as the API's /.well-known/jwks.json or an identity provider's keys.
+// Keys are refreshed hourly, and when a token names a key the set doesn't
+// have, at most once a minute. While the issuer is unreachable the keys
+// fetched before keep being used.
+type KeySet struct {
+	url        string
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time     // Start of the last refresh, successful or not
+	refreshing  chan struct{} // Closed when the running refresh is done
+	refreshErr  error         // Error of the last refresh
+}
+
+// NewKeySet returns the key set at url, fetched on first use. A nil
+// httpClient uses a client with a 10 second timeout.
+func NewKeySet(url string, httpClient *http.Client) *KeySet {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &KeySet{
+		url:        url,
+		httpClient: httpClient,
+	}
+}
+
+// Keyfunc returns a jwt.Keyfunc that resolves the token's kid header against the key set
+func (s *KeySet) Keyfunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.Key(ctx, kid)
+	}
+}
+
+// Key returns the public key with the key ID. The set is fetched without
+// holding the lock, requests that have a key meanwhile use the cached one.
+func (s *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	if ok && time.Since(s.fetchedAt) < keySetCacheTTL {
+		s.mu.Unlock()
+		return key, nil
+	}
+
+	// Refresh on expiry or on an unknown kid, which usually means the issuer
+	// rotated its keys, but don't let unknown kids or a failing issuer hammer it
+	if s.keys != nil && time.Since(s.attemptedAt) < keySetMinRefreshInterval {
+		s.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSigningKey, kid)
+	}
+
+	done := s.refreshing
+	if done == nil {
+		done = make(chan struct{})
+		s.refreshing = done
+		s.attemptedAt = time.Now()
+		s.mu.Unlock()
+
+		keys, err := s.fetch(ctx)
+
+		s.mu.Lock()
+		if err == nil {
+			s.keys = keys
+			s.fetchedAt = time.Now()
+		}
+		s.refreshErr = err
+		s.refreshing = nil
+		close(done)
+		s.mu.Unlock()
+	} else {
+		s.mu.Unlock()
+		// Another request is refreshing, the expired key is good until it's done
+		if ok {
+			return key, nil
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A failed refresh keeps the previous keys
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if s.refreshErr != nil {
+		return nil, s.refreshErr
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownSigningKey, kid)
+}
+
+// fetch downloads the key set, skipping keys that aren't for signatures or of
+// an unsupported type
+func (s *KeySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := PublicKey(jwk)
+		if err != nil {
+			// Skip key types we don't support instead of failing the whole set
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// PublicKey converts an RSA or EC JSON Web Key into a Go public key
+func PublicKey(k JSONWebKey) (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,29 @@
+#!/bin/sh
+# Creates the role the application connects as. It runs once, when the
+# postgres container initializes its data directory, as the superuser.
+#
+# The role is no superuser and has no BYPASSRLS, so the row-level security
+# policies of the organization tables apply to it. It owns the database and
+# runs the migrations; FORCE ROW LEVEL SECURITY applies the policies to the
+# owner of a table as well.
+set -e
+
+APP_USER="${DB_USER:-jeki_app}"
+APP_PASSWORD="${DB_PASSWORD:-jeki_app}"
+
+if [ "$APP_USER" = "$POSTGRES_USER" ]; then
+	echo "DB_USER must differ from the superuser POSTGRES_USER ($POSTGRES_USER)" >&2
+	exit 1
+fi
+
+psql -v ON_ERROR_STOP=1 --username "$POSTGRES_USER" --dbname "$POSTGRES_DB" \
+	-v app_user="$APP_USER" -v app_password="$APP_PASSWORD" -v db="$POSTGRES_DB" <<'SQL'
+CREATE ROLE :"app_user" LOGIN PASSWORD :'app_password' NOSUPERUSER NOBYPASSRLS NOCREATEDB NOCREATEROLE;
+ALTER DATABASE :"db" OWNER TO :"app_user";
+ALTER SCHEMA public OWNER TO :"app_user";
+SQL
+
+# Tables created by the earlier init scripts
+psql -v ON_ERROR_STOP=1 --username "$POSTGRES_USER" --dbname "$POSTGRES_DB" -At \
+	-c "SELECT format('ALTER TABLE public.%I OWNER TO %I;', tablename, '$APP_USER') FROM pg_tables WHERE schemaname = 'public'" |
+	psql -v ON_ERROR_STOP=1 --username "$POSTGRES_USER" --dbname "$POSTGRES_DB"